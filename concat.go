@@ -0,0 +1,213 @@
+package oggmeta
+
+import (
+	"bytes"
+	"io"
+)
+
+// Concat writes the inputs one after another as a chained Ogg stream, giving
+// every logical stream a serial number not used before it.
+func Concat(w io.Writer, inputs ...io.ReadSeeker) error {
+	return ConcatTags(w, nil, inputs...)
+}
+
+// ConcatTags is like Concat, but replaces the comment header of input i with
+// tags[i] when that is not nil.
+func ConcatTags(w io.Writer, tags []*OggTag, inputs ...io.ReadSeeker) error {
+	for i, r := range inputs {
+		if err := checkLink(i, r); err != nil {
+			return err
+		}
+	}
+	used := make(map[uint32]bool)
+	for i, r := range inputs {
+		var tag *OggTag
+		if i < len(tags) {
+			tag = tags[i]
+		}
+		if err := copyLink(w, r, tag, used); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLink verifies that input i is made of complete, properly grouped logical streams.
+func checkLink(i int, r io.ReadSeeker) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dec := &OGGDecoder{Reader: r}
+	open := make(map[uint32]bool)
+	data := false // a page other than a BOS page was seen in the current link
+	for pages := 0; ; pages++ {
+		page, err := dec.Decode()
+		if err == io.EOF {
+			if pages == 0 {
+				return &ErrInvalidLink{Index: i, Reason: "input is empty"}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		serial := page.Header.SerialNumber
+		if page.Header.Flags&FlagBOS != 0 {
+			if data && len(open) > 0 {
+				return &ErrInvalidLink{Index: i, Reason: "a stream begins while another is still open"}
+			}
+			if open[serial] {
+				return &ErrInvalidLink{Index: i, Reason: "two streams share a serial number"}
+			}
+			if len(open) == 0 {
+				data = false
+			}
+			open[serial] = true
+		} else {
+			if !open[serial] {
+				return &ErrInvalidLink{Index: i, Reason: "page outside the BOS and EOS of its stream"}
+			}
+			data = true
+		}
+		if page.Header.Flags&FlagEOS != 0 {
+			delete(open, serial)
+			data = true
+		}
+	}
+	if len(open) > 0 {
+		return &ErrInvalidLink{Index: i, Reason: "stream has no EOS page"}
+	}
+	return nil
+}
+
+// linkStream is a logical stream of an input being copied to the output.
+type linkStream struct {
+	enc *OGGEncoder
+
+	// when the comment header is replaced, the tags to write, the header
+	// packets seen so far and how many are still to come
+	tag       *OggTag
+	headers   *packetReader
+	collected [][]byte
+	remaining int
+}
+
+// copyLink copies the pages of r to w, moving every stream to an unused
+// serial number and numbering its pages afresh.
+func copyLink(w io.Writer, r io.ReadSeeker, tag *OggTag, used map[uint32]bool) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dec := &OGGDecoder{Reader: r}
+	streams := make(map[uint32]*linkStream)
+	for {
+		page, err := dec.Decode()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		serial := page.Header.SerialNumber
+		if page.Header.Flags&FlagBOS != 0 {
+			s := &linkStream{enc: &OGGEncoder{Writer: w, Serial: unusedSerial(serial, used)}}
+			if tag != nil {
+				if codec, headers := headerCount(page.Packets[0]); headers > 0 {
+					s.headers = newPacketReader(nil, 0, 0)
+					s.remaining = headers
+					s.tag = new(OggTag)
+					*s.tag = *tag
+					s.tag.Codec = codec
+					tag = nil
+				}
+			}
+			streams[serial] = s
+		}
+		s := streams[serial]
+
+		if s.headers != nil && s.remaining > 0 {
+			if err := s.collectHeaders(page); err != nil {
+				return err
+			}
+			if page.Header.Flags&FlagBOS == 0 {
+				if s.remaining > 0 {
+					continue
+				}
+				// the comment header is the second header packet
+				s.collected[1] = tagCommentPacket(s.tag)
+				if err := s.enc.Encode(0, s.collected[1:]); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if err := s.enc.copyPage(page); err != nil {
+			return err
+		}
+		if page.Header.Flags&FlagEOS != 0 {
+			delete(streams, serial)
+		}
+	}
+}
+
+// collectHeaders adds the packets of a header page to those collected.
+func (s *linkStream) collectHeaders(page *OGGPage) error {
+	copied := *page
+	copied.Packets = append([][]byte{}, page.Packets...)
+	if err := s.headers.push(&copied); err != nil {
+		return err
+	}
+	for _, p := range s.headers.queue {
+		s.collected = append(s.collected, p.Data)
+		s.remaining--
+	}
+	s.headers.queue = nil
+	return nil
+}
+
+// headerCount returns the codec and number of header packets of a stream
+// whose tags can be replaced, from its first packet.
+func headerCount(first []byte) (string, int) {
+	switch {
+	case bytes.HasPrefix(first, VorbisIdentPrefix):
+		return Vorbis, 3
+	case bytes.HasPrefix(first, OpusHeadPrefix):
+		return Opus, 2
+	case bytes.HasPrefix(first, SpeexPrefix):
+		return Speex, 2
+	}
+	return "", 0
+}
+
+// unusedSerial returns serial, or the next serial after it that is not
+// taken, and marks it as taken.
+func unusedSerial(serial uint32, used map[uint32]bool) uint32 {
+	for used[serial] {
+		serial++
+	}
+	used[serial] = true
+	return serial
+}
+
+// copyPage writes page as it is apart from the serial number, sequence
+// number and checksum.
+func (enc *OGGEncoder) copyPage(page *OGGPage) error {
+	header := page.Header
+	header.SerialNumber = enc.Serial
+	header.CRC = 0
+	var segtbl []byte
+	var payload []byte
+	for i, p := range page.Packets {
+		n := len(p)
+		for n >= MaxSegSize {
+			segtbl = append(segtbl, MaxSegSize)
+			n -= MaxSegSize
+		}
+		if i < len(page.Packets)-1 || !page.continues {
+			segtbl = append(segtbl, byte(n))
+		}
+		payload = append(payload, p...)
+	}
+	return enc.writePage(&header, segtbl, segmentizePayload{leftPay: payload})
+}
@@ -0,0 +1,98 @@
+package oggmeta
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcat(t *testing.T) {
+	opus, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	vorbis, err := os.ReadFile("./testdata/test1.ogg")
+	assert.NoError(t, err)
+
+	out := new(bytes.Buffer)
+	assert.NoError(t, Concat(out, bytes.NewReader(opus), bytes.NewReader(opus), bytes.NewReader(vorbis)))
+	headers := verifyPages(t, out.Bytes())
+
+	// every link keeps its pages, gets its own serial and numbers pages from 0
+	serials := make(map[uint32]uint32)
+	var order []uint32
+	for _, h := range headers {
+		if h.Flags&FlagBOS != 0 {
+			assert.NotContains(t, serials, h.SerialNumber)
+			order = append(order, h.SerialNumber)
+		}
+		assert.Equal(t, serials[h.SerialNumber], h.PageSequenceNumber)
+		serials[h.SerialNumber]++
+	}
+	assert.Len(t, order, 3)
+	assert.Equal(t, len(verifyPages(t, opus)), int(serials[order[0]]))
+	assert.Equal(t, len(verifyPages(t, opus)), int(serials[order[1]]))
+	assert.Equal(t, len(verifyPages(t, vorbis)), int(serials[order[2]]))
+
+	// the first link still reads and analyses as before
+	stats, err := AnalyzeOpus(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(164222), stats.FinalGranule)
+}
+
+func TestConcatTags(t *testing.T) {
+	vorbis, err := os.ReadFile("./testdata/test1.ogg")
+	assert.NoError(t, err)
+	opus, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+
+	out := new(bytes.Buffer)
+	tags := []*OggTag{{Title: "Intro", Artist: "Someone"}, {Title: "Outro"}}
+	assert.NoError(t, ConcatTags(out, tags, bytes.NewReader(vorbis), bytes.NewReader(opus)))
+	verifyPages(t, out.Bytes())
+
+	tag, err := ReadOGG(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "Intro", tag.Title)
+	assert.Equal(t, "Someone", tag.Artist)
+
+	stats, err := AnalyzeVorbis(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(149440), stats.FinalGranule)
+
+	// the second link carries its own tags
+	packets := newPacketReader(&OGGDecoder{Reader: bytes.NewReader(out.Bytes())}, 0, 0)
+	var comment []byte
+	for {
+		packet, err := packets.next()
+		if !assert.NoError(t, err) {
+			return
+		}
+		if bytes.HasPrefix(packet.Data, OpusPrefix) {
+			comment = packet.Data
+			break
+		}
+	}
+	assert.Contains(t, string(comment), "TITLE=Outro")
+}
+
+func TestConcatIncompleteInput(t *testing.T) {
+	opus, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	headers := verifyPages(t, opus)
+	last := headers[len(headers)-1]
+	size := HeaderSize + int(last.Segments)
+	// drop the EOS page
+	cut := len(opus) - size
+	for cut > 0 && !bytes.HasPrefix(opus[cut:], Oggs[:]) {
+		cut--
+	}
+
+	out := new(bytes.Buffer)
+	err = Concat(out, bytes.NewReader(opus), bytes.NewReader(opus[:cut]))
+	var invalid *ErrInvalidLink
+	if assert.ErrorAs(t, err, &invalid) {
+		assert.Equal(t, 1, invalid.Index)
+	}
+	assert.Zero(t, out.Len())
+}
@@ -0,0 +1,64 @@
+package oggmeta
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOGGContext(t *testing.T) {
+	b, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+
+	t.Run("progress", func(t *testing.T) {
+		var lastBytes, lastPages int64
+		tag, err := ReadOGGContext(context.Background(), bytes.NewReader(b), func(n, pages int64) {
+			assert.Greater(t, n, lastBytes)
+			assert.Equal(t, lastPages+1, pages)
+			lastBytes, lastPages = n, pages
+		})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, tag.GetTitle())
+		assert.Equal(t, int64(2), lastPages)
+		assert.Equal(t, int64(365), lastBytes)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := ReadOGGContext(ctx, bytes.NewReader(b), nil)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestSaveTagsContext(t *testing.T) {
+	b, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	tag, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+
+	t.Run("progress", func(t *testing.T) {
+		var lastBytes int64
+		out := new(bytes.Buffer)
+		err := SaveTagsContext(context.Background(), tag, out, SaveOptions{Progress: func(n, pages int64) {
+			lastBytes = n
+		}})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(b)), lastBytes)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		out := new(bytes.Buffer)
+		err := SaveTagsContext(ctx, tag, out, SaveOptions{Progress: func(n, pages int64) {
+			if pages == 2 {
+				cancel()
+			}
+		}})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Zero(t, out.Len())
+	})
+}
@@ -0,0 +1,231 @@
+package oggmeta
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CueSheet holds the parts of a cue sheet needed to split an album.
+type CueSheet struct {
+	Title     string
+	Performer string
+	Tracks    []CueTrack
+}
+
+// CueTrack is a TRACK entry; Start is its INDEX 01.
+type CueTrack struct {
+	Number    int
+	Title     string
+	Performer string
+	Start     time.Duration
+}
+
+// ParseCueSheet reads a cue sheet describing a single audio file.
+func ParseCueSheet(r io.Reader) (*CueSheet, error) {
+	cue := new(CueSheet)
+	scanner := bufio.NewScanner(r)
+	files := 0
+	var track *CueTrack
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := cueFields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "FILE":
+			if files++; files > 1 {
+				return nil, &ErrInvalidCue{Line: line, Reason: "more than one FILE"}
+			}
+		case "TITLE", "PERFORMER":
+			if len(fields) < 2 {
+				return nil, &ErrInvalidCue{Line: line, Reason: fields[0] + " without a value"}
+			}
+			title := strings.ToUpper(fields[0]) == "TITLE"
+			switch {
+			case track == nil && title:
+				cue.Title = fields[1]
+			case track == nil:
+				cue.Performer = fields[1]
+			case title:
+				track.Title = fields[1]
+			default:
+				track.Performer = fields[1]
+			}
+		case "TRACK":
+			if len(fields) < 2 {
+				return nil, &ErrInvalidCue{Line: line, Reason: "TRACK without a number"}
+			}
+			n, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, &ErrInvalidCue{Line: line, Reason: "invalid track number " + fields[1]}
+			}
+			if err := cue.checkStart(track, line); err != nil {
+				return nil, err
+			}
+			cue.Tracks = append(cue.Tracks, CueTrack{Number: n, Start: -1})
+			track = &cue.Tracks[len(cue.Tracks)-1]
+		case "INDEX":
+			if len(fields) < 3 || track == nil {
+				return nil, &ErrInvalidCue{Line: line, Reason: "invalid INDEX"}
+			}
+			if fields[1] != "01" && fields[1] != "1" {
+				continue
+			}
+			start, err := parseCueTime(fields[2])
+			if err != nil {
+				return nil, &ErrInvalidCue{Line: line, Reason: err.Error()}
+			}
+			track.Start = start
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := cue.checkStart(track, line); err != nil {
+		return nil, err
+	}
+	if len(cue.Tracks) == 0 {
+		return nil, &ErrInvalidCue{Line: line, Reason: "no tracks"}
+	}
+	return cue, nil
+}
+
+// checkStart makes sure a finished track has an INDEX 01 after the track before it.
+func (c *CueSheet) checkStart(track *CueTrack, line int) error {
+	if track == nil {
+		return nil
+	}
+	if track.Start < 0 {
+		return &ErrInvalidCue{Line: line, Reason: "track " + strconv.Itoa(track.Number) + " has no INDEX 01"}
+	}
+	if n := len(c.Tracks); n > 1 && c.Tracks[n-2].Start >= track.Start {
+		return &ErrInvalidCue{Line: line, Reason: "track " + strconv.Itoa(track.Number) + " starts before the track before it"}
+	}
+	return nil
+}
+
+// cueFields splits a cue sheet line into words, keeping quoted strings whole.
+func cueFields(line string) []string {
+	var fields []string
+	line = strings.TrimSpace(line)
+	for line != "" {
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				fields = append(fields, line[1:])
+				break
+			}
+			fields = append(fields, line[1:end+1])
+			line = strings.TrimSpace(line[end+2:])
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			fields = append(fields, line)
+			break
+		}
+		fields = append(fields, line[:end])
+		line = strings.TrimSpace(line[end:])
+	}
+	return fields
+}
+
+// parseCueTime parses mm:ss:ff, where a frame is 1/75 of a second. The
+// result is rounded up so that converting it back to samples is exact.
+func parseCueTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, &strconv.NumError{Func: "parseCueTime", Num: s, Err: strconv.ErrSyntax}
+	}
+	var v [3]int64
+	for i, p := range parts {
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil || n < 0 {
+			return 0, &strconv.NumError{Func: "parseCueTime", Num: s, Err: strconv.ErrSyntax}
+		}
+		v[i] = n
+	}
+	if v[1] >= 60 || v[2] >= 75 {
+		return 0, &strconv.NumError{Func: "parseCueTime", Num: s, Err: strconv.ErrRange}
+	}
+	frames := (v[0]*60+v[1])*75 + v[2]
+	return time.Duration((frames*int64(time.Second) + 74) / 75), nil
+}
+
+// SplitCue cuts r into one file per track of cue, or of its CUESHEET comment
+// when cue is nil.
+func SplitCue(r io.ReadSeeker, cue *CueSheet) ([][]byte, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	album, err := ReadOGG(r)
+	if err != nil {
+		return nil, err
+	}
+	if cue == nil {
+		sheet, ok := album.UnmappedFields["CUESHEET"]
+		if !ok {
+			return nil, &ErrInvalidCue{Reason: "no CUESHEET comment"}
+		}
+		if cue, err = ParseCueSheet(strings.NewReader(sheet)); err != nil {
+			return nil, err
+		}
+	}
+
+	src, err := analyzeTrim(r)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([][]byte, 0, len(cue.Tracks))
+	for i, track := range cue.Tracks {
+		end := untilEnd
+		if i+1 < len(cue.Tracks) {
+			end = cue.Tracks[i+1].Start
+		}
+		trimmed := new(bytes.Buffer)
+		if err := src.trim(r, trimmed, track.Start, end); err != nil {
+			return nil, err
+		}
+		out := new(bytes.Buffer)
+		tags := []*OggTag{cue.trackTag(album, i)}
+		if err := ConcatTags(out, tags, bytes.NewReader(trimmed.Bytes())); err != nil {
+			return nil, err
+		}
+		parts = append(parts, out.Bytes())
+	}
+	return parts, nil
+}
+
+// trackTag merges the album tags with the cue entries of track i.
+func (c *CueSheet) trackTag(album *OggTag, i int) *OggTag {
+	track := c.Tracks[i]
+	tag := *album
+	tag.UnmappedFields = make(map[string]string, len(album.UnmappedFields)+1)
+	for k, v := range album.UnmappedFields {
+		if k != "CUESHEET" && !trackGainField(k) {
+			tag.UnmappedFields[k] = v
+		}
+	}
+	if tag.Album == "" {
+		tag.Album = c.Title
+	}
+	if track.Title != "" {
+		tag.Title = track.Title
+	}
+	tag.TrackNumber = strconv.Itoa(track.Number)
+	tag.TrackTotal = strconv.Itoa(len(c.Tracks))
+	performer := track.Performer
+	if performer == "" {
+		performer = c.Performer
+	}
+	if performer != "" {
+		tag.UnmappedFields["PERFORMER"] = performer
+	}
+	return &tag
+}
@@ -0,0 +1,82 @@
+package oggmeta
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testCue = `REM GENRE Test
+PERFORMER "The Band"
+TITLE "The Album"
+FILE "album.ogg" WAVE
+  TRACK 01 AUDIO
+    TITLE "First"
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Second"
+    PERFORMER "Guest Singer"
+    INDEX 00 00:00:70
+    INDEX 01 00:01:00
+  TRACK 03 AUDIO
+    TITLE "Third"
+    INDEX 01 00:02:37
+`
+
+func TestParseCueSheet(t *testing.T) {
+	cue, err := ParseCueSheet(strings.NewReader(testCue))
+	assert.NoError(t, err)
+	assert.Equal(t, "The Album", cue.Title)
+	assert.Equal(t, "The Band", cue.Performer)
+	assert.Equal(t, []CueTrack{
+		{Number: 1, Title: "First", Start: 0},
+		{Number: 2, Title: "Second", Performer: "Guest Singer", Start: time.Second},
+		{Number: 3, Title: "Third", Start: 2*time.Second + 493333334},
+	}, cue.Tracks)
+
+	_, err = ParseCueSheet(strings.NewReader("TRACK 01 AUDIO\n"))
+	assert.IsType(t, &ErrInvalidCue{}, err)
+	_, err = ParseCueSheet(strings.NewReader("TRACK 01 AUDIO\nINDEX 01 00:02:00\nTRACK 02 AUDIO\nINDEX 01 00:01:00\n"))
+	assert.IsType(t, &ErrInvalidCue{}, err)
+}
+
+func TestSplitCue(t *testing.T) {
+	src, err := os.ReadFile("./testdata/testdata-ogg-vorbis-nonEmpty.ogg")
+	assert.NoError(t, err)
+	album, err := ReadOGG(bytes.NewReader(src))
+	assert.NoError(t, err)
+	full := decodeVorbisFile(t, src)
+
+	// embed the cue sheet in the source
+	album.UnmappedFields["CUESHEET"] = testCue
+	withCue := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(album, withCue))
+
+	parts, err := SplitCue(bytes.NewReader(withCue.Bytes()), nil)
+	assert.NoError(t, err)
+	if !assert.Len(t, parts, 3) {
+		return
+	}
+	starts := []int{0, 44100, 2*44100 + 37*588, len(full)}
+	for i, part := range parts {
+		verifyPages(t, part)
+		tag, err := ReadOGG(bytes.NewReader(part))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"First", "Second", "Third"}[i], tag.Title)
+		assert.Equal(t, []string{"1", "2", "3"}[i], tag.TrackNumber)
+		assert.Equal(t, "3", tag.TrackTotal)
+		assert.Equal(t, album.Artist, tag.Artist)
+		assert.Equal(t, album.Album, tag.Album)
+		assert.Equal(t, []string{"The Band", "Guest Singer", "The Band"}[i], tag.UnmappedFields["PERFORMER"])
+		assert.NotContains(t, tag.UnmappedFields, "CUESHEET")
+
+		pcm := decodeVorbisFile(t, part)
+		if assert.Len(t, pcm, starts[i+1]-starts[i]) {
+			assert.InDeltaSlice(t, full[starts[i]:starts[i+1]], pcm, 1e-6)
+		}
+	}
+}
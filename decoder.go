@@ -2,6 +2,7 @@ package oggmeta
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/binary"
 	"fmt"
@@ -22,7 +23,8 @@ func (dec *OGGDecoder) Decode() (*OGGPage, error) {
 		return nil, new(ErrInvalidOggs)
 	}
 
-	if oggPage.Header.Segments < 1 {
+	// only an EOS page may end a stream without a packet
+	if oggPage.Header.Segments < 1 && oggPage.Header.Flags&(FlagBOS|FlagEOS) != FlagEOS {
 		return nil, new(ErrBadSegs)
 	}
 
@@ -46,6 +48,7 @@ func (dec *OGGDecoder) Decode() (*OGGPage, error) {
 		packetContinues = segmentLength == MaxSegSize
 		payloadLength += int(segmentLength)
 	}
+	oggPage.continues = packetContinues
 
 	payloadBytes := make([]byte, 0)
 
@@ -71,12 +74,43 @@ func (dec *OGGDecoder) Decode() (*OGGPage, error) {
 		fmt.Println("CRC32 mismatch")
 	}
 
+	dec.bytesRead += int64(HeaderSize + len(segmentTable) + payloadLength)
+	dec.pagesRead++
+	if dec.Progress != nil {
+		dec.Progress(dec.bytesRead, dec.pagesRead)
+	}
+
 	return oggPage, nil
 }
 
+// ReadPacket returns the next complete packet, joining packets that span pages.
+// It shares the underlying reader with Decode, so the two should not be mixed.
+func (dec *OGGDecoder) ReadPacket() (*Packet, error) {
+	if dec.packets == nil {
+		dec.packets = newPacketReader(dec, 0, 0)
+	}
+	return dec.packets.next()
+}
+
 func (dec *OGGDecoder) ReadTags() (*OggTag, error) {
+	return dec.readTags(context.Background())
+}
+
+func (dec *OGGDecoder) readTags(ctx context.Context) (*OggTag, error) {
+	if matroska, err := isMatroska(dec.Reader); err != nil || matroska {
+		if err != nil {
+			return nil, err
+		}
+		return dec.readMatroska(ctx)
+	}
+	limits := dec.Limits.withDefaults()
+	packets := newPacketReader(dec, limits.MaxHeaderPacketSize, limits.MaxPages)
+	packets.ctx = ctx
+	var opusHead *OpusHead
+	side := newSideStreams()
+	audio := false // an audio stream whose comment is still to come was seen
 	for {
-		oggPage, err := dec.Decode()
+		packet, err := packets.next()
 		if err != nil {
 			if err == io.EOF {
 				return nil, nil
@@ -84,37 +118,199 @@ func (dec *OGGDecoder) ReadTags() (*OggTag, error) {
 			return nil, err
 		}
 
-		for _, packet := range oggPage.Packets {
-			switch {
-			case bytes.HasPrefix(packet, VorbisPrefix):
-				dec.TagReader = bytes.NewReader(packet)
-				io.ReadFull(dec.TagReader, make([]byte, len(VorbisPrefix)))
-				resultTag, err := dec.readComments()
-				if err != nil {
-					return nil, err
-				}
-				resultTag.reader = dec.Reader
-				resultTag.Codec = Vorbis
-				return resultTag, nil
-
-			case bytes.HasPrefix(packet, OpusPrefix):
-				dec.TagReader = bytes.NewReader(packet)
-				io.ReadFull(dec.TagReader, make([]byte, len(OpusPrefix)))
-				resultTag, err := dec.readComments()
-				if err != nil {
-					return nil, err
-				}
-				resultTag.reader = dec.Reader
-				resultTag.Codec = Opus
-				return resultTag, nil
+		if handled, err := side.packet(dec, packet); err != nil {
+			return nil, err
+		} else if handled {
+			if main := side.main(); main != nil && !audio {
+				return dec.withSideStreams(main, packets, side)
+			}
+			continue
+		}
+
+		switch {
+		case packet.BOS && bytes.HasPrefix(packet.Data, OpusHeadPrefix):
+			if opusHead, err = parseOpusHead(packet.Data); err != nil {
+				return nil, err
+			}
+			audio = true
+
+		case packet.BOS && bytes.HasPrefix(packet.Data, VorbisIdentPrefix):
+			audio = true
+
+		case bytes.HasPrefix(packet.Data, VorbisPrefix):
+			dec.TagReader = bytes.NewReader(packet.Data)
+			io.ReadFull(dec.TagReader, make([]byte, len(VorbisPrefix)))
+			resultTag, err := dec.readComments()
+			if err != nil {
+				return nil, err
+			}
+			resultTag.Codec = Vorbis
+			return dec.withSideStreams(resultTag, packets, side)
+
+		case packet.BOS && bytes.HasPrefix(packet.Data, FLACPrefix):
+			resultTag, err := dec.readFLACHeaders(packet, packets, side)
+			if err != nil {
+				return nil, err
+			}
+			return dec.withSideStreams(resultTag, packets, side)
+
+		case packet.BOS && bytes.HasPrefix(packet.Data, SpeexPrefix):
+			resultTag, err := dec.readSpeexComment(packet, packets, side)
+			if err != nil {
+				return nil, err
+			}
+			return dec.withSideStreams(resultTag, packets, side)
+
+		case bytes.HasPrefix(packet.Data, OpusPrefix):
+			dec.TagReader = bytes.NewReader(packet.Data)
+			io.ReadFull(dec.TagReader, make([]byte, len(OpusPrefix)))
+			resultTag, err := dec.readComments()
+			if err != nil {
+				return nil, err
 			}
+			resultTag.Codec = Opus
+			resultTag.OpusHead = opusHead
+			return dec.withSideStreams(resultTag, packets, side)
 		}
 	}
 }
 
+// sideStreams are the video and subtitle streams ReadTags has seen so far.
+type sideStreams struct {
+	theoraSerial uint32
+	theora       *TheoraHeader
+	theoraTag    *OggTag
+
+	kateSerials []uint32
+	kates       map[uint32]*KateHeader
+	kateTags    map[uint32]*OggTag
+
+	missed map[uint32]bool // streams whose data began before their comment was seen
+}
+
+func newSideStreams() *sideStreams {
+	return &sideStreams{
+		kates:    make(map[uint32]*KateHeader),
+		kateTags: make(map[uint32]*OggTag),
+		missed:   make(map[uint32]bool),
+	}
+}
+
+// packet reads packet if it is a header of a side stream.
+func (s *sideStreams) packet(dec *OGGDecoder, packet *Packet) (bool, error) {
+	var err error
+	switch {
+	case packet.BOS && bytes.HasPrefix(packet.Data, TheoraIdentPrefix):
+		if s.theora, err = parseTheoraHeader(packet.Data); err != nil {
+			return false, err
+		}
+		s.theoraSerial = packet.Serial
+
+	case bytes.HasPrefix(packet.Data, TheoraPrefix):
+		if s.theoraTag, err = dec.readTheoraComment(packet.Data, s.theora); err != nil {
+			return false, err
+		}
+		s.theoraTag.serial = packet.Serial
+
+	case packet.BOS && bytes.HasPrefix(packet.Data, KateIdentPrefix):
+		head, err := parseKateHeader(packet.Data)
+		if err != nil {
+			return false, err
+		}
+		s.kateSerials = append(s.kateSerials, packet.Serial)
+		s.kates[packet.Serial] = head
+
+	case bytes.HasPrefix(packet.Data, KatePrefix):
+		tag, err := dec.readKateComment(packet.Data, s.kates[packet.Serial])
+		if err != nil {
+			return false, err
+		}
+		tag.serial = packet.Serial
+		s.kateTags[packet.Serial] = tag
+
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// nextPacketOf returns the next packet of serial, reading the headers of the
+// side streams it passes.
+func (s *sideStreams) nextPacketOf(dec *OGGDecoder, packets *packetReader, serial uint32) (*Packet, error) {
+	for {
+		packet, err := packets.next()
+		if err != nil || packet.Serial == serial {
+			return packet, err
+		}
+		if _, err := s.packet(dec, packet); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// main returns the tags that stand for a file without audio once they are read.
+func (s *sideStreams) main() *OggTag {
+	if s.theora != nil {
+		return s.theoraTag
+	}
+	if len(s.kateSerials) > 0 {
+		return s.kateTags[s.kateSerials[0]]
+	}
+	return nil
+}
+
+// missing reports whether the comment of a side stream is still to come,
+// given the packet read last.
+func (s *sideStreams) missing(packet *Packet) bool {
+	if packet != nil && len(packet.Data) > 0 && packet.Data[0]&0x80 == 0 {
+		// data packets have the top bit clear, headers have it set
+		s.missed[packet.Serial] = true
+	}
+	if s.theora != nil && s.theoraTag == nil && !s.missed[s.theoraSerial] {
+		return true
+	}
+	for _, serial := range s.kateSerials {
+		if s.kateTags[serial] == nil && !s.missed[serial] {
+			return true
+		}
+	}
+	return false
+}
+
+// withSideStreams finishes tag, attaching the side streams of a grouped file
+// and reading on to their comment headers if those come later.
+func (dec *OGGDecoder) withSideStreams(tag *OggTag, packets *packetReader, side *sideStreams) (*OggTag, error) {
+	tag.reader = dec.Reader
+	var packet *Packet
+	for side.missing(packet) {
+		var err error
+		packet, err = packets.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := side.packet(dec, packet); err != nil {
+			return nil, err
+		}
+	}
+	if tag.Codec != Theora && side.theora != nil {
+		tag.TheoraHeader = side.theora
+		tag.TheoraTag = side.theoraTag
+	}
+	for _, serial := range side.kateSerials {
+		if kate := side.kateTags[serial]; kate != nil && kate != tag {
+			tag.KateTags = append(tag.KateTags, kate)
+		}
+	}
+	return tag, nil
+}
+
 func (dec *OGGDecoder) readComments() (*OggTag, error) {
+	limits := dec.Limits.withDefaults()
 	oggTag := new(OggTag)
-	vendorLength, err := readUint32(dec.TagReader)
+	vendorLength, err := dec.readTagLength("vendor length", limits.MaxFieldLength)
 	if err != nil {
 		return nil, err
 	}
@@ -128,17 +324,31 @@ func (dec *OGGDecoder) readComments() (*OggTag, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := checkLimit("comment count", int64(commentsLength), limits.MaxCommentCount); err != nil {
+		return nil, err
+	}
 
+	maxPictureField := int64(len(pictureFieldPrefix) + base64.StdEncoding.EncodedLen(int(limits.MaxPictureSize)))
 	for i := uint32(0); i < commentsLength; i++ {
-		commentLength, err := readUint32(dec.TagReader)
+		commentLength, err := dec.readTagLength("comment length", maxPictureField)
 		if err != nil {
 			return nil, err
 		}
+		if int64(commentLength) > limits.MaxFieldLength {
+			// only a picture may be longer than an ordinary field
+			isPicture, err := dec.peekPrefix(pictureFieldPrefix)
+			if err != nil {
+				return nil, err
+			}
+			if !isPicture {
+				return nil, &ErrLimitExceeded{Limit: "comment length", Value: int64(commentLength), Max: limits.MaxFieldLength}
+			}
+		}
 		comment, err := readString(dec.TagReader, uint(commentLength))
 		if err != nil {
 			return nil, err
 		}
-		splitComment := strings.Split(comment, "=")
+		splitComment := strings.SplitN(comment, "=", 2)
 		if len(splitComment) != 2 {
 			continue
 		}
@@ -146,37 +356,106 @@ func (dec *OGGDecoder) readComments() (*OggTag, error) {
 		fieldValue := splitComment[1]
 		if fieldName == "METADATA_BLOCK_PICTURE" {
 			// process picture block
-			data, err := base64.StdEncoding.DecodeString(fieldValue)
-			if err != nil {
+			if err := checkLimit("picture size", int64(base64.StdEncoding.DecodedLen(len(fieldValue))), limits.MaxPictureSize); err != nil {
 				return nil, err
 			}
-			data, err = dec.readPictureBlock(data)
+			data, err := base64.StdEncoding.DecodeString(fieldValue)
 			if err != nil {
 				return nil, err
 			}
-			if len(data) > 0 {
-				if img, _, err := image.Decode(bytes.NewReader(data)); err != nil {
-					return nil, err
-				} else {
-					oggTag.CoverArt = &img
-				}
+			if err := dec.readCoverArt(oggTag, data); err != nil {
+				return nil, err
 			}
 
 		}
-		if tagField, ok := tagFieldMapping[fieldName]; ok {
-			field := reflect.ValueOf(oggTag).Elem().FieldByName(tagField)
-			field.SetString(fieldValue)
-		} else {
-			if oggTag.UnmappedFields == nil {
-				oggTag.UnmappedFields = make(map[string]string)
-			}
-			oggTag.UnmappedFields[fieldName] = fieldValue
-		}
+		setCommentField(oggTag, fieldName, fieldValue)
 	}
 	return oggTag, nil
 }
 
+// commentField returns the value of the struct member a comment field maps to.
+func commentField(tag *OggTag, name string) string {
+	return reflect.ValueOf(tag).Elem().FieldByName(tagFieldMapping[name]).String()
+}
+
+// setCommentField sets the struct member a comment field maps to, or the
+// unmapped field of that name.
+func setCommentField(tag *OggTag, name, value string) {
+	if tagField, ok := tagFieldMapping[name]; ok {
+		field := reflect.ValueOf(tag).Elem().FieldByName(tagField)
+		field.SetString(value)
+	} else {
+		if tag.UnmappedFields == nil {
+			tag.UnmappedFields = make(map[string]string)
+		}
+		tag.UnmappedFields[name] = value
+	}
+}
+
+// readTagLength reads a 32-bit length from the comment header and rejects it
+// before anything is allocated if it breaks the limit or overruns the packet.
+func (dec *OGGDecoder) readTagLength(name string, max int64) (uint32, error) {
+	n, err := readUint32(dec.TagReader)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkLimit(name, int64(n), max); err != nil {
+		return 0, err
+	}
+	remaining, err := bytesRemaining(dec.TagReader)
+	if err != nil {
+		return 0, err
+	}
+	if int64(n) > remaining {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return n, nil
+}
+
+// peekPrefix reports whether the tag reader continues with prefix, without consuming it.
+func (dec *OGGDecoder) peekPrefix(prefix string) (bool, error) {
+	b, err := readBytes(dec.TagReader, uint(len(prefix)))
+	if err != nil {
+		return false, err
+	}
+	if _, err := dec.TagReader.Seek(-int64(len(b)), io.SeekCurrent); err != nil {
+		return false, err
+	}
+	return strings.EqualFold(string(b), prefix), nil
+}
+
+// readCoverArt decodes a picture metadata block into the cover art of tag.
+func (dec *OGGDecoder) readCoverArt(tag *OggTag, block []byte) error {
+	data, err := dec.readPictureBlock(block)
+	if err != nil {
+		return err
+	}
+	return dec.decodeCoverArt(tag, data)
+}
+
+// decodeCoverArt decodes an image file into the cover art of tag.
+func (dec *OGGDecoder) decodeCoverArt(tag *OggTag, data []byte) error {
+	limits := dec.Limits.withDefaults()
+	if len(data) == 0 {
+		return nil
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if err := checkLimit("picture pixels", int64(config.Width)*int64(config.Height), limits.MaxPicturePixels); err != nil {
+		return err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	tag.CoverArt = &img
+	return nil
+}
+
 func (dec *OGGDecoder) readPictureBlock(data []byte) ([]byte, error) {
+	limits := dec.Limits.withDefaults()
 	reader := bytes.NewReader(data)
 	//skipping picture type
 	if _, err := readInt(reader, 4); err != nil {
@@ -186,6 +465,9 @@ func (dec *OGGDecoder) readPictureBlock(data []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if int64(mimeLen) > int64(reader.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
 	//skipping mime type
 	if _, err := readString(reader, mimeLen); err != nil {
 		return nil, err
@@ -194,6 +476,9 @@ func (dec *OGGDecoder) readPictureBlock(data []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if int64(descLen) > int64(reader.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
 	//skipping description
 	if _, err := readString(reader, descLen); err != nil {
 		return nil, err
@@ -222,6 +507,12 @@ func (dec *OGGDecoder) readPictureBlock(data []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := checkLimit("picture size", int64(dataLen), limits.MaxPictureSize); err != nil {
+		return nil, err
+	}
+	if dataLen > reader.Len() {
+		return nil, io.ErrUnexpectedEOF
+	}
 	output := make([]byte, dataLen)
 	if _, err = io.ReadFull(reader, output); err != nil {
 		return nil, err
@@ -0,0 +1,311 @@
+package oggmeta
+
+import (
+	"bytes"
+	"io"
+	"math"
+)
+
+// EBML and Matroska element IDs, length marker included.
+const (
+	ebmlHeaderID             = 0x1A45DFA3
+	ebmlVersionID            = 0x4286
+	ebmlReadVersionID        = 0x42F7
+	ebmlMaxIDLengthID        = 0x42F2
+	ebmlMaxSizeLengthID      = 0x42F3
+	ebmlDocTypeID            = 0x4282
+	ebmlDocTypeVersionID     = 0x4287
+	ebmlDocTypeReadVersionID = 0x4285
+	ebmlVoidID               = 0xEC
+	ebmlCRC32ID              = 0xBF
+
+	mkvSegmentID         = 0x18538067
+	mkvSeekHeadID        = 0x114D9B74
+	mkvSeekID            = 0x4DBB
+	mkvSeekIDID          = 0x53AB
+	mkvSeekPositionID    = 0x53AC
+	mkvInfoID            = 0x1549A966
+	mkvTimestampScaleID  = 0x2AD7B1
+	mkvDurationID        = 0x4489
+	mkvMuxingAppID       = 0x4D80
+	mkvWritingAppID      = 0x5741
+	mkvTracksID          = 0x1654AE6B
+	mkvTrackEntryID      = 0xAE
+	mkvTrackNumberID     = 0xD7
+	mkvTrackUIDID        = 0x73C5
+	mkvTrackTypeID       = 0x83
+	mkvCodecIDID         = 0x86
+	mkvCodecPrivateID    = 0x63A2
+	mkvCodecDelayID      = 0x56AA
+	mkvSeekPreRollID     = 0x56BB
+	mkvAudioID           = 0xE1
+	mkvSamplingFreqID    = 0xB5
+	mkvChannelsID        = 0x9F
+	mkvClusterID         = 0x1F43B675
+	mkvTimestampID       = 0xE7
+	mkvSimpleBlockID     = 0xA3
+	mkvBlockGroupID      = 0xA0
+	mkvBlockID           = 0xA1
+	mkvDiscardPaddingID  = 0x75A2
+	mkvCuesID            = 0x1C53BB6B
+	mkvCuePointID        = 0xBB
+	mkvCueTimeID         = 0xB3
+	mkvCuePositionsID    = 0xB7
+	mkvCueTrackID        = 0xF7
+	mkvCueClusterPosID   = 0xF1
+	mkvChaptersID        = 0x1043A770
+	mkvAttachmentsID     = 0x1941A469
+	mkvAttachedFileID    = 0x61A7
+	mkvFileMimeTypeID    = 0x4660
+	mkvFileDataID        = 0x465C
+	mkvTagsID            = 0x1254C367
+	mkvTagID             = 0x7373
+	mkvTargetsID         = 0x63C0
+	mkvTargetTypeValueID = 0x68CA
+	mkvTagTrackUIDID     = 0x63C5
+	mkvSimpleTagID       = 0x67C8
+	mkvTagNameID         = 0x45A3
+	mkvTagStringID       = 0x4487
+)
+
+// ebmlTopLevel are the elements that end a Cluster of unknown size.
+var ebmlTopLevel = map[uint32]bool{
+	ebmlHeaderID: true, mkvSegmentID: true, mkvSeekHeadID: true, mkvInfoID: true, mkvTracksID: true,
+	mkvClusterID: true, mkvCuesID: true, mkvChaptersID: true, mkvAttachmentsID: true, mkvTagsID: true,
+}
+
+// ebmlElement is the header of an EBML element.
+type ebmlElement struct {
+	id     uint32
+	offset int64 // of the element
+	data   int64 // of its data
+	size   int64 // of its data, -1 if unknown
+}
+
+func (e ebmlElement) end() int64 {
+	return e.data + e.size
+}
+
+// ebmlReader reads EBML element headers, keeping track of its offset in r.
+type ebmlReader struct {
+	r   io.ReadSeeker
+	pos int64
+	buf [1]byte
+}
+
+func (er *ebmlReader) readByte() (byte, error) {
+	if _, err := io.ReadFull(er.r, er.buf[:]); err != nil {
+		return 0, err
+	}
+	er.pos++
+	return er.buf[0], nil
+}
+
+// vint reads a variable length integer, with its length marker for an
+// element ID. The second value reports a size of all ones, which means unknown.
+func (er *ebmlReader) vint(id bool) (uint64, bool, error) {
+	first, err := er.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	width := 1
+	for mask := byte(0x80); first&mask == 0; mask >>= 1 {
+		if mask == 1 {
+			return 0, false, &ErrInvalidMatroska{Reason: "invalid variable length integer"}
+		}
+		width++
+	}
+	if id && width > 4 {
+		return 0, false, &ErrInvalidMatroska{Reason: "element ID too long"}
+	}
+	v := uint64(first)
+	if !id {
+		v &= 0xff >> uint(width)
+	}
+	for i := 1; i < width; i++ {
+		b, err := er.readByte()
+		if err != nil {
+			return 0, false, unexpectedEOF(err)
+		}
+		v = v<<8 | uint64(b)
+	}
+	return v, !id && v == 1<<(7*uint(width))-1, nil
+}
+
+// element reads the header of the element at the current offset.
+func (er *ebmlReader) element() (ebmlElement, error) {
+	e := ebmlElement{offset: er.pos}
+	id, _, err := er.vint(true)
+	if err != nil {
+		return e, err
+	}
+	size, unknown, err := er.vint(false)
+	if err != nil {
+		return e, unexpectedEOF(err)
+	}
+	e.id, e.data, e.size = uint32(id), er.pos, int64(size)
+	if unknown {
+		e.size = -1
+	} else if e.size < 0 {
+		return e, &ErrInvalidMatroska{Reason: "element size out of range"}
+	}
+	return e, nil
+}
+
+func (er *ebmlReader) seek(pos int64) error {
+	if _, err := er.r.Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+	er.pos = pos
+	return nil
+}
+
+// read returns the data of e, which must be of known size within max.
+func (er *ebmlReader) read(e ebmlElement, name string, max int64) ([]byte, error) {
+	if e.size < 0 {
+		return nil, &ErrInvalidMatroska{Reason: name + " has unknown size"}
+	}
+	if err := checkLimit(name, e.size, max); err != nil {
+		return nil, err
+	}
+	if err := er.seek(e.data); err != nil {
+		return nil, err
+	}
+	data := make([]byte, e.size)
+	if _, err := io.ReadFull(er.r, data); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	er.pos += e.size
+	return data, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ebmlChild is an element of a master element held in memory.
+type ebmlChild struct {
+	id   uint32
+	data []byte
+	raw  []byte // the whole element
+}
+
+// ebmlChildren splits the data of a master element into its elements.
+func ebmlChildren(b []byte) ([]ebmlChild, error) {
+	var children []ebmlChild
+	for len(b) > 0 {
+		er := &ebmlReader{r: bytes.NewReader(b)}
+		e, err := er.element()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if e.size < 0 || e.size > int64(len(b))-e.data {
+			return nil, &ErrInvalidMatroska{Reason: "element overruns its parent"}
+		}
+		children = append(children, ebmlChild{id: e.id, data: b[e.data:e.end()], raw: b[:e.end()]})
+		b = b[e.end():]
+	}
+	return children, nil
+}
+
+// ebmlUint decodes an unsigned integer element.
+func ebmlUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// ebmlInt decodes a signed integer element.
+func ebmlInt(b []byte) int64 {
+	if len(b) == 0 {
+		return 0
+	}
+	v := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v
+}
+
+func appendEBMLID(b []byte, id uint32) []byte {
+	switch {
+	case id > 0xffffff:
+		return append(b, byte(id>>24), byte(id>>16), byte(id>>8), byte(id))
+	case id > 0xffff:
+		return append(b, byte(id>>16), byte(id>>8), byte(id))
+	case id > 0xff:
+		return append(b, byte(id>>8), byte(id))
+	}
+	return append(b, byte(id))
+}
+
+// appendEBMLSize appends size as a variable length integer of width bytes,
+// or of the fewest bytes that hold it for a width of 0.
+func appendEBMLSize(b []byte, size uint64, width int) []byte {
+	if width == 0 {
+		width = 1
+		// all ones is reserved for an unknown size
+		for size >= 1<<(7*uint(width))-1 {
+			width++
+		}
+	}
+	size |= 1 << (7 * uint(width))
+	for i := width - 1; i >= 0; i-- {
+		b = append(b, byte(size>>(8*uint(i))))
+	}
+	return b
+}
+
+func appendEBMLElement(b []byte, id uint32, data []byte) []byte {
+	b = appendEBMLID(b, id)
+	b = appendEBMLSize(b, uint64(len(data)), 0)
+	return append(b, data...)
+}
+
+func appendEBMLUint(b []byte, id uint32, v uint64) []byte {
+	n := 1
+	for n < 8 && v>>(8*uint(n)) != 0 {
+		n++
+	}
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(v >> (8 * uint(n-1-i)))
+	}
+	return appendEBMLElement(b, id, data)
+}
+
+func appendEBMLInt(b []byte, id uint32, v int64) []byte {
+	n := 1
+	for n < 8 && (v>>(8*uint(n)-1) != 0 && v>>(8*uint(n)-1) != -1) {
+		n++
+	}
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(v >> (8 * uint(n-1-i)))
+	}
+	return appendEBMLElement(b, id, data)
+}
+
+func appendEBMLFloat(b []byte, id uint32, v float64) []byte {
+	bits := math.Float64bits(v)
+	data := make([]byte, 8)
+	for i := range data {
+		data[i] = byte(bits >> (8 * uint(7-i)))
+	}
+	return appendEBMLElement(b, id, data)
+}
+
+// ebmlVoid returns a Void element n bytes long, n being at least 2.
+func ebmlVoid(n int) []byte {
+	width := 1
+	if n-2 >= 127 {
+		width = 8
+	}
+	b := appendEBMLSize([]byte{ebmlVoidID}, uint64(n-1-width), width)
+	return append(b, make([]byte, n-1-width)...)
+}
@@ -2,6 +2,7 @@ package oggmeta
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
@@ -12,6 +13,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 
 	"github.com/aler9/writerseeker"
 )
@@ -117,6 +119,12 @@ func (enc *OGGEncoder) EncodeEOS(granule int64, packets [][]byte) error {
 	return enc.WritePackets(FlagEOS, granule, packets)
 }
 
+// encodeEmptyEOS writes an end-of-stream page that carries no packet.
+func (enc *OGGEncoder) encodeEmptyEOS(granule int64) error {
+	header := OGGPageHeader{Oggs: Oggs, Flags: FlagEOS, GranulePosition: granule, SerialNumber: enc.Serial}
+	return enc.writePage(&header, nil, segmentizePayload{})
+}
+
 func (enc *OGGEncoder) writePage(h *OGGPageHeader, segtbl []byte, pay segmentizePayload) error {
 	page := &OGGPage{}
 	h.PageSequenceNumber = enc.PageNumber
@@ -155,23 +163,97 @@ func (enc *OGGEncoder) writePage(h *OGGPageHeader, segtbl []byte, pay segmentize
 }
 
 func SaveTags(tag *OggTag, writer io.Writer) error {
+	return SaveTagsContext(context.Background(), tag, writer, SaveOptions{})
+}
+
+// SaveTagsContext is like SaveTags but stops with the context's error once ctx
+// is done, checking between pages. Nothing is written to writer in that case.
+func SaveTagsContext(ctx context.Context, tag *OggTag, writer io.Writer, opts SaveOptions) error {
 	if _, err := tag.reader.Seek(0, 0); err != nil {
 		return err
 	}
 	tempWriter := &writerseeker.WriterSeeker{}
-	decoder := &OGGDecoder{Reader: tag.reader}
-	encoder := &OGGEncoder{Writer: tempWriter}
+	if matroska, err := isMatroska(tag.reader); err != nil || matroska {
+		if err != nil {
+			return err
+		}
+		saved, err := saveMatroska(ctx, tag)
+		if err != nil {
+			return err
+		}
+		if _, err = tempWriter.Write(saved); err != nil {
+			return err
+		}
+		// a Skeleton index is for Ogg files only
+		return flushSaved(ctx, tempWriter, writer, SaveOptions{})
+	}
+	decoder := &OGGDecoder{Reader: tag.reader, Progress: opts.Progress}
 
 	page, err := decoder.Decode()
 	if err != nil {
 		return err
 	}
+	if len(page.Packets) == 0 {
+		return new(ErrBadSegs)
+	}
+	encoder := &OGGEncoder{Writer: tempWriter, Serial: page.Header.SerialNumber}
+
+	// FLAC and Speex streams are known by their first page, which need not be
+	// the first page of the file
+	flacs := make(map[uint32]*flacHeaders)
+	speexComments := make(map[uint32]bool)
+	startStream := func(page *OGGPage) error {
+		switch {
+		case bytes.HasPrefix(page.Packets[0], FLACPrefix):
+			flac, err := saveFLACHeaders(ctx, tag, page, tag.reader, decoder.bytesRead)
+			if err != nil {
+				return err
+			}
+			flacs[page.Header.SerialNumber] = flac
+			page.Packets[0] = flac.first
+		case bytes.HasPrefix(page.Packets[0], SpeexPrefix):
+			// a Speex comment has no prefix, it is the packet after the header
+			speexComments[page.Header.SerialNumber] = true
+		}
+		return nil
+	}
+	if err = startStream(page); err != nil {
+		return err
+	}
+
+	var granules *vorbisGranules
+	var start int64
+	if opts.FixGranules && bytes.HasPrefix(page.Packets[0], VorbisIdentPrefix) {
+		stats, err := analyzeVorbis(ctx, tag.reader)
+		if err != nil {
+			return err
+		}
+		start = stats.StartGranule
+		granules = newVorbisGranules()
+		if _, err = granules.page(page); err != nil {
+			return err
+		}
+		if _, err = tag.reader.Seek(decoder.bytesRead, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	if tag.OpusHead != nil && bytes.HasPrefix(page.Packets[0], OpusHeadPrefix) {
+		if err = tag.OpusHead.validate(); err != nil {
+			return err
+		}
+		page.Packets[0] = tag.OpusHead.toBytesSlice()
+	}
 
 	if err = encoder.EncodeBOS(page.Header.GranulePosition, page.Packets); err != nil {
 		return err
 	}
 
+	encoders := map[uint32]*OGGEncoder{encoder.Serial: encoder}
 	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
 		page, err = decoder.Decode()
 		if err != nil {
 			if err == io.EOF {
@@ -179,37 +261,89 @@ func SaveTags(tag *OggTag, writer io.Writer) error {
 			}
 			return err
 		}
-
-		if bytes.HasPrefix(page.Packets[0], VorbisPrefix) || bytes.HasPrefix(page.Packets[0], OpusPrefix) {
-			commentFields := make([]string, 0)
-			for key, value := range tagFieldMapping {
-				if reflect.ValueOf(tag).Elem().FieldByName(value).IsValid() {
-					commentFields = append(commentFields, fmt.Sprintf("%s=%s", key, reflect.ValueOf(tag).Elem().FieldByName(value).String()))
-				}
+		if granules != nil && page.Header.SerialNumber == encoder.Serial {
+			samples, err := granules.page(page)
+			if err != nil {
+				return err
 			}
-			img := make([]byte, 0)
-			if tag.CoverArt != nil {
-				// Convert album art image to JPEG format
-				buf := new(bytes.Buffer)
-				if err = jpeg.Encode(buf, *tag.CoverArt, nil); err == nil {
-					img, _ = createMetadataBlockPicture(buf.Bytes())
-				}
+			switch {
+			case granules.audio:
+				page.Header.GranulePosition = vorbisPage{
+					granule: page.Header.GranulePosition,
+					samples: samples,
+					last:    granules.last,
+					eos:     page.Header.Flags&FlagEOS != 0,
+				}.expected(start)
+			case samples < 0:
+				page.Header.GranulePosition = -1
+			default:
+				page.Header.GranulePosition = 0
+			}
+		}
+
+		// every logical stream keeps its own serial and page sequence
+		enc := encoders[page.Header.SerialNumber]
+		if enc == nil {
+			enc = &OGGEncoder{Writer: tempWriter, Serial: page.Header.SerialNumber}
+			encoders[enc.Serial] = enc
+		}
+		if page.Header.Flags&FlagBOS != 0 {
+			if err = startStream(page); err != nil {
+				return err
 			}
-			page.Packets[0] = createCommentPacket(commentFields, img, tag.Codec)
-			if err = encoder.Encode(page.Header.GranulePosition, page.Packets); err != nil {
+			if err = enc.copyPage(page); err != nil {
 				return err
 			}
-		} else {
-			if page.Header.Flags == FlagEOS {
-				if err = encoder.EncodeEOS(page.Header.GranulePosition, page.Packets); err != nil {
-					return err
-				}
-			} else {
-				if err = encoder.Encode(page.Header.GranulePosition, page.Packets); err != nil {
+			continue
+		}
+		if flac := flacs[enc.Serial]; flac != nil {
+			// the rebuilt metadata takes the place of the old header pages
+			if flac.blocks != nil {
+				if err = enc.Encode(0, flac.blocks); err != nil {
 					return err
 				}
+				flac.blocks = nil
+			}
+			if flac.pages > 0 {
+				flac.pages--
+				continue
 			}
+			delete(flacs, enc.Serial)
+		}
+		var comment []byte
+		switch {
+		case len(page.Packets) == 0:
+			// an EOS page without a packet
+		case bytes.HasPrefix(page.Packets[0], VorbisPrefix) || bytes.HasPrefix(page.Packets[0], OpusPrefix):
+			comment = tagCommentPacket(tag)
+		case speexComments[enc.Serial]:
+			comment = tagCommentPacket(tag)
+			delete(speexComments, enc.Serial)
+		case bytes.HasPrefix(page.Packets[0], TheoraPrefix):
+			comment = theoraCommentPacket(tag)
+		case bytes.HasPrefix(page.Packets[0], KatePrefix):
+			comment = kateCommentPacket(tag, enc.Serial)
+		}
+		if comment != nil {
+			page.Packets[0] = comment
+			if err = enc.Encode(page.Header.GranulePosition, page.Packets); err != nil {
+				return err
+			}
+		} else if err = enc.copyPage(page); err != nil {
+			return err
+		}
+	}
+	return flushSaved(ctx, tempWriter, writer, opts)
+}
+
+// flushSaved copies the file SaveTags built in memory to writer.
+func flushSaved(ctx context.Context, tempWriter *writerseeker.WriterSeeker, writer io.Writer, opts SaveOptions) error {
+	if opts.SkeletonIndex {
+		indexed := &writerseeker.WriterSeeker{}
+		if err := indexSkeleton(ctx, tempWriter.BytesReader(), indexed); err != nil {
+			return err
 		}
+		tempWriter = indexed
 	}
 	if reflect.TypeOf(writer) == reflect.TypeOf(new(os.File)) {
 		path, err := filepath.Abs((writer.(*os.File)).Name())
@@ -235,6 +369,55 @@ func SaveTags(tag *OggTag, writer io.Writer) error {
 	return nil
 }
 
+// tagCommentPacket builds the comment header packet that SaveTags writes for tag.
+func tagCommentPacket(tag *OggTag) []byte {
+	return createCommentPacket(tagCommentFields(tag), tagPicture(tag), tag.Codec)
+}
+
+// tagCommentFields returns the mapped fields of tag followed by UnmappedFields,
+// without the picture.
+func tagCommentFields(tag *OggTag) []string {
+	commentFields := make([]string, 0)
+	for key, value := range tagFieldMapping {
+		if reflect.ValueOf(tag).Elem().FieldByName(value).IsValid() {
+			commentFields = append(commentFields, fmt.Sprintf("%s=%s", key, reflect.ValueOf(tag).Elem().FieldByName(value).String()))
+		}
+	}
+	return append(commentFields, unmappedCommentFields(tag)...)
+}
+
+// tagPicture returns the cover art of tag as a picture metadata block, or
+// nothing if there is none.
+func tagPicture(tag *OggTag) []byte {
+	img := make([]byte, 0)
+	if tag.CoverArt != nil {
+		// Convert album art image to JPEG format
+		buf := new(bytes.Buffer)
+		if err := jpeg.Encode(buf, *tag.CoverArt, nil); err == nil {
+			img, _ = createMetadataBlockPicture(buf.Bytes())
+		}
+	}
+	return img
+}
+
+// unmappedCommentFields returns the fields without a struct member in sorted
+// order. The picture is left out as it is rebuilt from CoverArt.
+func unmappedCommentFields(tag *OggTag) []string {
+	keys := make([]string, 0, len(tag.UnmappedFields))
+	for key := range tag.UnmappedFields {
+		if _, mapped := tagFieldMapping[key]; mapped || key == "METADATA_BLOCK_PICTURE" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fields := make([]string, 0, len(keys))
+	for _, key := range keys {
+		fields = append(fields, fmt.Sprintf("%s=%s", key, tag.UnmappedFields[key]))
+	}
+	return fields
+}
+
 func createMetadataBlockPicture(albumArtData []byte) ([]byte, error) {
 	mimeType := "image/jpeg"
 	description := "Cover"
@@ -275,9 +458,16 @@ func createCommentPacket(commentFields []string, albumArt []byte, codec string)
 		commentPacket = append(commentPacket, buf...)
 		commentPacket = append(commentPacket, []byte(field)...)
 	}
-	if codec == Vorbis {
+	switch codec {
+	case Vorbis:
 		commentPacket = append([]byte("\x03vorbis"), commentPacket...)
-	} else {
+	case Theora:
+		commentPacket = append(append([]byte{}, TheoraPrefix...), commentPacket...)
+	case Kate:
+		commentPacket = append(append(append([]byte{}, KatePrefix...), 0), commentPacket...)
+	case FLAC, Speex:
+		// a FLAC VORBIS_COMMENT block and a Speex comment have no prefix
+	default:
 		commentPacket = append([]byte("OpusTags"), commentPacket...)
 	}
 	if len(albumArt) > 1 {
@@ -288,7 +478,7 @@ func createCommentPacket(commentFields []string, albumArt []byte, codec string)
 		commentPacket = append(commentPacket, []byte("METADATA_BLOCK_PICTURE=")...)
 		commentPacket = append(commentPacket, []byte(albumArtBase64)...)
 	}
-	if codec == Vorbis {
+	if codec == Vorbis || codec == Kate {
 		commentPacket = append(commentPacket, []byte("\x01")...)
 	}
 
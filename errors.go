@@ -1,5 +1,10 @@
 package oggmeta
 
+import (
+	"fmt"
+	"time"
+)
+
 type ErrInvalidOggs struct{}
 
 func (e *ErrInvalidOggs) Error() string {
@@ -11,3 +16,120 @@ type ErrBadSegs struct{}
 func (e *ErrBadSegs) Error() string {
 	return "ogg page has invalid number of segments"
 }
+
+// ErrLimitExceeded is returned when a value read from the file is larger than
+// the decoder's configured Limits allow.
+type ErrLimitExceeded struct {
+	Limit string
+	Value int64
+	Max   int64
+}
+
+func (e *ErrLimitExceeded) Error() string {
+	return fmt.Sprintf("ogg %s of %d exceeds limit of %d", e.Limit, e.Value, e.Max)
+}
+
+type ErrInvalidOpusHead struct {
+	Reason string
+}
+
+func (e *ErrInvalidOpusHead) Error() string {
+	return "invalid OpusHead: " + e.Reason
+}
+
+type ErrInvalidGain struct {
+	Field string
+	Value float64
+}
+
+func (e *ErrInvalidGain) Error() string {
+	return fmt.Sprintf("invalid value %v for %s", e.Value, e.Field)
+}
+
+type ErrUnsupportedCodec struct{}
+
+func (e *ErrUnsupportedCodec) Error() string {
+	return "ogg stream has no supported audio codec"
+}
+
+type ErrInvalidRange struct {
+	Start, End time.Duration
+}
+
+func (e *ErrInvalidRange) Error() string {
+	return fmt.Sprintf("invalid range from %v to %v", e.Start, e.End)
+}
+
+type ErrInvalidLink struct {
+	Index  int
+	Reason string
+}
+
+func (e *ErrInvalidLink) Error() string {
+	return fmt.Sprintf("ogg input %d: %s", e.Index, e.Reason)
+}
+
+type ErrInvalidCue struct {
+	Line   int
+	Reason string
+}
+
+func (e *ErrInvalidCue) Error() string {
+	return fmt.Sprintf("invalid cue sheet at line %d: %s", e.Line, e.Reason)
+}
+
+type ErrInvalidFLAC struct {
+	Reason string
+}
+
+func (e *ErrInvalidFLAC) Error() string {
+	return "invalid Ogg FLAC stream: " + e.Reason
+}
+
+type ErrInvalidSpeexHeader struct {
+	Reason string
+}
+
+func (e *ErrInvalidSpeexHeader) Error() string {
+	return "invalid Speex header: " + e.Reason
+}
+
+type ErrInvalidTheoraHeader struct {
+	Reason string
+}
+
+func (e *ErrInvalidTheoraHeader) Error() string {
+	return "invalid Theora header: " + e.Reason
+}
+
+type ErrInvalidSkeleton struct {
+	Reason string
+}
+
+func (e *ErrInvalidSkeleton) Error() string {
+	return "invalid Ogg Skeleton stream: " + e.Reason
+}
+
+type ErrInvalidKateHeader struct {
+	Reason string
+}
+
+func (e *ErrInvalidKateHeader) Error() string {
+	return "invalid Kate header: " + e.Reason
+}
+
+type ErrInvalidMatroska struct {
+	Reason string
+}
+
+func (e *ErrInvalidMatroska) Error() string {
+	return "invalid Matroska file: " + e.Reason
+}
+
+type ErrInvalidRTP struct {
+	Reason string
+}
+
+func (e *ErrInvalidRTP) Error() string {
+	return "invalid RTP packet: " + e.Reason
+}
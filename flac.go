@@ -0,0 +1,210 @@
+package oggmeta
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+)
+
+// FLAC metadata block types used by the Ogg FLAC mapping.
+const (
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+	flacPicture       = 6
+)
+
+// flacFirstHeaderSize is the length of the first packet of an Ogg FLAC
+// stream: the mapping header, the fLaC marker and the STREAMINFO block.
+const flacFirstHeaderSize = 13 + 4 + 34
+
+// FLACStreamInfo holds the STREAMINFO metadata block of a FLAC stream.
+type FLACStreamInfo struct {
+	MinBlockSize  uint16
+	MaxBlockSize  uint16
+	MinFrameSize  uint32 // 0 if unknown
+	MaxFrameSize  uint32 // 0 if unknown
+	SampleRate    uint32
+	Channels      uint8
+	BitsPerSample uint8
+	TotalSamples  uint64 // 0 if unknown
+	MD5           [16]byte
+}
+
+func parseFLACStreamInfo(b []byte) *FLACStreamInfo {
+	info := &FLACStreamInfo{
+		MinBlockSize: binary.BigEndian.Uint16(b[0:]),
+		MaxBlockSize: binary.BigEndian.Uint16(b[2:]),
+		MinFrameSize: uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6]),
+		MaxFrameSize: uint32(b[7])<<16 | uint32(b[8])<<8 | uint32(b[9]),
+	}
+	packed := binary.BigEndian.Uint64(b[10:])
+	info.SampleRate = uint32(packed >> 44)
+	info.Channels = uint8(packed>>41&0x7) + 1
+	info.BitsPerSample = uint8(packed>>36&0x1f) + 1
+	info.TotalSamples = packed & (1<<36 - 1)
+	copy(info.MD5[:], b[18:34])
+	return info
+}
+
+// flacBlock splits a metadata block into its type, last-block flag and data.
+func flacBlock(b []byte) (byte, bool, []byte, error) {
+	if len(b) < 4 {
+		return 0, false, nil, &ErrInvalidFLAC{Reason: "metadata block too short"}
+	}
+	n := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+	if n > len(b)-4 {
+		return 0, false, nil, &ErrInvalidFLAC{Reason: "metadata block truncated"}
+	}
+	return b[0] & 0x7f, b[0]&0x80 != 0, b[4 : 4+n], nil
+}
+
+func newFLACBlock(blockType byte, data []byte) []byte {
+	n := len(data)
+	return append([]byte{blockType, byte(n >> 16), byte(n >> 8), byte(n)}, data...)
+}
+
+// checkFLACHead validates the first packet of an Ogg FLAC stream.
+func checkFLACHead(first []byte) error {
+	if len(first) < flacFirstHeaderSize || !bytes.HasPrefix(first, FLACPrefix) {
+		return &ErrInvalidFLAC{Reason: "first packet too short"}
+	}
+	if first[5] != 1 {
+		return &ErrInvalidFLAC{Reason: "unsupported mapping version"}
+	}
+	if string(first[9:13]) != "fLaC" {
+		return &ErrInvalidFLAC{Reason: "missing fLaC marker"}
+	}
+	if blockType, _, data, err := flacBlock(first[13:]); err != nil || blockType != flacStreamInfo || len(data) != 34 {
+		return &ErrInvalidFLAC{Reason: "first packet does not hold STREAMINFO"}
+	}
+	return nil
+}
+
+// readFLACHeaders reads the metadata blocks following the first packet of an
+// Ogg FLAC stream, one per packet up to the block marked last.
+func (dec *OGGDecoder) readFLACHeaders(first *Packet, packets *packetReader, side *sideStreams) (*OggTag, error) {
+	if err := checkFLACHead(first.Data); err != nil {
+		return nil, err
+	}
+	info := parseFLACStreamInfo(first.Data[17:])
+
+	var comment []byte
+	var pictures [][]byte
+	last := first.Data[13]&0x80 != 0
+	for !last {
+		packet, err := side.nextPacketOf(dec, packets, first.Serial)
+		if err != nil {
+			return nil, err
+		}
+		var blockType byte
+		var data []byte
+		if blockType, last, data, err = flacBlock(packet.Data); err != nil {
+			return nil, err
+		}
+		switch blockType {
+		case flacVorbisComment:
+			comment = data
+		case flacPicture:
+			pictures = append(pictures, data)
+		}
+	}
+
+	tag := new(OggTag)
+	if comment != nil {
+		dec.TagReader = bytes.NewReader(comment)
+		var err error
+		if tag, err = dec.readComments(); err != nil {
+			return nil, err
+		}
+	}
+	if tag.CoverArt == nil && len(pictures) > 0 {
+		// prefer the front cover
+		picture := pictures[0]
+		for _, p := range pictures {
+			if len(p) >= 4 && binary.BigEndian.Uint32(p) == 3 {
+				picture = p
+				break
+			}
+		}
+		if err := dec.readCoverArt(tag, picture); err != nil {
+			return nil, err
+		}
+	}
+	tag.Codec = FLAC
+	tag.FLACStreamInfo = info
+	return tag, nil
+}
+
+// flacHeaders is the metadata of an Ogg FLAC stream as SaveTags rewrites it.
+type flacHeaders struct {
+	first  []byte   // the mapping header and STREAMINFO
+	blocks [][]byte // the other metadata blocks, one per packet
+	pages  int      // pages after the first that held the old metadata
+}
+
+// saveFLACHeaders rebuilds the metadata of the Ogg FLAC stream that starts
+// with page, taking its VORBIS_COMMENT and PICTURE blocks from tag. It reads
+// the header pages of the stream on from r and then seeks r back to offset.
+func saveFLACHeaders(ctx context.Context, tag *OggTag, page *OGGPage, r io.ReadSeeker, offset int64) (*flacHeaders, error) {
+	first := append([]byte{}, page.Packets[0]...)
+	if err := checkFLACHead(first); err != nil {
+		return nil, err
+	}
+	serial := page.Header.SerialNumber
+	h := &flacHeaders{first: first}
+
+	var kept [][]byte
+	decoder := &OGGDecoder{Reader: r}
+	headers := newPacketReader(nil, 0, 0)
+	last := first[13]&0x80 != 0
+	for !last {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		page, err := decoder.Decode()
+		if err != nil {
+			return nil, err
+		}
+		if page.Header.SerialNumber != serial {
+			continue
+		}
+		h.pages++
+		if err := headers.push(page); err != nil {
+			return nil, err
+		}
+		for _, packet := range headers.queue {
+			if last {
+				return nil, &ErrInvalidFLAC{Reason: "audio shares a page with the metadata"}
+			}
+			var blockType byte
+			if blockType, last, _, err = flacBlock(packet.Data); err != nil {
+				return nil, err
+			}
+			if blockType != flacVorbisComment && blockType != flacPicture {
+				kept = append(kept, packet.Data)
+			}
+		}
+		headers.queue = nil
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	flacTag := *tag
+	flacTag.Codec = FLAC
+	h.blocks = [][]byte{newFLACBlock(flacVorbisComment, createCommentPacket(tagCommentFields(&flacTag), nil, FLAC))}
+	for _, block := range kept {
+		h.blocks = append(h.blocks, append([]byte{}, block...))
+	}
+	if picture := tagPicture(tag); len(picture) > 0 {
+		h.blocks = append(h.blocks, newFLACBlock(flacPicture, picture))
+	}
+	first[13] &^= 0x80
+	for _, block := range h.blocks {
+		block[0] &^= 0x80
+	}
+	h.blocks[len(h.blocks)-1][0] |= 0x80
+	binary.BigEndian.PutUint16(first[7:], uint16(len(h.blocks)))
+	return h, nil
+}
@@ -0,0 +1,145 @@
+package oggmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/jpeg"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildFLACFile writes an Ogg FLAC stream with the given metadata blocks
+// after STREAMINFO and a few stand-in audio packets.
+func buildFLACFile(t *testing.T, blocks ...[]byte) []byte {
+	streamInfo := make([]byte, 34)
+	binary.BigEndian.PutUint16(streamInfo[0:], 4096)
+	binary.BigEndian.PutUint16(streamInfo[2:], 4096)
+	// 44100 Hz, 2 channels, 16 bits, 88200 samples
+	binary.BigEndian.PutUint64(streamInfo[10:], 44100<<44|1<<41|15<<36|88200)
+	copy(streamInfo[18:], "0123456789abcdef")
+
+	first := append([]byte{}, FLACPrefix...)
+	first = append(first, 1, 0, 0, byte(len(blocks)))
+	first = append(first, "fLaC"...)
+	first = append(first, newFLACBlock(flacStreamInfo, streamInfo)...)
+	for i := range blocks {
+		blocks[i][0] &^= 0x80
+	}
+	blocks[len(blocks)-1][0] |= 0x80
+
+	out := new(bytes.Buffer)
+	enc := &OGGEncoder{Writer: out, Serial: 0x1234}
+	assert.NoError(t, enc.EncodeBOS(0, [][]byte{first}))
+	assert.NoError(t, enc.Encode(0, blocks))
+	assert.NoError(t, enc.Encode(4096, [][]byte{{0xff, 0xf8, 1, 2, 3}}))
+	assert.NoError(t, enc.EncodeEOS(88200, [][]byte{{0xff, 0xf8, 4, 5, 6}}))
+	return out.Bytes()
+}
+
+func TestReadFLAC(t *testing.T) {
+	comment := createCommentPacket([]string{"TITLE=Song", "ARTIST=Band", "CUSTOM=x"}, nil, FLAC)
+	b := buildFLACFile(t, newFLACBlock(flacVorbisComment, comment), newFLACBlock(1, make([]byte, 100)))
+
+	tag, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, FLAC, tag.Codec)
+	assert.Equal(t, "Song", tag.Title)
+	assert.Equal(t, "Band", tag.Artist)
+	assert.Equal(t, "x", tag.UnmappedFields["CUSTOM"])
+	if assert.NotNil(t, tag.FLACStreamInfo) {
+		assert.Equal(t, uint32(44100), tag.FLACStreamInfo.SampleRate)
+		assert.Equal(t, uint8(2), tag.FLACStreamInfo.Channels)
+		assert.Equal(t, uint8(16), tag.FLACStreamInfo.BitsPerSample)
+		assert.Equal(t, uint64(88200), tag.FLACStreamInfo.TotalSamples)
+		assert.Equal(t, uint16(4096), tag.FLACStreamInfo.MaxBlockSize)
+		assert.Equal(t, "0123456789abcdef", string(tag.FLACStreamInfo.MD5[:]))
+	}
+}
+
+func TestSaveFLAC(t *testing.T) {
+	// padding but no comment block yet
+	b := buildFLACFile(t, newFLACBlock(1, make([]byte, 100)))
+	tag, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, "", tag.Title)
+
+	f, err := os.Open("./testdata/testdata-img-1.jpg")
+	assert.NoError(t, err)
+	defer f.Close()
+	img, err := jpeg.Decode(f)
+	assert.NoError(t, err)
+	tag.SetTitle("New Title")
+	tag.SetCoverArt(&img)
+
+	out := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, out))
+	verifyPages(t, out.Bytes())
+
+	saved, err := ReadOGG(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, FLAC, saved.Codec)
+	assert.Equal(t, "New Title", saved.Title)
+	assert.NotNil(t, saved.CoverArt)
+	assert.Equal(t, tag.FLACStreamInfo, saved.FLACStreamInfo)
+
+	// comment, padding and picture follow STREAMINFO, the picture last
+	dec := &OGGDecoder{Reader: bytes.NewReader(out.Bytes())}
+	first, err := dec.ReadPacket()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(3), binary.BigEndian.Uint16(first.Data[7:]))
+	assert.Equal(t, byte(flacStreamInfo), first.Data[13])
+	var types []byte
+	for i := 0; i < 3; i++ {
+		packet, err := dec.ReadPacket()
+		assert.NoError(t, err)
+		types = append(types, packet.Data[0])
+	}
+	assert.Equal(t, []byte{flacVorbisComment, 1, flacPicture | 0x80}, types)
+
+	// the audio packets are untouched
+	for _, want := range [][]byte{{0xff, 0xf8, 1, 2, 3}, {0xff, 0xf8, 4, 5, 6}} {
+		packet, err := dec.ReadPacket()
+		assert.NoError(t, err)
+		assert.Equal(t, want, packet.Data)
+	}
+}
+
+func TestSaveFLACGrouped(t *testing.T) {
+	comment := createCommentPacket([]string{"TITLE=Song"}, nil, FLAC)
+	audio := buildFLACFile(t, newFLACBlock(flacVorbisComment, comment), newFLACBlock(1, make([]byte, 100)))
+	subtitles := buildKateFile(t, []string{"TITLE=Lyrics"}, kateEvents)
+	grouped := new(bytes.Buffer)
+	assert.NoError(t, Mux(grouped, bytes.NewReader(subtitles), bytes.NewReader(audio)))
+
+	tag, err := ReadOGG(bytes.NewReader(grouped.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, FLAC, tag.Codec)
+	tag.SetTitle("Renamed")
+	out := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, out))
+
+	// every stream keeps its serial and an unbroken page sequence
+	sequence := make(map[uint32]uint32)
+	for _, h := range verifyPages(t, out.Bytes()) {
+		assert.Equal(t, sequence[h.SerialNumber], h.PageSequenceNumber)
+		sequence[h.SerialNumber]++
+	}
+	assert.Len(t, sequence, 2)
+
+	saved, err := ReadOGG(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, FLAC, saved.Codec)
+	assert.Equal(t, "Renamed", saved.Title)
+	if assert.Len(t, saved.KateTags, 1) {
+		assert.Equal(t, "Lyrics", saved.KateTags[0].Title)
+	}
+	streams, err := Demux(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	if assert.Len(t, streams, 2) {
+		assert.Equal(t, readAllPackets(t, subtitles)[2:], readAllPackets(t, streams[0].Data)[2:])
+		packets := readAllPackets(t, streams[1].Data)
+		assert.Equal(t, readAllPackets(t, audio)[3:], packets[len(packets)-2:])
+	}
+}
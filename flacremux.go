@@ -0,0 +1,409 @@
+package oggmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+// FLAC metadata block types that only make sense in a native FLAC file.
+const (
+	flacPadding   = 1
+	flacSeekTable = 3
+)
+
+// flacFrameHeader is what RemuxFLACToOgg needs from the header of a FLAC frame.
+type flacFrameHeader struct {
+	blockSize int
+	number    uint64 // the frame number, or the sample number if variable
+	variable  bool
+	size      int // of the header, CRC-8 included
+}
+
+// start returns the number of the first sample of the frame.
+func (h flacFrameHeader) start(info *FLACStreamInfo) uint64 {
+	if h.variable {
+		return h.number
+	}
+	return h.number * uint64(info.MaxBlockSize)
+}
+
+// parseFLACFrameHeader reads the frame header at the start of b, if it has
+// a valid CRC-8.
+func parseFLACFrameHeader(b []byte) (flacFrameHeader, bool) {
+	h := flacFrameHeader{}
+	if len(b) < 6 || b[0] != 0xff || b[1]&0xfe != 0xf8 {
+		return h, false
+	}
+	h.variable = b[1]&1 != 0
+	blockCode, rateCode := b[2]>>4, b[2]&0xf
+	if blockCode == 0 || rateCode == 0xf || b[3]>>4 > 10 || b[3]&1 != 0 {
+		return h, false
+	}
+
+	// the frame or sample number, coded like UTF-8
+	i := 4
+	first := b[i]
+	i++
+	width := 0
+	for mask := byte(0x80); first&mask != 0; mask >>= 1 {
+		width++
+	}
+	if width == 1 || width > 7 {
+		return h, false
+	}
+	h.number = uint64(first)
+	if width > 1 {
+		h.number = uint64(first & (0x7f >> uint(width)))
+		for ; width > 1; width-- {
+			if i >= len(b) || b[i]&0xc0 != 0x80 {
+				return h, false
+			}
+			h.number = h.number<<6 | uint64(b[i]&0x3f)
+			i++
+		}
+	}
+
+	extra := 0
+	switch {
+	case blockCode == 1:
+		h.blockSize = 192
+	case blockCode <= 5:
+		h.blockSize = 576 << (blockCode - 2)
+	case blockCode == 6:
+		extra = 1
+	case blockCode == 7:
+		extra = 2
+	default:
+		h.blockSize = 256 << (blockCode - 8)
+	}
+	if i+extra > len(b) {
+		return h, false
+	}
+	if extra > 0 {
+		h.blockSize = int(b[i]) + 1
+		if extra == 2 {
+			h.blockSize = int(binary.BigEndian.Uint16(b[i:])) + 1
+		}
+		i += extra
+	}
+	switch rateCode {
+	case 12:
+		i++
+	case 13, 14:
+		i += 2
+	}
+	if i >= len(b) || flacCRC8(b[:i]) != b[i] {
+		return h, false
+	}
+	h.size = i + 1
+	return h, true
+}
+
+// flacMaxHeaderSize is the longest a frame header can be.
+const flacMaxHeaderSize = 16
+
+// flacFrameReader splits native FLAC audio into frames as it reads it.
+type flacFrameReader struct {
+	r   io.Reader
+	buf []byte
+	eof bool
+}
+
+// next returns the next frame and its header, or io.EOF after the last.
+func (f *flacFrameReader) next() ([]byte, flacFrameHeader, error) {
+	if err := f.fill(flacMaxHeaderSize); err != nil {
+		return nil, flacFrameHeader{}, err
+	}
+	if len(f.buf) == 0 {
+		return nil, flacFrameHeader{}, io.EOF
+	}
+	h, ok := parseFLACFrameHeader(f.buf)
+	if !ok {
+		return nil, h, &ErrInvalidFLAC{Reason: "missing frame header"}
+	}
+	// a frame ends where a valid header follows a valid CRC-16, or at the end
+	end := -1
+	for i := h.size; end < 0; i++ {
+		if err := f.fill(i + flacMaxHeaderSize); err != nil {
+			return nil, h, err
+		}
+		if i+1 >= len(f.buf) {
+			end = len(f.buf)
+			continue
+		}
+		if f.buf[i] != 0xff || f.buf[i+1]&0xfe != 0xf8 {
+			continue
+		}
+		if _, ok := parseFLACFrameHeader(f.buf[i:]); ok && flacCRC16(f.buf[:i]) == 0 {
+			end = i
+		}
+	}
+	if flacCRC16(f.buf[:end]) != 0 {
+		return nil, h, &ErrInvalidFLAC{Reason: "frame CRC mismatch"}
+	}
+	frame := f.buf[:end:end]
+	f.buf = f.buf[end:]
+	return frame, h, nil
+}
+
+// fill reads until the buffer holds n bytes or the audio ends.
+func (f *flacFrameReader) fill(n int) error {
+	for len(f.buf) < n && !f.eof {
+		if cap(f.buf)-len(f.buf) < 4096 {
+			grown := make([]byte, len(f.buf), 2*cap(f.buf)+65536)
+			copy(grown, f.buf)
+			f.buf = grown
+		}
+		k, err := f.r.Read(f.buf[len(f.buf):cap(f.buf)])
+		f.buf = f.buf[:len(f.buf)+k]
+		if err == io.EOF {
+			f.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func flacCRC8(b []byte) byte {
+	var crc byte
+	for _, c := range b {
+		crc ^= c
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// flacCRC16 returns the CRC-16 of b, which is 0 for a frame that ends with its own CRC.
+func flacCRC16(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// skipID3v2 skips the ID3v2 tag some tools put before the fLaC marker.
+func skipID3v2(r io.Reader, head []byte) ([]byte, error) {
+	if !bytes.HasPrefix(head, []byte("ID3")) {
+		return head, nil
+	}
+	rest := make([]byte, 6)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	size := int64(rest[2]&0x7f)<<21 | int64(rest[3]&0x7f)<<14 | int64(rest[4]&0x7f)<<7 | int64(rest[5]&0x7f)
+	if rest[1]&0x10 != 0 {
+		// a footer follows the tag
+		size += 10
+	}
+	if _, err := io.CopyN(io.Discard, r, size); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return head, nil
+}
+
+// RemuxFLACToOgg wraps a native FLAC file in Ogg without re-encoding.
+func RemuxFLACToOgg(r io.Reader, w io.Writer) error {
+	marker := make([]byte, 4)
+	if _, err := io.ReadFull(r, marker); err != nil {
+		return unexpectedEOF(err)
+	}
+	marker, err := skipID3v2(r, marker)
+	if err != nil {
+		return err
+	}
+	if string(marker) != "fLaC" {
+		return &ErrInvalidFLAC{Reason: "missing fLaC marker"}
+	}
+
+	var streamInfo, comment []byte
+	var blocks [][]byte
+	for last := false; !last; {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return unexpectedEOF(err)
+		}
+		block := make([]byte, 4+(int(header[1])<<16|int(header[2])<<8|int(header[3])))
+		copy(block, header)
+		if _, err := io.ReadFull(r, block[4:]); err != nil {
+			return unexpectedEOF(err)
+		}
+		var blockType byte
+		var data []byte
+		if blockType, last, data, err = flacBlock(block); err != nil {
+			return err
+		}
+		switch {
+		case streamInfo == nil:
+			if blockType != flacStreamInfo || len(data) != 34 {
+				return &ErrInvalidFLAC{Reason: "first metadata block is not STREAMINFO"}
+			}
+			streamInfo = block
+		case blockType == flacVorbisComment:
+			comment = block
+		case blockType != flacPadding && blockType != flacSeekTable:
+			blocks = append(blocks, block)
+		}
+	}
+	if comment == nil {
+		comment = newFLACBlock(flacVorbisComment, createCommentPacket(nil, nil, FLAC))
+	}
+	blocks = append([][]byte{comment}, blocks...)
+	for _, block := range blocks {
+		block[0] &^= 0x80
+	}
+	blocks[len(blocks)-1][0] |= 0x80
+	info := parseFLACStreamInfo(streamInfo[4:])
+
+	first := append([]byte{}, FLACPrefix...)
+	first = append(first, 1, 0, byte(len(blocks)>>8), byte(len(blocks)))
+	first = append(first, "fLaC"...)
+	first = append(first, streamInfo...)
+	first[13] &^= 0x80
+
+	serial := binary.BigEndian.Uint32(info.MD5[:])
+	if serial == 0 {
+		// the encoder left the MD5 signature unset
+		serial = crc32.ChecksumIEEE(streamInfo)
+	}
+	pages := &packetPager{enc: &OGGEncoder{Writer: w, Serial: serial}}
+	if err = pages.enc.EncodeBOS(0, [][]byte{first}); err != nil {
+		return err
+	}
+	if err = pages.enc.Encode(0, blocks); err != nil {
+		return err
+	}
+	frames := &flacFrameReader{r: r}
+	var granule int64
+	for {
+		frame, h, err := frames.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		granule = int64(h.start(info)) + int64(h.blockSize)
+		if err = pages.add(frame, granule); err != nil {
+			return err
+		}
+	}
+	return pages.finish(granule)
+}
+
+// RemuxOggToFLAC unwraps the Ogg FLAC stream of r into a native FLAC file.
+// A STREAMINFO without a total sample count gets the one of the last page,
+// which is patched in by seeking if w is an io.WriteSeeker and means holding
+// the file in memory otherwise.
+func RemuxOggToFLAC(r io.ReadSeeker, w io.Writer) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	packets := newPacketReader(&OGGDecoder{Reader: r}, 0, 0)
+	head, err := packets.next()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if !bytes.HasPrefix(head.Data, FLACPrefix) {
+		return &ErrUnsupportedCodec{}
+	}
+	if err = checkFLACHead(head.Data); err != nil {
+		return err
+	}
+	streamInfo := append([]byte{}, head.Data[13:flacFirstHeaderSize]...)
+	info := parseFLACStreamInfo(streamInfo[4:])
+
+	out := w
+	var held *bytes.Buffer
+	start := int64(-1)
+	if info.TotalSamples == 0 {
+		if ws, ok := w.(io.WriteSeeker); ok {
+			if start, err = ws.Seek(0, io.SeekCurrent); err != nil {
+				return err
+			}
+		} else {
+			held = new(bytes.Buffer)
+			out = held
+		}
+	}
+	if _, err = out.Write(append([]byte("fLaC"), streamInfo...)); err != nil {
+		return err
+	}
+	for last := streamInfo[0]&0x80 != 0; !last; {
+		packet, err := nextPacketOf(packets, head.Serial)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		if _, last, _, err = flacBlock(packet.Data); err != nil {
+			return err
+		}
+		if _, err = out.Write(packet.Data); err != nil {
+			return err
+		}
+	}
+
+	var final int64
+	for {
+		packet, err := nextPacketOf(packets, head.Serial)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, err = out.Write(packet.Data); err != nil {
+			return err
+		}
+		if packet.Granule > 0 {
+			final = packet.Granule
+		}
+	}
+	if info.TotalSamples != 0 || final <= 0 || final >= 1<<36 {
+		if held == nil {
+			return nil
+		}
+		_, err = w.Write(held.Bytes())
+		return err
+	}
+	// the low 36 bits of the 8 bytes at offset 10 of STREAMINFO
+	packed := make([]byte, 8)
+	copy(packed, streamInfo[4+10:])
+	binary.BigEndian.PutUint64(packed, binary.BigEndian.Uint64(packed)&^(1<<36-1)|uint64(final))
+	if held != nil {
+		copy(held.Bytes()[4+4+10:], packed)
+		_, err = w.Write(held.Bytes())
+		return err
+	}
+	ws := w.(io.WriteSeeker)
+	end, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = ws.Seek(start+4+4+10, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = ws.Write(packed); err != nil {
+		return err
+	}
+	_, err = ws.Seek(end, io.SeekStart)
+	return err
+}
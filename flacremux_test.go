@@ -0,0 +1,152 @@
+package oggmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"github.com/aler9/writerseeker"
+	"github.com/stretchr/testify/assert"
+)
+
+// buildFLACFrame writes a 16 bit stereo frame of two constant subframes.
+// Frames of 4096 samples use a block size code, others store the size.
+func buildFLACFrame(number int, blockSize int, value uint16) []byte {
+	frame := []byte{0xff, 0xf8, 0xc9, 0x18, byte(number)}
+	if blockSize != 4096 {
+		frame[2] = 0x79
+		frame = append(frame, byte((blockSize-1)>>8), byte(blockSize-1))
+	}
+	frame = append(frame, flacCRC8(frame))
+	for ch := 0; ch < 2; ch++ {
+		frame = append(frame, 0, byte(value>>8), byte(value))
+	}
+	crc := flacCRC16(frame)
+	return append(frame, byte(crc>>8), byte(crc))
+}
+
+// buildNativeFLAC writes a native FLAC file of three frames and 9192 samples
+// with the given blocks after STREAMINFO. The middle frame holds sync codes.
+func buildNativeFLAC(t *testing.T, blocks ...[]byte) []byte {
+	streamInfo := make([]byte, 34)
+	binary.BigEndian.PutUint16(streamInfo[0:], 4096)
+	binary.BigEndian.PutUint16(streamInfo[2:], 4096)
+	binary.BigEndian.PutUint64(streamInfo[10:], 44100<<44|1<<41|15<<36)
+	copy(streamInfo[18:], "fedcba9876543210")
+
+	out := append([]byte("fLaC"), newFLACBlock(flacStreamInfo, streamInfo)...)
+	for i, block := range blocks {
+		if i == len(blocks)-1 {
+			block[0] |= 0x80
+		}
+		out = append(out, block...)
+	}
+	if len(blocks) == 0 {
+		out[4] |= 0x80
+	}
+	out = append(out, buildFLACFrame(0, 4096, 1)...)
+	out = append(out, buildFLACFrame(1, 4096, 0xfff8)...)
+	return append(out, buildFLACFrame(2, 1000, 2)...)
+}
+
+func TestRemuxFLAC(t *testing.T) {
+	comment := createCommentPacket([]string{"TITLE=Archive", "ARTIST=Band"}, nil, FLAC)
+	img, err := os.ReadFile("./testdata/testdata-img-1.jpg")
+	assert.NoError(t, err)
+	picture, err := createMetadataBlockPicture(img)
+	assert.NoError(t, err)
+	native := buildNativeFLAC(t,
+		newFLACBlock(flacSeekTable, make([]byte, 18)),
+		newFLACBlock(flacVorbisComment, comment),
+		newFLACBlock(flacPicture, picture),
+		newFLACBlock(flacPadding, make([]byte, 1024)))
+
+	ogg := new(bytes.Buffer)
+	assert.NoError(t, RemuxFLACToOgg(bytes.NewReader(native), ogg))
+	verifyPages(t, ogg.Bytes())
+	tag, err := ReadOGG(bytes.NewReader(ogg.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, FLAC, tag.Codec)
+	assert.Equal(t, "Archive", tag.Title)
+	assert.Equal(t, "Band", tag.Artist)
+	assert.NotNil(t, tag.CoverArt)
+
+	packets := readAllPackets(t, ogg.Bytes())
+	if assert.Len(t, packets, 6) {
+		assert.Equal(t, uint16(2), binary.BigEndian.Uint16(packets[0][7:]))
+		assert.Equal(t, byte(flacVorbisComment), packets[1][0])
+		assert.Equal(t, byte(0x80|flacPicture), packets[2][0])
+		assert.Equal(t, buildFLACFrame(1, 4096, 0xfff8), packets[4])
+	}
+	dec := &OGGDecoder{Reader: bytes.NewReader(ogg.Bytes())}
+	var granules []int64
+	for {
+		page, err := dec.Decode()
+		if err != nil {
+			break
+		}
+		granules = append(granules, page.Header.GranulePosition)
+	}
+	assert.Equal(t, []int64{0, 0, 9192}, granules)
+
+	back := new(bytes.Buffer)
+	assert.NoError(t, RemuxOggToFLAC(bytes.NewReader(ogg.Bytes()), back))
+	want := append([]byte("fLaC"), packets[0][13:]...)
+	for _, p := range packets[1:] {
+		want = append(want, p...)
+	}
+	// the total sample count is filled in
+	binary.BigEndian.PutUint64(want[18:], 44100<<44|1<<41|15<<36|9192)
+	assert.Equal(t, want, back.Bytes())
+
+	again := new(bytes.Buffer)
+	assert.NoError(t, RemuxFLACToOgg(bytes.NewReader(back.Bytes()), again))
+	assert.Equal(t, packets[1:], readAllPackets(t, again.Bytes())[1:])
+}
+
+func TestRemuxFLACWithoutComment(t *testing.T) {
+	// an ID3v2 tag before the marker, and no metadata but STREAMINFO
+	id3 := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x05"), 1, 2, 3, 4, 5)
+	native := append(id3, buildNativeFLAC(t)...)
+	ogg := new(bytes.Buffer)
+	assert.NoError(t, RemuxFLACToOgg(bytes.NewReader(native), ogg))
+	tag, err := ReadOGG(bytes.NewReader(ogg.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, FLAC, tag.Codec)
+	assert.Equal(t, uint32(44100), tag.FLACStreamInfo.SampleRate)
+	packets := readAllPackets(t, ogg.Bytes())
+	assert.Len(t, packets, 5)
+	assert.Equal(t, byte(0x80|flacVorbisComment), packets[1][0])
+
+	corrupt := append([]byte{}, buildNativeFLAC(t)...)
+	corrupt[len(corrupt)-3] ^= 1
+	err = RemuxFLACToOgg(bytes.NewReader(corrupt), new(bytes.Buffer))
+	assert.IsType(t, &ErrInvalidFLAC{}, err)
+
+	opus, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	err = RemuxOggToFLAC(bytes.NewReader(opus), new(bytes.Buffer))
+	assert.IsType(t, &ErrUnsupportedCodec{}, err)
+}
+
+func TestRemuxFLACStreaming(t *testing.T) {
+	native := buildNativeFLAC(t)
+	// no MD5 signature
+	copy(native[4+4+18:], make([]byte, 16))
+	ogg := new(bytes.Buffer)
+	assert.NoError(t, RemuxFLACToOgg(iotest.OneByteReader(bytes.NewReader(native)), ogg))
+	pages := verifyPages(t, ogg.Bytes())
+	assert.NotZero(t, pages[0].SerialNumber)
+	assert.Len(t, readAllPackets(t, ogg.Bytes()), 5)
+
+	held := new(bytes.Buffer)
+	assert.NoError(t, RemuxOggToFLAC(bytes.NewReader(ogg.Bytes()), held))
+	seeked := &writerseeker.WriterSeeker{}
+	_, err := seeked.Write([]byte("before"))
+	assert.NoError(t, err)
+	assert.NoError(t, RemuxOggToFLAC(bytes.NewReader(ogg.Bytes()), seeked))
+	assert.Equal(t, append([]byte("before"), held.Bytes()...), seeked.Bytes())
+	assert.Equal(t, uint64(9192), binary.BigEndian.Uint64(held.Bytes()[4+4+10:])&(1<<36-1))
+}
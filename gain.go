@@ -0,0 +1,210 @@
+package oggmeta
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Gain fields, kept in UnmappedFields. ReplayGain is a dB offset to about
+// -18 LUFS, R128 a Q7.8 dB offset to -23 LUFS.
+const (
+	ReplayGainTrackGain = "REPLAYGAIN_TRACK_GAIN"
+	ReplayGainTrackPeak = "REPLAYGAIN_TRACK_PEAK"
+	ReplayGainAlbumGain = "REPLAYGAIN_ALBUM_GAIN"
+	ReplayGainAlbumPeak = "REPLAYGAIN_ALBUM_PEAK"
+	R128TrackGain       = "R128_TRACK_GAIN"
+	R128AlbumGain       = "R128_ALBUM_GAIN"
+)
+
+const (
+	ReplayGainReferenceLUFS = -18.0
+	R128ReferenceLUFS       = -23.0
+
+	// replayGainToR128 is added to a ReplayGain value to get the R128 value.
+	replayGainToR128 = R128ReferenceLUFS - ReplayGainReferenceLUFS
+)
+
+// GetReplayGainTrackGain returns the track gain in ReplayGain dB, converting
+// from R128_TRACK_GAIN for Opus. ok is false if the field is missing or invalid.
+func (o *OggTag) GetReplayGainTrackGain() (float64, bool) {
+	return o.getGain(ReplayGainTrackGain, R128TrackGain, false)
+}
+
+// GetReplayGainAlbumGain is the album counterpart of GetReplayGainTrackGain.
+func (o *OggTag) GetReplayGainAlbumGain() (float64, bool) {
+	return o.getGain(ReplayGainAlbumGain, R128AlbumGain, false)
+}
+
+// GetR128TrackGain returns the track gain in dB relative to -23 LUFS,
+// converting from REPLAYGAIN_TRACK_GAIN for Vorbis.
+func (o *OggTag) GetR128TrackGain() (float64, bool) {
+	return o.getGain(ReplayGainTrackGain, R128TrackGain, true)
+}
+
+// GetR128AlbumGain is the album counterpart of GetR128TrackGain.
+func (o *OggTag) GetR128AlbumGain() (float64, bool) {
+	return o.getGain(ReplayGainAlbumGain, R128AlbumGain, true)
+}
+
+// GetReplayGainTrackPeak returns the linear track peak. Opus has no peak field.
+func (o *OggTag) GetReplayGainTrackPeak() (float64, bool) {
+	return parsePeak(o.UnmappedFields[ReplayGainTrackPeak])
+}
+
+// GetReplayGainAlbumPeak returns the linear album peak. Opus has no peak field.
+func (o *OggTag) GetReplayGainAlbumPeak() (float64, bool) {
+	return parsePeak(o.UnmappedFields[ReplayGainAlbumPeak])
+}
+
+// SetReplayGainTrackGain stores a ReplayGain dB track gain in the field that
+// matches Codec, converting to R128_TRACK_GAIN for Opus.
+func (o *OggTag) SetReplayGainTrackGain(db float64) error {
+	return o.setGain(ReplayGainTrackGain, R128TrackGain, db, false)
+}
+
+// SetReplayGainAlbumGain is the album counterpart of SetReplayGainTrackGain.
+func (o *OggTag) SetReplayGainAlbumGain(db float64) error {
+	return o.setGain(ReplayGainAlbumGain, R128AlbumGain, db, false)
+}
+
+// SetR128TrackGain stores a track gain relative to -23 LUFS in the field that
+// matches Codec, converting to REPLAYGAIN_TRACK_GAIN for Vorbis.
+func (o *OggTag) SetR128TrackGain(db float64) error {
+	return o.setGain(ReplayGainTrackGain, R128TrackGain, db, true)
+}
+
+// SetR128AlbumGain is the album counterpart of SetR128TrackGain.
+func (o *OggTag) SetR128AlbumGain(db float64) error {
+	return o.setGain(ReplayGainAlbumGain, R128AlbumGain, db, true)
+}
+
+// SetReplayGainTrackPeak stores the linear track peak. It fails for Opus,
+// which does not allow REPLAYGAIN_* fields.
+func (o *OggTag) SetReplayGainTrackPeak(peak float64) error {
+	return o.setPeak(ReplayGainTrackPeak, peak)
+}
+
+// SetReplayGainAlbumPeak stores the linear album peak. It fails for Opus.
+func (o *OggTag) SetReplayGainAlbumPeak(peak float64) error {
+	return o.setPeak(ReplayGainAlbumPeak, peak)
+}
+
+// GainWarnings reports gain fields that are malformed or that the codec's
+// specification forbids, such as REPLAYGAIN_* fields in an Opus file.
+func (o *OggTag) GainWarnings() []string {
+	var warnings []string
+	for _, field := range []string{ReplayGainTrackGain, ReplayGainAlbumGain, ReplayGainTrackPeak, ReplayGainAlbumPeak} {
+		value, ok := o.UnmappedFields[field]
+		if !ok {
+			continue
+		}
+		if o.Codec == Opus {
+			warnings = append(warnings, fmt.Sprintf("%s is not allowed in Opus files, use R128 gain fields", field))
+		}
+		var valid bool
+		if strings.HasSuffix(field, "_PEAK") {
+			_, valid = parsePeak(value)
+		} else {
+			_, valid = parseReplayGain(value)
+		}
+		if !valid {
+			warnings = append(warnings, fmt.Sprintf("%s has invalid value %q", field, value))
+		}
+	}
+	for _, field := range []string{R128TrackGain, R128AlbumGain} {
+		value, ok := o.UnmappedFields[field]
+		if !ok {
+			continue
+		}
+		if o.Codec == Vorbis {
+			warnings = append(warnings, fmt.Sprintf("%s is an Opus field, Vorbis players expect ReplayGain fields", field))
+		}
+		if _, valid := parseR128(value); !valid {
+			warnings = append(warnings, fmt.Sprintf("%s has invalid value %q", field, value))
+		}
+	}
+	return warnings
+}
+
+func (o *OggTag) getGain(rgField, r128Field string, asR128 bool) (float64, bool) {
+	var db float64
+	var ok bool
+	if o.Codec == Opus {
+		db, ok = parseR128(o.UnmappedFields[r128Field])
+		db -= replayGainToR128
+	} else {
+		db, ok = parseReplayGain(o.UnmappedFields[rgField])
+	}
+	if !ok {
+		return 0, false
+	}
+	if asR128 {
+		db += replayGainToR128
+	}
+	return db, true
+}
+
+func (o *OggTag) setGain(rgField, r128Field string, db float64, fromR128 bool) error {
+	if fromR128 {
+		db -= replayGainToR128
+	}
+	if o.UnmappedFields == nil {
+		o.UnmappedFields = make(map[string]string)
+	}
+	if o.Codec == Opus {
+		q := math.Round((db + replayGainToR128) * 256)
+		if math.IsNaN(q) || q < math.MinInt16 || q > math.MaxInt16 {
+			return &ErrInvalidGain{Field: r128Field, Value: db + replayGainToR128}
+		}
+		o.UnmappedFields[r128Field] = strconv.Itoa(int(q))
+		delete(o.UnmappedFields, rgField)
+		return nil
+	}
+	if math.IsNaN(db) || math.IsInf(db, 0) || db < math.MinInt16/256.0 || db > math.MaxInt16/256.0 {
+		return &ErrInvalidGain{Field: rgField, Value: db}
+	}
+	o.UnmappedFields[rgField] = fmt.Sprintf("%.2f dB", db)
+	delete(o.UnmappedFields, r128Field)
+	return nil
+}
+
+func (o *OggTag) setPeak(field string, peak float64) error {
+	if o.Codec == Opus || math.IsNaN(peak) || math.IsInf(peak, 0) || peak < 0 {
+		return &ErrInvalidGain{Field: field, Value: peak}
+	}
+	if o.UnmappedFields == nil {
+		o.UnmappedFields = make(map[string]string)
+	}
+	o.UnmappedFields[field] = fmt.Sprintf("%.6f", peak)
+	return nil
+}
+
+func parseReplayGain(value string) (float64, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) > 2 && strings.EqualFold(value[len(value)-2:], "dB") {
+		value = strings.TrimSpace(value[:len(value)-2])
+	}
+	db, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(db) || math.IsInf(db, 0) {
+		return 0, false
+	}
+	return db, true
+}
+
+func parseR128(value string) (float64, bool) {
+	q, err := strconv.ParseInt(strings.TrimSpace(value), 10, 16)
+	if err != nil {
+		return 0, false
+	}
+	return float64(q) / 256, true
+}
+
+func parsePeak(value string) (float64, bool) {
+	peak, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsNaN(peak) || math.IsInf(peak, 0) || peak < 0 {
+		return 0, false
+	}
+	return peak, true
+}
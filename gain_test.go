@@ -0,0 +1,66 @@
+package oggmeta
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGainConversion(t *testing.T) {
+	vorbis := &OggTag{Codec: Vorbis}
+	assert.NoError(t, vorbis.SetReplayGainTrackGain(-6.5))
+	assert.Equal(t, "-6.50 dB", vorbis.UnmappedFields[ReplayGainTrackGain])
+	r128, ok := vorbis.GetR128TrackGain()
+	assert.True(t, ok)
+	assert.Equal(t, -11.5, r128)
+	assert.NoError(t, vorbis.SetReplayGainTrackPeak(0.988))
+	peak, ok := vorbis.GetReplayGainTrackPeak()
+	assert.True(t, ok)
+	assert.Equal(t, 0.988, peak)
+
+	opus := &OggTag{Codec: Opus}
+	assert.NoError(t, opus.SetReplayGainAlbumGain(-6.5))
+	assert.Equal(t, "-2944", opus.UnmappedFields[R128AlbumGain])
+	rg, ok := opus.GetReplayGainAlbumGain()
+	assert.True(t, ok)
+	assert.Equal(t, -6.5, rg)
+	assert.Error(t, opus.SetReplayGainTrackPeak(1))
+	assert.Error(t, opus.SetR128TrackGain(500))
+
+	_, ok = opus.GetR128TrackGain()
+	assert.False(t, ok)
+}
+
+func TestGainWarnings(t *testing.T) {
+	opus := &OggTag{Codec: Opus, UnmappedFields: map[string]string{
+		ReplayGainTrackGain: "-3.00 dB",
+		R128TrackGain:       "not a number",
+	}}
+	assert.Len(t, opus.GainWarnings(), 2)
+	_, ok := opus.GetR128TrackGain()
+	assert.False(t, ok)
+
+	vorbis := &OggTag{Codec: Vorbis, UnmappedFields: map[string]string{ReplayGainTrackGain: "+1.25 dB"}}
+	assert.Empty(t, vorbis.GainWarnings())
+	rg, ok := vorbis.GetReplayGainTrackGain()
+	assert.True(t, ok)
+	assert.Equal(t, 1.25, rg)
+}
+
+func TestGainSaveRoundTrip(t *testing.T) {
+	b, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	tag, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.NoError(t, tag.SetR128TrackGain(-1.5))
+	out := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, out))
+
+	tag, err = ReadOGG(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	gain, ok := tag.GetR128TrackGain()
+	assert.True(t, ok)
+	assert.Equal(t, -1.5, gain)
+}
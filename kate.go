@@ -0,0 +1,271 @@
+package oggmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// kateIdentSize is the length of the Kate identification header.
+const kateIdentSize = 64
+
+// kateHeaderSize is the length of the packet type, magic and reserved byte
+// that start every Kate header.
+const kateHeaderSize = 9
+
+// Kate data packet types.
+const (
+	kateText = 0x00
+	kateEnd  = 0x7f
+)
+
+// KateHeader is the identification header that starts every Ogg Kate stream.
+type KateHeader struct {
+	VersionMajor   uint8
+	VersionMinor   uint8
+	Headers        uint8 // header packets, this one included
+	TextEncoding   uint8 // 0 for UTF-8
+	Directionality uint8
+	GranuleShift   uint8 // bits of the granule position that count from the earliest active event
+
+	GranuleRateNumerator   uint32 // granule units per second, as a fraction
+	GranuleRateDenominator uint32
+
+	Language string // RFC 3066 tag such as "en" or "de_DE"
+	Category string // such as "SUB" for subtitles or "CC" for closed captions
+}
+
+// duration converts a time in granule units to a duration.
+func (h *KateHeader) duration(units int64) time.Duration {
+	return time.Duration(units) * time.Duration(h.GranuleRateDenominator) * time.Second / time.Duration(h.GranuleRateNumerator)
+}
+
+// units returns the time a granule position stands for, in granule units.
+func (h *KateHeader) units(granule int64) int64 {
+	base := granule >> h.GranuleShift
+	return base + granule - base<<h.GranuleShift
+}
+
+func parseKateHeader(data []byte) (*KateHeader, error) {
+	if !bytes.HasPrefix(data, KateIdentPrefix) || len(data) < kateIdentSize {
+		return nil, &ErrInvalidKateHeader{Reason: "packet too short"}
+	}
+	text := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return string(b)
+	}
+	h := &KateHeader{
+		VersionMajor:           data[9],
+		VersionMinor:           data[10],
+		Headers:                data[11],
+		TextEncoding:           data[12],
+		Directionality:         data[13],
+		GranuleShift:           data[15],
+		GranuleRateNumerator:   binary.LittleEndian.Uint32(data[24:]),
+		GranuleRateDenominator: binary.LittleEndian.Uint32(data[28:]),
+		Language:               text(data[32:48]),
+		Category:               text(data[48:64]),
+	}
+	switch {
+	case h.VersionMajor != 0:
+		return nil, &ErrInvalidKateHeader{Reason: "unsupported version"}
+	case h.Headers < 2:
+		return nil, &ErrInvalidKateHeader{Reason: "too few header packets"}
+	case h.GranuleRateNumerator == 0 || h.GranuleRateDenominator == 0:
+		return nil, &ErrInvalidKateHeader{Reason: "granule rate is zero"}
+	case h.GranuleShift > 62:
+		return nil, &ErrInvalidKateHeader{Reason: "granule shift out of range"}
+	}
+	return h, nil
+}
+
+// readKateComment reads a Kate comment header, which is a Vorbis comment
+// after the common Kate header bytes.
+func (dec *OGGDecoder) readKateComment(data []byte, head *KateHeader) (*OggTag, error) {
+	if head == nil {
+		return nil, &ErrInvalidKateHeader{Reason: "comment header before the identification header"}
+	}
+	if len(data) < kateHeaderSize {
+		return nil, &ErrInvalidKateHeader{Reason: "packet too short"}
+	}
+	dec.TagReader = bytes.NewReader(data[kateHeaderSize:])
+	tag, err := dec.readComments()
+	if err != nil {
+		return nil, err
+	}
+	tag.Codec = Kate
+	tag.KateHeader = head
+	return tag, nil
+}
+
+// kateCommentPacket returns the Kate comment header SaveTags writes for the
+// stream with serial, or nothing to keep the one in the file.
+func kateCommentPacket(tag *OggTag, serial uint32) []byte {
+	if tag.Codec == Kate && tag.serial == serial {
+		return tagCommentPacket(tag)
+	}
+	for _, kate := range tag.KateTags {
+		if kate.serial == serial {
+			subtitles := *kate
+			subtitles.Codec = Kate
+			return tagCommentPacket(&subtitles)
+		}
+	}
+	return nil
+}
+
+func newKateClock(head []byte) (*streamClock, error) {
+	header, err := parseKateHeader(head)
+	if err != nil {
+		return nil, err
+	}
+	bone := SkeletonBone{
+		HeaderPackets:          uint32(header.Headers),
+		GranuleRateNumerator:   int64(header.GranuleRateNumerator),
+		GranuleRateDenominator: int64(header.GranuleRateDenominator),
+		GranuleShift:           header.GranuleShift,
+		ContentType:            "application/x-kate",
+		Role:                   "text/subtitle",
+	}
+	if header.Category == "CC" {
+		bone.Role = "text/caption"
+	}
+	if header.Language != "" {
+		bone.Fields = map[string]string{"Language": header.Language}
+	}
+	return &streamClock{
+		headers: int(header.Headers),
+		seconds: func(g int64) float64 { return header.duration(header.units(g)).Seconds() },
+		bone:    bone,
+	}, nil
+}
+
+// KateEvent is a text event of a Kate stream.
+type KateEvent struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// KateTrack is a Kate stream with its text events in the order they appear.
+type KateTrack struct {
+	Serial uint32
+	Header *KateHeader
+	Tag    *OggTag
+	Events []KateEvent
+}
+
+// ReadKateTracks reads every Kate stream of r.
+func ReadKateTracks(r io.ReadSeeker) ([]*KateTrack, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	dec := &OGGDecoder{Reader: r}
+	packets := newPacketReader(dec, 0, 0)
+	var tracks []*KateTrack
+	open := make(map[uint32]*KateTrack)
+	for {
+		packet, err := packets.next()
+		if err == io.EOF {
+			return tracks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if packet.BOS && bytes.HasPrefix(packet.Data, KateIdentPrefix) {
+			head, err := parseKateHeader(packet.Data)
+			if err != nil {
+				return nil, err
+			}
+			track := &KateTrack{Serial: packet.Serial, Header: head}
+			tracks = append(tracks, track)
+			open[packet.Serial] = track
+			continue
+		}
+		track := open[packet.Serial]
+		if track == nil || len(packet.Data) == 0 {
+			continue
+		}
+		switch {
+		case bytes.HasPrefix(packet.Data, KatePrefix):
+			if track.Tag, err = dec.readKateComment(packet.Data, track.Header); err != nil {
+				return nil, err
+			}
+		case packet.Data[0] == kateText:
+			event, err := parseKateText(packet.Data, track.Header)
+			if err != nil {
+				return nil, err
+			}
+			track.Events = append(track.Events, event)
+		}
+		if packet.EOS || packet.Data[0] == kateEnd {
+			delete(open, packet.Serial)
+		}
+	}
+}
+
+// parseKateText reads the times and text of a text data packet. What
+// follows the text, such as region and style references, is left out.
+func parseKateText(data []byte, head *KateHeader) (KateEvent, error) {
+	if len(data) < 29 {
+		return KateEvent{}, &ErrInvalidKateHeader{Reason: "text packet too short"}
+	}
+	start := int64(binary.LittleEndian.Uint64(data[1:]))
+	duration := int64(binary.LittleEndian.Uint64(data[9:]))
+	n := binary.LittleEndian.Uint32(data[25:])
+	if uint64(n) > uint64(len(data)-29) {
+		return KateEvent{}, io.ErrUnexpectedEOF
+	}
+	return KateEvent{
+		Start: head.duration(start),
+		End:   head.duration(start + duration),
+		Text:  string(data[29 : 29+n]),
+	}, nil
+}
+
+// WriteWebVTT writes the events of t as a WebVTT file.
+func (t *KateTrack) WriteWebVTT(w io.Writer) error {
+	if _, err := io.WriteString(w, "WEBVTT\n\n"); err != nil {
+		return err
+	}
+	for _, e := range t.Events {
+		text := strings.Replace(cueText(e.Text), "-->", "--&gt;", -1)
+		if _, err := fmt.Fprintf(w, "%s --> %s\n%s\n\n", cueTime(e.Start, '.'), cueTime(e.End, '.'), text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSRT writes the events of t as a SubRip file.
+func (t *KateTrack) WriteSRT(w io.Writer) error {
+	for i, e := range t.Events {
+		if _, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1, cueTime(e.Start, ','), cueTime(e.End, ','), cueText(e.Text)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cueTime formats d as hours, minutes, seconds and milliseconds, the last
+// two separated by sep.
+func cueTime(d time.Duration, sep byte) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// cueText drops the empty lines of text, which would end a cue early.
+func cueText(text string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,149 @@
+package oggmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildKateFile writes an English subtitle stream with a millisecond granule
+// rate and one text packet per event.
+func buildKateFile(t *testing.T, fields []string, events []KateEvent) []byte {
+	ident := make([]byte, kateIdentSize)
+	copy(ident, KateIdentPrefix)
+	ident[10] = 6 // version 0.6
+	ident[11] = 9 // headers
+	ident[15] = 32
+	binary.LittleEndian.PutUint32(ident[24:], 1000)
+	binary.LittleEndian.PutUint32(ident[28:], 1)
+	copy(ident[32:], "en")
+	copy(ident[48:], "SUB")
+
+	// the comment header is built by hand to pin its layout: the packet
+	// type, the magic, a reserved byte, then a Vorbis comment
+	comment := bytes.NewBufferString("\x81kate\x00\x00\x00\x00")
+	binary.Write(comment, binary.LittleEndian, uint32(len("libkate")))
+	comment.WriteString("libkate")
+	binary.Write(comment, binary.LittleEndian, uint32(len(fields)))
+	for _, field := range fields {
+		binary.Write(comment, binary.LittleEndian, uint32(len(field)))
+		comment.WriteString(field)
+	}
+	comment.WriteByte(1)
+	headers := [][]byte{comment.Bytes()}
+	for i := byte(2); i < 9; i++ {
+		headers = append(headers, append([]byte{0x80 | i}, "kate\x00\x00\x00\x00"...))
+	}
+
+	out := new(bytes.Buffer)
+	enc := &OGGEncoder{Writer: out, Serial: 0xca7e}
+	assert.NoError(t, enc.EncodeBOS(0, [][]byte{ident}))
+	assert.NoError(t, enc.Encode(0, headers))
+	var end int64
+	for _, e := range events {
+		start := int64(e.Start / time.Millisecond)
+		end = int64(e.End / time.Millisecond)
+		packet := make([]byte, 29)
+		binary.LittleEndian.PutUint64(packet[1:], uint64(start))
+		binary.LittleEndian.PutUint64(packet[9:], uint64(end-start))
+		binary.LittleEndian.PutUint64(packet[17:], 0)
+		binary.LittleEndian.PutUint32(packet[25:], uint32(len(e.Text)))
+		packet = append(packet, e.Text...)
+		assert.NoError(t, enc.Encode(start<<32, [][]byte{packet}))
+	}
+	assert.NoError(t, enc.EncodeEOS(end<<32, [][]byte{{kateEnd}}))
+	return out.Bytes()
+}
+
+var kateEvents = []KateEvent{
+	{Start: 500 * time.Millisecond, End: 1800 * time.Millisecond, Text: "Good morning."},
+	{Start: 2 * time.Second, End: 3250 * time.Millisecond, Text: "Today:\n\nOgg streams"},
+}
+
+func TestKateGrouped(t *testing.T) {
+	audio, err := os.ReadFile("./testdata/testdata-ogg-vorbis-nonEmpty.ogg")
+	assert.NoError(t, err)
+	subtitles := buildKateFile(t, []string{"TITLE=Lecture subtitles"}, kateEvents)
+	grouped := new(bytes.Buffer)
+	assert.NoError(t, Mux(grouped, bytes.NewReader(audio), bytes.NewReader(subtitles)))
+
+	tag, err := ReadOGG(bytes.NewReader(grouped.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, Vorbis, tag.Codec)
+	if !assert.Len(t, tag.KateTags, 1) {
+		return
+	}
+	kate := tag.KateTags[0]
+	assert.Equal(t, Kate, kate.Codec)
+	assert.Equal(t, "Lecture subtitles", kate.Title)
+	if assert.NotNil(t, kate.KateHeader) {
+		assert.Equal(t, "en", kate.KateHeader.Language)
+		assert.Equal(t, "SUB", kate.KateHeader.Category)
+		assert.Equal(t, uint32(1000), kate.KateHeader.GranuleRateNumerator)
+		assert.Equal(t, uint8(9), kate.KateHeader.Headers)
+	}
+
+	kate.SetTitle("Renamed subtitles")
+	out := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, out))
+	verifyPages(t, out.Bytes())
+	saved, err := ReadOGG(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, tag.Title, saved.Title)
+	if assert.Len(t, saved.KateTags, 1) {
+		assert.Equal(t, "Renamed subtitles", saved.KateTags[0].Title)
+	}
+
+	// the audio is untouched and the events survive
+	streams, err := Demux(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	if assert.Len(t, streams, 2) {
+		assert.Equal(t, readAllPackets(t, audio)[2:], readAllPackets(t, streams[0].Data)[2:])
+	}
+	tracks, err := ReadKateTracks(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	if assert.Len(t, tracks, 1) {
+		assert.Equal(t, kateEvents, tracks[0].Events)
+		assert.Equal(t, "Renamed subtitles", tracks[0].Tag.Title)
+	}
+}
+
+func TestKateOnly(t *testing.T) {
+	b := buildKateFile(t, []string{"TITLE=Only subtitles"}, kateEvents)
+	tag, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, Kate, tag.Codec)
+	assert.Equal(t, "Only subtitles", tag.Title)
+	assert.Empty(t, tag.KateTags)
+
+	tag.SetTitle("Changed")
+	out := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, out))
+	saved, err := ReadOGG(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "Changed", saved.Title)
+	assert.Equal(t, []byte("\x81kate\x00\x00\x00\x00"), readAllPackets(t, out.Bytes())[1][:kateHeaderSize])
+}
+
+func TestKateSubtitles(t *testing.T) {
+	tracks, err := ReadKateTracks(bytes.NewReader(buildKateFile(t, nil, kateEvents)))
+	assert.NoError(t, err)
+	if !assert.Len(t, tracks, 1) {
+		return
+	}
+
+	vtt := new(bytes.Buffer)
+	assert.NoError(t, tracks[0].WriteWebVTT(vtt))
+	assert.Equal(t, "WEBVTT\n\n"+
+		"00:00:00.500 --> 00:00:01.800\nGood morning.\n\n"+
+		"00:00:02.000 --> 00:00:03.250\nToday:\nOgg streams\n\n", vtt.String())
+
+	srt := new(bytes.Buffer)
+	assert.NoError(t, tracks[0].WriteSRT(srt))
+	assert.Equal(t, "1\n00:00:00,500 --> 00:00:01,800\nGood morning.\n\n"+
+		"2\n00:00:02,000 --> 00:00:03,250\nToday:\nOgg streams\n\n", srt.String())
+}
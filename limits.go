@@ -0,0 +1,51 @@
+package oggmeta
+
+// Limits bounds the memory and work the decoder will commit to a single file.
+// A zero field falls back to the matching value in DefaultLimits.
+type Limits struct {
+	MaxCommentCount     int64 // number of entries in a comment header
+	MaxFieldLength      int64 // length of the vendor string and of each comment
+	MaxPictureSize      int64 // decoded size of a METADATA_BLOCK_PICTURE image
+	MaxPicturePixels    int64 // width times height of a cover image before it is decoded
+	MaxHeaderPacketSize int64 // size of a header packet reassembled across pages
+	MaxPages            int64 // pages scanned while looking for the comment header
+}
+
+// DefaultLimits are the limits used by a decoder that does not set its own.
+var DefaultLimits = Limits{
+	MaxCommentCount:     4096,
+	MaxFieldLength:      1 << 20,
+	MaxPictureSize:      16 << 20,
+	MaxPicturePixels:    64 << 20,
+	MaxHeaderPacketSize: 32 << 20,
+	MaxPages:            4096,
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxCommentCount <= 0 {
+		l.MaxCommentCount = DefaultLimits.MaxCommentCount
+	}
+	if l.MaxFieldLength <= 0 {
+		l.MaxFieldLength = DefaultLimits.MaxFieldLength
+	}
+	if l.MaxPictureSize <= 0 {
+		l.MaxPictureSize = DefaultLimits.MaxPictureSize
+	}
+	if l.MaxPicturePixels <= 0 {
+		l.MaxPicturePixels = DefaultLimits.MaxPicturePixels
+	}
+	if l.MaxHeaderPacketSize <= 0 {
+		l.MaxHeaderPacketSize = DefaultLimits.MaxHeaderPacketSize
+	}
+	if l.MaxPages <= 0 {
+		l.MaxPages = DefaultLimits.MaxPages
+	}
+	return l
+}
+
+func checkLimit(name string, value, max int64) error {
+	if value > max {
+		return &ErrLimitExceeded{Limit: name, Value: value, Max: max}
+	}
+	return nil
+}
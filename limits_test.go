@@ -0,0 +1,159 @@
+package oggmeta
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildOpusFile writes an OpusHead page followed by the given OpusTags body.
+func buildOpusFile(t *testing.T, tags []byte) []byte {
+	src, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	dec := &OGGDecoder{Reader: bytes.NewReader(src)}
+	head, err := dec.Decode()
+	assert.NoError(t, err)
+
+	out := new(bytes.Buffer)
+	enc := &OGGEncoder{Writer: out, Serial: head.Header.SerialNumber}
+	assert.NoError(t, enc.EncodeBOS(0, head.Packets))
+	assert.NoError(t, enc.Encode(0, [][]byte{append(append([]byte{}, OpusPrefix...), tags...)}))
+	return out.Bytes()
+}
+
+func le32(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, n)
+	return b
+}
+
+// pictureTags returns an OpusTags body holding block as its only field.
+func pictureTags(block []byte) []byte {
+	field := pictureFieldPrefix + base64.StdEncoding.EncodeToString(block)
+	tags := append(le32(0), le32(1)...)
+	tags = append(tags, le32(uint32(len(field)))...)
+	return append(tags, field...)
+}
+
+func TestReadTagsLimits(t *testing.T) {
+	t.Run("comment-count", func(t *testing.T) {
+		tags := append(le32(0), le32(0xFFFFFFFF)...)
+		dec := &OGGDecoder{Reader: bytes.NewReader(buildOpusFile(t, tags))}
+		_, err := dec.ReadTags()
+		var limitErr *ErrLimitExceeded
+		assert.True(t, errors.As(err, &limitErr))
+		assert.Equal(t, "comment count", limitErr.Limit)
+	})
+
+	t.Run("field-length", func(t *testing.T) {
+		tags := append(le32(0), le32(1)...)
+		tags = append(tags, le32(0x7FFFFFFF)...)
+		dec := &OGGDecoder{Reader: bytes.NewReader(buildOpusFile(t, tags))}
+		_, err := dec.ReadTags()
+		var limitErr *ErrLimitExceeded
+		assert.True(t, errors.As(err, &limitErr))
+		assert.Equal(t, "comment length", limitErr.Limit)
+	})
+
+	t.Run("field-overruns-packet", func(t *testing.T) {
+		tags := append(le32(0), le32(1)...)
+		tags = append(tags, le32(1000)...)
+		tags = append(tags, []byte("TITLE=short")...)
+		dec := &OGGDecoder{Reader: bytes.NewReader(buildOpusFile(t, tags))}
+		_, err := dec.ReadTags()
+		assert.Error(t, err)
+	})
+
+	t.Run("custom-limits", func(t *testing.T) {
+		field := []byte("TITLE=a title longer than sixteen bytes")
+		tags := append(le32(0), le32(1)...)
+		tags = append(tags, le32(uint32(len(field)))...)
+		tags = append(tags, field...)
+		dec := &OGGDecoder{Reader: bytes.NewReader(buildOpusFile(t, tags)), Limits: Limits{MaxFieldLength: 16}}
+		_, err := dec.ReadTags()
+		var limitErr *ErrLimitExceeded
+		assert.True(t, errors.As(err, &limitErr))
+
+		dec = &OGGDecoder{Reader: bytes.NewReader(buildOpusFile(t, tags))}
+		tag, err := dec.ReadTags()
+		assert.NoError(t, err)
+		assert.Equal(t, "a title longer than sixteen bytes", tag.GetTitle())
+	})
+
+	t.Run("max-pages", func(t *testing.T) {
+		b, err := os.ReadFile("./testdata/testdata-opus.ogg")
+		assert.NoError(t, err)
+		dec := &OGGDecoder{Reader: bytes.NewReader(b), Limits: Limits{MaxPages: 1}}
+		_, err = dec.ReadTags()
+		var limitErr *ErrLimitExceeded
+		assert.True(t, errors.As(err, &limitErr))
+		assert.Equal(t, "page count", limitErr.Limit)
+	})
+
+	t.Run("picture-size", func(t *testing.T) {
+		img, err := os.ReadFile("./testdata/testdata-img-1.jpg")
+		assert.NoError(t, err)
+		block, err := createMetadataBlockPicture(img)
+		assert.NoError(t, err)
+		tags := pictureTags(block)
+		dec := &OGGDecoder{Reader: bytes.NewReader(buildOpusFile(t, tags)), Limits: Limits{MaxPictureSize: 1024}}
+		_, err = dec.ReadTags()
+		var limitErr *ErrLimitExceeded
+		assert.True(t, errors.As(err, &limitErr))
+
+		dec = &OGGDecoder{Reader: bytes.NewReader(buildOpusFile(t, tags))}
+		tag, err := dec.ReadTags()
+		assert.NoError(t, err)
+		assert.NotNil(t, tag.CoverArt)
+	})
+
+	t.Run("picture-data-length", func(t *testing.T) {
+		// a small block that claims 2 GiB of image data
+		block := append(make([]byte, 28), le32(0)...)
+		binary.BigEndian.PutUint32(block[28:], 0x7FFFFFFF)
+		dec := &OGGDecoder{Reader: bytes.NewReader(buildOpusFile(t, pictureTags(block)))}
+		_, err := dec.ReadTags()
+		var limitErr *ErrLimitExceeded
+		assert.True(t, errors.As(err, &limitErr))
+		assert.Equal(t, "picture size", limitErr.Limit)
+	})
+
+	t.Run("picture-pixels", func(t *testing.T) {
+		img, err := os.ReadFile("./testdata/testdata-img-1.jpg")
+		assert.NoError(t, err)
+		block, err := createMetadataBlockPicture(img)
+		assert.NoError(t, err)
+		dec := &OGGDecoder{Reader: bytes.NewReader(buildOpusFile(t, pictureTags(block))), Limits: Limits{MaxPicturePixels: 64}}
+		_, err = dec.ReadTags()
+		var limitErr *ErrLimitExceeded
+		assert.True(t, errors.As(err, &limitErr))
+		assert.Equal(t, "picture pixels", limitErr.Limit)
+	})
+
+	t.Run("header-packet-size", func(t *testing.T) {
+		b, err := os.ReadFile("./testdata/testdata-opus-nonEmpty.ogg")
+		assert.NoError(t, err)
+		dec := &OGGDecoder{Reader: bytes.NewReader(b), Limits: Limits{MaxHeaderPacketSize: 1024}}
+		_, err = dec.ReadTags()
+		var limitErr *ErrLimitExceeded
+		assert.True(t, errors.As(err, &limitErr))
+		assert.Equal(t, "packet size", limitErr.Limit)
+	})
+}
+
+func TestReadTagsSpanningPages(t *testing.T) {
+	title := bytes.Repeat([]byte("x"), 100000)
+	field := append([]byte("TITLE="), title...)
+	tags := append(le32(0), le32(1)...)
+	tags = append(tags, le32(uint32(len(field)))...)
+	tags = append(tags, field...)
+	dec := &OGGDecoder{Reader: bytes.NewReader(buildOpusFile(t, tags))}
+	tag, err := dec.ReadTags()
+	assert.NoError(t, err)
+	assert.Equal(t, string(title), tag.GetTitle())
+}
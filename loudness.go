@@ -0,0 +1,318 @@
+package oggmeta
+
+import (
+	"bytes"
+	"io"
+	"math"
+)
+
+// Loudness is an EBU R128 / ITU-R BS.1770-4 measurement of a file or album.
+type Loudness struct {
+	IntegratedLUFS float64 // gated integrated loudness, -Inf for digital silence
+	TruePeak       float64 // linear true peak, 1.0 is full scale
+
+	blocks []float64 // channel-weighted mean square of every 400 ms gating block
+}
+
+// TruePeakDBTP returns the true peak in dB relative to full scale.
+func (l *Loudness) TruePeakDBTP() float64 {
+	return 20 * math.Log10(l.TruePeak)
+}
+
+// WriteTrackTags stores the measurement as the track gain of tag, in the
+// R128 field for Opus and the ReplayGain fields for Vorbis.
+func (l *Loudness) WriteTrackTags(tag *OggTag) error {
+	if err := tag.SetR128TrackGain(R128ReferenceLUFS - l.IntegratedLUFS); err != nil {
+		return err
+	}
+	if tag.Codec == Opus {
+		return nil
+	}
+	return tag.SetReplayGainTrackPeak(l.TruePeak)
+}
+
+// WriteAlbumTags is the album counterpart of WriteTrackTags.
+func (l *Loudness) WriteAlbumTags(tag *OggTag) error {
+	if err := tag.SetR128AlbumGain(R128ReferenceLUFS - l.IntegratedLUFS); err != nil {
+		return err
+	}
+	if tag.Codec == Opus {
+		return nil
+	}
+	return tag.SetReplayGainAlbumPeak(l.TruePeak)
+}
+
+// pcmDecoder turns the packets of one logical stream into planar PCM.
+type pcmDecoder interface {
+	sampleRate() int
+	channels() int
+	decode(packet *Packet) ([][]float32, error)
+}
+
+// pcmDecoders are tried in order against the BOS packet of each stream.
+var pcmDecoders = []struct {
+	prefix []byte
+	new    func(head []byte) (pcmDecoder, error)
+}{
+	{VorbisIdentPrefix, newVorbisPCM},
+	{OpusHeadPrefix, newOpusPCM},
+}
+
+func newPCMDecoder(head []byte) (pcmDecoder, error) {
+	for _, d := range pcmDecoders {
+		if bytes.HasPrefix(head, d.prefix) {
+			return d.new(head)
+		}
+	}
+	return nil, &ErrUnsupportedCodec{}
+}
+
+// AnalyzeLoudness decodes the first decodable stream of r and measures its
+// integrated loudness and true peak.
+func AnalyzeLoudness(r io.ReadSeeker) (*Loudness, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	packets := newPacketReader(&OGGDecoder{Reader: r}, 0, 0)
+	var decoder pcmDecoder
+	var serial uint32
+	var m *loudnessMeter
+	for {
+		packet, err := packets.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if decoder == nil {
+			if !packet.BOS {
+				continue
+			}
+			if decoder, err = newPCMDecoder(packet.Data); err != nil {
+				if _, unsupported := err.(*ErrUnsupportedCodec); unsupported {
+					continue
+				}
+				return nil, err
+			}
+			serial = packet.Serial
+			m = newLoudnessMeter(decoder.sampleRate(), decoder.channels())
+			continue
+		}
+		if packet.Serial != serial {
+			continue
+		}
+		pcm, err := decoder.decode(packet)
+		if err != nil {
+			return nil, err
+		}
+		m.write(pcm)
+		if packet.EOS {
+			break
+		}
+	}
+	if m == nil {
+		return nil, &ErrUnsupportedCodec{}
+	}
+	return m.result(), nil
+}
+
+// AnalyzeAlbumLoudness measures every file and the album as a whole. The
+// album loudness gates the blocks of all tracks together, as EBU R128 asks.
+func AnalyzeAlbumLoudness(rs ...io.ReadSeeker) (*Loudness, []*Loudness, error) {
+	album := &Loudness{}
+	tracks := make([]*Loudness, 0, len(rs))
+	for _, r := range rs {
+		track, err := AnalyzeLoudness(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		tracks = append(tracks, track)
+		album.blocks = append(album.blocks, track.blocks...)
+		album.TruePeak = math.Max(album.TruePeak, track.TruePeak)
+	}
+	album.IntegratedLUFS = gatedLoudness(album.blocks)
+	return album, tracks, nil
+}
+
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// kWeighting returns the BS.1770 pre-filter and RLB high-pass for a sample rate.
+func kWeighting(rate float64) (biquad, biquad) {
+	const (
+		shelfF0   = 1681.974450955533
+		shelfGain = 3.999843853973347
+		shelfQ    = 0.7071752369554196
+		highF0    = 38.13547087602444
+		highQ     = 0.5003270373238773
+	)
+	k := math.Tan(math.Pi * shelfF0 / rate)
+	vh := math.Pow(10, shelfGain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/shelfQ + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/shelfQ + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/shelfQ + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/shelfQ + k*k) / a0,
+	}
+
+	k = math.Tan(math.Pi * highF0 / rate)
+	a0 = 1 + k/highQ + k*k
+	high := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/highQ + k*k) / a0,
+	}
+	return shelf, high
+}
+
+// channelWeights follows the Vorbis channel order, which Opus mapping family 1 shares.
+func channelWeights(channels int) []float64 {
+	switch channels {
+	case 4:
+		return []float64{1, 1, 1.41, 1.41}
+	case 5:
+		return []float64{1, 1, 1, 1.41, 1.41}
+	case 6:
+		return []float64{1, 1, 1, 1.41, 1.41, 0}
+	case 7:
+		return []float64{1, 1, 1, 1.41, 1.41, 1.41, 0}
+	case 8:
+		return []float64{1, 1, 1, 1.41, 1.41, 1.41, 1.41, 0}
+	}
+	weights := make([]float64, channels)
+	for i := range weights {
+		weights[i] = 1
+	}
+	return weights
+}
+
+// truePeakTaps are the 4x oversampling polyphase filter from BS.1770-4 annex 2.
+var truePeakTaps = [4][12]float64{
+	{0.0017089843750, 0.0109863281250, -0.0196533203125, 0.0332031250000, -0.0594482421875, 0.1373291015625, 0.9721679687500, -0.1022949218750, 0.0476074218750, -0.0266113281250, 0.0148925781250, -0.0083007812500},
+	{-0.0291748046875, 0.0292968750000, -0.0517578125000, 0.0891113281250, -0.1665039062500, 0.4650878906250, 0.7797851562500, -0.2003173828125, 0.1015625000000, -0.0582275390625, 0.0330810546875, -0.0189208984375},
+	{-0.0189208984375, 0.0330810546875, -0.0582275390625, 0.1015625000000, -0.2003173828125, 0.7797851562500, 0.4650878906250, -0.1665039062500, 0.0891113281250, -0.0517578125000, 0.0292968750000, -0.0291748046875},
+	{-0.0083007812500, 0.0148925781250, -0.0266113281250, 0.0476074218750, -0.1022949218750, 0.9721679687500, 0.1373291015625, -0.0594482421875, 0.0332031250000, -0.0196533203125, 0.0109863281250, 0.0017089843750},
+}
+
+type loudnessMeter struct {
+	weights  []float64
+	shelf    []biquad
+	high     []biquad
+	history  [][12]float64 // last input samples per channel for true peak oversampling
+	step     int           // samples per 100 ms
+	count    int
+	sum      []float64 // per channel sum of squares in the current 100 ms step
+	steps    []float64 // weighted mean square of every complete 100 ms step
+	truePeak float64
+}
+
+func newLoudnessMeter(rate, channels int) *loudnessMeter {
+	m := &loudnessMeter{
+		weights: channelWeights(channels),
+		shelf:   make([]biquad, channels),
+		high:    make([]biquad, channels),
+		history: make([][12]float64, channels),
+		step:    rate / 10,
+		sum:     make([]float64, channels),
+	}
+	for c := range m.shelf {
+		m.shelf[c], m.high[c] = kWeighting(float64(rate))
+	}
+	return m
+}
+
+func (m *loudnessMeter) write(pcm [][]float32) {
+	if len(pcm) == 0 {
+		return
+	}
+	for i := range pcm[0] {
+		for c := range m.sum {
+			if c >= len(pcm) {
+				continue
+			}
+			x := float64(pcm[c][i])
+			m.peak(c, x)
+			y := m.high[c].process(m.shelf[c].process(x))
+			m.sum[c] += y * y
+		}
+		m.count++
+		if m.count == m.step {
+			var power float64
+			for c, s := range m.sum {
+				power += m.weights[c] * s / float64(m.step)
+				m.sum[c] = 0
+			}
+			m.steps = append(m.steps, power)
+			m.count = 0
+		}
+	}
+}
+
+func (m *loudnessMeter) peak(c int, x float64) {
+	h := &m.history[c]
+	copy(h[1:], h[:11])
+	h[0] = x
+	for _, taps := range truePeakTaps {
+		var y float64
+		for k, t := range taps {
+			y += t * h[k]
+		}
+		m.truePeak = math.Max(m.truePeak, math.Abs(y))
+	}
+	m.truePeak = math.Max(m.truePeak, math.Abs(x))
+}
+
+func (m *loudnessMeter) result() *Loudness {
+	l := &Loudness{TruePeak: m.truePeak}
+	for i := 3; i < len(m.steps); i++ {
+		l.blocks = append(l.blocks, (m.steps[i-3]+m.steps[i-2]+m.steps[i-1]+m.steps[i])/4)
+	}
+	l.IntegratedLUFS = gatedLoudness(l.blocks)
+	return l
+}
+
+func blockLoudness(power float64) float64 {
+	return -0.691 + 10*math.Log10(power)
+}
+
+// gatedLoudness applies the -70 LUFS absolute gate and the -10 LU relative gate.
+func gatedLoudness(blocks []float64) float64 {
+	mean := func(threshold float64) (float64, int) {
+		var sum float64
+		var n int
+		for _, p := range blocks {
+			if blockLoudness(p) > threshold {
+				sum += p
+				n++
+			}
+		}
+		if n == 0 {
+			return 0, 0
+		}
+		return sum / float64(n), n
+	}
+	power, n := mean(-70)
+	if n == 0 {
+		return math.Inf(-1)
+	}
+	power, n = mean(blockLoudness(power) - 10)
+	if n == 0 {
+		return math.Inf(-1)
+	}
+	return blockLoudness(power)
+}
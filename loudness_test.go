@@ -0,0 +1,107 @@
+package oggmeta
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sine(rate, seconds int, freq, amplitude float64) []float32 {
+	out := make([]float32, rate*seconds)
+	for i := range out {
+		out[i] = float32(amplitude * math.Sin(2*math.Pi*freq*float64(i)/float64(rate)))
+	}
+	return out
+}
+
+func TestLoudnessMeter(t *testing.T) {
+	// EBU Tech 3341 case 1: stereo 1 kHz sine at -23 dBFS measures -23 LUFS.
+	for _, rate := range []int{44100, 48000} {
+		s := sine(rate, 20, 1000, math.Pow(10, -23.0/20))
+		m := newLoudnessMeter(rate, 2)
+		m.write([][]float32{s, s})
+		l := m.result()
+		assert.InDelta(t, -23, l.IntegratedLUFS, 0.1)
+		assert.InDelta(t, -23, l.TruePeakDBTP(), 0.2)
+	}
+
+	// a quiet section below the relative gate does not pull the result down
+	loud := sine(48000, 10, 1000, math.Pow(10, -20.0/20))
+	quiet := sine(48000, 10, 1000, math.Pow(10, -40.0/20))
+	m := newLoudnessMeter(48000, 1)
+	m.write([][]float32{loud})
+	m.write([][]float32{quiet})
+	assert.InDelta(t, -23.01, m.result().IntegratedLUFS, 0.1)
+
+	silent := newLoudnessMeter(48000, 2)
+	silent.write([][]float32{make([]float32, 48000), make([]float32, 48000)})
+	assert.True(t, math.IsInf(silent.result().IntegratedLUFS, -1))
+}
+
+func TestLoudnessWriteTags(t *testing.T) {
+	l := &Loudness{IntegratedLUFS: -14, TruePeak: 0.9}
+	vorbis := &OggTag{Codec: Vorbis}
+	assert.NoError(t, l.WriteTrackTags(vorbis))
+	gain, ok := vorbis.GetReplayGainTrackGain()
+	assert.True(t, ok)
+	assert.Equal(t, -4.0, gain)
+	peak, ok := vorbis.GetReplayGainTrackPeak()
+	assert.True(t, ok)
+	assert.Equal(t, 0.9, peak)
+
+	opus := &OggTag{Codec: Opus}
+	assert.NoError(t, l.WriteAlbumTags(opus))
+	assert.Equal(t, "-2304", opus.UnmappedFields[R128AlbumGain])
+
+	silence := &Loudness{IntegratedLUFS: math.Inf(-1)}
+	assert.Error(t, silence.WriteTrackTags(opus))
+}
+
+func TestAnalyzeLoudnessVorbis(t *testing.T) {
+	b, err := os.ReadFile("./testdata/test1.ogg")
+	assert.NoError(t, err)
+	track, err := AnalyzeLoudness(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.InDelta(t, -9.6, track.IntegratedLUFS, 0.5)
+	assert.InDelta(t, 1.0, track.TruePeak, 0.1)
+
+	b2, err := os.ReadFile("./testdata/testdata-ogg.ogg")
+	assert.NoError(t, err)
+	album, tracks, err := AnalyzeAlbumLoudness(bytes.NewReader(b), bytes.NewReader(b2))
+	assert.NoError(t, err)
+	assert.Len(t, tracks, 2)
+	assert.GreaterOrEqual(t, album.IntegratedLUFS, math.Min(tracks[0].IntegratedLUFS, tracks[1].IntegratedLUFS))
+	assert.LessOrEqual(t, album.IntegratedLUFS, math.Max(tracks[0].IntegratedLUFS, tracks[1].IntegratedLUFS))
+
+	tag, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.NoError(t, track.WriteTrackTags(tag))
+	assert.NoError(t, album.WriteAlbumTags(tag))
+	out := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, out))
+	tag, err = ReadOGG(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	gain, ok := tag.GetReplayGainTrackGain()
+	assert.True(t, ok)
+	assert.InDelta(t, ReplayGainReferenceLUFS-track.IntegratedLUFS, gain, 0.01)
+}
+
+func TestAnalyzeLoudnessOpus(t *testing.T) {
+	b, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	// -9.64 LUFS is the BS.1770-4 loudness of the file as decoded by an
+	// independent Opus decoder and measured by an independent meter.
+	track, err := AnalyzeLoudness(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.InDelta(t, -9.64, track.IntegratedLUFS, 0.01)
+
+	tag, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.NoError(t, track.WriteTrackTags(tag))
+	gain, ok := tag.GetR128TrackGain()
+	assert.True(t, ok)
+	assert.InDelta(t, R128ReferenceLUFS-track.IntegratedLUFS, gain, 1.0/256)
+}
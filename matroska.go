@@ -0,0 +1,860 @@
+package oggmeta
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+)
+
+// matroskaTagNames maps the Matroska names of tags to the Vorbis comment
+// names oggmeta uses, where the two differ.
+var matroskaTagNames = map[string]string{"ALBUM_ARTIST": "ALBUMARTIST", "PART_NUMBER": "TRACKNUMBER", "TOTAL_PARTS": "TRACKTOTAL"}
+
+// matroskaAlbumNames maps the names of tags at the album level to the
+// Vorbis comment names oggmeta uses.
+var matroskaAlbumNames = map[string]string{"TITLE": "ALBUM", "ARTIST": "ALBUMARTIST", "TOTAL_PARTS": "TRACKTOTAL"}
+
+// Matroska target levels, as TargetTypeValue.
+const (
+	mkvTargetTrack = 30
+	mkvTargetAlbum = 50
+)
+
+// matroskaTagOrder is the order SaveTags writes the mapped fields of a tag in.
+var matroskaTagOrder = []string{"TITLE", "ARTIST", "ALBUMARTIST", "ALBUM", "TRACKNUMBER", "TRACKTOTAL", "DISCNUMBER", "DISCTOTAL", "GENRE", "COMPOSER", "BPM", "COPYRIGHT", "ENCODER"}
+
+// matroskaTrack is the audio track oggmeta reads from a Matroska file.
+type matroskaTrack struct {
+	number  uint64
+	uid     uint64
+	codec   string
+	private []byte
+}
+
+// matroskaFile holds the elements of a Matroska Segment that oggmeta reads or rewrites.
+type matroskaFile struct {
+	segment  ebmlElement
+	scale    int64 // nanoseconds per timestamp unit
+	seekHead *ebmlElement
+	track    *matroskaTrack
+	tags     []ebmlElement
+	tagsData [][]byte
+	cover    []byte // the first image attachment
+	end      int64  // where the Segment's elements end
+}
+
+// isMatroska reports whether r continues with an EBML header, leaving its
+// offset unchanged.
+func isMatroska(r io.ReadSeeker) (bool, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	magic := make([]byte, 4)
+	n, err := io.ReadFull(r, magic)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	if _, err = r.Seek(start, io.SeekStart); err != nil {
+		return false, err
+	}
+	return n == 4 && ebmlUint(magic) == ebmlHeaderID, nil
+}
+
+// readMatroska reads the tags of the Opus or Vorbis track of a Matroska file.
+func (dec *OGGDecoder) readMatroska(ctx context.Context) (*OggTag, error) {
+	limits := dec.Limits.withDefaults()
+	start, err := dec.Reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	f, err := readMatroskaFile(ctx, &ebmlReader{r: dec.Reader, pos: start}, limits, true)
+	if err != nil {
+		return nil, err
+	}
+	tag := &OggTag{}
+	switch f.track.codec {
+	case "A_OPUS":
+		if tag.OpusHead, err = parseOpusHead(f.track.private); err != nil {
+			return nil, err
+		}
+		tag.Codec = Opus
+	case "A_VORBIS":
+		headers, err := xiphUnlace(f.track.private)
+		if err != nil {
+			return nil, err
+		}
+		if len(headers) != 3 || !bytes.HasPrefix(headers[1], VorbisPrefix) {
+			return nil, &ErrInvalidMatroska{Reason: "invalid Vorbis CodecPrivate"}
+		}
+		dec.TagReader = bytes.NewReader(headers[1][len(VorbisPrefix):])
+		if tag, err = dec.readComments(); err != nil {
+			return nil, err
+		}
+		tag.Codec = Vorbis
+	}
+	var simple []matroskaSimpleTag
+	track := false // a Tag for the track, even an empty one, wins over CodecPrivate
+	for _, data := range f.tagsData {
+		found, named, err := matroskaSimpleTags(data, f.track.uid)
+		if err != nil {
+			return nil, err
+		}
+		simple = append(simple, found...)
+		track = track || named
+	}
+	if len(simple) > 0 || track {
+		// fields of the track win over those of the album
+		fields := make(map[string]string)
+		for _, level := range []uint64{mkvTargetAlbum, mkvTargetTrack} {
+			for _, field := range simple {
+				if field.level != level {
+					continue
+				}
+				name := strings.ToUpper(field.name)
+				if vorbis, ok := matroskaAlbumNames[name]; ok && level == mkvTargetAlbum {
+					name = vorbis
+				} else if vorbis, ok := matroskaTagNames[name]; ok {
+					name = vorbis
+				}
+				fields[name] = field.value
+			}
+		}
+		vendor := tag.Vendor
+		*tag = OggTag{Codec: tag.Codec, OpusHead: tag.OpusHead, Vendor: vendor}
+		for name, value := range fields {
+			setCommentField(tag, name, value)
+		}
+	}
+	if err = dec.decodeCoverArt(tag, f.cover); err != nil {
+		return nil, err
+	}
+	tag.reader = dec.Reader
+	return tag, nil
+}
+
+// readMatroskaFile reads the EBML header and the level 1 elements of the
+// first Segment, skipping Clusters. Attachments are only read if attachments is set.
+func readMatroskaFile(ctx context.Context, er *ebmlReader, limits Limits, attachments bool) (*matroskaFile, error) {
+	header, err := er.element()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if header.id != ebmlHeaderID {
+		return nil, &ErrInvalidMatroska{Reason: "missing EBML header"}
+	}
+	data, err := er.read(header, "EBML header size", 4096)
+	if err != nil {
+		return nil, err
+	}
+	children, err := ebmlChildren(data)
+	if err != nil {
+		return nil, err
+	}
+	docType := "matroska"
+	for _, child := range children {
+		if child.id == ebmlDocTypeID {
+			docType = string(bytes.TrimRight(child.data, "\x00"))
+		}
+	}
+	if docType != "matroska" && docType != "webm" {
+		return nil, &ErrInvalidMatroska{Reason: "unsupported document type " + docType}
+	}
+
+	f := &matroskaFile{scale: 1000000}
+	for {
+		e, err := er.element()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if e.id == mkvSegmentID {
+			f.segment = e
+			break
+		}
+		if e.size < 0 {
+			return nil, &ErrInvalidMatroska{Reason: "element of unknown size before the Segment"}
+		}
+		if err = er.seek(e.end()); err != nil {
+			return nil, err
+		}
+	}
+
+	pos := f.segment.data
+loop:
+	for f.segment.size < 0 || pos < f.segment.end() {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err = er.seek(pos); err != nil {
+			return nil, err
+		}
+		e, err := er.element()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch e.id {
+		case ebmlHeaderID, mkvSegmentID:
+			// a following Segment, which oggmeta leaves alone
+			break loop
+		case mkvSeekHeadID:
+			if f.seekHead == nil {
+				f.seekHead = &e
+			}
+		case mkvInfoID:
+			data, err := er.read(e, "Info size", limits.MaxHeaderPacketSize)
+			if err != nil {
+				return nil, err
+			}
+			info, err := ebmlChildren(data)
+			if err != nil {
+				return nil, err
+			}
+			for _, child := range info {
+				if child.id == mkvTimestampScaleID && ebmlUint(child.data) != 0 {
+					f.scale = int64(ebmlUint(child.data))
+				}
+			}
+		case mkvTracksID:
+			data, err := er.read(e, "Tracks size", limits.MaxHeaderPacketSize)
+			if err != nil {
+				return nil, err
+			}
+			if f.track, err = matroskaAudioTrack(data); err != nil {
+				return nil, err
+			}
+		case mkvTagsID:
+			data, err := er.read(e, "Tags size", limits.MaxHeaderPacketSize)
+			if err != nil {
+				return nil, err
+			}
+			f.tags = append(f.tags, e)
+			f.tagsData = append(f.tagsData, data)
+		case mkvAttachmentsID:
+			if !attachments || f.cover != nil {
+				break
+			}
+			data, err := er.read(e, "Attachments size", limits.MaxPictureSize+limits.MaxHeaderPacketSize)
+			if err != nil {
+				return nil, err
+			}
+			if f.cover, err = matroskaCover(data); err != nil {
+				return nil, err
+			}
+		}
+		if e.size >= 0 {
+			pos = e.end()
+			continue
+		}
+		if e.id != mkvClusterID {
+			return nil, &ErrInvalidMatroska{Reason: "element of unknown size"}
+		}
+		if pos, err = er.skipUnknown(e); err != nil {
+			return nil, err
+		}
+	}
+	f.end = pos
+	if f.segment.size >= 0 && f.end > f.segment.end() {
+		return nil, &ErrInvalidMatroska{Reason: "element overruns the Segment"}
+	}
+	if f.track == nil {
+		return nil, &ErrUnsupportedCodec{}
+	}
+	return f, nil
+}
+
+// skipUnknown returns the offset of the element that ends e, which is of
+// unknown size, or of the end of the file.
+func (er *ebmlReader) skipUnknown(e ebmlElement) (int64, error) {
+	if err := er.seek(e.data); err != nil {
+		return 0, err
+	}
+	for {
+		child, err := er.element()
+		if err == io.EOF {
+			return child.offset, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if ebmlTopLevel[child.id] {
+			return child.offset, nil
+		}
+		if child.size < 0 {
+			return 0, &ErrInvalidMatroska{Reason: "element of unknown size"}
+		}
+		if err = er.seek(child.end()); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// matroskaAudioTrack returns the first Opus or Vorbis audio track of Tracks.
+func matroskaAudioTrack(data []byte) (*matroskaTrack, error) {
+	entries, err := ebmlChildren(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.id != mkvTrackEntryID {
+			continue
+		}
+		children, err := ebmlChildren(entry.data)
+		if err != nil {
+			return nil, err
+		}
+		track := &matroskaTrack{}
+		var kind uint64
+		for _, child := range children {
+			switch child.id {
+			case mkvTrackNumberID:
+				track.number = ebmlUint(child.data)
+			case mkvTrackUIDID:
+				track.uid = ebmlUint(child.data)
+			case mkvTrackTypeID:
+				kind = ebmlUint(child.data)
+			case mkvCodecIDID:
+				track.codec = string(bytes.TrimRight(child.data, "\x00"))
+			case mkvCodecPrivateID:
+				track.private = child.data
+			}
+		}
+		if kind == 2 && (track.codec == "A_OPUS" || track.codec == "A_VORBIS") {
+			return track, nil
+		}
+	}
+	return nil, &ErrUnsupportedCodec{}
+}
+
+// xiphUnlace splits the packets of a CodecPrivate that uses Xiph lacing.
+func xiphUnlace(data []byte) ([][]byte, error) {
+	if len(data) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	count := int(data[0]) + 1
+	data = data[1:]
+	sizes := make([]int, count-1)
+	for i := range sizes {
+		for {
+			if len(data) == 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			b := data[0]
+			data = data[1:]
+			sizes[i] += int(b)
+			if b != 255 {
+				break
+			}
+		}
+	}
+	packets := make([][]byte, 0, count)
+	for _, size := range sizes {
+		if size > len(data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		packets = append(packets, data[:size])
+		data = data[size:]
+	}
+	return append(packets, data), nil
+}
+
+// matroskaTagApplies reports whether a Tag is about the whole file or the
+// track with uid, from its Targets, and returns its TargetTypeValue and
+// whether the Targets name the track. A Tag without a TargetTypeValue is
+// taken to be about the track, as ffmpeg writes them, although the default
+// is the album level.
+func matroskaTagApplies(children []ebmlChild, uid uint64) (uint64, bool, bool) {
+	level := uint64(mkvTargetTrack)
+	named := false
+	for _, child := range children {
+		if child.id != mkvTargetsID {
+			continue
+		}
+		targets, err := ebmlChildren(child.data)
+		if err != nil {
+			return 0, false, false
+		}
+		for _, target := range targets {
+			switch target.id {
+			case mkvTargetTypeValueID:
+				level = ebmlUint(target.data)
+			case mkvTagTrackUIDID:
+				if v := ebmlUint(target.data); v != 0 && v != uid {
+					return 0, false, false
+				} else if v == uid {
+					named = true
+				}
+			default:
+				// editions, chapters and attachments
+				if ebmlUint(target.data) != 0 {
+					return 0, false, false
+				}
+			}
+		}
+	}
+	return level, named, true
+}
+
+// matroskaSimpleTag is a SimpleTag with the TargetTypeValue of its Tag.
+type matroskaSimpleTag struct {
+	level       uint64
+	name, value string
+}
+
+// matroskaSimpleTags returns the SimpleTags in Tags that apply to the track
+// with uid, and whether a Tag names the track, even one left empty.
+func matroskaSimpleTags(data []byte, uid uint64) ([]matroskaSimpleTag, bool, error) {
+	tags, err := ebmlChildren(data)
+	if err != nil {
+		return nil, false, err
+	}
+	var fields []matroskaSimpleTag
+	track := false
+	for _, tag := range tags {
+		if tag.id != mkvTagID {
+			continue
+		}
+		children, err := ebmlChildren(tag.data)
+		if err != nil {
+			return nil, false, err
+		}
+		level, named, ok := matroskaTagApplies(children, uid)
+		if !ok {
+			continue
+		}
+		track = track || named
+		for _, child := range children {
+			if child.id != mkvSimpleTagID {
+				continue
+			}
+			simple, err := ebmlChildren(child.data)
+			if err != nil {
+				return nil, false, err
+			}
+			var name, value string
+			for _, s := range simple {
+				switch s.id {
+				case mkvTagNameID:
+					name = string(bytes.TrimRight(s.data, "\x00"))
+				case mkvTagStringID:
+					value = string(bytes.TrimRight(s.data, "\x00"))
+				}
+			}
+			if name != "" {
+				fields = append(fields, matroskaSimpleTag{level, name, value})
+			}
+		}
+	}
+	return fields, track, nil
+}
+
+// matroskaCover returns the data of the first image in Attachments.
+func matroskaCover(data []byte) ([]byte, error) {
+	files, err := ebmlChildren(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.id != mkvAttachedFileID {
+			continue
+		}
+		children, err := ebmlChildren(file.data)
+		if err != nil {
+			return nil, err
+		}
+		var mime string
+		var image []byte
+		for _, child := range children {
+			switch child.id {
+			case mkvFileMimeTypeID:
+				mime = string(child.data)
+			case mkvFileDataID:
+				image = child.data
+			}
+		}
+		if strings.HasPrefix(mime, "image/") && image != nil {
+			return image, nil
+		}
+	}
+	return nil, nil
+}
+
+// matroskaTags builds the Tags element SaveTags writes.
+func matroskaTags(tag *OggTag, f *matroskaFile) ([]byte, error) {
+	var data []byte
+	for _, old := range f.tagsData {
+		tags, err := ebmlChildren(old)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tags {
+			if t.id != mkvTagID {
+				continue
+			}
+			children, err := ebmlChildren(t.data)
+			if err != nil {
+				return nil, err
+			}
+			if _, _, ok := matroskaTagApplies(children, f.track.uid); !ok {
+				data = append(data, t.raw...)
+			}
+		}
+	}
+
+	var fields [][2]string
+	for _, name := range matroskaTagOrder {
+		fields = append(fields, [2]string{name, commentField(tag, name)})
+	}
+	keys := make([]string, 0, len(tag.UnmappedFields))
+	for key := range tag.UnmappedFields {
+		if _, mapped := tagFieldMapping[key]; !mapped && key != "METADATA_BLOCK_PICTURE" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fields = append(fields, [2]string{key, tag.UnmappedFields[key]})
+	}
+
+	// one Tag for the album and one for the track, both targeting the track
+	var simple [2][]byte
+	var count [2]int
+	for i, level := range []uint64{mkvTargetAlbum, mkvTargetTrack} {
+		targets := appendEBMLUint(nil, mkvTargetTypeValueID, level)
+		targets = appendEBMLUint(targets, mkvTagTrackUIDID, f.track.uid)
+		simple[i] = appendEBMLElement(nil, mkvTargetsID, targets)
+	}
+	for _, field := range fields {
+		if field[1] == "" {
+			continue
+		}
+		i, name := 1, field[0]
+		for matroska, vorbis := range matroskaAlbumNames {
+			if vorbis == name {
+				i, name = 0, matroska
+			}
+		}
+		for matroska, vorbis := range matroskaTagNames {
+			if vorbis == name {
+				name = matroska
+			}
+		}
+		s := appendEBMLElement(nil, mkvTagNameID, []byte(name))
+		s = appendEBMLElement(s, mkvTagStringID, []byte(field[1]))
+		simple[i] = appendEBMLElement(simple[i], mkvSimpleTagID, s)
+		count[i]++
+	}
+	if count[0] > 0 {
+		data = appendEBMLElement(data, mkvTagID, simple[0])
+	}
+	data = appendEBMLElement(data, mkvTagID, simple[1])
+	return appendEBMLElement(nil, mkvTagsID, data), nil
+}
+
+// fitEBMLElement encodes an element to fill exactly n bytes, or returns nil.
+func fitEBMLElement(id uint32, data []byte, n int) []byte {
+	b := appendEBMLElement(nil, id, data)
+	switch {
+	case len(b) == n:
+		return b
+	case len(b)+2 <= n:
+		return append(b, ebmlVoid(n-len(b))...)
+	case len(b)+1 == n:
+		// one byte is too short for a Void, so the size takes it
+		width := len(appendEBMLSize(nil, uint64(len(data)), 0)) + 1
+		if width > 8 {
+			return nil
+		}
+		b = appendEBMLSize(appendEBMLID(nil, id), uint64(len(data)), width)
+		return append(b, data...)
+	}
+	return nil
+}
+
+// saveMatroska returns the Matroska file of tag with its Tags replaced,
+// leaving everything ahead of the Tags where it was.
+func saveMatroska(ctx context.Context, tag *OggTag) ([]byte, error) {
+	if _, err := tag.reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(tag.reader)
+	if err != nil {
+		return nil, err
+	}
+	er := &ebmlReader{r: bytes.NewReader(data)}
+	f, err := readMatroskaFile(ctx, er, DefaultLimits, false)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := matroskaTags(tag, f)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.tags) > 0 {
+		first := f.tags[0]
+		space := voidFollowing(data, first.end()) - first.offset
+		if b := fitTags(tags, int(space)); b != nil {
+			copy(data[first.offset:], b)
+			voidElements(data, f.tags[1:])
+			return data, nil
+		}
+	}
+	voidElements(data, f.tags)
+
+	at := f.end
+	out := make([]byte, 0, len(data)+len(tags))
+	out = append(append(append(out, data[:at]...), tags...), data[at:]...)
+	if f.segment.size >= 0 {
+		width := int(f.segment.data - f.segment.offset - 4)
+		size := uint64(f.segment.size) + uint64(len(tags))
+		if width < 8 && size >= 1<<(7*uint(width))-1 {
+			return nil, &ErrInvalidMatroska{Reason: "Segment size field too short for the new Tags"}
+		}
+		copy(out[f.segment.offset+4:], appendEBMLSize(nil, size, width))
+	}
+	if f.seekHead != nil {
+		if err = seekTags(out, f, uint64(at-f.segment.data)); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// fitTags fits an encoded Tags element into n bytes.
+func fitTags(tags []byte, n int) []byte {
+	er := &ebmlReader{r: bytes.NewReader(tags)}
+	e, err := er.element()
+	if err != nil {
+		return nil
+	}
+	return fitEBMLElement(mkvTagsID, tags[e.data:], n)
+}
+
+// voidFollowing returns the end of the Void elements that start at pos, or
+// pos if there are none.
+func voidFollowing(data []byte, pos int64) int64 {
+	for pos < int64(len(data)) {
+		er := &ebmlReader{r: bytes.NewReader(data[pos:])}
+		e, err := er.element()
+		if err != nil || e.id != ebmlVoidID || e.size < 0 || pos+e.end() > int64(len(data)) {
+			break
+		}
+		pos += e.end()
+	}
+	return pos
+}
+
+// voidElements overwrites elements with Voids of the same length.
+func voidElements(data []byte, elements []ebmlElement) {
+	for _, e := range elements {
+		copy(data[e.offset:], ebmlVoid(int(e.end()-e.offset)))
+	}
+}
+
+// seekTags points the SeekHead entry for Tags at pos.
+func seekTags(data []byte, f *matroskaFile, pos uint64) error {
+	head := *f.seekHead
+	children, err := ebmlChildren(data[head.data:head.end()])
+	if err != nil {
+		return err
+	}
+	tagsID := appendEBMLID(nil, mkvTagsID)
+	var kept []byte
+	for _, child := range children {
+		if child.id == ebmlCRC32ID {
+			// dropped, as the checksum would no longer match
+			continue
+		}
+		if child.id == mkvSeekID {
+			entry, err := ebmlChildren(child.data)
+			if err != nil {
+				return err
+			}
+			tags := false
+			for _, e := range entry {
+				tags = tags || e.id == mkvSeekIDID && bytes.Equal(e.data, tagsID)
+			}
+			if tags {
+				continue
+			}
+		}
+		kept = append(kept, child.raw...)
+	}
+	space := int(voidFollowing(data, head.end()) - head.offset)
+	entry := appendEBMLElement(nil, mkvSeekIDID, tagsID)
+	entry = appendEBMLUint(entry, mkvSeekPositionID, pos)
+	b := fitEBMLElement(mkvSeekHeadID, appendEBMLElement(kept, mkvSeekID, entry), space)
+	if b == nil {
+		b = fitEBMLElement(mkvSeekHeadID, kept, space)
+	}
+	if b == nil {
+		return &ErrInvalidMatroska{Reason: "SeekHead cannot be rewritten"}
+	}
+	copy(data[head.offset:], b)
+	return nil
+}
+
+// matroskaBlock is a SimpleBlock or Block with its frames split.
+type matroskaBlock struct {
+	track   uint64
+	time    int64 // in timestamp units, the Cluster's included
+	frames  [][]byte
+	discard int64 // DiscardPadding in nanoseconds
+}
+
+// parseMatroskaBlock reads a block of a Cluster with timestamp cluster.
+func parseMatroskaBlock(data []byte, cluster int64) (*matroskaBlock, error) {
+	er := &ebmlReader{r: bytes.NewReader(data)}
+	track, _, err := er.vint(false)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	rest := data[er.pos:]
+	if len(rest) < 3 {
+		return nil, &ErrInvalidMatroska{Reason: "block too short"}
+	}
+	b := &matroskaBlock{track: track, time: cluster + int64(int16(uint16(rest[0])<<8|uint16(rest[1])))}
+	lacing := rest[2] >> 1 & 3
+	rest = rest[3:]
+	if lacing == 0 {
+		b.frames = [][]byte{rest}
+		return b, nil
+	}
+	if len(rest) == 0 {
+		return nil, &ErrInvalidMatroska{Reason: "block too short"}
+	}
+	switch lacing {
+	case 1:
+		b.frames, err = xiphUnlace(rest)
+		if err != nil {
+			return nil, err
+		}
+	case 2:
+		count := int(rest[0]) + 1
+		rest = rest[1:]
+		if len(rest)%count != 0 {
+			return nil, &ErrInvalidMatroska{Reason: "fixed size lacing does not divide the block"}
+		}
+		size := len(rest) / count
+		for i := 0; i < count; i++ {
+			b.frames = append(b.frames, rest[i*size:(i+1)*size])
+		}
+	case 3:
+		count := int(rest[0]) + 1
+		er := &ebmlReader{r: bytes.NewReader(rest[1:])}
+		sizes := make([]int64, count-1)
+		for i := range sizes {
+			start := er.pos
+			v, _, err := er.vint(false)
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			sizes[i] = int64(v)
+			if i > 0 {
+				// later sizes are differences, biased to be unsigned
+				width := uint(er.pos - start)
+				sizes[i] = sizes[i-1] + int64(v) - (1<<(7*width-1) - 1)
+			}
+		}
+		rest = rest[1+er.pos:]
+		for _, size := range sizes {
+			if size < 0 || size > int64(len(rest)) {
+				return nil, &ErrInvalidMatroska{Reason: "laced frame overruns the block"}
+			}
+			b.frames = append(b.frames, rest[:size])
+			rest = rest[size:]
+		}
+		b.frames = append(b.frames, rest)
+	}
+	return b, nil
+}
+
+// readMatroskaBlocks calls fn with the blocks of the track of f, in the
+// order they appear in the Clusters.
+func readMatroskaBlocks(er *ebmlReader, f *matroskaFile, limits Limits, fn func(*matroskaBlock) error) error {
+	pos := f.segment.data
+	for pos < f.end {
+		if err := er.seek(pos); err != nil {
+			return err
+		}
+		e, err := er.element()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		if e.id != mkvClusterID {
+			pos = e.end()
+			continue
+		}
+		var cluster int64
+		pos = e.data
+		for e.size < 0 || pos < e.end() {
+			if err = er.seek(pos); err != nil {
+				return err
+			}
+			child, err := er.element()
+			if err == io.EOF || err == nil && e.size < 0 && ebmlTopLevel[child.id] {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			var block *matroskaBlock
+			switch child.id {
+			case mkvTimestampID:
+				data, err := er.read(child, "Cluster timestamp size", 8)
+				if err != nil {
+					return err
+				}
+				cluster = int64(ebmlUint(data))
+			case mkvSimpleBlockID:
+				data, err := er.read(child, "block size", limits.MaxHeaderPacketSize)
+				if err != nil {
+					return err
+				}
+				if block, err = parseMatroskaBlock(data, cluster); err != nil {
+					return err
+				}
+			case mkvBlockGroupID:
+				data, err := er.read(child, "block size", limits.MaxHeaderPacketSize)
+				if err != nil {
+					return err
+				}
+				children, err := ebmlChildren(data)
+				if err != nil {
+					return err
+				}
+				var discard int64
+				for _, c := range children {
+					switch c.id {
+					case mkvBlockID:
+						if block, err = parseMatroskaBlock(c.data, cluster); err != nil {
+							return err
+						}
+					case mkvDiscardPaddingID:
+						discard = ebmlInt(c.data)
+					}
+				}
+				if block != nil {
+					block.discard = discard
+				}
+			}
+			if child.size < 0 {
+				return &ErrInvalidMatroska{Reason: "element of unknown size"}
+			}
+			pos = child.end()
+			if block != nil && block.track == f.track.number {
+				if err = fn(block); err != nil {
+					return err
+				}
+			}
+		}
+		if e.size >= 0 {
+			pos = e.end()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package oggmeta
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	webmTrackUID = 0x5eed
+	webmOtherUID = 0x07e5
+)
+
+// webmFile describes the file buildWebM writes.
+type webmFile struct {
+	codec   string
+	private []byte
+	tags    []byte // Tags data, left out if nil
+	unknown bool   // Segment and Cluster of unknown size
+}
+
+// webmTag encodes a Tag about target, or about the whole file for 0.
+func webmTag(target uint64, fields ...string) []byte {
+	return webmLevelTag(0, target, fields...)
+}
+
+// webmLevelTag encodes a Tag like webmTag, with a TargetTypeValue unless
+// level is 0.
+func webmLevelTag(level, target uint64, fields ...string) []byte {
+	targets := []byte(nil)
+	if level != 0 {
+		targets = appendEBMLUint(nil, mkvTargetTypeValueID, level)
+	}
+	if target != 0 {
+		targets = appendEBMLUint(targets, mkvTagTrackUIDID, target)
+	}
+	data := appendEBMLElement(nil, mkvTargetsID, targets)
+	for i := 0; i < len(fields); i += 2 {
+		simple := appendEBMLElement(nil, mkvTagNameID, []byte(fields[i]))
+		simple = appendEBMLElement(simple, mkvTagStringID, []byte(fields[i+1]))
+		data = appendEBMLElement(data, mkvSimpleTagID, simple)
+	}
+	return appendEBMLElement(nil, mkvTagID, data)
+}
+
+// buildWebM writes a WebM file with one audio track and one Cluster of three
+// blocks. The SeekHead is followed by a Void that leaves room for more entries.
+func buildWebM(t *testing.T, w webmFile) []byte {
+	header := appendEBMLUint(nil, 0x4286, 1)
+	header = appendEBMLElement(header, ebmlDocTypeID, []byte("webm"))
+	out := appendEBMLElement(nil, ebmlHeaderID, header)
+
+	info := appendEBMLUint(nil, mkvTimestampScaleID, 1000000)
+	info = appendEBMLElement(info, mkvMuxingAppID, []byte("oggmeta test"))
+	info = appendEBMLElement(nil, mkvInfoID, info)
+
+	entry := appendEBMLUint(nil, mkvTrackNumberID, 1)
+	entry = appendEBMLUint(entry, mkvTrackUIDID, webmTrackUID)
+	entry = appendEBMLUint(entry, mkvTrackTypeID, 2)
+	entry = appendEBMLElement(entry, mkvCodecIDID, []byte(w.codec))
+	entry = appendEBMLElement(entry, mkvCodecPrivateID, w.private)
+	tracks := appendEBMLElement(nil, mkvTracksID, appendEBMLElement(nil, mkvTrackEntryID, entry))
+
+	cluster := appendEBMLUint(nil, mkvTimestampID, 0)
+	for i := 0; i < 3; i++ {
+		cluster = appendEBMLElement(cluster, mkvSimpleBlockID, []byte{0x81, 0, byte(20 * i), 0x80, 0xfc, byte(i), 0xff})
+	}
+	if w.unknown {
+		cluster = append([]byte{0x1f, 0x43, 0xb6, 0x75, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, cluster...)
+	} else {
+		cluster = appendEBMLElement(nil, mkvClusterID, cluster)
+	}
+
+	seek := func(id uint32, pos int) []byte {
+		entry := appendEBMLElement(nil, mkvSeekIDID, appendEBMLID(nil, id))
+		return appendEBMLElement(nil, mkvSeekID, appendEBMLUint(entry, mkvSeekPositionID, uint64(pos)))
+	}
+	// the SeekHead is 64 bytes with its Void, whatever it holds
+	var seeks []byte
+	pos := 64
+	seeks = append(seeks, seek(mkvInfoID, pos)...)
+	pos += len(info)
+	seeks = append(seeks, seek(mkvTracksID, pos)...)
+	pos += len(tracks) + len(cluster)
+	if w.tags != nil {
+		seeks = append(seeks, seek(mkvTagsID, pos)...)
+	}
+	segment := fitEBMLElement(mkvSeekHeadID, seeks, 64)
+	assert.NotNil(t, segment)
+	segment = append(append(append(segment, info...), tracks...), cluster...)
+	if w.tags != nil {
+		segment = appendEBMLElement(segment, mkvTagsID, w.tags)
+	}
+
+	out = appendEBMLID(out, mkvSegmentID)
+	if w.unknown {
+		out = append(out, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	} else {
+		out = appendEBMLSize(out, uint64(len(segment)), 8)
+	}
+	return append(out, segment...)
+}
+
+func webmOpusHead(t *testing.T) []byte {
+	b, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	return readAllPackets(t, b)[0]
+}
+
+// webmSeekTags returns the Tags the SeekHead of a saved file points at.
+func webmSeekTags(t *testing.T, b []byte) ebmlElement {
+	f, err := readMatroskaFile(context.Background(), &ebmlReader{r: bytes.NewReader(b)}, DefaultLimits, false)
+	assert.NoError(t, err)
+	if !assert.NotNil(t, f.seekHead) {
+		return ebmlElement{}
+	}
+	seeks, err := ebmlChildren(b[f.seekHead.data:f.seekHead.end()])
+	assert.NoError(t, err)
+	for _, seek := range seeks {
+		entry, err := ebmlChildren(seek.data)
+		assert.NoError(t, err)
+		if len(entry) == 2 && ebmlUint(entry[0].data) == mkvTagsID {
+			er := &ebmlReader{r: bytes.NewReader(b)}
+			assert.NoError(t, er.seek(f.segment.data+int64(ebmlUint(entry[1].data))))
+			e, err := er.element()
+			assert.NoError(t, err)
+			return e
+		}
+	}
+	t.Error("no SeekHead entry for Tags")
+	return ebmlElement{}
+}
+
+func TestMatroskaOpus(t *testing.T) {
+	tags := append(webmTag(0, "TITLE", "Voice memo", "ARTIST", "Browser", "ALBUM_ARTIST", "Team", "PART_NUMBER", "3", "LOCATION", "Office"),
+		webmTag(webmOtherUID, "TITLE", "Other track")...)
+	original := buildWebM(t, webmFile{codec: "A_OPUS", private: webmOpusHead(t), tags: tags})
+
+	tag, err := ReadOGG(bytes.NewReader(original))
+	assert.NoError(t, err)
+	assert.Equal(t, Opus, tag.Codec)
+	assert.NotNil(t, tag.OpusHead)
+	assert.Equal(t, "Voice memo", tag.Title)
+	assert.Equal(t, "Browser", tag.Artist)
+	assert.Equal(t, "Team", tag.AlbumArtist)
+	assert.Equal(t, "3", tag.TrackNumber)
+	assert.Equal(t, "Office", tag.UnmappedFields["LOCATION"])
+
+	// longer tags go to the end of the Segment
+	tag.Title = "Voice memo recorded in the browser and uploaded as is"
+	tag.Genre = "Speech"
+	saved := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, saved))
+	b := saved.Bytes()
+	assert.Greater(t, len(b), len(original))
+	assert.Equal(t, webmSeekTags(t, b).offset, int64(len(original)))
+
+	again, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, tag.Title, again.Title)
+	assert.Equal(t, "Speech", again.Genre)
+	assert.Equal(t, "Team", again.AlbumArtist)
+	assert.Equal(t, "3", again.TrackNumber)
+	assert.Equal(t, "Office", again.UnmappedFields["LOCATION"])
+
+	f, err := readMatroskaFile(context.Background(), &ebmlReader{r: bytes.NewReader(b)}, DefaultLimits, false)
+	assert.NoError(t, err)
+	assert.Len(t, f.tags, 1)
+	other, _, err := matroskaSimpleTags(f.tagsData[0], webmOtherUID)
+	assert.NoError(t, err)
+	assert.Equal(t, []matroskaSimpleTag{{mkvTargetTrack, "TITLE", "Other track"}}, other)
+	own, named, err := matroskaSimpleTags(f.tagsData[0], webmTrackUID)
+	assert.NoError(t, err)
+	assert.True(t, named)
+	assert.Contains(t, own, matroskaSimpleTag{mkvTargetAlbum, "ARTIST", "Team"})
+	assert.Contains(t, own, matroskaSimpleTag{mkvTargetTrack, "TITLE", tag.Title})
+	assert.Contains(t, own, matroskaSimpleTag{mkvTargetTrack, "PART_NUMBER", "3"})
+
+	// shorter tags take the place of the old ones
+	again.Title = "Memo"
+	again.Genre = ""
+	saved = new(bytes.Buffer)
+	assert.NoError(t, SaveTags(again, saved))
+	assert.Equal(t, len(b), saved.Len())
+	third, err := ReadOGG(bytes.NewReader(saved.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "Memo", third.Title)
+	assert.Equal(t, "", third.Genre)
+	assert.Equal(t, "Browser", third.Artist)
+	assert.Equal(t, webmSeekTags(t, saved.Bytes()).offset, int64(len(original)))
+}
+
+func TestMatroskaTargetLevels(t *testing.T) {
+	tags := append(webmLevelTag(mkvTargetAlbum, 0, "TITLE", "Sessions", "ARTIST", "Band", "TOTAL_PARTS", "9", "GENRE", "Jazz", "DATE_RELEASED", "2001"),
+		webmLevelTag(mkvTargetTrack, webmTrackUID, "TITLE", "Take 2", "PART_NUMBER", "4", "GENRE", "Bebop")...)
+	tags = append(tags, webmLevelTag(70, 0, "TITLE", "Box set")...)
+	original := buildWebM(t, webmFile{codec: "A_OPUS", private: webmOpusHead(t), tags: tags})
+
+	tag, err := ReadOGG(bytes.NewReader(original))
+	assert.NoError(t, err)
+	assert.Equal(t, "Take 2", tag.Title)
+	assert.Equal(t, "Sessions", tag.Album)
+	assert.Equal(t, "Band", tag.AlbumArtist)
+	assert.Equal(t, "", tag.Artist)
+	assert.Equal(t, "4", tag.TrackNumber)
+	assert.Equal(t, "9", tag.TrackTotal)
+	assert.Equal(t, "Bebop", tag.Genre)
+	assert.Equal(t, "2001", tag.UnmappedFields["DATE_RELEASED"])
+
+	saved := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, saved))
+	again, err := ReadOGG(bytes.NewReader(saved.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, tag.Title, again.Title)
+	assert.Equal(t, tag.Album, again.Album)
+	assert.Equal(t, tag.AlbumArtist, again.AlbumArtist)
+	assert.Equal(t, tag.TrackTotal, again.TrackTotal)
+}
+
+func TestMatroskaUnknownSize(t *testing.T) {
+	original := buildWebM(t, webmFile{codec: "A_OPUS", private: webmOpusHead(t), tags: webmTag(0, "TITLE", "Live"), unknown: true})
+	tag, err := ReadOGG(bytes.NewReader(original))
+	assert.NoError(t, err)
+	assert.Equal(t, "Live", tag.Title)
+
+	tag.Title = "Live stream recording"
+	saved := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, saved))
+	again, err := ReadOGG(bytes.NewReader(saved.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "Live stream recording", again.Title)
+	assert.Equal(t, webmSeekTags(t, saved.Bytes()).offset, int64(len(original)))
+}
+
+func TestMatroskaVorbis(t *testing.T) {
+	b, err := os.ReadFile("./testdata/testdata-ogg-vorbis-nonEmpty.ogg")
+	assert.NoError(t, err)
+	headers := readAllPackets(t, b)[:3]
+	private := xiphLace(headers)
+	original := buildWebM(t, webmFile{codec: "A_VORBIS", private: private})
+
+	want, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	tag, err := ReadOGG(bytes.NewReader(original))
+	assert.NoError(t, err)
+	assert.Equal(t, Vorbis, tag.Codec)
+	assert.Equal(t, want.Vendor, tag.Vendor)
+	assert.Equal(t, want.Title, tag.Title)
+	assert.Equal(t, want.Artist, tag.Artist)
+
+	// the file has no Tags, so the SeekHead gains an entry for them
+	tag.Title = "Renamed"
+	saved := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, saved))
+	assert.Equal(t, webmSeekTags(t, saved.Bytes()).offset, int64(len(original)))
+	again, err := ReadOGG(bytes.NewReader(saved.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "Renamed", again.Title)
+	assert.Equal(t, want.Artist, again.Artist)
+
+	// clearing every field sticks, although the CodecPrivate comment has them
+	cleared := &OggTag{Codec: Vorbis, reader: again.reader}
+	saved = new(bytes.Buffer)
+	assert.NoError(t, SaveTags(cleared, saved))
+	empty, err := ReadOGG(bytes.NewReader(saved.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "", empty.Title)
+	assert.Equal(t, "", empty.Artist)
+	assert.Empty(t, empty.UnmappedFields)
+}
+
+func TestMatroskaInvalid(t *testing.T) {
+	header := appendEBMLElement(nil, ebmlDocTypeID, []byte("mp4"))
+	_, err := ReadOGG(bytes.NewReader(appendEBMLElement(nil, ebmlHeaderID, header)))
+	assert.IsType(t, &ErrInvalidMatroska{}, err)
+
+	original := buildWebM(t, webmFile{codec: "A_AAC"})
+	_, err = ReadOGG(bytes.NewReader(original))
+	assert.IsType(t, &ErrUnsupportedCodec{}, err)
+}
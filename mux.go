@@ -0,0 +1,287 @@
+package oggmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/gcottom/oggmeta/vorbis"
+)
+
+// LogicalStream is one logical stream split out of a grouped or chained file.
+type LogicalStream struct {
+	Serial uint32
+	Link   int    // index of the chain link the stream belongs to
+	Data   []byte // the stream as a file of its own
+}
+
+// Demux splits r into its logical streams, copying pages as they are.
+func Demux(r io.ReadSeeker) ([]*LogicalStream, error) {
+	if err := checkLink(0, r); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	dec := &OGGDecoder{Reader: r}
+	var streams []*LogicalStream
+	type output struct {
+		stream *LogicalStream
+		buf    *bytes.Buffer
+		enc    *OGGEncoder
+	}
+	open := make(map[uint32]*output)
+	link := -1
+	for {
+		page, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		serial := page.Header.SerialNumber
+		if page.Header.Flags&FlagBOS != 0 {
+			if len(open) == 0 {
+				link++
+			}
+			out := &output{stream: &LogicalStream{Serial: serial, Link: link}, buf: new(bytes.Buffer)}
+			out.enc = &OGGEncoder{Writer: out.buf, Serial: serial}
+			streams = append(streams, out.stream)
+			open[serial] = out
+		}
+		out := open[serial]
+		if err := out.enc.copyPage(page); err != nil {
+			return nil, err
+		}
+		if page.Header.Flags&FlagEOS != 0 {
+			out.stream.Data = out.buf.Bytes()
+			delete(open, serial)
+		}
+	}
+	return streams, nil
+}
+
+// streamClock converts the granule positions of a stream to seconds and says
+// how many header packets precede its data.
+type streamClock struct {
+	headers int
+	seconds func(granule int64) float64
+
+	keyframe func(packet []byte) bool // nil if every packet is one
+	bone     SkeletonBone             // describes the stream in a Skeleton, apart from its serial
+}
+
+// streamClocks are tried in order against the BOS packet of each stream.
+var streamClocks = []struct {
+	prefix []byte
+	new    func(head []byte) (*streamClock, error)
+}{
+	{VorbisIdentPrefix, newVorbisClock},
+	{OpusHeadPrefix, newOpusClock},
+	{SpeexPrefix, newSpeexClock},
+	{TheoraIdentPrefix, newTheoraClock},
+	{FLACPrefix, newFLACClock},
+	{KateIdentPrefix, newKateClock},
+}
+
+func newStreamClock(head []byte) (*streamClock, error) {
+	for _, c := range streamClocks {
+		if bytes.HasPrefix(head, c.prefix) {
+			return c.new(head)
+		}
+	}
+	return nil, &ErrUnsupportedCodec{}
+}
+
+func newVorbisClock(head []byte) (*streamClock, error) {
+	ident, err := vorbis.ParseIdent(head)
+	if err != nil {
+		return nil, err
+	}
+	rate := float64(ident.SampleRate)
+	return &streamClock{
+		headers: 3,
+		seconds: func(g int64) float64 { return float64(g) / rate },
+		bone:    audioBone("audio/vorbis", 3, int64(ident.SampleRate), 2),
+	}, nil
+}
+
+// audioBone describes an audio stream whose granule positions count samples.
+func audioBone(contentType string, headers int, rate int64, preroll uint32) SkeletonBone {
+	return SkeletonBone{
+		HeaderPackets:          uint32(headers),
+		GranuleRateNumerator:   rate,
+		GranuleRateDenominator: 1,
+		Preroll:                preroll,
+		ContentType:            contentType,
+		Role:                   "audio/main",
+	}
+}
+
+func newOpusClock(head []byte) (*streamClock, error) {
+	if len(head) < 19 {
+		return nil, &ErrInvalidOpusHead{Reason: "packet too short"}
+	}
+	preSkip := int64(binary.LittleEndian.Uint16(head[10:]))
+	return &streamClock{
+		headers: 2,
+		seconds: func(g int64) float64 { return float64(g-preSkip) / 48000 },
+		// 80 ms of 20 ms packets, the preroll RFC 7845 recommends
+		bone: audioBone("audio/opus", 2, 48000, 4),
+	}, nil
+}
+
+func newSpeexClock(head []byte) (*streamClock, error) {
+	header, err := parseSpeexHeader(head)
+	if err != nil {
+		return nil, err
+	}
+	rate := float64(header.SampleRate)
+	headers := 2 + int(header.ExtraHeaders)
+	return &streamClock{
+		headers: headers,
+		seconds: func(g int64) float64 { return float64(g) / rate },
+		bone:    audioBone("audio/speex", headers, int64(header.SampleRate), 3),
+	}, nil
+}
+
+func newTheoraClock(head []byte) (*streamClock, error) {
+	header, err := parseTheoraHeader(head)
+	if err != nil {
+		return nil, err
+	}
+	return &streamClock{
+		headers: 3,
+		seconds: func(g int64) float64 { return float64(header.frames(g)) / header.FrameRate() },
+		// the second bit of a data packet is clear on a keyframe
+		keyframe: func(p []byte) bool { return len(p) > 0 && p[0]&0x40 == 0 },
+		bone: SkeletonBone{
+			HeaderPackets:          3,
+			GranuleRateNumerator:   int64(header.FrameRateNumerator),
+			GranuleRateDenominator: int64(header.FrameRateDenominator),
+			GranuleShift:           header.KeyframeShift,
+			ContentType:            "video/theora",
+			Role:                   "video/main",
+		},
+	}, nil
+}
+
+func newFLACClock(head []byte) (*streamClock, error) {
+	if err := checkFLACHead(head); err != nil {
+		return nil, err
+	}
+	info := parseFLACStreamInfo(head[17:])
+	headers := 1 + int(binary.BigEndian.Uint16(head[7:]))
+	rate := float64(info.SampleRate)
+	return &streamClock{
+		headers: headers,
+		seconds: func(g int64) float64 { return float64(g) / rate },
+		bone:    audioBone("audio/flac", headers, int64(info.SampleRate), 0),
+	}, nil
+}
+
+// muxStream is an input of Mux with the page it will write next.
+type muxStream struct {
+	dec     *OGGDecoder
+	serial  uint32 // of the stream in the input
+	enc     *OGGEncoder
+	clock   *streamClock
+	page    *OGGPage
+	start   float64 // time the data of page begins at
+	headers int     // header packets not yet written
+	done    bool
+}
+
+// next reads the following page of the stream, skipping any other stream in the input.
+func (s *muxStream) next() error {
+	if s.page != nil && s.page.Header.GranulePosition != -1 {
+		s.start = s.clock.seconds(s.page.Header.GranulePosition)
+	}
+	for {
+		if s.page != nil && s.page.Header.Flags&FlagEOS != 0 {
+			s.page, s.done = nil, true
+			return nil
+		}
+		page, err := s.dec.Decode()
+		if err == io.EOF {
+			s.page, s.done = nil, true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if page.Header.SerialNumber == s.serial {
+			s.page = page
+			return nil
+		}
+	}
+}
+
+// write copies the pending page to the output and reads the next one.
+func (s *muxStream) write() error {
+	if err := s.enc.copyPage(s.page); err != nil {
+		return err
+	}
+	ended := len(s.page.Packets)
+	if s.page.continues {
+		ended--
+	}
+	s.headers -= ended
+	return s.next()
+}
+
+// Mux interleaves the first logical stream of every input into one grouped
+// file, ordering data pages by time.
+func Mux(w io.Writer, streams ...io.ReadSeeker) error {
+	used := make(map[uint32]bool)
+	inputs := make([]*muxStream, len(streams))
+	for i, r := range streams {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		s := &muxStream{dec: &OGGDecoder{Reader: r}}
+		page, err := s.dec.Decode()
+		if err != nil {
+			return err
+		}
+		if page.Header.Flags&FlagBOS == 0 {
+			return &ErrInvalidLink{Index: i, Reason: "input does not start with a BOS page"}
+		}
+		if s.clock, err = newStreamClock(page.Packets[0]); err != nil {
+			return err
+		}
+		s.page = page
+		s.serial = page.Header.SerialNumber
+		s.headers = s.clock.headers
+		s.enc = &OGGEncoder{Writer: w, Serial: unusedSerial(s.serial, used)}
+		inputs[i] = s
+	}
+
+	for _, s := range inputs {
+		if err := s.write(); err != nil {
+			return err
+		}
+	}
+	for _, s := range inputs {
+		for s.headers > 0 && !s.done {
+			if err := s.write(); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		var first *muxStream
+		for _, s := range inputs {
+			if !s.done && (first == nil || s.start < first.start) {
+				first = s
+			}
+		}
+		if first == nil {
+			return nil
+		}
+		if err := first.write(); err != nil {
+			return err
+		}
+	}
+}
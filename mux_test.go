@@ -0,0 +1,67 @@
+package oggmeta
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMuxDemux(t *testing.T) {
+	opus, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	vorbis, err := os.ReadFile("./testdata/test1.ogg")
+	assert.NoError(t, err)
+
+	out := new(bytes.Buffer)
+	assert.NoError(t, Mux(out, bytes.NewReader(vorbis), bytes.NewReader(opus)))
+	headers := verifyPages(t, out.Bytes())
+
+	// both BOS pages lead, then the header pages, then data in time order
+	assert.Equal(t, byte(FlagBOS), headers[0].Flags)
+	assert.Equal(t, byte(FlagBOS), headers[1].Flags)
+	vorbisSerial, opusSerial := headers[0].SerialNumber, headers[1].SerialNumber
+	assert.NotEqual(t, vorbisSerial, opusSerial)
+	for _, h := range headers[2:4] {
+		assert.Equal(t, int64(0), h.GranulePosition)
+	}
+	var lastVorbis, lastOpus float64
+	for _, h := range headers[4:] {
+		switch h.SerialNumber {
+		case vorbisSerial:
+			// a page is written before the other stream passes its start
+			assert.True(t, lastOpus <= float64(h.GranulePosition)/44100+1)
+			lastVorbis = float64(h.GranulePosition) / 44100
+		case opusSerial:
+			assert.True(t, lastVorbis <= float64(h.GranulePosition)/48000+1)
+			lastOpus = float64(h.GranulePosition) / 48000
+		}
+	}
+
+	streams, err := Demux(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	if assert.Len(t, streams, 2) {
+		assert.Equal(t, vorbis, streams[0].Data)
+		assert.Equal(t, opus, streams[1].Data)
+		assert.Equal(t, 0, streams[1].Link)
+	}
+}
+
+func TestDemuxChained(t *testing.T) {
+	opus, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	out := new(bytes.Buffer)
+	assert.NoError(t, Concat(out, bytes.NewReader(opus), bytes.NewReader(opus)))
+
+	streams, err := Demux(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	if assert.Len(t, streams, 2) {
+		assert.Equal(t, opus, streams[0].Data)
+		assert.Equal(t, 1, streams[1].Link)
+		assert.NotEqual(t, streams[0].Serial, streams[1].Serial)
+		stats, err := AnalyzeOpus(bytes.NewReader(streams[1].Data))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(164222), stats.FinalGranule)
+	}
+}
@@ -1,6 +1,7 @@
 package oggmeta
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"io"
@@ -19,7 +20,14 @@ type OggTag struct {
 	DiscNumber     string
 	DiscTotal      string
 	Encoder        string
+	FLACStreamInfo *FLACStreamInfo
 	Genre          string
+	KateHeader     *KateHeader
+	KateTags       []*OggTag // comments of the Kate streams of a file, apart from the one these are
+	OpusHead       *OpusHead
+	SpeexHeader    *SpeexHeader
+	TheoraHeader   *TheoraHeader
+	TheoraTag      *OggTag // comments of the Theora stream of a file that also holds audio
 	Title          string
 	TrackNumber    string
 	TrackTotal     string
@@ -27,6 +35,7 @@ type OggTag struct {
 	Vendor         string
 
 	reader io.ReadSeeker
+	serial uint32 // of the stream the tags of a side stream belong to
 }
 
 func (o *OggTag) ClearAllTags() {
@@ -183,6 +192,12 @@ func (o *OggTag) Save(w io.Writer) error {
 }
 
 func ReadOGG(r io.ReadSeeker) (*OggTag, error) {
-	dec := &OGGDecoder{Reader: r}
-	return dec.ReadTags()
+	return ReadOGGContext(context.Background(), r, nil)
+}
+
+// ReadOGGContext is like ReadOGG but can be cancelled through ctx and reports
+// progress to the optional progress callback.
+func ReadOGGContext(ctx context.Context, r io.ReadSeeker, progress ProgressFunc) (*OggTag, error) {
+	dec := &OGGDecoder{Reader: r, Progress: progress}
+	return dec.readTags(ctx)
 }
@@ -177,3 +177,36 @@ func TestWriteOggVorbis(t *testing.T) {
 		assert.True(t, compareImages(img1data, img2data))
 	})
 }
+
+func TestWriteUnmappedFields(t *testing.T) {
+	b, err := os.ReadFile("./testdata/testdata-opus-nonEmpty.ogg")
+	assert.NoError(t, err)
+	tag, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Contains(t, tag.UnmappedFields, "METADATA_BLOCK_PICTURE")
+	tag.UnmappedFields["LYRICS"] = "a=b"
+	tag.UnmappedFields["DATE"] = "2001"
+
+	out := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, out))
+	comment := readAllPackets(t, out.Bytes())[1]
+	// unmapped fields follow the mapped ones in name order, and the picture
+	// is written once, from CoverArt
+	assert.Less(t, bytes.Index(comment, []byte("DATE=2001")), bytes.Index(comment, []byte("LYRICS=a=b")))
+	assert.Equal(t, 1, bytes.Count(comment, []byte(pictureFieldPrefix)))
+
+	got, err := ReadOGG(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "a=b", got.UnmappedFields["LYRICS"])
+	assert.Equal(t, "2001", got.UnmappedFields["DATE"])
+	assert.NotNil(t, got.CoverArt)
+
+	// removing an unmapped field drops it from the file
+	delete(got.UnmappedFields, "LYRICS")
+	again := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(got, again))
+	got, err = ReadOGG(bytes.NewReader(again.Bytes()))
+	assert.NoError(t, err)
+	assert.NotContains(t, got.UnmappedFields, "LYRICS")
+	assert.Equal(t, "2001", got.UnmappedFields["DATE"])
+}
@@ -0,0 +1,697 @@
+package opus
+
+import "math"
+
+// celtPostfilter is the pitch post-filter of one CELT frame.
+type celtPostfilter struct {
+	period int
+	gain   float32
+	tapset int
+}
+
+// celtDecoder holds the state of the CELT layer of one Opus stream
+// (RFC 6716 section 4.3). It always synthesises at 48 kHz.
+type celtDecoder struct {
+	logE, logE1, logE2 [2][celtMaxBands]float32
+
+	overlap   [2][120]float32
+	pfMem     [2][celtPFHistory]float32
+	pf, pfOld celtPostfilter
+	preemph   [2]float32
+	rng       uint32 // final range of the last frame, which seeds noise fill
+	lossCount int
+}
+
+// celtFrame is the side information of one CELT frame.
+type celtFrame struct {
+	lm         int
+	start, end int
+	channels   int
+	totalBits  int
+
+	transient bool
+	intra     bool
+	pf        celtPostfilter
+	tfChange  [celtMaxBands]int
+	spread    int
+	trim      int
+	boost     [celtMaxBands]int
+	caps      [celtMaxBands]int
+
+	antiCollapseRsv int
+	pulses          [celtMaxBands]int // shape bits in 1/8 bit
+	fineQuant       [celtMaxBands]int
+	finePriority    [celtMaxBands]int
+	intensity       int
+	dualStereo      bool
+	balance         int
+	codedBands      int
+}
+
+const celtBitRes = 3
+
+func newCeltDecoder() *celtDecoder {
+	d := &celtDecoder{}
+	d.reset()
+	return d
+}
+
+func (d *celtDecoder) reset() {
+	*d = celtDecoder{}
+	for c := range d.logE1 {
+		for b := range d.logE1[c] {
+			d.logE1[c][b] = -28
+			d.logE2[c][b] = -28
+		}
+	}
+}
+
+// decode decodes one CELT frame from rd, which the caller has initialised on
+// the frame (or shares with the SILK layer of a hybrid frame), into out: one
+// or two slices of 48 kHz samples as long as the frame. frame holds the bytes
+// the CELT layer may use; a frame of at most one byte is concealed as lost.
+func (d *celtDecoder) decode(rd *rangeDecoder, frame []byte, out [][]float32, stereo bool, start, end int) {
+	n := len(out[0])
+	f := &celtFrame{start: start, end: end, channels: 1, spread: 2, trim: 5}
+	for n > 120<<f.lm {
+		f.lm++
+	}
+	if stereo {
+		f.channels = 2
+	}
+	if len(frame) <= 1 {
+		d.decodeLost(f, out)
+		return
+	}
+	f.totalBits = len(frame) * 8
+
+	x := make([]float32, n)
+	var y []float32
+	if stereo {
+		y = make([]float32, n)
+	}
+	silence := false
+	if tell := rd.tell(); tell >= f.totalBits {
+		silence = true
+	} else if tell == 1 {
+		silence = rd.bit(15)
+	}
+	if silence {
+		for c := 0; c < f.channels; c++ {
+			for b := start; b < end; b++ {
+				d.logE[c][b] = -28
+			}
+		}
+		d.synthesize(f, x, y, [2][celtMaxBands]float32{}, out)
+		d.finishFrame(f, rd)
+		return
+	}
+
+	if start == 0 && rd.tell()+16 <= f.totalBits && rd.bit(1) {
+		oct := int(rd.uint(6))
+		f.pf.period = 16<<uint(oct) + int(rd.bits(uint(4+oct))) - 1
+		f.pf.gain = 0.09375 * float32(rd.bits(3)+1)
+		if rd.tell()+2 <= f.totalBits {
+			f.pf.tapset = rd.icdf(celtTapsetICDF, 2)
+		}
+	}
+	if f.lm > 0 && rd.tell()+3 <= f.totalBits {
+		f.transient = rd.bit(3)
+	}
+	if rd.tell()+3 <= f.totalBits {
+		f.intra = rd.bit(3)
+	}
+	d.decodeCoarseEnergy(rd, f)
+	d.decodeTF(rd, f)
+	if rd.tell()+4 <= f.totalBits {
+		f.spread = rd.icdf(celtSpreadICDF, 5)
+	}
+	total := d.decodeDynalloc(rd, f)
+	if rd.tellFrac()+6<<celtBitRes <= total {
+		f.trim = rd.icdf(celtTrimICDF, 7)
+	}
+
+	bits := f.totalBits<<celtBitRes - rd.tellFrac() - 1
+	if f.transient && f.lm >= 2 && bits >= (f.lm+2)<<celtBitRes {
+		f.antiCollapseRsv = 1 << celtBitRes
+	}
+	bits -= f.antiCollapseRsv
+	f.computeAllocation(rd, bits)
+	d.decodeFineEnergy(rd, f)
+
+	seed := d.rng
+	masks := quantAllBands(rd, f, x, y, f.totalBits<<celtBitRes-f.antiCollapseRsv, &seed)
+	antiCollapse := f.antiCollapseRsv > 0 && rd.bits(1) != 0
+	d.finalizeFineEnergy(rd, f, f.totalBits-rd.tell())
+	if antiCollapse {
+		d.antiCollapse(f, x, y, masks, seed)
+	}
+
+	var amp [2][celtMaxBands]float32
+	for c := 0; c < f.channels; c++ {
+		for b := start; b < end; b++ {
+			lg := d.logE[c][b] + celtMeans[b]
+			if lg > 32 {
+				lg = 32
+			}
+			amp[c][b] = float32(math.Exp2(float64(lg)))
+		}
+	}
+	d.synthesize(f, x, y, amp, out)
+	d.finishFrame(f, rd)
+}
+
+// decodeLost conceals a lost frame with silence and decays the energy
+// history.
+func (d *celtDecoder) decodeLost(f *celtFrame, out [][]float32) {
+	for _, o := range out {
+		for i := range o {
+			o[i] = 0
+		}
+	}
+	decay := float32(1.5)
+	if d.lossCount > 0 {
+		decay = 0.5
+	}
+	for c := 0; c < f.channels; c++ {
+		for b := f.start; b < f.end; b++ {
+			d.logE[c][b] -= decay
+		}
+	}
+	if f.channels == 1 {
+		d.logE[1] = d.logE[0]
+	}
+	d.resetInactiveBands(f)
+	d.overlap = [2][120]float32{}
+	d.pfMem = [2][celtPFHistory]float32{}
+	d.preemph = [2]float32{}
+	d.lossCount++
+}
+
+// finishFrame updates the energy history after a frame was synthesised.
+func (d *celtDecoder) finishFrame(f *celtFrame, rd *rangeDecoder) {
+	if f.channels == 1 {
+		d.logE[1] = d.logE[0]
+	}
+	if f.transient {
+		for c := range d.logE {
+			for b := range d.logE[c] {
+				if d.logE[c][b] < d.logE1[c][b] {
+					d.logE1[c][b] = d.logE[c][b]
+				}
+			}
+		}
+	} else {
+		d.logE2 = d.logE1
+		d.logE1 = d.logE
+	}
+	d.resetInactiveBands(f)
+	d.rng = rd.rng
+	d.lossCount = 0
+}
+
+// resetInactiveBands clears the history of bands outside the coded range, so
+// it does not leak into frames with a different band range.
+func (d *celtDecoder) resetInactiveBands(f *celtFrame) {
+	for c := range d.logE {
+		for b := 0; b < celtMaxBands; b++ {
+			if b < f.start || b >= f.end {
+				d.logE[c][b] = 0
+				d.logE1[c][b] = -28
+				d.logE2[c][b] = -28
+			}
+		}
+	}
+}
+
+// decodeCoarseEnergy decodes the coarse band energies (RFC 6716 section
+// 4.3.2.1) into logE.
+func (d *celtDecoder) decodeCoarseEnergy(rd *rangeDecoder, f *celtFrame) {
+	if f.channels == 1 {
+		for b := range d.logE[0] {
+			if d.logE[1][b] > d.logE[0][b] {
+				d.logE[0][b] = d.logE[1][b]
+			}
+		}
+	}
+	prob := celtProbModel[f.lm][0][:]
+	coef := celtPredCoef[f.lm]
+	beta := celtBetaCoef[f.lm]
+	if f.intra {
+		prob = celtProbModel[f.lm][1][:]
+		coef = 0
+		beta = celtBetaIntra
+	}
+	var pred [2]float32
+	for b := f.start; b < f.end; b++ {
+		for c := 0; c < f.channels; c++ {
+			var qi int
+			tell := rd.tell()
+			switch left := f.totalBits - tell; {
+			case tell >= f.totalBits:
+				qi = -1
+			case left >= 15:
+				i := 2 * b
+				if b > 20 {
+					i = 40
+				}
+				qi = rd.laplace(uint32(prob[i])<<7, uint32(prob[i+1])<<6)
+			case left >= 2:
+				qi = []int{0, -1, 1}[rd.icdf(celtSmallEnergyICDF, 2)]
+			default:
+				if rd.bit(1) {
+					qi = -1
+				}
+			}
+			q := float32(qi)
+			old := d.logE[c][b]
+			if old < -9 {
+				old = -9
+			}
+			d.logE[c][b] = coef*old + pred[c] + q
+			pred[c] += q - beta*q
+		}
+	}
+	if f.channels == 1 {
+		d.logE[1] = d.logE[0]
+	}
+}
+
+// decodeTF decodes the per-band time-frequency resolution changes (RFC 6716
+// section 4.3.4.5).
+func (d *celtDecoder) decodeTF(rd *rangeDecoder, f *celtFrame) {
+	logp := 4
+	if f.transient {
+		logp = 2
+	}
+	budget := f.totalBits
+	tell := rd.tell()
+	selectRsv := f.lm > 0 && tell+logp+1 <= budget
+	if selectRsv {
+		budget--
+	}
+	cur, changed := 0, 0
+	for b := f.start; b < f.end; b++ {
+		if tell+logp <= budget {
+			if rd.bit(uint(logp)) {
+				cur ^= 1
+			}
+			tell = rd.tell()
+			changed |= cur
+		}
+		f.tfChange[b] = cur
+		logp = 5
+		if f.transient {
+			logp = 4
+		}
+	}
+	table := celtTFSelect[f.lm]
+	t := 0
+	if f.transient {
+		t = 4
+	}
+	tfSelect := 0
+	if selectRsv && table[t+changed] != table[t+2+changed] && rd.bit(1) {
+		tfSelect = 1
+	}
+	for b := f.start; b < f.end; b++ {
+		f.tfChange[b] = int(table[t+2*tfSelect+f.tfChange[b]])
+	}
+}
+
+// decodeDynalloc decodes the band boosts and returns the budget left for the
+// allocation trim, in 1/8 bit.
+func (d *celtDecoder) decodeDynalloc(rd *rangeDecoder, f *celtFrame) int {
+	base := celtMaxBands * (2*f.lm + f.channels - 1)
+	for b := 0; b < celtMaxBands; b++ {
+		width := int(celtBandEdges[b+1]-celtBandEdges[b]) << uint(f.lm)
+		f.caps[b] = (int(celtCaps[base+b]) + 64) * f.channels * width >> 2
+	}
+	total := f.totalBits << celtBitRes
+	logp := 6
+	tellFrac := rd.tellFrac()
+	for b := f.start; b < f.end; b++ {
+		width := f.channels * int(celtBandEdges[b+1]-celtBandEdges[b]) << uint(f.lm)
+		quanta := 6 << celtBitRes
+		if width > quanta {
+			quanta = width
+		}
+		if width<<celtBitRes < quanta {
+			quanta = width << celtBitRes
+		}
+		loopLogp := logp
+		boost := 0
+		for tellFrac+loopLogp<<celtBitRes < total && boost < f.caps[b] {
+			flag := rd.bit(uint(loopLogp))
+			tellFrac = rd.tellFrac()
+			if !flag {
+				break
+			}
+			boost += quanta
+			total -= quanta
+			if total < 0 {
+				total = 0
+			}
+			loopLogp = 1
+		}
+		f.boost[b] = boost
+		if boost > 0 && logp > 2 {
+			logp--
+		}
+	}
+	return total
+}
+
+// decodeFineEnergy decodes the first fine energy refinement (RFC 6716
+// section 4.3.2.2).
+func (d *celtDecoder) decodeFineEnergy(rd *rangeDecoder, f *celtFrame) {
+	for b := f.start; b < f.end; b++ {
+		if f.fineQuant[b] <= 0 {
+			continue
+		}
+		for c := 0; c < f.channels; c++ {
+			q2 := rd.bits(uint(f.fineQuant[b]))
+			d.logE[c][b] += (float32(q2)+0.5)*float32(uint(1)<<uint(14-f.fineQuant[b]))/16384 - 0.5
+		}
+	}
+}
+
+// finalizeFineEnergy spends the bits left after the band shapes on one more
+// bit of fine energy per band, in priority order.
+func (d *celtDecoder) finalizeFineEnergy(rd *rangeDecoder, f *celtFrame, left int) {
+	for prio := 0; prio < 2; prio++ {
+		for b := f.start; b < f.end && left >= f.channels; b++ {
+			if f.fineQuant[b] >= 8 || f.finePriority[b] != prio {
+				continue
+			}
+			for c := 0; c < f.channels; c++ {
+				q2 := rd.bits(1)
+				d.logE[c][b] += (float32(q2) - 0.5) * float32(uint(1)<<uint(14-f.fineQuant[b]-1)) / 16384
+				left--
+			}
+		}
+	}
+}
+
+// computeAllocation splits total, in 1/8 bit, between the shape and fine
+// energy of each band (RFC 6716 section 4.3.3), decoding the band skip,
+// intensity and dual stereo symbols on the way.
+func (f *celtFrame) computeAllocation(rd *rangeDecoder, total int) {
+	if total < 0 {
+		total = 0
+	}
+	start, end, lm, C := f.start, f.end, f.lm, f.channels
+	skipRsv := 0
+	if total >= 1<<celtBitRes {
+		skipRsv = 1 << celtBitRes
+	}
+	total -= skipRsv
+	intensityRsv, dualRsv := 0, 0
+	if C == 2 {
+		intensityRsv = celtLog2Frac[end-start]
+		if intensityRsv > total {
+			intensityRsv = 0
+		} else {
+			total -= intensityRsv
+			if total >= 1<<celtBitRes {
+				dualRsv = 1 << celtBitRes
+			}
+			total -= dualRsv
+		}
+	}
+
+	var bits1, bits2, thresh, trimOffset [celtMaxBands]int
+	for b := start; b < end; b++ {
+		width := int(celtBandEdges[b+1] - celtBandEdges[b])
+		thresh[b] = C << celtBitRes
+		if t := (3 * width << uint(lm) << celtBitRes) >> 4; t > thresh[b] {
+			thresh[b] = t
+		}
+		trimOffset[b] = C * width * (f.trim - 5 - lm) * (end - b - 1) * (1 << uint(lm+celtBitRes)) >> 6
+		if width<<uint(lm) == 1 {
+			trimOffset[b] -= C << celtBitRes
+		}
+	}
+
+	vectorBits := func(row, b int) int {
+		var bits int
+		if row >= len(celtAllocation) {
+			bits = f.caps[b]
+		} else {
+			width := int(celtBandEdges[b+1] - celtBandEdges[b])
+			bits = C * width * int(celtAllocation[row][b]) << uint(lm) >> 2
+		}
+		if bits > 0 {
+			bits += trimOffset[b]
+			if bits < 0 {
+				bits = 0
+			}
+		}
+		return bits
+	}
+	lo, hi := 1, len(celtAllocation)-1
+	for lo <= hi {
+		mid := (lo + hi) >> 1
+		psum := 0
+		done := false
+		for b := end - 1; b >= start; b-- {
+			bits := vectorBits(mid, b) + f.boost[b]
+			if bits >= thresh[b] || done {
+				done = true
+				if bits > f.caps[b] {
+					bits = f.caps[b]
+				}
+				psum += bits
+			} else if bits >= C<<celtBitRes {
+				psum += C << celtBitRes
+			}
+		}
+		if psum > total {
+			hi = mid - 1
+		} else {
+			lo = mid + 1
+		}
+	}
+	hi = lo
+	lo--
+	skipStart := start
+	for b := start; b < end; b++ {
+		b1 := vectorBits(lo, b)
+		b2 := vectorBits(hi, b)
+		if lo > 0 {
+			b1 += f.boost[b]
+		}
+		b2 += f.boost[b]
+		if f.boost[b] > 0 {
+			skipStart = b
+		}
+		bits2[b] = b2 - b1
+		if bits2[b] < 0 {
+			bits2[b] = 0
+		}
+		bits1[b] = b1
+	}
+
+	f.interpolate(rd, skipStart, bits1[:], bits2[:], thresh[:], total, skipRsv, intensityRsv, dualRsv)
+}
+
+func (f *celtFrame) interpolate(rd *rangeDecoder, skipStart int, bits1, bits2, thresh []int, total, skipRsv, intensityRsv, dualRsv int) {
+	start, end, lm, C := f.start, f.end, f.lm, f.channels
+	floor := C << celtBitRes
+	stereo := uint(0)
+	if C > 1 {
+		stereo = 1
+	}
+	edge := func(b int) int { return int(celtBandEdges[b]) }
+
+	lo, hi := 0, 1<<6
+	for i := 0; i < 6; i++ {
+		mid := (lo + hi) >> 1
+		psum := 0
+		done := false
+		for b := end - 1; b >= start; b-- {
+			tmp := bits1[b] + (mid * bits2[b] >> 6)
+			if tmp >= thresh[b] || done {
+				done = true
+				if tmp > f.caps[b] {
+					tmp = f.caps[b]
+				}
+				psum += tmp
+			} else if tmp >= floor {
+				psum += floor
+			}
+		}
+		if psum > total {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	bits := f.pulses[:]
+	psum := 0
+	done := false
+	for b := end - 1; b >= start; b-- {
+		tmp := bits1[b] + (lo * bits2[b] >> 6)
+		if tmp < thresh[b] && !done {
+			if tmp >= floor {
+				tmp = floor
+			} else {
+				tmp = 0
+			}
+		} else {
+			done = true
+		}
+		if tmp > f.caps[b] {
+			tmp = f.caps[b]
+		}
+		bits[b] = tmp
+		psum += tmp
+	}
+
+	coded := end
+	for {
+		coded--
+		b := coded
+		if b <= skipStart {
+			total += skipRsv
+			coded++
+			break
+		}
+		left := total - psum
+		perCoeff := left / (edge(coded+1) - edge(start))
+		left -= (edge(coded+1) - edge(start)) * perCoeff
+		rem := left - (edge(b) - edge(start))
+		if rem < 0 {
+			rem = 0
+		}
+		bandBits := bits[b] + perCoeff*(edge(coded+1)-edge(b)) + rem
+		need := thresh[b]
+		if floor+1<<celtBitRes > need {
+			need = floor + 1<<celtBitRes
+		}
+		if bandBits >= need {
+			if rd.bit(1) {
+				coded++
+				break
+			}
+			psum += 1 << celtBitRes
+			bandBits -= 1 << celtBitRes
+		}
+		psum -= bits[b] + intensityRsv
+		if intensityRsv > 0 {
+			intensityRsv = celtLog2Frac[b-start]
+		}
+		psum += intensityRsv
+		if bandBits >= floor {
+			psum += floor
+			bits[b] = floor
+		} else {
+			bits[b] = 0
+		}
+	}
+	f.codedBands = coded
+
+	f.intensity = 0
+	if intensityRsv > 0 {
+		f.intensity = start + int(rd.uint(uint32(coded+1-start)))
+	}
+	if f.intensity <= start {
+		total += dualRsv
+		dualRsv = 0
+	}
+	if dualRsv > 0 {
+		f.dualStereo = rd.bit(1)
+	}
+
+	left := total - psum
+	perCoeff := left / (edge(coded) - edge(start))
+	left -= (edge(coded) - edge(start)) * perCoeff
+	for b := start; b < coded; b++ {
+		bits[b] += perCoeff * (edge(b+1) - edge(b))
+	}
+	for b := start; b < coded; b++ {
+		tmp := edge(b+1) - edge(b)
+		if left < tmp {
+			tmp = left
+		}
+		bits[b] += tmp
+		left -= tmp
+	}
+
+	balance := 0
+	b := start
+	for ; b < coded; b++ {
+		n := (edge(b+1) - edge(b)) << uint(lm)
+		bits[b] += balance
+		excess := 0
+		if n > 1 {
+			excess = bits[b] - f.caps[b]
+			if excess < 0 {
+				excess = 0
+			}
+			bits[b] -= excess
+			den := C * n
+			if C == 2 && n > 2 && !f.dualStereo && b < f.intensity {
+				den++
+			}
+			ncLogN := den * (celtLogN400[b] + lm<<celtBitRes)
+			offset := ncLogN>>1 - den*21
+			if n == 2 {
+				offset += den << celtBitRes >> 2
+			}
+			if bits[b]+offset < den*2<<celtBitRes {
+				offset += ncLogN >> 2
+			} else if bits[b]+offset < den*3<<celtBitRes {
+				offset += ncLogN >> 3
+			}
+			fq := (bits[b] + offset + den<<(celtBitRes-1)) / (den << celtBitRes)
+			if fq < 0 {
+				fq = 0
+			}
+			if C*fq > bits[b]>>celtBitRes {
+				fq = bits[b] >> stereo >> celtBitRes
+			}
+			if fq > 8 {
+				fq = 8
+			}
+			f.fineQuant[b] = fq
+			f.finePriority[b] = 0
+			if fq*(den<<celtBitRes) >= bits[b]+offset {
+				f.finePriority[b] = 1
+			}
+			bits[b] -= C * fq << celtBitRes
+		} else {
+			excess = bits[b] - C<<celtBitRes
+			if excess < 0 {
+				excess = 0
+			}
+			bits[b] -= excess
+			f.fineQuant[b] = 0
+			f.finePriority[b] = 1
+		}
+		if excess > 0 {
+			extra := excess >> (stereo + celtBitRes)
+			if extra > 8-f.fineQuant[b] {
+				extra = 8 - f.fineQuant[b]
+			}
+			f.fineQuant[b] += extra
+			extraBits := extra * C << celtBitRes
+			f.finePriority[b] = 0
+			if extraBits >= excess-balance {
+				f.finePriority[b] = 1
+			}
+			excess -= extraBits
+		}
+		balance = excess
+	}
+	f.balance = balance
+	for ; b < end; b++ {
+		f.fineQuant[b] = bits[b] >> stereo >> celtBitRes
+		bits[b] = 0
+		f.finePriority[b] = 0
+		if f.fineQuant[b] < 1 {
+			f.finePriority[b] = 1
+		}
+	}
+}
@@ -0,0 +1,804 @@
+package opus
+
+import (
+	"math"
+	"math/bits"
+)
+
+// bandDecoder holds what the recursive band shape decoding of one CELT frame
+// shares (RFC 6716 section 4.3.4).
+type bandDecoder struct {
+	rd        *rangeDecoder
+	seed      *uint32
+	band      int
+	spread    int
+	intensity int
+	tfChange  int
+	remaining int // bits left for the frame, in 1/8 bit
+}
+
+var celtOrdery = [...]int{
+	1, 0,
+	3, 0, 2, 1,
+	7, 0, 4, 3, 6, 1, 5, 2,
+	15, 0, 8, 7, 12, 3, 11, 4, 14, 1, 9, 6, 13, 2, 10, 5,
+}
+
+// quantAllBands decodes the normalised shape of every coded band into x (and
+// y for stereo) and returns the collapse mask of each band and channel.
+func quantAllBands(rd *rangeDecoder, f *celtFrame, x, y []float32, totalBits int, seed *uint32) []byte {
+	C := 1
+	if y != nil {
+		C = 2
+	}
+	blocks := 1
+	if f.transient {
+		blocks = 1 << uint(f.lm)
+	}
+	M := 1 << uint(f.lm)
+	edge := func(b int) int { return M * int(celtBandEdges[b]) }
+	frameBins := edge(celtMaxBands)
+	norm := make([]float32, C*frameBins)
+	norm2 := norm[frameBins:]
+	lowScratch := make([]float32, edge(celtMaxBands)-edge(celtMaxBands-1))
+	masks := make([]byte, C*celtMaxBands)
+
+	bd := &bandDecoder{rd: rd, seed: seed, spread: f.spread, intensity: f.intensity}
+	lowbandOffset := 0
+	updateLowband := true
+	balance := f.balance
+	dualStereo := C == 2 && f.dualStereo
+	for b := f.start; b < f.end; b++ {
+		tell := rd.tellFrac()
+		if b != f.start {
+			balance -= tell
+		}
+		bd.remaining = totalBits - tell - 1
+		bandBits := 0
+		if b <= f.codedBands-1 {
+			d := f.codedBands - b
+			if d > 3 {
+				d = 3
+			}
+			bandBits = f.pulses[b] + balance/d
+			if bandBits > bd.remaining+1 {
+				bandBits = bd.remaining + 1
+			}
+			if bandBits > 16383 {
+				bandBits = 16383
+			}
+			if bandBits < 0 {
+				bandBits = 0
+			}
+		}
+
+		start, end := edge(b), edge(b+1)
+		n := end - start
+		if start-n >= edge(f.start) || b == f.start+1 {
+			if updateLowband || lowbandOffset == 0 {
+				lowbandOffset = b
+			}
+		}
+		if b == f.start+1 {
+			n1 := edge(f.start+1) - edge(f.start)
+			n2 := edge(f.start+2) - edge(f.start+1)
+			off := edge(f.start)
+			if n2 > n1 {
+				copy(norm[off+n1:off+n2], norm[off+2*n1-n2:off+n1])
+				if C == 2 {
+					copy(norm2[off+n1:off+n2], norm2[off+2*n1-n2:off+n1])
+				}
+			}
+		}
+
+		effLowband := -1
+		var xMask, yMask uint
+		if lowbandOffset != 0 && (f.spread != 3 || blocks > 1 || f.tfChange[b] < 0) {
+			effLowband = edge(lowbandOffset) - n
+			if effLowband < edge(f.start) {
+				effLowband = edge(f.start)
+			}
+			foldStart := lowbandOffset
+			for {
+				foldStart--
+				if edge(foldStart) <= effLowband {
+					break
+				}
+			}
+			foldEnd := lowbandOffset - 1
+			for {
+				foldEnd++
+				if foldEnd >= b || edge(foldEnd) >= effLowband+n {
+					break
+				}
+			}
+			for i := foldStart; i < foldEnd; i++ {
+				xMask |= uint(masks[i*C])
+				yMask |= uint(masks[i*C+C-1])
+			}
+		} else {
+			xMask = 1<<uint(blocks) - 1
+			yMask = xMask
+		}
+
+		if dualStereo && b == f.intensity {
+			dualStereo = false
+			for i := edge(f.start); i < start; i++ {
+				norm[i] = 0.5 * (norm[i] + norm2[i])
+			}
+		}
+
+		bd.band = b
+		bd.tfChange = f.tfChange[b]
+		var lowband, lowbandY []float32
+		if effLowband >= 0 {
+			lowband = norm[effLowband:]
+			if C == 2 {
+				lowbandY = norm2[effLowband:]
+			}
+		}
+		if dualStereo {
+			xMask = bd.quantBand(x[start:end], nil, n, bandBits/2, blocks, lowband, f.lm, norm[start:], 0, 1, lowScratch, xMask)
+			yMask = bd.quantBand(y[start:end], nil, n, bandBits/2, blocks, lowbandY, f.lm, norm2[start:], 0, 1, lowScratch, yMask)
+		} else {
+			var yb []float32
+			if y != nil {
+				yb = y[start:end]
+			}
+			xMask = bd.quantBand(x[start:end], yb, n, bandBits, blocks, lowband, f.lm, norm[start:], 0, 1, lowScratch, xMask|yMask)
+			yMask = xMask
+		}
+		masks[b*C] = byte(xMask)
+		masks[b*C+C-1] = byte(yMask)
+		balance += f.pulses[b] + tell
+		updateLowband = bandBits > n<<celtBitRes
+	}
+	return masks
+}
+
+// quantBand decodes one band, or one half of a split band, and returns its
+// collapse mask. y is set for the two channels of a stereo band.
+func (bd *bandDecoder) quantBand(x, y []float32, n, bandBits, blocks int, lowband []float32, lm int, lowbandOut []float32, level int, gain float32, lowScratch []float32, fill uint) uint {
+	rd := bd.rd
+	fullBand := x
+	stereo := y != nil
+	split := stereo
+	origN := n
+	nPerBlock := n / blocks
+	origBlocks := blocks
+	longBlocks := blocks == 1
+	tfChange := bd.tfChange
+	timeDivide, recombine := 0, 0
+	invert := false
+	var mid, side float32
+	var mask uint
+
+	if n == 1 {
+		for c, v := range [][]float32{x, y} {
+			if c == 1 && !stereo {
+				break
+			}
+			sign := uint32(0)
+			if bd.remaining >= 1<<celtBitRes {
+				sign = rd.bits(1)
+				bd.remaining -= 1 << celtBitRes
+			}
+			v[0] = 1
+			if sign != 0 {
+				v[0] = -1
+			}
+		}
+		if lowbandOut != nil {
+			lowbandOut[0] = x[0]
+		}
+		return 1
+	}
+
+	if !stereo && level == 0 {
+		if tfChange > 0 {
+			recombine = tfChange
+		}
+		if lowband != nil && (recombine != 0 || nPerBlock&1 == 0 && tfChange < 0 || origBlocks > 1) {
+			copy(lowScratch[:n], lowband[:n])
+			lowband = lowScratch[:n]
+		}
+		for k := 0; k < recombine; k++ {
+			if lowband != nil {
+				haar1(lowband, n>>uint(k), 1<<uint(k))
+			}
+			fill = bitInterleave(fill)
+		}
+		blocks >>= uint(recombine)
+		nPerBlock <<= uint(recombine)
+		for nPerBlock&1 == 0 && tfChange < 0 {
+			if lowband != nil {
+				haar1(lowband, nPerBlock, blocks)
+			}
+			fill |= fill << uint(blocks)
+			blocks <<= 1
+			nPerBlock >>= 1
+			timeDivide++
+			tfChange++
+		}
+		origBlocks = blocks
+		if origBlocks > 1 && lowband != nil {
+			deinterleaveHadamard(lowband, nPerBlock>>uint(recombine), origBlocks<<uint(recombine), longBlocks)
+		}
+	}
+
+	if !stereo && lm != -1 && n > 2 && celtShouldSplit(bd.band, lm, bandBits) {
+		n >>= 1
+		y = x[n:]
+		x = x[:n]
+		split = true
+		lm--
+		if blocks == 1 {
+			fill = fill&1 | fill<<1
+		}
+		blocks = (blocks + 1) >> 1
+	}
+
+	if split {
+		pulseCap := celtLogN400[bd.band] + lm<<celtBitRes
+		offset := 4
+		if stereo && n == 2 {
+			offset = 16
+		}
+		qn := celtQN(n, bandBits, pulseCap>>1-offset, pulseCap, stereo)
+		if stereo && bd.band >= bd.intensity {
+			qn = 1
+		}
+		tell := rd.tellFrac()
+		itheta := 0
+		if qn != 1 {
+			itheta = decodeTheta(rd, qn, n, stereo, origBlocks) * 16384 / qn
+		} else if stereo && bandBits > 2<<celtBitRes && bd.remaining > 2<<celtBitRes {
+			invert = rd.bit(2)
+		}
+		qalloc := rd.tellFrac() - tell
+		bandBits -= qalloc
+
+		origFill := fill
+		var delta, imid, iside int
+		switch itheta {
+		case 0:
+			imid = 32767
+			fill &= 1<<uint(blocks) - 1
+			delta = -16384
+		case 16384:
+			iside = 32767
+			fill &= (1<<uint(blocks) - 1) << uint(blocks)
+			delta = 16384
+		default:
+			imid = bitexactCos(itheta)
+			iside = bitexactCos(16384 - itheta)
+			delta = fracMul16((n-1)<<7, bitexactLog2Tan(iside, imid))
+		}
+		mid = float32(imid) / 32768
+		side = float32(iside) / 32768
+
+		if n == 2 && stereo {
+			sideBits := 0
+			if itheta != 0 && itheta != 16384 {
+				sideBits = 1 << celtBitRes
+			}
+			midBits := bandBits - sideBits
+			bd.remaining -= qalloc + sideBits
+			x2, y2 := x, y
+			if itheta > 8192 {
+				x2, y2 = y, x
+			}
+			sign := float32(1)
+			if sideBits != 0 && rd.bits(1) != 0 {
+				sign = -1
+			}
+			mask = bd.quantBand(x2, nil, n, midBits, blocks, lowband, lm, lowbandOut, level, gain, lowScratch, origFill)
+			y2[0] = -sign * x2[1]
+			y2[1] = sign * x2[0]
+			x0, x1 := mid*x[0], mid*x[1]
+			y0, y1 := side*y[0], side*y[1]
+			x[0] = x0 - y0
+			y[0] = x0 + y0
+			x[1] = x1 - y1
+			y[1] = x1 + y1
+		} else {
+			if origBlocks > 1 && !stereo && itheta&0x3fff != 0 {
+				if itheta > 8192 {
+					delta -= delta >> uint(4-lm)
+				} else {
+					delta += n << celtBitRes >> uint(5-lm)
+					if delta > 0 {
+						delta = 0
+					}
+				}
+			}
+			midBits := (bandBits - delta) / 2
+			if midBits > bandBits {
+				midBits = bandBits
+			}
+			if midBits < 0 {
+				midBits = 0
+			}
+			sideBits := bandBits - midBits
+			bd.remaining -= qalloc
+
+			var nextLowband2, nextLowbandOut []float32
+			if lowband != nil && !stereo {
+				nextLowband2 = lowband[n:]
+			}
+			nextLevel := level + 1
+			midGain := gain * mid
+			shift := uint(origBlocks >> 1)
+			if stereo {
+				nextLowbandOut = lowbandOut
+				nextLevel = 0
+				midGain = 1
+				shift = 0
+			}
+			rebalance := bd.remaining
+			if midBits >= sideBits {
+				mask = bd.quantBand(x, nil, n, midBits, blocks, lowband, lm, nextLowbandOut, nextLevel, midGain, lowScratch, fill)
+				rebalance = midBits - (rebalance - bd.remaining)
+				if rebalance > 3<<celtBitRes && itheta != 0 {
+					sideBits += rebalance - 3<<celtBitRes
+				}
+				mask |= bd.quantBand(y, nil, n, sideBits, blocks, nextLowband2, lm, nil, nextLevel, gain*side, nil, fill>>uint(blocks)) << shift
+			} else {
+				mask = bd.quantBand(y, nil, n, sideBits, blocks, nextLowband2, lm, nil, nextLevel, gain*side, nil, fill>>uint(blocks)) << shift
+				rebalance = sideBits - (rebalance - bd.remaining)
+				if rebalance > 3<<celtBitRes && itheta != 16384 {
+					midBits += rebalance - 3<<celtBitRes
+				}
+				mask |= bd.quantBand(x, nil, n, midBits, blocks, lowband, lm, nextLowbandOut, nextLevel, midGain, lowScratch, fill)
+			}
+		}
+	} else {
+		q := celtBitsToPulses(bd.band, lm, bandBits)
+		cost := celtPulsesToBits(bd.band, lm, q)
+		bd.remaining -= cost
+		for bd.remaining < 0 && q > 0 {
+			bd.remaining += cost
+			q--
+			cost = celtPulsesToBits(bd.band, lm, q)
+			bd.remaining -= cost
+		}
+		if q != 0 {
+			mask = bd.algUnquant(x, n, celtGetPulses(q), blocks, gain)
+		} else {
+			all := uint(1)<<uint(blocks) - 1
+			fill &= all
+			if fill == 0 {
+				for i := range x[:n] {
+					x[i] = 0
+				}
+			} else {
+				if lowband == nil {
+					for i := 0; i < n; i++ {
+						*bd.seed = lcgRand(*bd.seed)
+						x[i] = float32(int32(*bd.seed) >> 20)
+					}
+					mask = all
+				} else {
+					for i := 0; i < n; i++ {
+						*bd.seed = lcgRand(*bd.seed)
+						noise := float32(1.0 / 256)
+						if *bd.seed&0x8000 == 0 {
+							noise = -noise
+						}
+						x[i] = lowband[i] + noise
+					}
+					mask = fill
+				}
+				renormaliseVector(x[:n], gain)
+			}
+		}
+	}
+
+	if stereo {
+		if n != 2 {
+			stereoMerge(x, y, mid, n)
+		}
+		if invert {
+			for i := range y[:n] {
+				y[i] = -y[i]
+			}
+		}
+	} else if level == 0 {
+		x = fullBand
+		if origBlocks > 1 {
+			interleaveHadamard(x, nPerBlock>>uint(recombine), origBlocks<<uint(recombine), longBlocks)
+		}
+		nPerBlock = origN / origBlocks
+		blocks = origBlocks
+		for i := 0; i < timeDivide; i++ {
+			blocks >>= 1
+			nPerBlock <<= 1
+			mask |= mask >> uint(blocks)
+			haar1(x, nPerBlock, blocks)
+		}
+		for k := 0; k < recombine; k++ {
+			mask = bitDeinterleave(mask)
+			haar1(x, origN>>uint(k), 1<<uint(k))
+		}
+		blocks <<= uint(recombine)
+		if lowbandOut != nil {
+			scale := float32(math.Sqrt(float64(origN)))
+			for i := 0; i < origN; i++ {
+				lowbandOut[i] = scale * x[i]
+			}
+		}
+		mask &= 1<<uint(blocks) - 1
+	}
+	return mask
+}
+
+// algUnquant decodes a PVQ codeword of k pulses (RFC 6716 section 4.3.4.2)
+// into x with norm gain and undoes the spreading rotation.
+func (bd *bandDecoder) algUnquant(x []float32, n, k, blocks int, gain float32) uint {
+	iy := decodePulses(bd.rd, n, k)
+	energy := 0
+	mask := uint(0)
+	if blocks <= 1 {
+		mask = 1
+	}
+	for i, v := range iy {
+		energy += v * v
+		if blocks > 1 && v != 0 {
+			mask |= 1 << uint(i/(n/blocks))
+		}
+	}
+	if energy <= 0 {
+		for i := range x[:n] {
+			x[i] = 0
+		}
+	} else {
+		scale := gain / float32(math.Sqrt(float64(energy)))
+		for i, v := range iy {
+			x[i] = float32(v) * scale
+		}
+	}
+	expRotation(x[:n], blocks, k, bd.spread)
+	return mask
+}
+
+// expRotation undoes the spreading rotation of RFC 6716 section 4.3.4.3.
+func expRotation(x []float32, stride, k, spread int) {
+	n := len(x)
+	if 2*k >= n || spread == 0 {
+		return
+	}
+	factor := []int{15, 10, 5}[spread-1]
+	g := float64(n) / float64(n+factor*k)
+	theta := 0.5 * g * g
+	c := float32(math.Cos(0.5 * math.Pi * theta))
+	s := float32(math.Sin(0.5 * math.Pi * theta))
+
+	stride2 := 0
+	if n >= 8*stride {
+		stride2 = 1
+		for (stride2*stride2+stride2)*stride+stride>>2 < n {
+			stride2++
+		}
+	}
+	l := n / stride
+	for i := 0; i < stride; i++ {
+		seg := x[i*l : (i+1)*l]
+		if stride2 != 0 {
+			expRotation1(seg, stride2, s, c)
+		}
+		expRotation1(seg, 1, c, s)
+	}
+}
+
+func expRotation1(x []float32, stride int, c, s float32) {
+	n := len(x)
+	if n <= stride {
+		return
+	}
+	for i := 0; i < n-stride; i++ {
+		x1, x2 := x[i], x[i+stride]
+		x[i+stride] = c*x2 + s*x1
+		x[i] = c*x1 - s*x2
+	}
+	for i := n - 2*stride - 1; i >= 0; i-- {
+		x1, x2 := x[i], x[i+stride]
+		x[i+stride] = c*x2 + s*x1
+		x[i] = c*x1 - s*x2
+	}
+}
+
+func renormaliseVector(x []float32, gain float32) {
+	energy := float32(1e-27)
+	for _, v := range x {
+		energy += v * v
+	}
+	scale := gain / float32(math.Sqrt(float64(energy)))
+	for i := range x {
+		x[i] *= scale
+	}
+}
+
+// decodePulses decodes the CWRS index of a vector of n dimensions and k
+// pulses (RFC 6716 section 4.3.4.2).
+func decodePulses(rd *rangeDecoder, n, k int) []int {
+	u := make([]uint32, k+2)
+	u[1] = 1
+	for i := 2; i < len(u); i++ {
+		u[i] = uint32(2*i - 1)
+	}
+	for i := 2; i < n; i++ {
+		cwrsNextRow(u[1:], 1)
+	}
+	index := rd.uint(u[k] + u[k+1])
+	y := make([]int, n)
+	for j := range y {
+		p := u[k+1]
+		s := 0
+		if index >= p {
+			index -= p
+			s = -1
+		}
+		yj := k
+		p = u[k]
+		for p > index {
+			k--
+			p = u[k]
+		}
+		index -= p
+		yj -= k
+		y[j] = (yj + s) ^ s
+		cwrsPrevRow(u[:k+2], 0)
+	}
+	return y
+}
+
+func cwrsNextRow(u []uint32, u0 uint32) {
+	for j := 1; j < len(u); j++ {
+		next := u[j] + u[j-1] + u0
+		u[j-1] = u0
+		u0 = next
+	}
+	u[len(u)-1] = u0
+}
+
+func cwrsPrevRow(u []uint32, u0 uint32) {
+	for j := 1; j < len(u); j++ {
+		next := u[j] - u[j-1] - u0
+		u[j-1] = u0
+		u0 = next
+	}
+	u[len(u)-1] = u0
+}
+
+func celtGetPulses(i int) int {
+	if i < 8 {
+		return i
+	}
+	return (8 + i&7) << uint(i>>3-1)
+}
+
+// celtBitsToPulses returns the pulse count whose cost is closest to bits.
+func celtBitsToPulses(band, lm, bits int) int {
+	if bits <= 0 {
+		return 0
+	}
+	i := celtCacheIndex[(lm+1)*celtMaxBands+band]
+	if i < 0 {
+		return 0
+	}
+	cache := celtCacheBits[i:]
+	lo, hi := 0, int(cache[0])
+	bits--
+	for i := 0; i < 6; i++ {
+		mid := (lo + hi + 1) >> 1
+		if int(cache[mid]) >= bits {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	loBits := -1
+	if lo != 0 {
+		loBits = int(cache[lo])
+	}
+	if bits-loBits <= int(cache[hi])-bits {
+		return lo
+	}
+	return hi
+}
+
+func celtPulsesToBits(band, lm, pulses int) int {
+	if pulses == 0 {
+		return 0
+	}
+	return int(celtCacheBits[int(celtCacheIndex[(lm+1)*celtMaxBands+band])+pulses]) + 1
+}
+
+// celtShouldSplit reports whether a band has more bits than its largest
+// codebook can use, so it must be split in two.
+func celtShouldSplit(band, lm, bits int) bool {
+	i := celtCacheIndex[(lm+1)*celtMaxBands+band]
+	if i < 0 {
+		return false
+	}
+	cache := celtCacheBits[i:]
+	return bits > int(cache[cache[0]])+12
+}
+
+// celtQN returns the number of steps the split angle is quantised to.
+func celtQN(n, b, offset, pulseCap int, stereo bool) int {
+	exp2Table8 := [8]int{16384, 17866, 19483, 21247, 23170, 25267, 27554, 30048}
+	n2 := 2*n - 1
+	if stereo && n == 2 {
+		n2--
+	}
+	qb := (b + n2*offset) / n2
+	if t := b - pulseCap - 4<<celtBitRes; t < qb {
+		qb = t
+	}
+	if qb > 8<<celtBitRes {
+		qb = 8 << celtBitRes
+	}
+	if qb < 1<<celtBitRes>>1 {
+		return 1
+	}
+	return (exp2Table8[qb&7]>>uint(14-qb>>celtBitRes) + 1) >> 1 << 1
+}
+
+// decodeTheta decodes the quantised split angle of a band.
+func decodeTheta(rd *rangeDecoder, qn, n int, stereo bool, blocks int) int {
+	if stereo && n > 2 {
+		const p0 = 3
+		x0 := uint32(qn / 2)
+		ft := p0*(x0+1) + x0
+		fs := rd.decode(ft)
+		var x, fl, fh uint32
+		if fs < (x0+1)*p0 {
+			x = fs / p0
+			fl, fh = p0*x, p0*(x+1)
+		} else {
+			x = x0 + 1 + fs - (x0+1)*p0
+			fl, fh = x-1-x0+(x0+1)*p0, x-x0+(x0+1)*p0
+		}
+		rd.update(fl, fh, ft)
+		return int(x)
+	}
+	if blocks > 1 || stereo {
+		return int(rd.uint(uint32(qn + 1)))
+	}
+	half := qn >> 1
+	ft := uint32((half + 1) * (half + 1))
+	fm := rd.decode(ft)
+	var itheta, fs, fl int
+	if fm < uint32(half*(half+1)>>1) {
+		itheta = (int(isqrt32(8*fm+1)) - 1) >> 1
+		fs = itheta + 1
+		fl = itheta * (itheta + 1) >> 1
+	} else {
+		itheta = (2*(qn+1) - int(isqrt32(8*(ft-fm-1)+1))) >> 1
+		fs = qn + 1 - itheta
+		fl = int(ft) - (qn+1-itheta)*(qn+2-itheta)>>1
+	}
+	rd.update(uint32(fl), uint32(fl+fs), ft)
+	return itheta
+}
+
+func bitexactCos(x int) int {
+	x2 := (4096 + x*x) >> 13
+	x2 = (32767 - x2) + fracMul16(x2, -7651+fracMul16(x2, 8277+fracMul16(-626, x2)))
+	return 1 + x2
+}
+
+func bitexactLog2Tan(isin, icos int) int {
+	lc := bits.Len(uint(icos))
+	ls := bits.Len(uint(isin))
+	icos <<= uint(15 - lc)
+	isin <<= uint(15 - ls)
+	return (ls-lc)<<11 +
+		fracMul16(isin, fracMul16(isin, -2597)+7932) -
+		fracMul16(icos, fracMul16(icos, -2597)+7932)
+}
+
+func fracMul16(a, b int) int {
+	return (16384 + int(int16(a))*int(int16(b))) >> 15
+}
+
+func isqrt32(v uint32) uint32 {
+	g := uint32(0)
+	shift := (bits.Len32(v) - 1) >> 1
+	b := uint32(1) << uint(shift)
+	for {
+		t := (g<<1 + b) << uint(shift)
+		if t <= v {
+			g += b
+			v -= t
+		}
+		if shift == 0 {
+			return g
+		}
+		b >>= 1
+		shift--
+	}
+}
+
+func lcgRand(seed uint32) uint32 {
+	return 1664525*seed + 1013904223
+}
+
+// stereoMerge turns the mid and side of a stereo band back into left and
+// right, each with unit norm.
+func stereoMerge(x, y []float32, mid float32, n int) {
+	var cross, sideE float32
+	for i := 0; i < n; i++ {
+		cross += x[i] * y[i]
+		sideE += y[i] * y[i]
+	}
+	cross *= mid
+	el := mid*mid + sideE - 2*cross
+	er := mid*mid + sideE + 2*cross
+	if el < 6e-4 || er < 6e-4 {
+		copy(y[:n], x[:n])
+		return
+	}
+	lg := float32(1 / math.Sqrt(float64(el)))
+	rg := float32(1 / math.Sqrt(float64(er)))
+	for i := 0; i < n; i++ {
+		l := mid*x[i] - y[i]
+		r := mid*x[i] + y[i]
+		x[i] = l * lg
+		y[i] = r * rg
+	}
+}
+
+func haar1(x []float32, n0, stride int) {
+	n0 >>= 1
+	for i := 0; i < stride; i++ {
+		for j := 0; j < n0; j++ {
+			a := float32(math.Sqrt(0.5)) * x[stride*2*j+i]
+			b := float32(math.Sqrt(0.5)) * x[stride*(2*j+1)+i]
+			x[stride*2*j+i] = a + b
+			x[stride*(2*j+1)+i] = a - b
+		}
+	}
+}
+
+func deinterleaveHadamard(x []float32, n0, stride int, hadamard bool) {
+	tmp := make([]float32, n0*stride)
+	for i := 0; i < stride; i++ {
+		row := i
+		if hadamard {
+			row = celtOrdery[stride-2+i]
+		}
+		for j := 0; j < n0; j++ {
+			tmp[row*n0+j] = x[j*stride+i]
+		}
+	}
+	copy(x, tmp)
+}
+
+func interleaveHadamard(x []float32, n0, stride int, hadamard bool) {
+	tmp := make([]float32, n0*stride)
+	for i := 0; i < stride; i++ {
+		row := i
+		if hadamard {
+			row = celtOrdery[stride-2+i]
+		}
+		for j := 0; j < n0; j++ {
+			tmp[j*stride+i] = x[row*n0+j]
+		}
+	}
+	copy(x, tmp)
+}
+
+func bitInterleave(fill uint) uint {
+	table := [16]uint{0, 1, 1, 1, 2, 3, 3, 3, 2, 3, 3, 3, 2, 3, 3, 3}
+	return table[fill&0xF] | table[fill>>4]<<2
+}
+
+func bitDeinterleave(fill uint) uint {
+	table := [16]uint{
+		0x00, 0x03, 0x0C, 0x0F, 0x30, 0x33, 0x3C, 0x3F,
+		0xC0, 0xC3, 0xCC, 0xCF, 0xF0, 0xF3, 0xFC, 0xFF,
+	}
+	return table[fill&0xF]
+}
@@ -0,0 +1,190 @@
+// Package opus reads Opus packets as carried in Ogg Opus files (RFC 7845).
+//
+// It parses the TOC byte and the frame layout of every packet (RFC 6716
+// section 3), splits multistream packets, applies the channel mapping,
+// pre-skip and output gain from OpusHead, and produces 48 kHz PCM from the
+// SILK, CELT and hybrid frames. Lost and DTX frames are not concealed beyond
+// what the CELT layer does on its own; SILK and hybrid ones decode to silence.
+package opus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// SampleRate is the rate Opus always decodes at.
+const SampleRate = 48000
+
+var headMagic = []byte("OpusHead")
+
+// Head holds the fields of the OpusHead identification header the decoder uses.
+type Head struct {
+	Channels       int
+	PreSkip        int
+	OutputGain     int16 // Q7.8 dB
+	MappingFamily  int
+	StreamCount    int
+	CoupledCount   int
+	ChannelMapping []byte
+}
+
+// ParseHead parses an OpusHead packet.
+func ParseHead(packet []byte) (Head, error) {
+	if len(packet) < 19 || !bytes.HasPrefix(packet, headMagic) {
+		return Head{}, &ErrInvalidHead{Reason: "not an OpusHead packet"}
+	}
+	if packet[8]>>4 != 0 {
+		return Head{}, &ErrInvalidHead{Reason: "unsupported version"}
+	}
+	h := Head{
+		Channels:      int(packet[9]),
+		PreSkip:       int(binary.LittleEndian.Uint16(packet[10:])),
+		OutputGain:    int16(binary.LittleEndian.Uint16(packet[16:])),
+		MappingFamily: int(packet[18]),
+	}
+	if h.Channels == 0 {
+		return Head{}, &ErrInvalidHead{Reason: "channel count is zero"}
+	}
+	if h.MappingFamily == 0 {
+		if h.Channels > 2 {
+			return Head{}, &ErrInvalidHead{Reason: "mapping family 0 allows at most 2 channels"}
+		}
+		h.StreamCount = 1
+		h.CoupledCount = h.Channels - 1
+		h.ChannelMapping = []byte{0, 1}[:h.Channels]
+		return h, nil
+	}
+	if len(packet) < 21+h.Channels {
+		return Head{}, &ErrInvalidHead{Reason: "channel mapping table truncated"}
+	}
+	h.StreamCount = int(packet[19])
+	h.CoupledCount = int(packet[20])
+	h.ChannelMapping = append([]byte{}, packet[21:21+h.Channels]...)
+	if h.StreamCount == 0 || h.CoupledCount > h.StreamCount || h.StreamCount+h.CoupledCount > 255 {
+		return Head{}, &ErrInvalidHead{Reason: "invalid stream counts"}
+	}
+	for _, m := range h.ChannelMapping {
+		if m != 255 && int(m) >= h.StreamCount+h.CoupledCount {
+			return Head{}, &ErrInvalidHead{Reason: "channel mapping refers to a missing stream"}
+		}
+	}
+	return h, nil
+}
+
+// streamDecoder decodes the frames of one elementary Opus stream into one or
+// two channels of 48 kHz samples.
+type streamDecoder interface {
+	decodeFrame(toc TOC, frame []byte, out [][]float32) error
+	reset()
+}
+
+// Decoder decodes the packets of a single Ogg Opus stream.
+type Decoder struct {
+	Head Head
+
+	streams []streamDecoder
+	preSkip int // samples still to drop from the start
+	gain    float32
+}
+
+// NewDecoder returns a decoder for the stream described by an OpusHead packet.
+func NewDecoder(head []byte) (*Decoder, error) {
+	h, err := ParseHead(head)
+	if err != nil {
+		return nil, err
+	}
+	d := &Decoder{
+		Head:    h,
+		streams: make([]streamDecoder, h.StreamCount),
+		preSkip: h.PreSkip,
+		gain:    float32(math.Pow(10, float64(h.OutputGain)/(20*256))),
+	}
+	for i := range d.streams {
+		channels := 1
+		if i < h.CoupledCount {
+			channels = 2
+		}
+		d.streams[i] = newStream(channels)
+	}
+	return d, nil
+}
+
+// Reset drops the decoder state, as is needed after a seek. Pre-skip is not
+// applied again.
+func (d *Decoder) Reset() {
+	for _, s := range d.streams {
+		s.reset()
+	}
+}
+
+// Decode decodes an audio packet into one slice of 48 kHz samples per
+// channel, with pre-skip and output gain applied.
+func (d *Decoder) Decode(packet []byte) ([][]float32, error) {
+	packets := make([]*Packet, len(d.streams))
+	rest := packet
+	for s := range d.streams {
+		var err error
+		var n int
+		packets[s], n, err = parsePacket(rest, s < len(d.streams)-1)
+		if err != nil {
+			return nil, err
+		}
+		rest = rest[n:]
+		if packets[s].Samples() != packets[0].Samples() {
+			return nil, &ErrInvalidPacket{Reason: "streams differ in duration"}
+		}
+	}
+	samples := packets[0].Samples()
+
+	decoded := make([][][]float32, len(d.streams))
+	for s, p := range packets {
+		channels := 1
+		if s < d.Head.CoupledCount {
+			channels = 2
+		}
+		decoded[s] = make([][]float32, channels)
+		for ch := range decoded[s] {
+			decoded[s][ch] = make([]float32, samples)
+		}
+		size := p.TOC.FrameSize()
+		for i, frame := range p.Frames {
+			out := make([][]float32, channels)
+			for ch := range out {
+				out[ch] = decoded[s][ch][i*size : (i+1)*size]
+			}
+			if err := d.streams[s].decodeFrame(p.TOC, frame, out); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	pcm := make([][]float32, d.Head.Channels)
+	for ch, m := range d.Head.ChannelMapping {
+		switch {
+		case m == 255:
+			pcm[ch] = make([]float32, samples)
+		case int(m) < 2*d.Head.CoupledCount:
+			pcm[ch] = append([]float32{}, decoded[m/2][m%2]...)
+		default:
+			pcm[ch] = append([]float32{}, decoded[int(m)-d.Head.CoupledCount][0]...)
+		}
+		if d.gain != 1 {
+			for i := range pcm[ch] {
+				pcm[ch][i] *= d.gain
+			}
+		}
+	}
+
+	if d.preSkip > 0 {
+		skip := d.preSkip
+		if skip > samples {
+			skip = samples
+		}
+		for ch := range pcm {
+			pcm[ch] = pcm[ch][skip:]
+		}
+		d.preSkip -= skip
+	}
+	return pcm, nil
+}
@@ -0,0 +1,88 @@
+package opus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// constSynth fills every frame with its stream's value.
+type constSynth struct {
+	value float32
+}
+
+func (c constSynth) decodeFrame(toc TOC, frame []byte, out [][]float32) error {
+	for ch := range out {
+		for i := range out[ch] {
+			out[ch][i] = c.value + float32(ch)/10
+		}
+	}
+	return nil
+}
+
+func (constSynth) reset() {}
+
+func head(channels, preSkip int, gain int16, family, streams, coupled int, mapping []byte) []byte {
+	b := append([]byte{}, headMagic...)
+	fields := make([]byte, 10)
+	fields[0], fields[1] = 1, byte(channels)
+	binary.LittleEndian.PutUint16(fields[2:], uint16(preSkip))
+	binary.LittleEndian.PutUint32(fields[4:], 48000)
+	binary.LittleEndian.PutUint16(fields[8:], uint16(gain))
+	b = append(b, fields...)
+	b = append(b, byte(family))
+	if family != 0 {
+		b = append(b, byte(streams), byte(coupled))
+		b = append(b, mapping...)
+	}
+	return b
+}
+
+func TestDecoderMultistream(t *testing.T) {
+	// 3 channels from a coupled stream and a mono stream, with a silent channel
+	d, err := NewDecoder(head(4, 100, 256*6, 1, 2, 1, []byte{2, 1, 255, 0}))
+	assert.NoError(t, err)
+	d.streams[0] = constSynth{value: 0.1}
+	d.streams[1] = constSynth{value: 0.5}
+
+	toc := byte(31 << 3) // 20 ms CELT
+	packet := []byte{toc, 1, 0xAA, toc, 0xBB}
+	pcm, err := d.Decode(packet)
+	assert.NoError(t, err)
+	assert.Len(t, pcm, 4)
+	assert.Len(t, pcm[0], 960-100)
+
+	gain := float32(1.9952623) // +6 dB
+	assert.InDelta(t, 0.5*gain, pcm[0][0], 1e-4)
+	assert.InDelta(t, 0.2*gain, pcm[1][0], 1e-4)
+	assert.Zero(t, pcm[2][0])
+	assert.InDelta(t, 0.1*gain, pcm[3][0], 1e-4)
+
+	pcm, err = d.Decode(packet)
+	assert.NoError(t, err)
+	assert.Len(t, pcm[0], 960)
+
+	// streams of different duration
+	_, err = d.Decode([]byte{toc, 1, 0xAA, toc | 1, 0xBB, 0xCC})
+	assert.Error(t, err)
+}
+
+func TestDecoderOggFile(t *testing.T) {
+	b, err := os.ReadFile("../testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	// the OpusHead packet is alone on the first page
+	start := bytes.Index(b, headMagic)
+	d, err := NewDecoder(b[start : start+19])
+	assert.NoError(t, err)
+	assert.Equal(t, 2, d.Head.Channels)
+	assert.Equal(t, 1, d.Head.CoupledCount)
+
+	// a DTX packet decodes to silence
+	pcm, err := d.Decode([]byte{31 << 3})
+	assert.NoError(t, err)
+	assert.Len(t, pcm, 2)
+	assert.Equal(t, make([]float32, 960-d.Head.PreSkip), pcm[0])
+}
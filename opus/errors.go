@@ -0,0 +1,17 @@
+package opus
+
+type ErrInvalidPacket struct {
+	Reason string
+}
+
+func (e *ErrInvalidPacket) Error() string {
+	return "invalid opus packet: " + e.Reason
+}
+
+type ErrInvalidHead struct {
+	Reason string
+}
+
+func (e *ErrInvalidHead) Error() string {
+	return "invalid OpusHead: " + e.Reason
+}
@@ -0,0 +1,411 @@
+package opus
+
+import "math"
+
+// celtPFHistory is the number of past samples the pitch post-filter reads:
+// the longest period plus the two extra taps.
+const celtPFHistory = 1024 + 2
+
+// synthesize scales the normalised bands by their amplitudes, runs the
+// inverse MDCT, the post-filter and the de-emphasis (RFC 6716 sections 4.3.6
+// and 4.3.7) and writes the result to out.
+func (d *celtDecoder) synthesize(f *celtFrame, x, y []float32, amp [2][celtMaxBands]float32, out [][]float32) {
+	n := len(x)
+	M := 1 << uint(f.lm)
+	denormalise := func(norm []float32, amp [celtMaxBands]float32) []float32 {
+		freq := make([]float32, n)
+		for b := f.start; b < f.end; b++ {
+			for i := M * int(celtBandEdges[b]); i < M*int(celtBandEdges[b+1]); i++ {
+				freq[i] = norm[i] * amp[b]
+			}
+		}
+		return freq
+	}
+	freq := [][]float32{denormalise(x, amp[0])}
+	if f.channels == 2 {
+		freq = append(freq, denormalise(y, amp[1]))
+	}
+	switch {
+	case len(out) == 2 && f.channels == 1:
+		freq = append(freq, append([]float32{}, freq[0]...))
+	case len(out) == 1 && f.channels == 2:
+		for i := range freq[0] {
+			freq[0][i] = 0.5 * (freq[0][i] + freq[1][i])
+		}
+		freq = freq[:1]
+	}
+
+	for c, o := range out {
+		t := d.inverseTransform(f, freq[c], c)
+		d.postfilter(f, t, c)
+		copy(o, t)
+	}
+	d.pfOld = d.pf
+	d.pf = f.pf
+	if f.lm != 0 {
+		d.pfOld = d.pf
+	}
+	for c, o := range out {
+		mem := d.preemph[c]
+		for i, v := range o {
+			v += mem
+			mem = 0.85000610 * v
+			o[i] = v / 32768
+		}
+		d.preemph[c] = mem
+	}
+}
+
+// inverseTransform runs the inverse MDCT of one channel, one long block or
+// interleaved short blocks, and overlap-adds it with the previous frame.
+func (d *celtDecoder) inverseTransform(f *celtFrame, freq []float32, c int) []float32 {
+	n := len(freq)
+	acc := make([]float32, n+120)
+	blocks, blockLen := 1, n
+	if f.transient {
+		blocks = 1 << uint(f.lm)
+		blockLen = 120
+	}
+	in := make([]float32, blockLen)
+	for b := 0; b < blocks; b++ {
+		if f.transient {
+			for i := range in {
+				in[i] = freq[b+i*blocks]
+			}
+		} else {
+			copy(in, freq)
+		}
+		for i, v := range imdct(in) {
+			acc[b*blockLen+i] += v
+		}
+	}
+	t := acc[:n]
+	for i := 0; i < 120; i++ {
+		t[i] += d.overlap[c][i]
+	}
+	copy(d.overlap[c][:], acc[n:])
+	return t
+}
+
+// postfilter applies the pitch post-filter to one channel, fading from the
+// previous frame's filter over the overlap (RFC 6716 section 4.3.7.1).
+func (d *celtDecoder) postfilter(f *celtFrame, t []float32, c int) {
+	const h = celtPFHistory
+	buf := make([]float32, h+len(t))
+	copy(buf, d.pfMem[c][:])
+	copy(buf[h:], t)
+	period := clampPeriod(d.pf.period)
+	n := len(t)
+	if n > 120 {
+		n = 120
+	}
+	combFilter(buf, h, clampPeriod(d.pfOld.period), period, n, d.pfOld, d.pf)
+	if f.lm != 0 && len(t) > 120 {
+		combFilter(buf, h+120, period, clampPeriod(f.pf.period), len(t)-120, d.pf, f.pf)
+	}
+	copy(t, buf[h:])
+	copy(d.pfMem[c][:], buf[len(t):])
+}
+
+func clampPeriod(p int) int {
+	if p < 15 {
+		return 15
+	}
+	return p
+}
+
+// combFilter filters n samples of buf from start, cross-fading over the first
+// 120 samples from filter f0 at period t0 to f1 at period t1.
+func combFilter(buf []float32, start, t0, t1, n int, f0, f1 celtPostfilter) {
+	gains := [3][3]float32{
+		{0.3066406250, 0.2170410156, 0.1296386719},
+		{0.4638671875, 0.2680664062, 0},
+		{0.7998046875, 0.1000976562, 0},
+	}
+	g00 := f0.gain * gains[f0.tapset][0]
+	g01 := f0.gain * gains[f0.tapset][1]
+	g02 := f0.gain * gains[f0.tapset][2]
+	g10 := f1.gain * gains[f1.tapset][0]
+	g11 := f1.gain * gains[f1.tapset][1]
+	g12 := f1.gain * gains[f1.tapset][2]
+	overlap := n
+	if overlap > 120 {
+		overlap = 120
+	}
+	for i := 0; i < n; i++ {
+		j := start + i
+		out := buf[j]
+		if i < overlap {
+			w := celtWindow[i] * celtWindow[i]
+			out = out +
+				(1-w)*g00*buf[j-t0] +
+				(1-w)*g01*buf[j-t0-1] +
+				(1-w)*g01*buf[j-t0+1] +
+				(1-w)*g02*buf[j-t0-2] +
+				(1-w)*g02*buf[j-t0+2] +
+				w*g10*buf[j-t1] +
+				w*g11*buf[j-t1-1] +
+				w*g11*buf[j-t1+1] +
+				w*g12*buf[j-t1-2] +
+				w*g12*buf[j-t1+2]
+		} else {
+			out = out +
+				g10*buf[j-t1] +
+				g11*buf[j-t1-1] +
+				g11*buf[j-t1+1] +
+				g12*buf[j-t1-2] +
+				g12*buf[j-t1+2]
+		}
+		buf[j] = out
+	}
+}
+
+// antiCollapse fills the short blocks of transient bands that got no pulses
+// with noise (RFC 6716 section 4.3.5).
+func (d *celtDecoder) antiCollapse(f *celtFrame, x, y []float32, masks []byte, seed uint32) {
+	spectra := [2][]float32{x, y}
+	for b := f.start; b < f.end; b++ {
+		n0 := int(celtBandEdges[b+1] - celtBandEdges[b])
+		n := n0 << uint(f.lm)
+		depth := (1 + f.pulses[b]) / n
+		thresh := 0.5 * math.Pow(2, -0.125*float64(depth))
+		sqrtInv := 1 / math.Sqrt(float64(n))
+		for c := 0; c < f.channels; c++ {
+			prev1, prev2 := d.logE1[c][b], d.logE2[c][b]
+			if f.channels == 1 {
+				if d.logE1[1][b] > prev1 {
+					prev1 = d.logE1[1][b]
+				}
+				if d.logE2[1][b] > prev2 {
+					prev2 = d.logE2[1][b]
+				}
+			}
+			if prev2 < prev1 {
+				prev1 = prev2
+			}
+			diff := d.logE[c][b] - prev1
+			if diff < 0 {
+				diff = 0
+			}
+			r := 2 * math.Pow(2, -float64(diff))
+			if f.lm == 3 {
+				r *= math.Sqrt2
+			}
+			r = math.Min(thresh, r) * sqrtInv
+			xb := spectra[c][int(celtBandEdges[b])<<uint(f.lm):]
+			renormalise := false
+			for k := 0; k < 1<<uint(f.lm); k++ {
+				if masks[b*f.channels+c]&(1<<uint(k)) != 0 {
+					continue
+				}
+				for j := 0; j < n0; j++ {
+					seed = lcgRand(seed)
+					v := float32(r)
+					if seed&0x8000 == 0 {
+						v = -v
+					}
+					xb[j<<uint(f.lm)+k] = v
+				}
+				renormalise = true
+			}
+			if renormalise {
+				renormaliseVector(xb[:n], 1)
+			}
+		}
+	}
+}
+
+// imdctPlan holds the twiddles of the inverse MDCT of one size, computed
+// with a mixed-radix FFT of a quarter of the transform length.
+type imdctPlan struct {
+	n2      int
+	sine    float32
+	cos     []float32 // cos(2πi/N)
+	sinQ    []float32 // cos(2π(N/4-i)/N)
+	twiddle []complex64
+	bitrev  []int
+	factors [][2]int // {radix, remaining length}
+}
+
+var imdctPlans = map[int]*imdctPlan{}
+
+func init() {
+	for lm := 0; lm <= celtMaxLM; lm++ {
+		n2 := 120 << uint(lm)
+		imdctPlans[n2] = newIMDCTPlan(n2)
+	}
+}
+
+func newIMDCTPlan(n2 int) *imdctPlan {
+	n := 2 * n2
+	n4 := n >> 2
+	p := &imdctPlan{
+		n2:      n2,
+		sine:    float32(2 * math.Pi * 0.125 / float64(n)),
+		cos:     make([]float32, n4),
+		sinQ:    make([]float32, n4),
+		twiddle: make([]complex64, n4),
+		bitrev:  make([]int, n4),
+	}
+	for i := 0; i < n4; i++ {
+		p.cos[i] = float32(math.Cos(2 * math.Pi * float64(i) / float64(n)))
+		p.sinQ[i] = float32(math.Cos(2 * math.Pi * float64(n4-i) / float64(n)))
+		a := 2 * math.Pi * float64(i) / float64(n4)
+		p.twiddle[i] = complex(float32(math.Cos(a)), float32(math.Sin(a)))
+	}
+	m, radix := n4, 4
+	for m > 1 {
+		for m%radix != 0 {
+			switch radix {
+			case 4:
+				radix = 2
+			case 2:
+				radix = 3
+			default:
+				radix += 2
+			}
+			if radix*radix > m {
+				radix = m
+			}
+		}
+		m /= radix
+		p.factors = append(p.factors, [2]int{radix, m})
+	}
+	p.fillBitrev(0, 1, 0, 0)
+	return p
+}
+
+func (p *imdctPlan) fillBitrev(out, fstride, in, stage int) {
+	radix, m := p.factors[stage][0], p.factors[stage][1]
+	if m == 1 {
+		for j := 0; j < radix; j++ {
+			p.bitrev[out+j*fstride] = in + j
+		}
+		return
+	}
+	for j := 0; j < radix; j++ {
+		p.fillBitrev(out, fstride*radix, in, stage+1)
+		out += fstride
+		in += m
+	}
+}
+
+// imdct returns the 2N-sample windowed inverse MDCT of N coefficients,
+// truncated to N+120 samples as CELT's low-overlap window is zero elsewhere.
+func imdct(freq []float32) []float32 {
+	n2 := len(freq)
+	p := imdctPlans[n2]
+	n4 := n2 / 2
+	buf := make([]cpx, n4)
+	for i := 0; i < n4; i++ {
+		x1, x2 := freq[2*i], freq[n2-1-2*i]
+		yr := -x2*p.cos[i] + x1*p.sinQ[i]
+		yi := -x2*p.sinQ[i] - x1*p.cos[i]
+		buf[p.bitrev[i]] = cpx{yr - yi*p.sine, yi + yr*p.sine}
+	}
+	p.fft(buf)
+	d := make([]float32, n2)
+	for i, v := range buf {
+		yr := v.r*p.cos[i] - v.i*p.sinQ[i]
+		yi := v.i*p.cos[i] + v.r*p.sinQ[i]
+		d[2*i] = -(yr - yi*p.sine)
+		d[n2-1-2*i] = yi + yr*p.sine
+	}
+
+	const overlap = 120
+	out := make([]float32, n2+overlap)
+	plain := n4 - overlap/2
+	for i := 0; i < plain; i++ {
+		out[n4+overlap/2-1-i] = d[n4-1-i]
+	}
+	for i := plain; i < n4; i++ {
+		x1 := d[n4-1-i]
+		w := i - plain
+		out[w] += -celtWindow[w] * x1
+		out[n4+overlap/2-1-i] += celtWindow[overlap-1-w] * x1
+	}
+	for i := 0; i < plain; i++ {
+		out[n4+overlap/2+i] = d[n4+i]
+	}
+	for i := plain; i < n4; i++ {
+		x2 := d[n4+i]
+		w := i - plain
+		out[n2+overlap-1-w] = celtWindow[w] * x2
+		out[n4+overlap/2+i] = celtWindow[overlap-1-w] * x2
+	}
+	return out
+}
+
+// cpx is a complex number with every operation rounded to float32, as the
+// reference FFT computes it.
+type cpx struct{ r, i float32 }
+
+func (a cpx) add(b cpx) cpx       { return cpx{a.r + b.r, a.i + b.i} }
+func (a cpx) sub(b cpx) cpx       { return cpx{a.r - b.r, a.i - b.i} }
+func (a cpx) scale(s float32) cpx { return cpx{a.r * s, a.i * s} }
+func (a cpx) mul(b complex64) cpx {
+	br, bi := real(b), imag(b)
+	return cpx{a.r*br - a.i*bi, a.r*bi + a.i*br}
+}
+
+// fft runs the inverse FFT in place on bit-reversed input.
+func (p *imdctPlan) fft(x []cpx) {
+	n4 := len(x)
+	tw := p.twiddle
+	for s := len(p.factors) - 1; s >= 0; s-- {
+		radix, m := p.factors[s][0], p.factors[s][1]
+		fstride := n4 / (radix * m)
+		for g := 0; g < fstride; g++ {
+			o := x[g*radix*m:]
+			for k := 0; k < m; k++ {
+				switch radix {
+				case 2:
+					a := o[k]
+					b := o[m+k].mul(tw[k*fstride])
+					o[k] = a.add(b)
+					o[m+k] = a.sub(b)
+				case 3:
+					epi3 := imag(tw[fstride*m])
+					a := o[k]
+					b := o[m+k].mul(tw[k*fstride])
+					c := o[2*m+k].mul(tw[2*k*fstride])
+					sum := b.add(c)
+					diff := b.sub(c).scale(epi3)
+					mid := a.sub(sum.scale(0.5))
+					o[k] = a.add(sum)
+					o[m+k] = cpx{mid.r - diff.i, mid.i + diff.r}
+					o[2*m+k] = cpx{mid.r + diff.i, mid.i - diff.r}
+				case 4:
+					a := o[k]
+					b := o[m+k].mul(tw[k*fstride])
+					c := o[2*m+k].mul(tw[2*k*fstride])
+					e := o[3*m+k].mul(tw[3*k*fstride])
+					d0, s0 := a.sub(c), a.add(c)
+					s1, d1 := b.add(e), b.sub(e)
+					o[k] = s0.add(s1)
+					o[2*m+k] = s0.sub(s1)
+					o[m+k] = cpx{d0.r - d1.i, d0.i + d1.r}
+					o[3*m+k] = cpx{d0.r + d1.i, d0.i - d1.r}
+				case 5:
+					ya, yb := tw[fstride*m], tw[2*fstride*m]
+					a := o[k]
+					b := o[m+k].mul(tw[k*fstride])
+					c := o[2*m+k].mul(tw[2*k*fstride])
+					e := o[3*m+k].mul(tw[3*k*fstride])
+					g4 := o[4*m+k].mul(tw[4*k*fstride])
+					s14, d14 := b.add(g4), b.sub(g4)
+					s23, d23 := c.add(e), c.sub(e)
+					o[k] = a.add(s14.add(s23))
+					b14 := a.add(s14.scale(real(ya)).add(s23.scale(real(yb))))
+					r14 := cpx{-d14.i*imag(ya) - d23.i*imag(yb), d14.r*imag(ya) + d23.r*imag(yb)}
+					o[m+k] = b14.add(r14)
+					o[4*m+k] = b14.sub(r14)
+					b23 := a.add(s14.scale(real(yb)).add(s23.scale(real(ya))))
+					r23 := cpx{-d14.i*imag(yb) + d23.i*imag(ya), d14.r*imag(yb) - d23.r*imag(ya)}
+					o[2*m+k] = b23.add(r23)
+					o[3*m+k] = b23.sub(r23)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package opus
+
+// MaxPacketDuration is the longest audio a single packet may hold, in samples at 48 kHz.
+const MaxPacketDuration = 5760
+
+// Packet is an Opus packet split into its frames, following RFC 6716 section 3.
+type Packet struct {
+	TOC    TOC
+	Frames [][]byte // a zero-length frame is a DTX or lost frame
+}
+
+// Samples returns the duration of the packet in samples at 48 kHz.
+func (p *Packet) Samples() int {
+	return len(p.Frames) * p.TOC.FrameSize()
+}
+
+// ParsePacket splits a packet into frames.
+func ParsePacket(data []byte) (*Packet, error) {
+	p, _, err := parsePacket(data, false)
+	return p, err
+}
+
+// PacketSamples returns the duration of a packet in samples at 48 kHz from its
+// first bytes only, without validating the frame lengths.
+func PacketSamples(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, &ErrInvalidPacket{Reason: "empty packet"}
+	}
+	toc := TOC(data[0])
+	count := 1
+	switch toc.FrameCountCode() {
+	case 1, 2:
+		count = 2
+	case 3:
+		if len(data) < 2 {
+			return 0, &ErrInvalidPacket{Reason: "missing frame count"}
+		}
+		count = int(data[1] & 0x3f)
+	}
+	samples := count * toc.FrameSize()
+	if count == 0 || samples > MaxPacketDuration {
+		return 0, &ErrInvalidPacket{Reason: "invalid frame count"}
+	}
+	return samples, nil
+}
+
+// parsePacket splits data into frames. selfDelimited selects the framing of
+// RFC 6716 appendix B.
+func parsePacket(data []byte, selfDelimited bool) (*Packet, int, error) {
+	if len(data) == 0 {
+		return nil, 0, &ErrInvalidPacket{Reason: "empty packet"}
+	}
+	p := &Packet{TOC: TOC(data[0])}
+	pos := 1
+	end := len(data)
+	padding := 0
+
+	readLength := func() (int, error) {
+		if pos >= end {
+			return 0, &ErrInvalidPacket{Reason: "truncated frame length"}
+		}
+		n := int(data[pos])
+		pos++
+		if n >= 252 {
+			if pos >= end {
+				return 0, &ErrInvalidPacket{Reason: "truncated frame length"}
+			}
+			n += 4 * int(data[pos])
+			pos++
+		}
+		return n, nil
+	}
+
+	var lengths []int
+	switch p.TOC.FrameCountCode() {
+	case 0:
+		lengths = []int{-1}
+	case 1:
+		lengths = []int{-2, -2}
+	case 2:
+		n, err := readLength()
+		if err != nil {
+			return nil, 0, err
+		}
+		lengths = []int{n, -1}
+	case 3:
+		if pos >= end {
+			return nil, 0, &ErrInvalidPacket{Reason: "missing frame count"}
+		}
+		vbr := data[pos]&0x80 != 0
+		padded := data[pos]&0x40 != 0
+		count := int(data[pos] & 0x3f)
+		pos++
+		if count == 0 || count*p.TOC.FrameSize() > MaxPacketDuration {
+			return nil, 0, &ErrInvalidPacket{Reason: "invalid frame count"}
+		}
+		if padded {
+			for {
+				if pos >= end {
+					return nil, 0, &ErrInvalidPacket{Reason: "truncated padding"}
+				}
+				b := int(data[pos])
+				pos++
+				if b == 255 {
+					padding += 254
+					continue
+				}
+				padding += b
+				break
+			}
+			if padding > end-pos {
+				return nil, 0, &ErrInvalidPacket{Reason: "padding exceeds packet"}
+			}
+			if !selfDelimited {
+				end -= padding
+			}
+		}
+		lengths = make([]int, count)
+		for i := range lengths {
+			lengths[i] = -2
+		}
+		if vbr {
+			for i := 0; i < count-1; i++ {
+				n, err := readLength()
+				if err != nil {
+					return nil, 0, err
+				}
+				lengths[i] = n
+			}
+			lengths[count-1] = -1
+		}
+	}
+
+	// -1 marks a frame that takes what is left, -2 frames that share it equally
+	if selfDelimited {
+		n, err := readLength()
+		if err != nil {
+			return nil, 0, err
+		}
+		frameEnd := pos
+		for i, l := range lengths {
+			if l < 0 {
+				lengths[i] = n
+			}
+			frameEnd += lengths[i]
+		}
+		// padding follows the frames when the packet is self-delimited
+		if frameEnd+padding > len(data) {
+			return nil, 0, &ErrInvalidPacket{Reason: "frames exceed packet"}
+		}
+		p.Frames = splitFrames(data[pos:frameEnd], lengths)
+		return p, frameEnd + padding, nil
+	}
+
+	remaining := end - pos
+	var fixed, shared int
+	for _, l := range lengths {
+		switch {
+		case l >= 0:
+			fixed += l
+		case l == -2:
+			shared++
+		}
+	}
+	remaining -= fixed
+	if remaining < 0 {
+		return nil, 0, &ErrInvalidPacket{Reason: "frames exceed packet"}
+	}
+	if shared > 0 {
+		if remaining%shared != 0 {
+			return nil, 0, &ErrInvalidPacket{Reason: "constant bitrate frames differ in size"}
+		}
+		for i, l := range lengths {
+			if l == -2 {
+				lengths[i] = remaining / shared
+			}
+		}
+	}
+	for i, l := range lengths {
+		if l == -1 {
+			lengths[i] = remaining
+		}
+	}
+	for _, l := range lengths {
+		if l > 1275 {
+			return nil, 0, &ErrInvalidPacket{Reason: "frame longer than 1275 bytes"}
+		}
+	}
+	p.Frames = splitFrames(data[pos:end], lengths)
+	return p, len(data), nil
+}
+
+func splitFrames(data []byte, lengths []int) [][]byte {
+	frames := make([][]byte, len(lengths))
+	for i, l := range lengths {
+		frames[i] = data[:l:l]
+		data = data[l:]
+	}
+	return frames
+}
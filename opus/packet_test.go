@@ -0,0 +1,78 @@
+package opus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOC(t *testing.T) {
+	cases := []struct {
+		config    byte
+		mode      Mode
+		bandwidth Bandwidth
+		size      int
+	}{
+		{0, ModeSILK, Narrowband, 480},
+		{5, ModeSILK, Mediumband, 960},
+		{11, ModeSILK, Wideband, 2880},
+		{12, ModeHybrid, SuperWideband, 480},
+		{15, ModeHybrid, Fullband, 960},
+		{16, ModeCELT, Narrowband, 120},
+		{22, ModeCELT, Wideband, 480},
+		{25, ModeCELT, SuperWideband, 240},
+		{31, ModeCELT, Fullband, 960},
+	}
+	for _, c := range cases {
+		toc := TOC(c.config<<3 | 0x04)
+		assert.Equal(t, c.mode, toc.Mode(), "config %d", c.config)
+		assert.Equal(t, c.bandwidth, toc.Bandwidth(), "config %d", c.config)
+		assert.Equal(t, c.size, toc.FrameSize(), "config %d", c.config)
+		assert.True(t, toc.Stereo())
+	}
+}
+
+func TestParsePacket(t *testing.T) {
+	toc := byte(31 << 3)
+
+	p, err := ParsePacket([]byte{toc, 1, 2, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{{1, 2, 3}}, p.Frames)
+
+	p, err = ParsePacket([]byte{toc | 1, 1, 2, 3, 4})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{{1, 2}, {3, 4}}, p.Frames)
+	_, err = ParsePacket([]byte{toc | 1, 1, 2, 3})
+	assert.Error(t, err)
+
+	p, err = ParsePacket([]byte{toc | 2, 1, 9, 3, 4})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{{9}, {3, 4}}, p.Frames)
+
+	// code 3, VBR with padding: 3 frames of 1, 0 and the rest, 2 padding bytes
+	p, err = ParsePacket([]byte{toc | 3, 0xC3, 2, 1, 0, 7, 8, 9, 0, 0})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{{7}, {}, {8, 9}}, p.Frames)
+	assert.Equal(t, 3*960, p.Samples())
+
+	// code 3 with more than 120 ms
+	_, err = ParsePacket([]byte{toc | 3, 7})
+	assert.Error(t, err)
+
+	n, err := PacketSamples([]byte{toc | 3, 0x83})
+	assert.NoError(t, err)
+	assert.Equal(t, 2880, n)
+}
+
+func TestParseSelfDelimited(t *testing.T) {
+	toc := byte(31 << 3)
+	data := []byte{toc, 2, 5, 6, toc | 1, 9, 9, 9, 9}
+	p, n, err := parsePacket(data, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, [][]byte{{5, 6}}, p.Frames)
+
+	p, _, err = parsePacket(data[n:], false)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{{9, 9}, {9, 9}}, p.Frames)
+}
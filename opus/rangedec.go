@@ -0,0 +1,204 @@
+package opus
+
+import "math/bits"
+
+// rangeDecoder is the entropy decoder of RFC 6716 section 4.1. Range coded
+// symbols are read from the start of the frame and raw bits from its end.
+type rangeDecoder struct {
+	buf     []byte
+	storage int // bytes of buf the decoder may read
+	offs    int
+	endOffs int
+
+	endWindow uint32
+	nendBits  int
+	nbits     int // nbits_total of the reference decoder
+	rng       uint32
+	val       uint32
+	rem       int
+}
+
+func (r *rangeDecoder) init(buf []byte) {
+	*r = rangeDecoder{buf: buf, storage: len(buf), nbits: 9, rng: 128}
+	r.rem = r.readByte()
+	r.val = r.rng - 1 - uint32(r.rem>>1)
+	r.normalize()
+}
+
+func (r *rangeDecoder) readByte() int {
+	if r.offs < r.storage {
+		r.offs++
+		return int(r.buf[r.offs-1])
+	}
+	return 0
+}
+
+func (r *rangeDecoder) readByteFromEnd() int {
+	if r.endOffs < r.storage {
+		r.endOffs++
+		return int(r.buf[r.storage-r.endOffs])
+	}
+	return 0
+}
+
+func (r *rangeDecoder) normalize() {
+	for r.rng <= 1<<23 {
+		r.nbits += 8
+		r.rng <<= 8
+		sym := r.rem
+		r.rem = r.readByte()
+		sym = (sym<<8 | r.rem) >> 1
+		r.val = (r.val<<8 + uint32(255&^sym)) & 0x7FFFFFFF
+	}
+}
+
+// decode returns the cumulative frequency of the next symbol in a context
+// of total ft; update must follow.
+func (r *rangeDecoder) decode(ft uint32) uint32 {
+	ext := r.rng / ft
+	s := r.val/ext + 1
+	if s > ft {
+		s = ft
+	}
+	return ft - s
+}
+
+func (r *rangeDecoder) update(fl, fh, ft uint32) {
+	ext := r.rng / ft
+	s := ext * (ft - fh)
+	r.val -= s
+	if fl > 0 {
+		r.rng = ext * (fh - fl)
+	} else {
+		r.rng -= s
+	}
+	r.normalize()
+}
+
+// bit decodes a symbol that is 1 with probability 1/2**logp.
+func (r *rangeDecoder) bit(logp uint) bool {
+	s := r.rng >> logp
+	ret := r.val < s
+	if !ret {
+		r.val -= s
+		r.rng -= s
+	} else {
+		r.rng = s
+	}
+	r.normalize()
+	return ret
+}
+
+// icdf decodes a symbol with an inverse cumulative table scaled to 1<<ftb.
+func (r *rangeDecoder) icdf(icdf []uint8, ftb uint) int {
+	s := r.rng
+	d := r.val
+	q := s >> ftb
+	ret := -1
+	var t uint32
+	for {
+		t = s
+		ret++
+		s = q * uint32(icdf[ret])
+		if d >= s {
+			break
+		}
+	}
+	r.val = d - s
+	r.rng = t - s
+	r.normalize()
+	return ret
+}
+
+// uint decodes an integer uniformly distributed in [0, ft).
+func (r *rangeDecoder) uint(ft uint32) uint32 {
+	ft--
+	ftb := bits.Len32(ft)
+	if ftb > 8 {
+		ftb -= 8
+		ft1 := ft>>uint(ftb) + 1
+		s := r.decode(ft1)
+		r.update(s, s+1, ft1)
+		t := s<<uint(ftb) | r.bits(uint(ftb))
+		if t <= ft {
+			return t
+		}
+		return ft
+	}
+	ft++
+	s := r.decode(ft)
+	r.update(s, s+1, ft)
+	return s
+}
+
+// bits reads n raw bits from the end of the frame.
+func (r *rangeDecoder) bits(n uint) uint32 {
+	window := r.endWindow
+	available := r.nendBits
+	if uint(available) < n {
+		for available <= 24 {
+			window |= uint32(r.readByteFromEnd()) << uint(available)
+			available += 8
+		}
+	}
+	ret := window & (1<<n - 1)
+	window >>= n
+	available -= int(n)
+	r.endWindow = window
+	r.nendBits = available
+	r.nbits += int(n)
+	return ret
+}
+
+// laplace decodes a CELT energy delta with probability fs of zero and decay.
+func (r *rangeDecoder) laplace(fs, decay uint32) int {
+	val := 0
+	fm := r.decode(1 << 15)
+	fl := uint32(0)
+	if fm >= fs {
+		val++
+		fl = fs
+		fs = (32768-32-fs)*(16384-decay)>>15 + 1
+		for fs > 1 && fm >= fl+2*fs {
+			fs *= 2
+			fl += fs
+			fs = (fs-2)*decay>>15 + 1
+			val++
+		}
+		if fs <= 1 {
+			di := (fm - fl) >> 1
+			val += int(di)
+			fl += 2 * di
+		}
+		if fm < fl+fs {
+			val = -val
+		} else {
+			fl += fs
+		}
+	}
+	fh := fl + fs
+	if fh > 32768 {
+		fh = 32768
+	}
+	r.update(fl, fh, 32768)
+	return val
+}
+
+// tell returns the number of bits used so far, rounded up.
+func (r *rangeDecoder) tell() int {
+	return r.nbits - bits.Len32(r.rng)
+}
+
+// tellFrac returns the number of bits used so far in 1/8 bit units.
+func (r *rangeDecoder) tellFrac() int {
+	nbits := r.nbits << 3
+	l := bits.Len32(r.rng)
+	q := r.rng >> uint(l-16)
+	for i := 0; i < 3; i++ {
+		q = q * q >> 15
+		b := int(q >> 16)
+		l = l<<1 | b
+		q >>= uint(b)
+	}
+	return nbits - l
+}
@@ -0,0 +1,68 @@
+package opus_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/gcottom/oggmeta"
+	"github.com/gcottom/oggmeta/opus"
+	"github.com/stretchr/testify/assert"
+)
+
+// The reference files hold every 64th stereo frame of a file, after pre-skip,
+// as decoded by an independent decoder into little-endian float32.
+// testdata-opus.ogg is CELT only; testdata-opus-modes.ogg is random packets
+// that switch between SILK, hybrid and CELT frames of every bandwidth and
+// duration, with redundant CELT frames in both directions.
+func TestDecodeReference(t *testing.T) {
+	for _, name := range []string{"testdata-opus", "testdata-opus-modes"} {
+		t.Run(name, func(t *testing.T) {
+			b, err := os.ReadFile("../testdata/" + name + ".ogg")
+			assert.NoError(t, err)
+			ref, err := os.ReadFile("../testdata/" + name + "-reference.f32")
+			assert.NoError(t, err)
+			ogg := &oggmeta.OGGDecoder{Reader: bytes.NewReader(b)}
+
+			var dec *opus.Decoder
+			var pcm [2][]float32
+			for n := 0; ; n++ {
+				packet, err := ogg.ReadPacket()
+				if err == io.EOF {
+					break
+				}
+				assert.NoError(t, err)
+				switch n {
+				case 0:
+					dec, err = opus.NewDecoder(packet.Data)
+					assert.NoError(t, err)
+					continue
+				case 1:
+					continue
+				}
+				out, err := dec.Decode(packet.Data)
+				if !assert.NoError(t, err) {
+					return
+				}
+				for ch := range out {
+					pcm[ch] = append(pcm[ch], out[ch]...)
+				}
+			}
+			frames := (len(pcm[0]) + 63) / 64
+			if !assert.Equal(t, frames*8, len(ref)) {
+				return
+			}
+			var worst float64
+			for i := 0; i < frames; i++ {
+				for ch := 0; ch < 2; ch++ {
+					want := math.Float32frombits(binary.LittleEndian.Uint32(ref[8*i+4*ch:]))
+					worst = math.Max(worst, math.Abs(float64(pcm[ch][64*i]-want)))
+				}
+			}
+			assert.Less(t, worst, 1e-5)
+		})
+	}
+}
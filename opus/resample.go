@@ -0,0 +1,151 @@
+package opus
+
+import "math"
+
+// resampler converts SILK output from its internal rate to 48 kHz with the
+// fixed-point allpass/FIR upsampler of the reference decoder (libopus
+// silk/resampler_private_IIR_FIR.c).
+type resampler struct {
+	rate     int // input rate in kHz
+	delay    int
+	invRatio int32 // Q16
+	sIIR     [6]int32
+	sFIR     [8]int16
+	delayBuf [16]int16
+}
+
+var resamplerUp2HQ0 = [3]int32{1746, 14986, 39083 - 65536}
+var resamplerUp2HQ1 = [3]int32{6854, 25769, 55542 - 65536}
+
+var resamplerFIR12 = [12][4]int32{
+	{189, -600, 617, 30567},
+	{117, -159, -1070, 29704},
+	{52, 221, -2392, 28276},
+	{-4, 529, -3350, 26341},
+	{-48, 758, -3956, 23973},
+	{-80, 905, -4235, 21254},
+	{-99, 972, -4222, 18278},
+	{-107, 967, -3957, 15143},
+	{-103, 896, -3487, 11950},
+	{-91, 773, -2865, 8798},
+	{-71, 611, -2143, 5784},
+	{-46, 425, -1375, 2996},
+}
+
+// init prepares the resampler for an input rate of 8, 12 or 16 kHz.
+func (r *resampler) init(rate int) {
+	*r = resampler{rate: rate / 1000}
+	r.delay = map[int]int{8: 0, 12: 4, 16: 7}[r.rate]
+	r.invRatio = int32((int64(rate)<<15)/SampleRate) << 2
+	for smulww(r.invRatio, SampleRate) < int32(rate<<1) {
+		r.invRatio++
+	}
+}
+
+// resample converts in, a whole number of milliseconds, into out.
+func (r *resampler) resample(in, out []float32) {
+	in16 := make([]int16, len(in))
+	for i, v := range in {
+		in16[i] = toInt16(v)
+	}
+	outLen := len(in) * 48 / r.rate
+	out16 := make([]int16, outLen+48)
+	n := r.rate - r.delay
+	copy(r.delayBuf[r.delay:], in16[:n])
+	r.iirFIR(out16, r.delayBuf[:r.rate])
+	r.iirFIR(out16[48:], in16[n:len(in16)-r.delay])
+	copy(r.delayBuf[:], in16[len(in16)-r.delay:])
+	for i := 0; i < outLen; i++ {
+		out[i] = float32(out16[i]) / 32768.0
+	}
+}
+
+func (r *resampler) iirFIR(out, in []int16) {
+	batch := r.rate * 10
+	buf := make([]int16, 2*batch+8)
+	copy(buf, r.sFIR[:])
+	o := 0
+	for len(in) > 0 {
+		n := len(in)
+		if n > batch {
+			n = batch
+		}
+		r.up2HQ(buf[8:], in[:n])
+		for index := int32(0); index < int32(n)<<17; index += r.invRatio {
+			t := silkSMULWB(int32(uint32(index)&0xffff), 12)
+			p := buf[index>>16:]
+			res := int32(p[0])*resamplerFIR12[t][0] +
+				int32(p[1])*resamplerFIR12[t][1] +
+				int32(p[2])*resamplerFIR12[t][2] +
+				int32(p[3])*resamplerFIR12[t][3] +
+				int32(p[4])*resamplerFIR12[11-t][3] +
+				int32(p[5])*resamplerFIR12[11-t][2] +
+				int32(p[6])*resamplerFIR12[11-t][1] +
+				int32(p[7])*resamplerFIR12[11-t][0]
+			out[o] = sat16(rshiftRound32(res, 15))
+			o++
+		}
+		in = in[n:]
+		if len(in) > 0 {
+			copy(buf[:8], buf[n*2:])
+		} else {
+			copy(r.sFIR[:], buf[n*2:])
+		}
+	}
+}
+
+// up2HQ upsamples by two with a pair of third-order allpass filters.
+func (r *resampler) up2HQ(out, in []int16) {
+	section := func(s *int32, x, c int32, last bool) int32 {
+		diff := x - *s
+		var delta int32
+		if last {
+			delta = diff + silkSMULWB(diff, c)
+		} else {
+			delta = silkSMULWB(diff, c)
+		}
+		y := *s + delta
+		*s = x + delta
+		return y
+	}
+	for i, v := range in {
+		x := int32(v) << 10
+		y := section(&r.sIIR[0], x, resamplerUp2HQ0[0], false)
+		y = section(&r.sIIR[1], y, resamplerUp2HQ0[1], false)
+		y = section(&r.sIIR[2], y, resamplerUp2HQ0[2], true)
+		out[2*i] = sat16(rshiftRound32(y, 10))
+		y = section(&r.sIIR[3], x, resamplerUp2HQ1[0], false)
+		y = section(&r.sIIR[4], y, resamplerUp2HQ1[1], false)
+		y = section(&r.sIIR[5], y, resamplerUp2HQ1[2], true)
+		out[2*i+1] = sat16(rshiftRound32(y, 10))
+	}
+}
+
+func silkSMULWB(a, b int32) int32 {
+	return (a>>16)*int32(int16(b)) + int32((int64(a&0xffff)*int64(int16(b)))>>16)
+}
+
+func smulww(a, b int32) int32 {
+	return silkSMULWB(a, b) + a*rshiftRound32(b, 16)
+}
+
+func sat16(v int32) int16 {
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(v)
+}
+
+// toInt16 converts a sample to 16 bits with rounding and clipping.
+func toInt16(v float32) int16 {
+	x := math.Round(float64(v * 32768))
+	if x < -32768 {
+		x = -32768
+	} else if x > 32767 {
+		x = 32767
+	}
+	return int16(x)
+}
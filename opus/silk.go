@@ -0,0 +1,929 @@
+package opus
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// SILK signal types (RFC 6716 section 4.2.7.3).
+const (
+	silkInactive = iota
+	silkUnvoiced
+	silkVoiced
+)
+
+// silkChannel holds the state of one SILK channel decoder: the mid channel,
+// or the side channel of a stereo stream.
+type silkChannel struct {
+	bandwidth Bandwidth // -1 before the first frame
+
+	haveDecoded bool
+	prevVoiced  bool
+	prevLag     int
+	prevLogGain int32
+	prevLPC     []float32 // last dLPC LPC synthesis values of the previous frame
+	finalOut    [306]float32
+	n0Q15       []int16
+}
+
+func newSilkChannel() *silkChannel {
+	return &silkChannel{bandwidth: -1}
+}
+
+func (c *silkChannel) resetPrediction() {
+	c.haveDecoded = false
+	c.prevVoiced = false
+	c.prevLag = 100
+	c.prevLogGain = 10
+	c.prevLPC = nil
+	c.finalOut = [306]float32{}
+	c.n0Q15 = nil
+}
+
+// silkDecoder decodes the SILK layer of one Opus stream at the internal
+// sample rate of its bandwidth (8, 12 or 16 kHz).
+type silkDecoder struct {
+	mid, side *silkChannel
+
+	prevWeights [2]int32
+	prevMid     [2]float32
+	prevSide    float32
+	prevMidOnly bool
+	wasStereo   bool
+}
+
+func newSilkDecoder() *silkDecoder {
+	return &silkDecoder{mid: newSilkChannel(), side: newSilkChannel()}
+}
+
+// silkRate returns the internal sample rate of a SILK bandwidth.
+func silkRate(bw Bandwidth) int {
+	return []int{8000, 12000, 16000}[bw]
+}
+
+// decode decodes the SILK frames of one Opus frame lasting ms milliseconds.
+// out holds one slice per output channel at the internal rate; a stereo frame
+// decoded into a single slice keeps only the mid channel.
+func (d *silkDecoder) decode(rd *rangeDecoder, out [][]float32, stereo bool, ms int, bw Bandwidth) {
+	frames := 1
+	if ms > 20 {
+		frames = ms / 20
+	}
+	frameMs := ms
+	if frameMs > 20 {
+		frameMs = 20
+	}
+	frameLen := silkSubframeLen(bw) * frameMs / 5
+
+	midVAD, midLBRR := silkHeaderBits(rd, frames)
+	if !stereo {
+		midFlags := silkLBRRFlags(rd, frames, midLBRR)
+		skipLBRR(rd, midFlags, nil, frameMs, bw)
+		for i := 0; i < frames; i++ {
+			d.mid.decodeFrame(rd, out[0][i*frameLen:(i+1)*frameLen], midVAD[i], frameMs, bw, i == 0, false)
+		}
+		d.delayMid(out[0][:frames*frameLen])
+		d.prevSide = 0
+		d.wasStereo = false
+		return
+	}
+
+	sideVAD, sideLBRR := silkHeaderBits(rd, frames)
+	midFlags := silkLBRRFlags(rd, frames, midLBRR)
+	sideFlags := silkLBRRFlags(rd, frames, sideLBRR)
+	skipLBRR(rd, midFlags, sideFlags, frameMs, bw)
+
+	if !d.wasStereo {
+		d.prevWeights = [2]int32{}
+		d.prevSide = 0
+		d.side = newSilkChannel()
+		d.side.resetPrediction()
+		d.prevMidOnly = false
+	}
+	mid := make([]float32, frameLen)
+	side := make([]float32, frameLen)
+	firstSide := true
+	for i := 0; i < frames; i++ {
+		w0, w1 := decodeStereoWeights(rd)
+		midOnly := !sideVAD[i] && rd.icdf(silkMidOnlyICDF, 8) == 1
+		if !midOnly && d.prevMidOnly {
+			d.side.resetPrediction()
+		}
+		d.mid.decodeFrame(rd, mid, midVAD[i], frameMs, bw, i == 0, false)
+		if !midOnly {
+			d.side.decodeFrame(rd, side, sideVAD[i], frameMs, bw, firstSide, d.prevMidOnly)
+			firstSide = false
+		} else {
+			for j := range side {
+				side[j] = 0
+			}
+		}
+		if len(out) == 2 {
+			d.stereoUnmix(mid, side, out[0][i*frameLen:(i+1)*frameLen], out[1][i*frameLen:(i+1)*frameLen], w0, w1, bw)
+		} else {
+			copy(out[0][i*frameLen:(i+1)*frameLen], mid)
+		}
+		d.prevMidOnly = midOnly
+	}
+	if len(out) == 1 {
+		d.delayMid(out[0][:frames*frameLen])
+		d.wasStereo = true
+	}
+}
+
+func silkHeaderBits(rd *rangeDecoder, frames int) (vad []bool, lbrr bool) {
+	vad = make([]bool, frames)
+	for i := range vad {
+		vad[i] = rd.bit(1)
+	}
+	return vad, rd.bit(1)
+}
+
+func silkLBRRFlags(rd *rangeDecoder, frames int, present bool) []bool {
+	flags := make([]bool, frames)
+	if !present {
+		return flags
+	}
+	if frames == 1 {
+		flags[0] = true
+		return flags
+	}
+	sym := rd.icdf(silkLBRRFlagsICDF[frames-2], 8) + 1 // no frame coded is not a valid symbol
+	for i := range flags {
+		flags[i] = sym&(1<<i) != 0
+	}
+	return flags
+}
+
+// skipLBRR decodes and discards the low-bitrate redundancy frames, which are
+// only useful for concealing a lost previous packet.
+func skipLBRR(rd *rangeDecoder, midFlags, sideFlags []bool, frameMs int, bw Bandwidth) {
+	coded := false
+	for i := range midFlags {
+		coded = coded || midFlags[i] || (sideFlags != nil && sideFlags[i])
+	}
+	if !coded {
+		return
+	}
+	discard := newSilkDecoder()
+	scratch := make([]float32, silkSubframeLen(bw)*frameMs/5)
+	prevMid, prevSide := false, false
+	for i := range midFlags {
+		midCoded := midFlags[i]
+		sideCoded := sideFlags != nil && sideFlags[i]
+		if midCoded && sideFlags != nil {
+			decodeStereoWeights(rd)
+			if !sideCoded {
+				rd.icdf(silkMidOnlyICDF, 8)
+			}
+		}
+		if midCoded {
+			discard.mid.decodeFrame(rd, scratch, true, frameMs, bw, i == 0 || !prevMid, false)
+		}
+		if sideCoded {
+			discard.side.decodeFrame(rd, scratch, true, frameMs, bw, i == 0 || !prevSide, false)
+		}
+		prevMid, prevSide = midCoded, sideCoded
+	}
+}
+
+// decodeStereoWeights decodes the Q13 mid-to-side prediction weights
+// (RFC 6716 section 4.2.7.1).
+func decodeStereoWeights(rd *rangeDecoder) (w0, w1 int32) {
+	n := int32(rd.icdf(silkStereoStage1ICDF, 8))
+	i0 := int32(rd.icdf(silkStereoStage2ICDF, 8))
+	i1 := int32(rd.icdf(silkStereoStage3ICDF, 8))
+	i2 := int32(rd.icdf(silkStereoStage2ICDF, 8))
+	i3 := int32(rd.icdf(silkStereoStage3ICDF, 8))
+	w := silkStereoWeights
+	wi0 := i0 + 3*(n/5)
+	wi1 := i2 + 3*(n%5)
+	w1 = w[wi1] + (((w[wi1+1]-w[wi1])*6554)>>16)*(2*i3+1)
+	w0 = w[wi0] + (((w[wi0+1]-w[wi0])*6554)>>16)*(2*i1+1) - w1
+	return w0, w1
+}
+
+// delayMid delays the mid channel by one sample, as stereo unmixing does, so
+// that switching between mono and stereo is seamless.
+func (d *silkDecoder) delayMid(out []float32) {
+	if len(out) == 0 {
+		return
+	}
+	prev := d.prevMid[1]
+	if len(out) == 1 {
+		d.prevMid[0] = d.prevMid[1]
+		d.prevMid[1] = out[0]
+	} else {
+		d.prevMid[0] = out[len(out)-2]
+		d.prevMid[1] = out[len(out)-1]
+	}
+	for i := range out {
+		out[i], prev = prev, out[i]
+	}
+}
+
+// stereoUnmix converts mid and side into left and right (RFC 6716 section
+// 4.2.8).
+func (d *silkDecoder) stereoUnmix(mid, side, left, right []float32, w0Q13, w1Q13 int32, bw Bandwidth) {
+	phase1 := []int{64, 96, 128}[bw]
+	pw0, pw1 := d.prevWeights[0], d.prevWeights[1]
+	mid2, mid1 := d.prevMid[0], d.prevMid[1]
+	sidePrev := d.prevSide
+	for i := range mid {
+		k := i
+		if k > phase1 {
+			k = phase1
+		}
+		w0 := float32(pw0)/8192.0 + float32(k)*float32(w0Q13-pw0)/(8192.0*float32(phase1))
+		w1 := float32(pw1)/8192.0 + float32(k)*float32(w1Q13-pw1)/(8192.0*float32(phase1))
+		p0 := (mid2 + 2*mid1 + mid[i]) / 4.0
+		left[i] = clampUnit((1+w1)*mid1 + sidePrev + w0*p0)
+		right[i] = clampUnit((1-w1)*mid1 - sidePrev - w0*p0)
+		mid2 = mid1
+		mid1 = mid[i]
+		sidePrev = side[i]
+	}
+	d.prevWeights = [2]int32{w0Q13, w1Q13}
+	d.prevMid = [2]float32{mid2, mid1}
+	d.prevSide = sidePrev
+	d.wasStereo = true
+}
+
+func silkSubframeLen(bw Bandwidth) int {
+	return []int{40, 60, 80}[bw]
+}
+
+// decodeFrame decodes one 10 or 20 ms SILK frame into out (RFC 6716 section
+// 4.2.7).
+func (c *silkChannel) decodeFrame(rd *rangeDecoder, out []float32, vad bool, ms int, bw Bandwidth, first, skipLTPScale bool) {
+	if c.bandwidth != bw {
+		if c.bandwidth >= 0 {
+			c.resetPrediction()
+		}
+		c.bandwidth = bw
+	}
+	wb := 0
+	if bw == Wideband {
+		wb = 1
+	}
+	subframes := ms / 5
+
+	// Frame type.
+	var signalType, qOffset int
+	if vad {
+		sym := rd.icdf(silkTypeActiveICDF, 8)
+		signalType, qOffset = 1+sym>>1, sym&1
+	} else {
+		qOffset = rd.icdf(silkTypeInactiveICDF, 8)
+	}
+	voiced := signalType == silkVoiced
+
+	// Subframe gains.
+	gains := make([]float32, subframes)
+	for s := range gains {
+		var logGain int32
+		if s == 0 && (first || !c.haveDecoded) {
+			idx := int32(rd.icdf(silkGainMSBICDF[signalType], 8))<<3 | int32(rd.icdf(silkGainLSBICDF, 8))
+			logGain = idx
+			if c.haveDecoded && c.prevLogGain-16 > idx {
+				logGain = c.prevLogGain - 16
+			}
+		} else {
+			delta := int32(rd.icdf(silkGainDeltaICDF, 8))
+			logGain = 2*delta - 16
+			if c.prevLogGain+delta-4 > logGain {
+				logGain = c.prevLogGain + delta - 4
+			}
+			logGain = clampInt32(0, logGain, 63)
+		}
+		c.prevLogGain = logGain
+		inLogQ7 := (0x1D1C71 * logGain >> 16) + 2090
+		i := inLogQ7 >> 7
+		f := inLogQ7 & 127
+		gains[s] = float32((1 << i) + ((-174*f*(128-f)>>16)+f)*((1<<i)>>7))
+	}
+
+	// Normalized line spectral frequencies.
+	voicedIdx := 0
+	if voiced {
+		voicedIdx = 1
+	}
+	i1 := rd.icdf(silkLSFStage1ICDF[wb][voicedIdx], 8)
+	dLPC := len(silkLSFStage2Select[wb][i1])
+	i2 := make([]int, dLPC)
+	for k := range i2 {
+		i2[k] = rd.icdf(silkLSFStage2ICDF[silkLSFStage2Select[wb][i1][k]], 8) - 4
+		if i2[k] == -4 {
+			i2[k] -= rd.icdf(silkLSFExtICDF, 8)
+		} else if i2[k] == 4 {
+			i2[k] += rd.icdf(silkLSFExtICDF, 8)
+		}
+	}
+	qstep := 11796
+	if wb == 1 {
+		qstep = 9830
+	}
+	resQ10 := make([]int16, dLPC)
+	for k := dLPC - 1; k >= 0; k-- {
+		first := 0
+		if k+1 < dLPC {
+			pred := int(silkLSFPredWeight[wb][silkLSFPredSelect[wb][i1][k]][k])
+			first = (int(resQ10[k+1]) * pred) >> 8
+		}
+		second := (((i2[k] << 10) - signInt(i2[k])*102) * qstep) >> 16
+		resQ10[k] = int16(first + second)
+	}
+	cb := silkLSFStage1[wb][i1]
+	nlsf := make([]int16, dLPC)
+	for k := range nlsf {
+		prev, next := 0, 256
+		if k != 0 {
+			prev = int(cb[k-1])
+		}
+		if k+1 != dLPC {
+			next = int(cb[k+1])
+		}
+		w2 := (1024/(int(cb[k])-prev) + 1024/(next-int(cb[k]))) << 16
+		i := bits.Len(uint(w2))
+		f := (w2 >> (i - 8)) & 127
+		y := 46214
+		if i&1 != 0 {
+			y = 32768
+		}
+		y >>= (32 - i) >> 1
+		wQ9 := int16(y + ((213 * f * y) >> 16))
+		nlsf[k] = int16(clampInt32(0, int32((int(cb[k])<<7)+(int(resQ10[k])<<14)/int(wQ9)), 32767))
+	}
+	stabilizeNLSF(nlsf, silkLSFMinSpacing[wb])
+
+	wQ2 := int16(4)
+	var n1 []int16
+	if ms == 20 {
+		wQ2 = int16(rd.icdf(silkLSFInterpICDF, 8))
+		if wQ2 != 4 && c.haveDecoded && len(c.n0Q15) == dLPC {
+			n1 = make([]int16, dLPC)
+			for k := range n1 {
+				n1[k] = int16(int32(c.n0Q15[k]) + int32(wQ2)*(int32(nlsf[k])-int32(c.n0Q15[k]))>>2)
+			}
+		}
+	}
+	var aQ12 [][]float32
+	if n1 != nil {
+		aQ12 = append(aQ12, nlsfToLPC(n1, wb))
+	}
+	aQ12 = append(aQ12, nlsfToLPC(nlsf, wb))
+
+	// Long-term prediction parameters.
+	var lags []int
+	var lagMax int
+	var bQ7 [][]int8
+	ltpScale := float32(15565.0)
+	if voiced {
+		scale, lagMin := []int{4, 6, 8}[bw], []int{16, 24, 32}[bw]
+		lagMax = []int{144, 216, 288}[bw]
+		var lag int
+		absolute := first || !c.prevVoiced
+		if !absolute {
+			if delta := rd.icdf(silkPitchDeltaICDF, 8); delta != 0 {
+				lag = c.prevLag + delta - 9
+			} else {
+				absolute = true
+			}
+		}
+		if absolute {
+			high := rd.icdf(silkPitchHighICDF, 8)
+			lag = high*scale + rd.icdf(silkPitchLowICDF[bw], 8) + lagMin
+		}
+		c.prevLag = lag
+		contour := 0
+		if bw != Narrowband {
+			contour = 2
+		}
+		if ms == 20 {
+			contour++
+		}
+		cidx := rd.icdf(silkContourICDF[contour], 8)
+		lags = make([]int, subframes)
+		for i := range lags {
+			lags[i] = int(clampInt32(int32(lagMin), int32(lag+int(silkContour[contour][cidx][i])), int32(lagMax)))
+		}
+
+		periodicity := rd.icdf(silkPeriodicityICDF, 8)
+		bQ7 = make([][]int8, subframes)
+		for i := range bQ7 {
+			bQ7[i] = silkLTPFilter[periodicity][rd.icdf(silkLTPFilterICDF[periodicity], 8)]
+		}
+		if first && !skipLTPScale {
+			ltpScale = []float32{15565.0, 12288.0, 8192.0}[rd.icdf(silkLTPScaleICDF, 8)]
+		}
+	}
+
+	// Excitation.
+	seed := uint32(rd.icdf(silkSeedICDF, 8))
+	blocks := frameLenBlocks(bw, ms)
+	rate := rd.icdf(silkRateLevelICDF[voicedIdx], 8)
+	counts := make([]int, blocks)
+	lsbs := make([]int, blocks)
+	for i := range counts {
+		counts[i] = rd.icdf(silkPulseCountICDF[rate], 8)
+		for counts[i] == 17 && lsbs[i] < 10 {
+			counts[i] = rd.icdf(silkPulseCountICDF[9], 8)
+			lsbs[i]++
+		}
+		if lsbs[i] == 10 {
+			counts[i] = rd.icdf(silkPulseCountICDF[10], 8)
+		}
+	}
+	eRaw := make([]int32, blocks*16)
+	for i, n := range counts {
+		if n != 0 {
+			silkPulseSplit(rd, eRaw[16*i:16*(i+1)], n, 0)
+		}
+	}
+	for i := range eRaw {
+		for b := 0; b < lsbs[i/16]; b++ {
+			eRaw[i] = eRaw[i]<<1 | int32(rd.icdf(silkExcLSBICDF, 8))
+		}
+	}
+	for i := range eRaw {
+		if eRaw[i] == 0 {
+			continue
+		}
+		n := counts[i/16]
+		if n > 6 {
+			n = 6
+		}
+		if rd.icdf(silkSignICDF[signalType][qOffset][n], 8) == 0 {
+			eRaw[i] = -eRaw[i]
+		}
+	}
+	offset := int32([2][3]int{{25, 25, 8}, {60, 60, 25}}[qOffset][signalType])
+	res := make([]float32, len(eRaw))
+	for i, e := range eRaw {
+		eQ23 := (e << 8) - int32(signInt(int(e)))*20 + offset
+		seed = 196314165*seed + 907633515
+		if seed&0x80000000 != 0 {
+			eQ23 = -eQ23
+		}
+		seed += uint32(e)
+		res[i] = float32(eQ23) / 8388608.0
+	}
+
+	// Reconstruction.
+	n := silkSubframeLen(bw)
+	lpc := make([]float32, n*subframes)
+	resLag := make([]float32, lagMax+2)
+	for s := 0; s < subframes; s++ {
+		a := aQ12[0]
+		if s > 1 && len(aQ12) > 1 {
+			a = aQ12[1]
+		}
+		if voiced {
+			c.ltpSynthesis(out, bQ7, lags, n, n*s, s, dLPC, ltpScale, wQ2, a, gains, res, resLag)
+		}
+		c.lpcSynthesis(out[n*s:], n, s, dLPC, a, res, gains, lpc)
+	}
+
+	c.prevVoiced = voiced
+	c.n0Q15 = nlsf
+	if len(out) >= len(c.finalOut) {
+		copy(c.finalOut[:], out[len(out)-len(c.finalOut):])
+	} else {
+		copy(c.finalOut[:], c.finalOut[len(out):])
+		copy(c.finalOut[len(c.finalOut)-len(out):], out)
+	}
+	c.haveDecoded = true
+}
+
+// frameLenBlocks returns the number of 16-sample shell blocks in a frame.
+func frameLenBlocks(bw Bandwidth, ms int) int {
+	n := silkSubframeLen(bw) * ms / 5 / 16
+	if bw == Mediumband && ms == 10 {
+		n++ // 120 samples are coded as 8 blocks
+	}
+	return n
+}
+
+// silkPulseSplit recursively splits a pulse count over a block of 16, 8, 4
+// and 2 samples.
+func silkPulseSplit(rd *rangeDecoder, out []int32, count, depth int) {
+	if count == 0 {
+		return
+	}
+	if len(out) == 1 {
+		out[0] = int32(count)
+		return
+	}
+	left := rd.icdf(silkPulseSplitICDF[depth][count-1], 8)
+	half := len(out) / 2
+	silkPulseSplit(rd, out[:half], left, depth+1)
+	silkPulseSplit(rd, out[half:], count-left, depth+1)
+}
+
+// stabilizeNLSF enforces the minimum spacing between the NLSFs (RFC 6716
+// section 4.2.7.5.4).
+func stabilizeNLSF(nlsf []int16, minSpacing []int32) {
+	d := len(nlsf)
+	for round := 0; round < 20; round++ {
+		i, least := 0, math.MaxInt32
+		for k := 0; k <= d; k++ {
+			prev, cur := 0, 32768
+			if k != 0 {
+				prev = int(nlsf[k-1])
+			}
+			if k != d {
+				cur = int(nlsf[k])
+			}
+			if v := cur - prev - int(minSpacing[k]); v < least {
+				i, least = k, v
+			}
+		}
+		switch {
+		case least >= 0:
+			return
+		case i == 0:
+			nlsf[0] = int16(minSpacing[0])
+			continue
+		case i == d:
+			nlsf[d-1] = int16(32768 - minSpacing[d])
+			continue
+		}
+		minCenter := minSpacing[i] >> 1
+		for k := 0; k < i; k++ {
+			minCenter += minSpacing[k]
+		}
+		maxCenter := 32768 - minSpacing[i]>>1
+		for k := i + 1; k <= d; k++ {
+			maxCenter -= minSpacing[k]
+		}
+		center := clampInt32(minCenter, (int32(nlsf[i-1])+int32(nlsf[i])+1)>>1, maxCenter)
+		nlsf[i-1] = int16(center - minSpacing[i]>>1)
+		nlsf[i] = nlsf[i-1] + int16(minSpacing[i])
+	}
+
+	sort.Slice(nlsf, func(a, b int) bool { return nlsf[a] < nlsf[b] })
+	for k := 0; k < d; k++ {
+		prev := int32(0)
+		if k != 0 {
+			prev = int32(nlsf[k-1])
+		}
+		if v := int16(clampInt32(math.MinInt16, prev+minSpacing[k], math.MaxInt16)); v > nlsf[k] {
+			nlsf[k] = v
+		}
+	}
+	for k := d - 1; k >= 0; k-- {
+		next := int32(32768)
+		if k != d-1 {
+			next = int32(nlsf[k+1])
+		}
+		if v := int16(next - minSpacing[k+1]); v < nlsf[k] {
+			nlsf[k] = v
+		}
+	}
+}
+
+// nlsfToLPC converts NLSFs to Q12 LPC coefficients and limits their range and
+// prediction gain (RFC 6716 sections 4.2.7.5.6 to 4.2.7.5.8).
+func nlsfToLPC(nlsf []int16, wb int) []float32 {
+	d := len(nlsf)
+	ordering := silkLSFOrdering[wb]
+	cQ17 := make([]int32, d)
+	for k, n := range nlsf {
+		i := int32(n >> 8)
+		f := int32(n & 255)
+		cQ17[ordering[k]] = (silkCosQ12[i]*256 + (silkCosQ12[i+1]-silkCosQ12[i])*f + 4) >> 3
+	}
+	d2 := d / 2
+	p := make([]int32, d2+1)
+	q := make([]int32, d2+1)
+	p[0], q[0] = 1<<16, 1<<16
+	p[1], q[1] = -cQ17[0], -cQ17[1]
+	mul := func(c, v int32) int32 {
+		return int32((int64(c)*int64(v) + 32768) >> 16)
+	}
+	for k := 1; k < d2; k++ {
+		p[k+1] = p[k-1]*2 - mul(cQ17[2*k], p[k])
+		q[k+1] = q[k-1]*2 - mul(cQ17[2*k+1], q[k])
+		for j := k; j > 1; j-- {
+			p[j] += p[j-2] - mul(cQ17[2*k], p[j-1])
+			q[j] += q[j-2] - mul(cQ17[2*k+1], q[j-1])
+		}
+		p[1] -= cQ17[2*k]
+		q[1] -= cQ17[2*k+1]
+	}
+	a := make([]int32, d)
+	for k := 0; k < d2; k++ {
+		a[k] = -(q[k+1] - q[k]) - (p[k+1] + p[k])
+		a[d-k-1] = (q[k+1] - q[k]) - (p[k+1] + p[k])
+	}
+
+	round := 0
+	for ; round < 10; round++ {
+		maxK, maxAbs := 0, int64(0)
+		for k, v := range a {
+			if abs := int64(absInt32(v)); abs > maxAbs {
+				maxK, maxAbs = k, abs
+			}
+		}
+		maxQ12 := (maxAbs + 16) >> 5
+		if maxQ12 > 163838 {
+			maxQ12 = 163838
+		}
+		if maxQ12 <= 32767 {
+			break
+		}
+		sc := 65470 - ((maxQ12-32767)<<14)/((maxQ12*int64(maxK+1))>>2)
+		bandwidthExpand(a, int32(sc))
+	}
+	if round == 10 {
+		for k := range a {
+			a[k] = clampInt32(-32768, (a[k]+16)>>5, 32767) << 5
+		}
+	}
+
+	aQ12 := make([]int16, d)
+	toQ12 := func() {
+		for k := range a {
+			aQ12[k] = int16((a[k] + 16) >> 5)
+		}
+	}
+	toQ12()
+	for i := 0; i < 16; i++ {
+		if inversePredictionGain(aQ12) >= 107374 {
+			break
+		}
+		bandwidthExpand(a, int32(65536-(2<<i)))
+		toQ12()
+	}
+	out := make([]float32, d)
+	for k, v := range aQ12 {
+		out[k] = float32(v)
+	}
+	return out
+}
+
+func bandwidthExpand(a []int32, chirpQ16 int32) {
+	initial := chirpQ16
+	for i := range a {
+		a[i] = int32((int64(a[i]) * int64(chirpQ16)) >> 16)
+		if i+1 < len(a) {
+			chirpQ16 = int32((int64(initial)*int64(chirpQ16) + 32768) >> 16)
+		}
+	}
+}
+
+// inversePredictionGain returns the Q30 inverse prediction gain of an LPC
+// filter, or 0 if it is unstable.
+func inversePredictionGain(aQ12 []int16) int32 {
+	const qa, limit = 24, 16773022
+	order := len(aQ12)
+	var tmp [2][16]int32
+	aNew := tmp[order&1][:]
+	dc := int32(0)
+	for k, v := range aQ12 {
+		dc += int32(v)
+		aNew[k] = int32(v) << (qa - 12)
+	}
+	if dc >= 4096 {
+		return 0
+	}
+	invGain := int32(1 << 30)
+	for k := order - 1; k > 0; k-- {
+		if aNew[k] > limit || aNew[k] < -limit {
+			return 0
+		}
+		rc := -(aNew[k] << (31 - qa))
+		mult1 := int32(1<<30) - smmul(rc, rc)
+		mult2Q := 32 - bits.LeadingZeros32(uint32(absInt32(mult1)))
+		mult2 := inverse32VarQ(mult1, mult2Q+30)
+		invGain = smmul(invGain, mult1) << 2
+		aOld := aNew
+		aNew = tmp[k&1][:]
+		for n := 0; n < k; n++ {
+			t := satSub32(aOld[n], int32(rshiftRound64(int64(aOld[k-n-1])*int64(rc), 31)))
+			t64 := rshiftRound64(int64(t)*int64(mult2), mult2Q)
+			if t64 > math.MaxInt32 || t64 < math.MinInt32 {
+				return 0
+			}
+			aNew[n] = int32(t64)
+		}
+	}
+	if aNew[0] > limit || aNew[0] < -limit {
+		return 0
+	}
+	rc := -(aNew[0] << (31 - qa))
+	mult1 := int32(1<<30) - smmul(rc, rc)
+	return smmul(invGain, mult1) << 2
+}
+
+// ltpSynthesis runs the long-term prediction filter of a voiced subframe
+// (RFC 6716 section 4.2.7.9.1).
+func (c *silkChannel) ltpSynthesis(out []float32, bQ7 [][]int8, lags []int, n, j, s, dLPC int,
+	ltpScale float32, wQ2 int16, aQ12, gains, res, resLag []float32) {
+	var outEnd int
+	if s < 2 || wQ2 == 4 {
+		outEnd = -s * n
+	} else {
+		outEnd = -(s - 2) * n
+		ltpScale = 16384.0
+	}
+	hist := c.finalOut[:]
+	for i := -lags[s] - 2; i < outEnd; i++ {
+		index := i + j
+		var v float32
+		var resIndex int
+		toLag := false
+		switch {
+		case index >= len(res):
+			continue
+		case index >= 0:
+			v = out[index]
+			resIndex = index
+		default:
+			resIndex = len(resLag) + index
+			v = hist[len(hist)+index]
+			toLag = true
+		}
+		for k := 0; k < dLPC; k++ {
+			var o float32
+			if oi := index - k - 1; oi >= 0 {
+				o = out[oi]
+			} else {
+				o = hist[len(hist)+oi]
+			}
+			v -= o * (aQ12[k] / 4096.0)
+		}
+		v = clampUnit(v)
+		v *= (4.0 * ltpScale) / gains[s]
+		if toLag {
+			resLag[resIndex] = v
+		} else {
+			res[resIndex] = v
+		}
+	}
+	if s > 0 {
+		scaled := gains[s-1] / gains[s]
+		for i := outEnd; i < 0; i++ {
+			if index := j + i; index < 0 {
+				resLag[len(resLag)+index] *= scaled
+			} else {
+				res[index] *= scaled
+			}
+		}
+	}
+	for i := j; i < j+n; i++ {
+		sum := res[i]
+		for k := 0; k <= 4; k++ {
+			var v float32
+			if ri := i - lags[s] + 2 - k; ri < 0 {
+				v = resLag[len(resLag)+ri]
+			} else {
+				v = res[ri]
+			}
+			sum += v * (float32(bQ7[s][k]) / 128.0)
+		}
+		res[i] = sum
+	}
+}
+
+// lpcSynthesis runs the short-term prediction filter of a subframe (RFC 6716
+// section 4.2.7.9.2).
+func (c *silkChannel) lpcSynthesis(out []float32, n, s, dLPC int, aQ12, res, gains, lpc []float32) {
+	var norm, rev [16]float32
+	for k := 0; k < dLPC; k++ {
+		norm[k] = aQ12[k] / 4096.0
+	}
+	for k := 0; k < dLPC; k++ {
+		rev[k] = norm[dLPC-k-1]
+	}
+	gain := gains[s] / 65536.0
+	off := n * s
+	if s > 0 {
+		for i := 0; i < n; i++ {
+			v := gain * res[off+i]
+			hist := lpc[off-dLPC+i : off+i]
+			for k := 0; k < dLPC; k++ {
+				v += hist[k] * rev[k]
+			}
+			lpc[off+i] = v
+			out[i] = clampUnit(v)
+		}
+	} else {
+		for i := 0; i < n; i++ {
+			v := gain * res[i]
+			for k := 0; k < dLPC; k++ {
+				var l float32
+				if li := i - k - 1; li >= 0 {
+					l = lpc[li]
+				} else if pi := len(c.prevLPC) - 1 + (i - k); pi >= 0 {
+					l = c.prevLPC[pi]
+				}
+				v += l * norm[k]
+			}
+			lpc[i] = v
+			out[i] = clampUnit(v)
+		}
+	}
+	if len(out) == n {
+		c.prevLPC = append(c.prevLPC[:0], lpc[len(lpc)-dLPC:]...)
+	}
+}
+
+func clampInt32(lo, v, hi int32) int32 {
+	if v > hi {
+		return hi
+	}
+	if v < lo {
+		return lo
+	}
+	return v
+}
+
+func clampUnit(v float32) float32 {
+	if v <= -1 {
+		return -1
+	}
+	if v >= 1 {
+		return 1
+	}
+	return v
+}
+
+func signInt(x int) int {
+	switch {
+	case x < 0:
+		return -1
+	case x > 0:
+		return 1
+	}
+	return 0
+}
+
+func absInt32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func satSub32(a, b int32) int32 {
+	d := int64(a) - int64(b)
+	if d > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	if d < math.MinInt32 {
+		return math.MinInt32
+	}
+	return int32(d)
+}
+
+func rshiftRound64(a int64, shift int) int64 {
+	if shift == 1 {
+		return (a >> 1) + (a & 1)
+	}
+	return ((a >> (shift - 1)) + 1) >> 1
+}
+
+func rshiftRound32(a int32, shift int) int32 {
+	if shift == 1 {
+		return (a >> 1) + (a & 1)
+	}
+	return ((a >> (shift - 1)) + 1) >> 1
+}
+
+func smmul(a, b int32) int32 {
+	return int32((int64(a) * int64(b)) >> 32)
+}
+
+func smulwb(a, b int32) int32 {
+	return int32((int64(a) * int64(int16(b))) >> 16)
+}
+
+func smlaww(a, b, c int32) int32 {
+	return a + smulwb(b, c) + b*rshiftRound32(c, 16)
+}
+
+func inverse32VarQ(b32 int32, qRes int) int32 {
+	headroom := bits.LeadingZeros32(uint32(absInt32(b32))) - 1
+	nrm := b32 << headroom
+	inv := (math.MaxInt32 >> 2) / (nrm >> 16)
+	result := inv << 16
+	errQ32 := ((1 << 29) - smulwb(nrm, inv)) << 3
+	result = smlaww(result, errQ32, inv)
+	shift := 61 - headroom - qRes
+	if shift <= 0 {
+		v := int64(result) << -shift
+		if v > math.MaxInt32 {
+			return math.MaxInt32
+		}
+		if v < math.MinInt32 {
+			return math.MinInt32
+		}
+		return int32(v)
+	}
+	if shift < 32 {
+		return result >> shift
+	}
+	return 0
+}
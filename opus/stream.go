@@ -0,0 +1,282 @@
+package opus
+
+// modeNone is the previous mode of a stream that has not decoded a frame.
+const modeNone Mode = -1
+
+// stream decodes the frames of one elementary Opus stream. It runs the SILK
+// and CELT layers for the mode of each frame and handles the switches
+// between modes (RFC 6716 section 4.5).
+type stream struct {
+	channels int
+	silk     *silkDecoder
+	celt     *celtDecoder
+
+	// SILK output is resampled to 48 kHz by silkRS in SILK-only frames and
+	// by hybridRS in hybrid frames; a channel count of 0 means not set up.
+	silkRS     [2]resampler
+	silkRSBw   Bandwidth
+	silkRSCh   int
+	hybridRS   [2]resampler
+	hybridRSCh int
+
+	prevMode       Mode
+	prevRedundancy bool // the previous frame ended in a redundant CELT frame
+}
+
+func newStream(channels int) *stream {
+	return &stream{
+		channels: channels,
+		silk:     newSilkDecoder(),
+		celt:     newCeltDecoder(),
+		prevMode: modeNone,
+	}
+}
+
+func (s *stream) reset() {
+	*s = *newStream(s.channels)
+}
+
+// redundancy is the redundant CELT frame a SILK or hybrid frame may carry
+// at a mode switch (RFC 6716 section 4.5.1).
+type redundancy struct {
+	present    bool
+	celtToSilk bool
+	data       []byte
+	audio      [][]float32
+}
+
+func (s *stream) decodeFrame(toc TOC, frame []byte, out [][]float32) error {
+	mode := toc.Mode()
+	if len(frame) == 0 && mode != ModeCELT {
+		// DTX and lost SILK frames are not concealed: they stay silent.
+		return nil
+	}
+	s.switchMode(mode)
+	switch mode {
+	case ModeSILK:
+		s.decodeSILK(toc, frame, out)
+	case ModeHybrid:
+		s.decodeHybrid(toc, frame, out)
+	default:
+		var rd rangeDecoder
+		rd.init(frame)
+		s.celt.decode(&rd, frame, out, toc.Stereo(), 0, celtEndBand(toc.Bandwidth()))
+		s.prevMode = ModeCELT
+		s.prevRedundancy = false
+	}
+	return nil
+}
+
+// switchMode resets the layers that do not carry over into the new mode.
+func (s *stream) switchMode(mode Mode) {
+	if s.prevMode == mode {
+		return
+	}
+	switch mode {
+	case ModeSILK:
+		if s.prevMode == ModeCELT {
+			s.silk = newSilkDecoder()
+		}
+		if s.prevMode == ModeHybrid && s.hybridRSCh != 0 {
+			s.silkRS = s.hybridRS
+			s.silkRSBw = Wideband
+			s.silkRSCh = s.hybridRSCh
+		}
+	case ModeHybrid:
+		if s.prevMode == ModeCELT {
+			s.silk = newSilkDecoder()
+			s.hybridRS = [2]resampler{}
+			s.hybridRSCh = 0
+		}
+		if s.prevMode == ModeSILK && s.silkRSBw == Wideband && s.silkRSCh != 0 {
+			s.hybridRS = s.silkRS
+			s.hybridRSCh = s.silkRSCh
+		}
+	default:
+		if !s.prevRedundancy {
+			s.celt.reset()
+		}
+	}
+}
+
+func (s *stream) decodeSILK(toc TOC, frame []byte, out [][]float32) {
+	bw := toc.Bandwidth()
+	ms := toc.FrameSize() / 48
+	pcm := makeChannels(s.silkChannels(toc), silkRate(bw)/1000*ms)
+	var rd rangeDecoder
+	rd.init(frame)
+	s.silk.decode(&rd, pcm, toc.Stereo(), ms, bw)
+
+	var red redundancy
+	if rd.tell()+17 <= 8*len(frame) {
+		celtToSilk := rd.bit(1)
+		n := len(frame) - (rd.tell()+7)>>3
+		if size := len(frame) - n; n > 0 && size*8 >= rd.tell() {
+			rd.storage = size
+			red = redundancy{present: true, celtToSilk: celtToSilk, data: frame[size:]}
+		}
+	}
+	end := celtEndBand(bw)
+	if bw == Mediumband {
+		end = celtEndBand(Wideband)
+	}
+	if red.present {
+		if !red.celtToSilk {
+			s.celt.reset()
+		}
+		s.decodeRedundancy(&red, toc.Stereo(), len(pcm), end)
+	}
+	var transition [][]float32
+	if s.prevMode == ModeHybrid && (!red.present || !red.celtToSilk || !s.prevRedundancy) {
+		// the CELT layer of the previous hybrid frame fades out
+		transition = makeChannels(len(pcm), 120)
+		var trd rangeDecoder
+		celtEnd := []byte{0xFF, 0xFF}
+		trd.init(celtEnd)
+		s.celt.decode(&trd, celtEnd, transition, toc.Stereo(), 0, end)
+	}
+	s.prevMode = ModeSILK
+	s.prevRedundancy = red.present && !red.celtToSilk
+
+	if s.silkRSCh == 0 || s.silkRSBw != bw {
+		for i := range s.silkRS {
+			s.silkRS[i].init(silkRate(bw))
+		}
+		s.silkRSBw = bw
+		s.silkRSCh = len(pcm)
+	}
+	if len(pcm) == 2 && s.silkRSCh == 1 {
+		s.silkRS[1] = s.silkRS[0]
+	}
+	s.silkRSCh = len(pcm)
+	res := makeChannels(len(pcm), len(out[0]))
+	for ch := range pcm {
+		s.silkRS[ch].resample(pcm[ch], res[ch])
+		if transition != nil {
+			for i, v := range transition[ch] {
+				res[ch][i] += v
+			}
+		}
+		if red.present {
+			crossfade(res[ch], red.audio[ch], red.celtToSilk)
+		}
+	}
+	spreadChannels(out, res)
+}
+
+func (s *stream) decodeHybrid(toc TOC, frame []byte, out [][]float32) {
+	n := toc.FrameSize()
+	ms := n / 48
+	pcm := makeChannels(s.silkChannels(toc), 16*ms)
+	var rd rangeDecoder
+	rd.init(frame)
+	s.silk.decode(&rd, pcm, toc.Stereo(), ms, Wideband)
+
+	celtLen := len(frame)
+	var red redundancy
+	if rd.tell()+37 <= 8*len(frame) && rd.bit(12) {
+		celtToSilk := rd.bit(1)
+		size := len(frame) - int(rd.uint(256)) - 2
+		if size >= 0 && size*8 >= rd.tell() {
+			rd.storage = size
+			celtLen = size
+			red = redundancy{present: true, celtToSilk: celtToSilk, data: frame[size:]}
+		}
+	}
+	end := celtEndBand(toc.Bandwidth())
+	if red.present && red.celtToSilk {
+		s.decodeRedundancy(&red, toc.Stereo(), len(out), end)
+	}
+	if s.prevMode != ModeHybrid && s.prevMode != modeNone && !s.prevRedundancy {
+		s.celt.reset()
+	}
+	s.celt.decode(&rd, frame[:celtLen], out, toc.Stereo(), 17, end)
+
+	if s.hybridRSCh == 0 {
+		for i := range s.hybridRS {
+			s.hybridRS[i].init(16000)
+		}
+	}
+	if len(pcm) == 2 && s.hybridRSCh == 1 {
+		s.hybridRS[1] = s.hybridRS[0]
+	}
+	s.hybridRSCh = len(pcm)
+	res := makeChannels(len(pcm), n)
+	for ch := range pcm {
+		s.hybridRS[ch].resample(pcm[ch], res[ch])
+	}
+	for ch := range out {
+		for i, v := range res[ch%len(res)] {
+			out[ch][i] += v
+		}
+	}
+
+	if red.present && !red.celtToSilk {
+		s.celt.reset()
+		s.decodeRedundancy(&red, toc.Stereo(), len(out), end)
+	}
+	if red.present {
+		for ch := range out {
+			crossfade(out[ch], red.audio[ch], red.celtToSilk)
+		}
+	}
+	s.prevMode = ModeHybrid
+	s.prevRedundancy = red.present && !red.celtToSilk
+}
+
+// silkChannels returns the number of channels the SILK layer decodes.
+func (s *stream) silkChannels(toc TOC) int {
+	if toc.Stereo() && s.channels == 2 {
+		return 2
+	}
+	return 1
+}
+
+// decodeRedundancy decodes the 5 ms redundant CELT frame.
+func (s *stream) decodeRedundancy(red *redundancy, stereo bool, channels, end int) {
+	red.audio = makeChannels(channels, 240)
+	var rd rangeDecoder
+	rd.init(red.data)
+	s.celt.decode(&rd, red.data, red.audio, stereo, 0, end)
+}
+
+// crossfade blends the redundant CELT frame red into out over 2.5 ms: at
+// the start of out when switching from CELT, at its end otherwise.
+func crossfade(out, red []float32, celtToSilk bool) {
+	if celtToSilk {
+		copy(out[:120], red[:120])
+		smoothFade(red[120:], out[120:], out[120:])
+		return
+	}
+	tail := out[len(out)-120:]
+	smoothFade(tail, red[120:], tail)
+}
+
+// smoothFade fades from in1 to in2 over 120 samples with the square of the
+// CELT window.
+func smoothFade(in1, in2, out []float32) {
+	for i := 0; i < 120; i++ {
+		w := celtWindow[i] * celtWindow[i]
+		out[i] = w*in2[i] + (1-w)*in1[i]
+	}
+}
+
+// celtEndBand returns the end of the CELT band range for a bandwidth.
+func celtEndBand(bw Bandwidth) int {
+	return [...]int{13, 17, 17, 19, 21}[bw]
+}
+
+func makeChannels(channels, n int) [][]float32 {
+	out := make([][]float32, channels)
+	for ch := range out {
+		out[ch] = make([]float32, n)
+	}
+	return out
+}
+
+// spreadChannels copies in to out, duplicating a mono signal into stereo.
+func spreadChannels(out, in [][]float32) {
+	for ch := range out {
+		copy(out[ch], in[ch%len(in)])
+	}
+}
@@ -0,0 +1,88 @@
+package opus
+
+// Mode is the coding mode of an Opus frame.
+type Mode int
+
+const (
+	ModeSILK Mode = iota
+	ModeHybrid
+	ModeCELT
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeSILK:
+		return "SILK"
+	case ModeHybrid:
+		return "Hybrid"
+	}
+	return "CELT"
+}
+
+// Bandwidth is the audio bandwidth of an Opus frame.
+type Bandwidth int
+
+const (
+	Narrowband    Bandwidth = iota // 4 kHz
+	Mediumband                     // 6 kHz
+	Wideband                       // 8 kHz
+	SuperWideband                  // 12 kHz
+	Fullband                       // 20 kHz
+)
+
+func (b Bandwidth) String() string {
+	return [...]string{"NB", "MB", "WB", "SWB", "FB"}[b]
+}
+
+// TOC is the table-of-contents byte that starts every Opus packet.
+type TOC byte
+
+// Config returns the 5-bit configuration number.
+func (t TOC) Config() int {
+	return int(t >> 3)
+}
+
+func (t TOC) Mode() Mode {
+	switch c := t.Config(); {
+	case c < 12:
+		return ModeSILK
+	case c < 16:
+		return ModeHybrid
+	}
+	return ModeCELT
+}
+
+func (t TOC) Bandwidth() Bandwidth {
+	switch c := t.Config(); {
+	case c < 12:
+		return Bandwidth(c / 4)
+	case c < 16:
+		return SuperWideband + Bandwidth((c-12)/2)
+	case c < 20:
+		return Narrowband
+	}
+	return Wideband + Bandwidth((t.Config()-20)/4)
+}
+
+// FrameSize returns the number of samples per frame at 48 kHz.
+func (t TOC) FrameSize() int {
+	c := t.Config()
+	switch {
+	case c < 12:
+		return [4]int{480, 960, 1920, 2880}[c%4]
+	case c < 16:
+		return [2]int{480, 960}[c%2]
+	}
+	return [4]int{120, 240, 480, 960}[c%4]
+}
+
+// Stereo reports whether the frames are coded in stereo.
+func (t TOC) Stereo() bool {
+	return t&0x04 != 0
+}
+
+// FrameCountCode returns the code in the low two bits that tells how the
+// frames are laid out in the packet.
+func (t TOC) FrameCountCode() int {
+	return int(t & 0x03)
+}
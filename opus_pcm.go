@@ -0,0 +1,51 @@
+package oggmeta
+
+import (
+	"bytes"
+
+	"github.com/gcottom/oggmeta/opus"
+)
+
+// opusPCM adapts opus.Decoder to pcmDecoder and cuts the end of the stream
+// at the final granule position.
+type opusPCM struct {
+	dec      *opus.Decoder
+	tagsRead bool
+	position int64 // granule of the last sample returned, pre-skip included
+}
+
+func newOpusPCM(head []byte) (pcmDecoder, error) {
+	dec, err := opus.NewDecoder(head)
+	if err != nil {
+		return nil, err
+	}
+	return &opusPCM{dec: dec, position: int64(dec.Head.PreSkip)}, nil
+}
+
+func (o *opusPCM) sampleRate() int {
+	return opus.SampleRate
+}
+
+func (o *opusPCM) channels() int {
+	return o.dec.Head.Channels
+}
+
+func (o *opusPCM) decode(packet *Packet) ([][]float32, error) {
+	if !o.tagsRead {
+		o.tagsRead = true
+		if bytes.HasPrefix(packet.Data, OpusPrefix) {
+			return nil, nil
+		}
+	}
+	pcm, err := o.dec.Decode(packet.Data)
+	if err != nil {
+		return nil, err
+	}
+	if packet.EOS && packet.Granule >= 0 {
+		if keep := packet.Granule - o.position; keep >= 0 && keep < int64(pcmLen(pcm)) {
+			pcm = trimPCM(pcm, 0, int(keep))
+		}
+	}
+	o.position += int64(pcmLen(pcm))
+	return pcm, nil
+}
@@ -0,0 +1,113 @@
+package oggmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// OpusHead is the identification header of an Ogg Opus stream.
+type OpusHead struct {
+	Version         uint8
+	Channels        uint8
+	PreSkip         uint16 // samples at 48 kHz to discard from the start of the decoded output
+	InputSampleRate uint32 // informational only, Opus always decodes at 48 kHz
+	OutputGain      int16  // Q7.8 dB
+	MappingFamily   uint8
+	StreamCount     uint8 // only used when MappingFamily is not 0
+	CoupledCount    uint8 // only used when MappingFamily is not 0
+	ChannelMapping  []byte
+}
+
+// GetOutputGain returns the output gain in dB.
+func (h *OpusHead) GetOutputGain() float64 {
+	return float64(h.OutputGain) / 256
+}
+
+// SetOutputGain sets the output gain in dB, rounded to the nearest 1/256 dB.
+func (h *OpusHead) SetOutputGain(db float64) error {
+	q := math.Round(db * 256)
+	if q < math.MinInt16 || q > math.MaxInt16 || math.IsNaN(q) {
+		return &ErrInvalidOpusHead{Reason: "output gain out of range"}
+	}
+	h.OutputGain = int16(q)
+	return nil
+}
+
+func (h *OpusHead) validate() error {
+	if h.Version>>4 != 0 {
+		return &ErrInvalidOpusHead{Reason: "unsupported version"}
+	}
+	if h.Channels == 0 {
+		return &ErrInvalidOpusHead{Reason: "channel count is zero"}
+	}
+	switch h.MappingFamily {
+	case 0:
+		if h.Channels > 2 {
+			return &ErrInvalidOpusHead{Reason: "mapping family 0 allows at most 2 channels"}
+		}
+		return nil
+	case 1:
+		if h.Channels > 8 {
+			return &ErrInvalidOpusHead{Reason: "mapping family 1 allows at most 8 channels"}
+		}
+	}
+	if h.StreamCount == 0 {
+		return &ErrInvalidOpusHead{Reason: "stream count is zero"}
+	}
+	if h.CoupledCount > h.StreamCount || int(h.StreamCount)+int(h.CoupledCount) > 255 {
+		return &ErrInvalidOpusHead{Reason: "invalid coupled stream count"}
+	}
+	if len(h.ChannelMapping) != int(h.Channels) {
+		return &ErrInvalidOpusHead{Reason: "channel mapping length does not match channel count"}
+	}
+	for _, m := range h.ChannelMapping {
+		if m != 255 && int(m) >= int(h.StreamCount)+int(h.CoupledCount) {
+			return &ErrInvalidOpusHead{Reason: "channel mapping refers to a missing stream"}
+		}
+	}
+	return nil
+}
+
+func parseOpusHead(data []byte) (*OpusHead, error) {
+	if !bytes.HasPrefix(data, OpusHeadPrefix) || len(data) < 19 {
+		return nil, &ErrInvalidOpusHead{Reason: "packet too short"}
+	}
+	h := &OpusHead{
+		Version:         data[8],
+		Channels:        data[9],
+		PreSkip:         binary.LittleEndian.Uint16(data[10:]),
+		InputSampleRate: binary.LittleEndian.Uint32(data[12:]),
+		OutputGain:      int16(binary.LittleEndian.Uint16(data[16:])),
+		MappingFamily:   data[18],
+	}
+	if h.MappingFamily != 0 {
+		if len(data) < 21+int(h.Channels) {
+			return nil, &ErrInvalidOpusHead{Reason: "channel mapping table truncated"}
+		}
+		h.StreamCount = data[19]
+		h.CoupledCount = data[20]
+		h.ChannelMapping = append([]byte{}, data[21:21+int(h.Channels)]...)
+	}
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *OpusHead) toBytesSlice() []byte {
+	b := new(bytes.Buffer)
+	b.Write(OpusHeadPrefix)
+	_ = binary.Write(b, binary.LittleEndian, h.Version)
+	_ = binary.Write(b, binary.LittleEndian, h.Channels)
+	_ = binary.Write(b, binary.LittleEndian, h.PreSkip)
+	_ = binary.Write(b, binary.LittleEndian, h.InputSampleRate)
+	_ = binary.Write(b, binary.LittleEndian, h.OutputGain)
+	_ = binary.Write(b, binary.LittleEndian, h.MappingFamily)
+	if h.MappingFamily != 0 {
+		_ = binary.Write(b, binary.LittleEndian, h.StreamCount)
+		_ = binary.Write(b, binary.LittleEndian, h.CoupledCount)
+		b.Write(h.ChannelMapping)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,83 @@
+package oggmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// verifyPages checks the CRC of every page in b and returns the page headers.
+func verifyPages(t *testing.T, b []byte) []OGGPageHeader {
+	var headers []OGGPageHeader
+	for off := 0; off < len(b); {
+		if !assert.True(t, bytes.HasPrefix(b[off:], Oggs[:])) {
+			return headers
+		}
+		segs := int(b[off+26])
+		size := HeaderSize + segs
+		for _, s := range b[off+HeaderSize : off+HeaderSize+segs] {
+			size += int(s)
+		}
+		page := append([]byte{}, b[off:off+size]...)
+		want := binary.LittleEndian.Uint32(page[22:])
+		binary.LittleEndian.PutUint32(page[22:], 0)
+		assert.Equal(t, want, calculateChecksum(page[:HeaderSize], page[HeaderSize+segs:], page[HeaderSize:HeaderSize+segs]))
+
+		var h OGGPageHeader
+		assert.NoError(t, binary.Read(bytes.NewReader(b[off:]), binary.LittleEndian, &h))
+		headers = append(headers, h)
+		off += size
+	}
+	return headers
+}
+
+func TestOpusHead(t *testing.T) {
+	b, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	tag, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	if !assert.NotNil(t, tag.OpusHead) {
+		return
+	}
+	assert.Equal(t, uint8(2), tag.OpusHead.Channels)
+	assert.Equal(t, uint8(0), tag.OpusHead.MappingFamily)
+	preSkip := tag.OpusHead.PreSkip
+	original := verifyPages(t, b)
+
+	assert.NoError(t, tag.OpusHead.SetOutputGain(-3.5))
+	tag.OpusHead.PreSkip = preSkip + 100
+	out := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, out))
+
+	saved := verifyPages(t, out.Bytes())
+	assert.Equal(t, len(original), len(saved))
+	for i := range saved {
+		assert.Equal(t, original[i].SerialNumber, saved[i].SerialNumber)
+		assert.Equal(t, original[i].GranulePosition, saved[i].GranulePosition)
+		assert.Equal(t, uint32(i), saved[i].PageSequenceNumber)
+	}
+
+	tag, err = ReadOGG(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, -3.5, tag.OpusHead.GetOutputGain())
+	assert.Equal(t, preSkip+100, tag.OpusHead.PreSkip)
+	assert.NotEmpty(t, tag.GetTitle())
+}
+
+func TestOpusHeadValidate(t *testing.T) {
+	h := &OpusHead{Version: 1, Channels: 6, MappingFamily: 1, StreamCount: 4, CoupledCount: 2, ChannelMapping: []byte{0, 4, 1, 2, 3, 5}}
+	assert.NoError(t, h.validate())
+	parsed, err := parseOpusHead(h.toBytesSlice())
+	assert.NoError(t, err)
+	assert.Equal(t, h, parsed)
+
+	h.ChannelMapping[1] = 6
+	assert.Error(t, h.validate())
+	h.ChannelMapping = h.ChannelMapping[:5]
+	assert.Error(t, h.validate())
+	assert.Error(t, (&OpusHead{Channels: 3}).validate())
+	assert.Error(t, (&OpusHead{Channels: 1}).SetOutputGain(200))
+}
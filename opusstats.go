@@ -0,0 +1,147 @@
+package oggmeta
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/gcottom/oggmeta/opus"
+)
+
+// OpusStats describes the audio packets of an Ogg Opus stream, taken from the
+// TOC byte of every packet.
+type OpusStats struct {
+	Packets      int
+	Samples      int64 // sum of all packet durations at 48 kHz, pre-skip included
+	PreSkip      int64
+	FinalGranule int64
+	StartGranule int64 // granule the stream would have before its first packet, 0 for a file that starts cleanly
+
+	ModeSamples      map[opus.Mode]int64      // samples coded in each mode
+	BandwidthSamples map[opus.Bandwidth]int64 // samples coded at each bandwidth
+	FrameSizes       map[int]int              // number of frames of each size in samples at 48 kHz
+
+	// GranuleMismatches lists pages whose granule position disagrees with the
+	// sample count of the packets before them. A final granule that trims
+	// less than one packet is end trimming and is not listed.
+	GranuleMismatches []GranuleMismatch
+}
+
+// GranuleMismatch is a page whose granule position is not the one its packets add up to.
+type GranuleMismatch struct {
+	Page     int64 // index of the page among the stream's pages that end a packet
+	Granule  int64
+	Expected int64
+}
+
+// PlayableSamples returns the number of samples a decoder outputs.
+func (s *OpusStats) PlayableSamples() int64 {
+	total := s.StartGranule + s.Samples
+	end := total
+	if trim := total - s.FinalGranule; trim >= 0 && trim < opus.MaxPacketDuration {
+		end = s.FinalGranule
+	}
+	playable := end - s.StartGranule - s.PreSkip
+	if playable < 0 {
+		return 0
+	}
+	return playable
+}
+
+// Duration returns PlayableSamples as a time.
+func (s *OpusStats) Duration() time.Duration {
+	return time.Duration(s.PlayableSamples()) * time.Second / opus.SampleRate
+}
+
+// MaxBandwidth returns the widest bandwidth any packet was coded at.
+func (s *OpusStats) MaxBandwidth() opus.Bandwidth {
+	max := opus.Narrowband
+	for b, n := range s.BandwidthSamples {
+		if n > 0 && b > max {
+			max = b
+		}
+	}
+	return max
+}
+
+// AnalyzeOpus walks the audio packets of the first Opus stream in r.
+func AnalyzeOpus(r io.ReadSeeker) (*OpusStats, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	packets := newPacketReader(&OGGDecoder{Reader: r}, 0, 0)
+	stats := &OpusStats{
+		ModeSamples:      make(map[opus.Mode]int64),
+		BandwidthSamples: make(map[opus.Bandwidth]int64),
+		FrameSizes:       make(map[int]int),
+	}
+	var serial uint32
+	found, tagsSeen, started := false, false, false
+	var pages int64
+	var lastGranule int64 = -1
+	for {
+		packet, err := packets.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			if packet.BOS && bytes.HasPrefix(packet.Data, OpusHeadPrefix) {
+				head, err := opus.ParseHead(packet.Data)
+				if err != nil {
+					return nil, err
+				}
+				found = true
+				serial = packet.Serial
+				stats.PreSkip = int64(head.PreSkip)
+			}
+			continue
+		}
+		if packet.Serial != serial {
+			continue
+		}
+		if !tagsSeen {
+			tagsSeen = true
+			continue
+		}
+
+		p, err := opus.ParsePacket(packet.Data)
+		if err != nil {
+			return nil, err
+		}
+		samples := int64(p.Samples())
+		stats.Packets++
+		stats.Samples += samples
+		stats.ModeSamples[p.TOC.Mode()] += samples
+		stats.BandwidthSamples[p.TOC.Bandwidth()] += samples
+		stats.FrameSizes[p.TOC.FrameSize()] += len(p.Frames)
+
+		if packet.Granule >= 0 {
+			if !started {
+				started = true
+				stats.StartGranule = packet.Granule - stats.Samples
+				if packet.EOS {
+					// a single page stream may trim its end below the packet total
+					stats.StartGranule = 0
+				}
+			}
+			expected := stats.StartGranule + stats.Samples
+			trimmed := packet.EOS && packet.Granule < expected && expected-packet.Granule < samples
+			if packet.Granule != expected && !trimmed {
+				stats.GranuleMismatches = append(stats.GranuleMismatches, GranuleMismatch{Page: pages, Granule: packet.Granule, Expected: expected})
+			}
+			lastGranule = packet.Granule
+			pages++
+		}
+		if packet.EOS {
+			break
+		}
+	}
+	if !found {
+		return nil, &ErrUnsupportedCodec{}
+	}
+	stats.FinalGranule = lastGranule
+	return stats, nil
+}
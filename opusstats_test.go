@@ -0,0 +1,75 @@
+package oggmeta
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/gcottom/oggmeta/opus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzeOpus(t *testing.T) {
+	f, err := os.Open("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	stats, err := AnalyzeOpus(f)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(164222), stats.FinalGranule)
+	assert.Equal(t, int64(0), stats.StartGranule)
+	assert.Empty(t, stats.GranuleMismatches)
+	assert.True(t, stats.Samples >= stats.FinalGranule)
+	assert.True(t, stats.Samples-stats.FinalGranule < opus.MaxPacketDuration)
+	assert.Equal(t, stats.FinalGranule-stats.PreSkip, stats.PlayableSamples())
+
+	var frames int64
+	for size, n := range stats.FrameSizes {
+		frames += int64(size * n)
+	}
+	assert.Equal(t, stats.Samples, frames)
+	var modes, bandwidths int64
+	for _, n := range stats.ModeSamples {
+		modes += n
+	}
+	for _, n := range stats.BandwidthSamples {
+		bandwidths += n
+	}
+	assert.Equal(t, stats.Samples, modes)
+	assert.Equal(t, stats.Samples, bandwidths)
+}
+
+func TestAnalyzeOpusGranuleMismatch(t *testing.T) {
+	// three 20 ms CELT fullband packets on separate pages, the second page
+	// claiming one packet too many
+	b := buildOpusFile(t, nil)
+	out := bytes.NewBuffer(b)
+	enc := &OGGEncoder{Writer: out, PageNumber: 2}
+	dec := &OGGDecoder{Reader: bytes.NewReader(b)}
+	head, err := dec.Decode()
+	assert.NoError(t, err)
+	enc.Serial = head.Header.SerialNumber
+	packet := []byte{0xfc, 0xff, 0xfe}
+	assert.NoError(t, enc.Encode(960, [][]byte{packet}))
+	assert.NoError(t, enc.Encode(2880, [][]byte{packet}))
+	assert.NoError(t, enc.EncodeEOS(2000, [][]byte{packet}))
+
+	stats, err := AnalyzeOpus(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, stats.Packets)
+	assert.Equal(t, int64(2880), stats.Samples)
+	assert.Equal(t, opus.Fullband, stats.MaxBandwidth())
+	assert.Equal(t, int64(2880), stats.ModeSamples[opus.ModeCELT])
+	assert.Equal(t, 3, stats.FrameSizes[960])
+	assert.Equal(t, []GranuleMismatch{{Page: 1, Granule: 2880, Expected: 1920}}, stats.GranuleMismatches)
+	assert.Equal(t, 2000-stats.PreSkip, stats.PlayableSamples())
+}
+
+func TestAnalyzeOpusNotOpus(t *testing.T) {
+	f, err := os.Open("./testdata/test1.ogg")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	_, err = AnalyzeOpus(f)
+	assert.IsType(t, &ErrUnsupportedCodec{}, err)
+}
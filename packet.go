@@ -0,0 +1,117 @@
+package oggmeta
+
+import "context"
+
+// Packet is a complete packet reassembled from one or more pages.
+type Packet struct {
+	Data    []byte
+	Serial  uint32
+	Granule int64 // granule of the page the packet ends on, -1 if another packet ends after it
+	BOS     bool
+	EOS     bool
+}
+
+// packetReader joins packets that span page boundaries, keeping a separate
+// partial packet for every logical stream so grouped files reassemble cleanly.
+type packetReader struct {
+	ctx      context.Context // nil when the read cannot be cancelled
+	dec      *OGGDecoder
+	maxSize  int64 // 0 means unlimited
+	maxPages int64 // 0 means unlimited
+	pages    int64
+	partial  map[uint32][]byte
+	ended    map[uint32]bool // streams whose EOS page has been read
+	queue    []*Packet
+}
+
+func newPacketReader(dec *OGGDecoder, maxSize, maxPages int64) *packetReader {
+	return &packetReader{
+		dec:      dec,
+		maxSize:  maxSize,
+		maxPages: maxPages,
+		partial:  make(map[uint32][]byte),
+		ended:    make(map[uint32]bool),
+	}
+}
+
+func (pr *packetReader) next() (*Packet, error) {
+	for len(pr.queue) == 0 {
+		if pr.ctx != nil {
+			if err := pr.ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if pr.maxPages > 0 && pr.pages >= pr.maxPages {
+			return nil, &ErrLimitExceeded{Limit: "page count", Value: pr.pages + 1, Max: pr.maxPages}
+		}
+		page, err := pr.dec.Decode()
+		if err != nil {
+			return nil, err
+		}
+		pr.pages++
+		if err := pr.push(page); err != nil {
+			return nil, err
+		}
+	}
+	packet := pr.queue[0]
+	pr.queue = pr.queue[1:]
+	return packet, nil
+}
+
+func (pr *packetReader) push(page *OGGPage) error {
+	serial := page.Header.SerialNumber
+	packets := page.Packets
+	if page.Header.Flags&FlagEOS != 0 {
+		pr.ended[serial] = true
+	}
+	partial, hasPartial := pr.partial[serial]
+	delete(pr.partial, serial)
+
+	if page.Header.Flags&FlagCOP != 0 && len(packets) > 0 {
+		if hasPartial {
+			if err := pr.checkSize(len(partial) + len(packets[0])); err != nil {
+				return err
+			}
+			packets[0] = append(partial, packets[0]...)
+		} else {
+			// continuation of a packet we never saw the start of
+			packets = packets[1:]
+			if len(packets) == 0 {
+				return nil
+			}
+		}
+	}
+
+	last := len(packets) - 1
+	if page.continues {
+		if err := pr.checkSize(len(packets[last])); err != nil {
+			return err
+		}
+		pr.partial[serial] = packets[last]
+		packets = packets[:last]
+		last--
+	}
+
+	for i, data := range packets {
+		if err := pr.checkSize(len(data)); err != nil {
+			return err
+		}
+		packet := &Packet{Data: data, Serial: serial, Granule: -1}
+		if i == last {
+			packet.Granule = page.Header.GranulePosition
+			packet.EOS = page.Header.Flags&FlagEOS != 0
+		}
+		if i == 0 && page.Header.Flags&FlagBOS != 0 {
+			packet.BOS = true
+		}
+		pr.queue = append(pr.queue, packet)
+	}
+	return nil
+}
+
+func (pr *packetReader) checkSize(n int) error {
+	if pr.maxSize > 0 {
+		return checkLimit("packet size", int64(n), pr.maxSize)
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+package oggmeta
+
+import (
+	"context"
+	"io"
+
+	"github.com/gcottom/oggmeta/vorbis"
+)
+
+// webmClusterLength is the longest stretch of audio RemuxToWebM puts in one
+// Cluster, in milliseconds.
+const webmClusterLength = 5000
+
+// webmSeekHeadSize is the room RemuxToWebM leaves for the SeekHead, which
+// lets SaveTags add entries later.
+const webmSeekHeadSize = 128
+
+// webmTagsPadding is the Void RemuxToWebM writes after the Tags so that
+// SaveTags can grow them in place.
+const webmTagsPadding = 256
+
+// RemuxToOgg copies the Opus or Vorbis track of a Matroska or WebM file to w
+// as an Ogg file, without re-encoding.
+func RemuxToOgg(r io.ReadSeeker, w io.Writer) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dec := &OGGDecoder{Reader: r}
+	tag, err := dec.readMatroska(context.Background())
+	if err != nil {
+		return err
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	er := &ebmlReader{r: r}
+	f, err := readMatroskaFile(context.Background(), er, DefaultLimits, false)
+	if err != nil {
+		return err
+	}
+	headers := [][]byte{f.track.private}
+	if tag.Codec == Vorbis {
+		if headers, err = xiphUnlace(f.track.private); err != nil {
+			return err
+		}
+	}
+	rate, samples, err := packetDurations(tag.Codec, headers)
+	if err != nil {
+		return err
+	}
+
+	pages := &packetPager{enc: &OGGEncoder{Writer: w, Serial: uint32(f.track.uid)}}
+	if err = pages.enc.EncodeBOS(0, headers[:1]); err != nil {
+		return err
+	}
+	rest := [][]byte{tagCommentPacket(tag)}
+	if tag.Codec == Vorbis {
+		rest = append(rest, headers[2])
+	}
+	if err = pages.enc.Encode(0, rest); err != nil {
+		return err
+	}
+	granule := int64(-1)
+	var discard int64
+	err = readMatroskaBlocks(er, f, DefaultLimits, func(b *matroskaBlock) error {
+		if granule < 0 {
+			granule = nanosecondSamples(b.time*f.scale, rate)
+			if granule < 0 {
+				granule = 0
+			}
+		}
+		for _, frame := range b.frames {
+			n, err := samples(frame)
+			if err != nil {
+				return err
+			}
+			granule += n
+			if err := pages.add(frame, granule); err != nil {
+				return err
+			}
+		}
+		discard = b.discard
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if granule < 0 {
+		granule = 0
+	}
+	if discard > 0 {
+		granule -= nanosecondSamples(discard, rate)
+	}
+	return pages.finish(granule)
+}
+
+// RemuxToWebM copies the Opus or Vorbis stream of an Ogg file to w as a WebM
+// file, without re-encoding.
+func RemuxToWebM(r io.ReadSeeker, w io.Writer) error {
+	info, err := readStreamInfo(r)
+	if err != nil {
+		return err
+	}
+	if info.codec != Opus && info.codec != Vorbis {
+		return &ErrUnsupportedCodec{}
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	tag, err := ReadOGG(r)
+	if err != nil {
+		return err
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	packets := newPacketReader(&OGGDecoder{Reader: r}, 0, 0)
+	ident, err := packets.next()
+	if err != nil {
+		return err
+	}
+	headers := [][]byte{ident.Data}
+	count := 2
+	if info.codec == Vorbis {
+		count = 3
+	}
+	for len(headers) < count {
+		packet, err := nextPacketOf(packets, ident.Serial)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		headers = append(headers, packet.Data)
+	}
+	rate, samples, err := packetDurations(info.codec, headers)
+	if err != nil {
+		return err
+	}
+
+	// the audio is read once for its length and then twice more to build
+	// the Clusters, first for the offsets the Cues need and then to write them
+	var frames int
+	var total, onFirstPage, final int64
+	first := int64(-1)
+	for {
+		packet, err := nextPacketOf(packets, ident.Serial)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		n, err := samples(packet.Data)
+		if err != nil {
+			return err
+		}
+		frames++
+		total += n
+		if first < 0 {
+			onFirstPage += n
+			if packet.Granule >= 0 {
+				first = packet.Granule
+			}
+		}
+		if packet.Granule >= 0 {
+			final = packet.Granule
+		}
+	}
+	start := first - onFirstPage
+	discard := start + total - final
+	if first < 0 || discard < 0 {
+		discard = 0
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	// buildClusters passes each Cluster to emit with its time
+	buildClusters := func(emit func(cluster []byte, time int64) error) error {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		packets := newPacketReader(&OGGDecoder{Reader: r}, 0, 0)
+		for i := 0; i < count; i++ {
+			if _, err := nextPacketOf(packets, ident.Serial); err != nil {
+				return unexpectedEOF(err)
+			}
+		}
+		// Vorbis durations depend on the packet before
+		_, samples, err := packetDurations(info.codec, headers)
+		if err != nil {
+			return err
+		}
+		var cluster []byte
+		clusterTime := int64(-1)
+		position := start
+		for i := 0; i < frames; i++ {
+			packet, err := nextPacketOf(packets, ident.Serial)
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			n, err := samples(packet.Data)
+			if err != nil {
+				return err
+			}
+			ms := samplesMilliseconds(position, rate)
+			if cluster == nil || ms-clusterTime >= webmClusterLength {
+				if cluster != nil {
+					if err := emit(appendEBMLElement(nil, mkvClusterID, cluster), clusterTime); err != nil {
+						return err
+					}
+				}
+				clusterTime = ms
+				cluster = appendEBMLUint(nil, mkvTimestampID, uint64(ms))
+			}
+			block := []byte{0x81, byte((ms - clusterTime) >> 8), byte(ms - clusterTime), 0}
+			if i == frames-1 && discard > 0 {
+				group := appendEBMLElement(nil, mkvBlockID, append(block, packet.Data...))
+				group = appendEBMLInt(group, mkvDiscardPaddingID, samplesNanoseconds(discard, rate))
+				cluster = appendEBMLElement(cluster, mkvBlockGroupID, group)
+			} else {
+				block[3] = 0x80 // keyframe
+				cluster = appendEBMLElement(cluster, mkvSimpleBlockID, append(block, packet.Data...))
+			}
+			position += n
+		}
+		if cluster == nil {
+			return nil
+		}
+		return emit(appendEBMLElement(nil, mkvClusterID, cluster), clusterTime)
+	}
+	var clustersLength int
+	var clusterStarts []int
+	var clusterTimes []int64
+	err = buildClusters(func(cluster []byte, time int64) error {
+		clusterStarts = append(clusterStarts, clustersLength)
+		clusterTimes = append(clusterTimes, time)
+		clustersLength += len(cluster)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	uid := uint64(ident.Serial)
+	if uid == 0 {
+		// a TrackUID of 0 is not allowed
+		uid = 1
+	}
+	private := headers[0]
+	audio := appendEBMLFloat(nil, mkvSamplingFreqID, float64(rate))
+	entry := appendEBMLUint(nil, mkvTrackNumberID, 1)
+	entry = appendEBMLUint(entry, mkvTrackUIDID, uid)
+	entry = appendEBMLUint(entry, mkvTrackTypeID, 2)
+	switch info.codec {
+	case Opus:
+		head, err := parseOpusHead(headers[0])
+		if err != nil {
+			return err
+		}
+		entry = appendEBMLElement(entry, mkvCodecIDID, []byte("A_OPUS"))
+		entry = appendEBMLUint(entry, mkvCodecDelayID, uint64(samplesNanoseconds(int64(head.PreSkip), rate)))
+		entry = appendEBMLUint(entry, mkvSeekPreRollID, uint64(samplesNanoseconds(opusPreroll, rate)))
+		audio = appendEBMLUint(audio, mkvChannelsID, uint64(head.Channels))
+	case Vorbis:
+		ident, err := vorbis.ParseIdent(headers[0])
+		if err != nil {
+			return err
+		}
+		private = xiphLace(headers)
+		entry = appendEBMLElement(entry, mkvCodecIDID, []byte("A_VORBIS"))
+		audio = appendEBMLUint(audio, mkvChannelsID, uint64(ident.Channels))
+	}
+	entry = appendEBMLElement(entry, mkvCodecPrivateID, private)
+	entry = appendEBMLElement(entry, mkvAudioID, audio)
+	tracks := appendEBMLElement(nil, mkvTracksID, appendEBMLElement(nil, mkvTrackEntryID, entry))
+
+	segmentInfo := appendEBMLUint(nil, mkvTimestampScaleID, 1000000)
+	segmentInfo = appendEBMLElement(segmentInfo, mkvMuxingAppID, []byte("oggmeta"))
+	segmentInfo = appendEBMLElement(segmentInfo, mkvWritingAppID, []byte("oggmeta"))
+	duration := samplesNanoseconds(start+total-discard, rate)
+	segmentInfo = appendEBMLFloat(segmentInfo, mkvDurationID, float64(duration)/1e6)
+	segmentInfo = appendEBMLElement(nil, mkvInfoID, segmentInfo)
+
+	tags, err := matroskaTags(tag, &matroskaFile{track: &matroskaTrack{uid: uid}})
+	if err != nil {
+		return err
+	}
+	tags = append(tags, ebmlVoid(webmTagsPadding)...)
+
+	base := webmSeekHeadSize + len(segmentInfo) + len(tracks) + len(tags)
+	var cues []byte
+	for i, at := range clusterStarts {
+		positions := appendEBMLUint(nil, mkvCueTrackID, 1)
+		positions = appendEBMLUint(positions, mkvCueClusterPosID, uint64(base+at))
+		point := appendEBMLUint(nil, mkvCueTimeID, uint64(clusterTimes[i]))
+		cues = appendEBMLElement(cues, mkvCuePointID, appendEBMLElement(point, mkvCuePositionsID, positions))
+	}
+	cues = appendEBMLElement(nil, mkvCuesID, cues)
+
+	var seeks []byte
+	for _, s := range []struct {
+		id  uint32
+		pos int
+	}{
+		{mkvInfoID, webmSeekHeadSize},
+		{mkvTracksID, webmSeekHeadSize + len(segmentInfo)},
+		{mkvTagsID, webmSeekHeadSize + len(segmentInfo) + len(tracks)},
+		{mkvCuesID, base + clustersLength},
+	} {
+		seek := appendEBMLElement(nil, mkvSeekIDID, appendEBMLID(nil, s.id))
+		seeks = appendEBMLElement(seeks, mkvSeekID, appendEBMLUint(seek, mkvSeekPositionID, uint64(s.pos)))
+	}
+	segment := fitEBMLElement(mkvSeekHeadID, seeks, webmSeekHeadSize)
+	if segment == nil {
+		return &ErrInvalidMatroska{Reason: "SeekHead too long"}
+	}
+	for _, e := range [][]byte{segmentInfo, tracks, tags} {
+		segment = append(segment, e...)
+	}
+
+	header := appendEBMLUint(nil, ebmlVersionID, 1)
+	header = appendEBMLUint(header, ebmlReadVersionID, 1)
+	header = appendEBMLUint(header, ebmlMaxIDLengthID, 4)
+	header = appendEBMLUint(header, ebmlMaxSizeLengthID, 8)
+	header = appendEBMLElement(header, ebmlDocTypeID, []byte("webm"))
+	header = appendEBMLUint(header, ebmlDocTypeVersionID, 4)
+	header = appendEBMLUint(header, ebmlDocTypeReadVersionID, 2)
+	out := appendEBMLElement(nil, ebmlHeaderID, header)
+	// a full width size lets SaveTags grow the Segment
+	out = appendEBMLSize(appendEBMLID(out, mkvSegmentID), uint64(len(segment)+clustersLength+len(cues)), 8)
+	if _, err = w.Write(append(out, segment...)); err != nil {
+		return err
+	}
+	err = buildClusters(func(cluster []byte, _ int64) error {
+		_, err := w.Write(cluster)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(cues)
+	return err
+}
+
+// packetDurations returns the sample rate of a stream and a function giving
+// the samples of each audio packet.
+func packetDurations(codec string, headers [][]byte) (int, func([]byte) (int64, error), error) {
+	if codec == Opus {
+		return 48000, opusPacketSamples, nil
+	}
+	if len(headers) != 3 {
+		return 0, nil, &ErrInvalidMatroska{Reason: "invalid Vorbis CodecPrivate"}
+	}
+	ident, err := vorbis.ParseIdent(headers[0])
+	if err != nil {
+		return 0, nil, err
+	}
+	dec := vorbis.NewDecoder()
+	for _, h := range headers {
+		if err := dec.ReadHeader(h); err != nil {
+			return 0, nil, err
+		}
+	}
+	previous := 0
+	return ident.SampleRate, func(data []byte) (int64, error) {
+		n := dec.BlockSize(data)
+		if n == 0 {
+			return 0, nil
+		}
+		var samples int64
+		if previous > 0 {
+			samples = int64(previous/4 + n/4)
+		}
+		previous = n
+		return samples, nil
+	}, nil
+}
+
+// xiphLace joins packets into a CodecPrivate with Xiph lacing.
+func xiphLace(packets [][]byte) []byte {
+	b := []byte{byte(len(packets) - 1)}
+	for _, p := range packets[:len(packets)-1] {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			b = append(b, 255)
+		}
+		b = append(b, byte(n))
+	}
+	for _, p := range packets {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func nanosecondSamples(ns int64, rate int) int64 {
+	return (ns*int64(rate) + 500000000) / 1000000000
+}
+
+func samplesNanoseconds(samples int64, rate int) int64 {
+	return samples * 1000000000 / int64(rate)
+}
+
+func samplesMilliseconds(samples int64, rate int) int64 {
+	return (samples*1000 + int64(rate)/2) / int64(rate)
+}
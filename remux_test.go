@@ -0,0 +1,125 @@
+package oggmeta
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemuxOpus(t *testing.T) {
+	b, err := os.ReadFile("./testdata/testdata-opus-nonEmpty.ogg")
+	assert.NoError(t, err)
+	want, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	stats, err := AnalyzeOpus(bytes.NewReader(b))
+	assert.NoError(t, err)
+
+	webm := new(bytes.Buffer)
+	assert.NoError(t, RemuxToWebM(bytes.NewReader(b), webm))
+	tag, err := ReadOGG(bytes.NewReader(webm.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, Opus, tag.Codec)
+	assert.Equal(t, want.OpusHead, tag.OpusHead)
+	assert.Equal(t, want.Title, tag.Title)
+	assert.Equal(t, want.Artist, tag.Artist)
+
+	// the Void after the Tags leaves room to edit them in place
+	tag.Album = "Recordings"
+	saved := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, saved))
+	assert.Equal(t, webm.Len(), saved.Len())
+
+	ogg := new(bytes.Buffer)
+	assert.NoError(t, RemuxToOgg(bytes.NewReader(webm.Bytes()), ogg))
+	verifyPages(t, ogg.Bytes())
+	again, err := AnalyzeOpus(bytes.NewReader(ogg.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, stats.Packets, again.Packets)
+	assert.Equal(t, stats.PreSkip, again.PreSkip)
+	assert.Equal(t, stats.PlayableSamples(), again.PlayableSamples())
+	assert.Empty(t, again.GranuleMismatches)
+	assert.Equal(t, readAllPackets(t, b)[2:], readAllPackets(t, ogg.Bytes())[2:])
+
+	tag, err = ReadOGG(bytes.NewReader(ogg.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, want.Title, tag.Title)
+	assert.Equal(t, want.Artist, tag.Artist)
+}
+
+func TestRemuxVorbis(t *testing.T) {
+	b, err := os.ReadFile("./testdata/testdata-ogg-vorbis-nonEmpty.ogg")
+	assert.NoError(t, err)
+	want, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	stats, err := AnalyzeVorbis(bytes.NewReader(b))
+	assert.NoError(t, err)
+
+	webm := new(bytes.Buffer)
+	assert.NoError(t, RemuxToWebM(bytes.NewReader(b), webm))
+	tag, err := ReadOGG(bytes.NewReader(webm.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, Vorbis, tag.Codec)
+	assert.Equal(t, want.Vendor, tag.Vendor)
+	assert.Equal(t, want.Title, tag.Title)
+
+	ogg := new(bytes.Buffer)
+	assert.NoError(t, RemuxToOgg(bytes.NewReader(webm.Bytes()), ogg))
+	verifyPages(t, ogg.Bytes())
+	again, err := AnalyzeVorbis(bytes.NewReader(ogg.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, stats.Packets, again.Packets)
+	assert.Equal(t, stats.PlayableSamples(), again.PlayableSamples())
+	assert.Empty(t, again.GranuleMismatches)
+	packets := readAllPackets(t, ogg.Bytes())
+	assert.Equal(t, readAllPackets(t, b)[3:], packets[3:])
+	assert.Equal(t, readAllPackets(t, b)[2], packets[2])
+
+	tag, err = ReadOGG(bytes.NewReader(ogg.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, want.Title, tag.Title)
+	assert.Equal(t, want.Artist, tag.Artist)
+}
+
+func TestRemuxToOggTiming(t *testing.T) {
+	// three 20 ms packets in a Cluster of unknown size
+	webm := buildWebM(t, webmFile{codec: "A_OPUS", private: webmOpusHead(t), tags: webmTag(0, "TITLE", "Live"), unknown: true})
+	ogg := new(bytes.Buffer)
+	assert.NoError(t, RemuxToOgg(bytes.NewReader(webm), ogg))
+	stats, err := AnalyzeOpus(bytes.NewReader(ogg.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, stats.Packets)
+	assert.Equal(t, int64(3*960), stats.FinalGranule)
+	tag, err := ReadOGG(bytes.NewReader(ogg.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "Live", tag.Title)
+}
+
+func TestMatroskaBlockLacing(t *testing.T) {
+	frames := [][]byte{bytes.Repeat([]byte{1}, 300), {2, 2}, {3, 3, 3}}
+	// track 1, timestamp 5, keyframe and Xiph lacing
+	xiph := append([]byte{0x81, 0, 5, 0x82}, xiphLace(frames)...)
+	b, err := parseMatroskaBlock(xiph, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), b.track)
+	assert.Equal(t, int64(105), b.time)
+	assert.Equal(t, frames, b.frames)
+
+	// EBML lacing: 300, then 2 as a difference of -298
+	ebml := []byte{0x81, 0xff, 0xfb, 0x86, 2, 0x41, 0x2c}
+	diff := 2 - 300 + (1<<13 - 1)
+	ebml = append(ebml, 0x40|byte(diff>>8), byte(diff))
+	for _, f := range frames {
+		ebml = append(ebml, f...)
+	}
+	b, err = parseMatroskaBlock(ebml, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(95), b.time)
+	assert.Equal(t, frames, b.frames)
+
+	fixed := []byte{0x81, 0, 0, 0x84, 1, 4, 4, 5, 5}
+	b, err = parseMatroskaBlock(fixed, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{{4, 4}, {5, 5}}, b.frames)
+}
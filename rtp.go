@@ -0,0 +1,227 @@
+package oggmeta
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// rtpOpusRate is the RTP clock rate of Opus, whatever the input sample rate.
+const rtpOpusRate = 48000
+
+// RTPOptions sets the header fields of the RTP packets an RTPPacketizer makes.
+type RTPOptions struct {
+	SSRC           uint32
+	PayloadType    uint8  // usually a dynamic type from 96 to 127
+	SequenceNumber uint16 // of the first packet
+	Timestamp      uint32 // RTP timestamp of the first sample of the stream
+}
+
+// RTPPacket is an RTP packet carrying one Opus packet, as RFC 7587 maps them.
+type RTPPacket struct {
+	PayloadType    uint8
+	Marker         bool
+	SequenceNumber uint16
+	Timestamp      uint32
+	SSRC           uint32
+	Payload        []byte
+
+	Duration time.Duration // of the audio in the payload
+}
+
+// Marshal returns the packet with its 12 byte RTP header.
+func (p *RTPPacket) Marshal() []byte {
+	b := make([]byte, 12, 12+len(p.Payload))
+	b[0] = 2 << 6 // version 2, no padding, extension or CSRCs
+	b[1] = p.PayloadType & 0x7f
+	if p.Marker {
+		b[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(b[2:], p.SequenceNumber)
+	binary.BigEndian.PutUint32(b[4:], p.Timestamp)
+	binary.BigEndian.PutUint32(b[8:], p.SSRC)
+	return append(b, p.Payload...)
+}
+
+// Unmarshal parses an RTP packet, dropping any CSRCs, header extension and
+// padding. Duration is left zero.
+func (p *RTPPacket) Unmarshal(b []byte) error {
+	if len(b) < 12 {
+		return &ErrInvalidRTP{Reason: "packet too short"}
+	}
+	if b[0]>>6 != 2 {
+		return &ErrInvalidRTP{Reason: "unsupported version"}
+	}
+	n := 12 + 4*int(b[0]&0xf)
+	if b[0]&0x10 != 0 {
+		if len(b) < n+4 {
+			return &ErrInvalidRTP{Reason: "header extension truncated"}
+		}
+		n += 4 + 4*int(binary.BigEndian.Uint16(b[n+2:]))
+	}
+	end := len(b)
+	if b[0]&0x20 != 0 {
+		end -= int(b[len(b)-1])
+	}
+	if n > end {
+		return &ErrInvalidRTP{Reason: "packet too short"}
+	}
+	*p = RTPPacket{
+		PayloadType:    b[1] & 0x7f,
+		Marker:         b[1]&0x80 != 0,
+		SequenceNumber: binary.BigEndian.Uint16(b[2:]),
+		Timestamp:      binary.BigEndian.Uint32(b[4:]),
+		SSRC:           binary.BigEndian.Uint32(b[8:]),
+		Payload:        b[n:end],
+	}
+	return nil
+}
+
+// RTPPacketizer turns the Opus stream of an Ogg file into RTP packets.
+type RTPPacketizer struct {
+	opts    RTPOptions
+	packets *packetReader
+	serial  uint32
+	queue   []*RTPPacket
+	sent    int
+	end     int64 // granule position after the last packet queued, -1 before the first
+
+	// stubbed by tests
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewRTPPacketizer reads the headers of the Opus stream of r and returns a
+// packetizer for its audio packets.
+func NewRTPPacketizer(r io.ReadSeeker, opts RTPOptions) (*RTPPacketizer, error) {
+	info, err := readStreamInfo(r)
+	if err != nil {
+		return nil, err
+	}
+	if info.codec != Opus {
+		return nil, &ErrUnsupportedCodec{}
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	packets := newPacketReader(&OGGDecoder{Reader: r}, 0, 0)
+	// OpusHead and OpusTags
+	for i := 0; i < 2; i++ {
+		if _, err = nextPacketOf(packets, info.serial); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	return &RTPPacketizer{
+		opts:    opts,
+		packets: packets,
+		serial:  info.serial,
+		end:     -1,
+		now:     time.Now,
+		sleep:   sleepContext,
+	}, nil
+}
+
+// Next returns the next RTP packet, or io.EOF after the last one.
+func (p *RTPPacketizer) Next() (*RTPPacket, error) {
+	if len(p.queue) == 0 {
+		if err := p.readPage(); err != nil {
+			return nil, err
+		}
+	}
+	packet := p.queue[0]
+	p.queue = p.queue[1:]
+	packet.SequenceNumber = p.opts.SequenceNumber + uint16(p.sent)
+	// the first packet starts a talkspurt
+	packet.Marker = p.sent == 0
+	p.sent++
+	return packet, nil
+}
+
+// readPage queues the packets up to the next one with a granule position,
+// which dates them all.
+func (p *RTPPacketizer) readPage() error {
+	var pending []*RTPPacket
+	var samples []int64
+	for {
+		packet, err := nextPacketOf(p.packets, p.serial)
+		if err == io.EOF && len(pending) > 0 {
+			// the packets cannot be dated without the granule of their page
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		n, err := opusPacketSamples(packet.Data)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, &RTPPacket{
+			PayloadType: p.opts.PayloadType,
+			SSRC:        p.opts.SSRC,
+			Payload:     packet.Data,
+			Duration:    samplesDuration(n, rtpOpusRate),
+		})
+		samples = append(samples, n)
+		if packet.Granule < 0 {
+			continue
+		}
+		start := packet.Granule
+		for _, n := range samples {
+			start -= n
+		}
+		if p.end >= 0 && start < p.end {
+			// the end trimming of the last page, which does not move the packets
+			start = p.end
+		}
+		for i, rtp := range pending {
+			rtp.Timestamp = p.opts.Timestamp + uint32(start)
+			start += samples[i]
+		}
+		p.end = start
+		p.queue = pending
+		return nil
+	}
+}
+
+// Pace calls send with each packet when it is due, until the stream ends or
+// ctx is done.
+func (p *RTPPacketizer) Pace(ctx context.Context, send func(*RTPPacket) error) error {
+	start := p.now()
+	var first uint32
+	for i := 0; ; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		packet, err := p.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			first = packet.Timestamp
+		}
+		due := samplesDuration(int64(packet.Timestamp-first), rtpOpusRate)
+		if wait := due - p.now().Sub(start); wait > 0 {
+			if err = p.sleep(ctx, wait); err != nil {
+				return err
+			}
+		}
+		if err = send(packet); err != nil {
+			return err
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
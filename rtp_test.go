@@ -0,0 +1,86 @@
+package oggmeta
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRTPPacketizer(t *testing.T) {
+	b, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	opts := RTPOptions{SSRC: 0xdecafbad, PayloadType: 111, SequenceNumber: 0xfffe, Timestamp: 0xffffff00}
+	p, err := NewRTPPacketizer(bytes.NewReader(b), opts)
+	assert.NoError(t, err)
+
+	audio := readAllPackets(t, b)[2:]
+	timestamp := opts.Timestamp
+	for i, want := range audio {
+		packet, err := p.Next()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, want, packet.Payload)
+		assert.Equal(t, opts.SequenceNumber+uint16(i), packet.SequenceNumber)
+		assert.Equal(t, timestamp, packet.Timestamp, "packet %d", i)
+		assert.Equal(t, i == 0, packet.Marker)
+		samples, err := opusPacketSamples(want)
+		assert.NoError(t, err)
+		assert.Equal(t, samplesDuration(samples, 48000), packet.Duration)
+		timestamp += uint32(samples)
+	}
+	_, err = p.Next()
+	assert.Equal(t, io.EOF, err)
+
+	raw := (&RTPPacket{PayloadType: 111, Marker: true, SequenceNumber: 7, Timestamp: 960, SSRC: 0xdecafbad, Payload: []byte{0xfc}}).Marshal()
+	assert.Equal(t, []byte{0x80, 0x80 | 111, 0, 7, 0, 0, 3, 0xc0, 0xde, 0xca, 0xfb, 0xad, 0xfc}, raw)
+	assert.Equal(t, uint32(960), binary.BigEndian.Uint32(raw[4:]))
+
+	vorbis, err := os.ReadFile("./testdata/test1.ogg")
+	assert.NoError(t, err)
+	_, err = NewRTPPacketizer(bytes.NewReader(vorbis), opts)
+	assert.IsType(t, &ErrUnsupportedCodec{}, err)
+}
+
+func TestRTPPace(t *testing.T) {
+	b, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	p, err := NewRTPPacketizer(bytes.NewReader(b), RTPOptions{})
+	assert.NoError(t, err)
+
+	// a clock that only moves when the packetizer sleeps, plus 1 ms per send
+	clock := time.Unix(0, 0)
+	p.now = func() time.Time { return clock }
+	p.sleep = func(ctx context.Context, d time.Duration) error {
+		clock = clock.Add(d)
+		return nil
+	}
+	var sent []time.Duration
+	var last *RTPPacket
+	err = p.Pace(context.Background(), func(packet *RTPPacket) error {
+		sent = append(sent, clock.Sub(time.Unix(0, 0)))
+		clock = clock.Add(time.Millisecond)
+		last = packet
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, sent, len(readAllPackets(t, b))-2)
+	assert.Equal(t, time.Duration(0), sent[0])
+	// sends are on schedule, as long as they take less than a packet
+	assert.Equal(t, samplesDuration(int64(last.Timestamp), 48000), sent[len(sent)-1])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err = NewRTPPacketizer(bytes.NewReader(b), RTPOptions{})
+	assert.NoError(t, err)
+	err = p.Pace(ctx, func(packet *RTPPacket) error {
+		cancel()
+		return nil
+	})
+	assert.Equal(t, context.Canceled, err)
+}
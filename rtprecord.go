@@ -0,0 +1,311 @@
+package oggmeta
+
+import (
+	"io"
+	"sort"
+	"time"
+)
+
+// RTPRecorderOptions configures an RTPRecorder.
+type RTPRecorderOptions struct {
+	Serial          uint32
+	Channels        uint8  // of the OpusHead, 0 for 2 as RFC 7587 signals every stream as stereo
+	PreSkip         uint16 // samples the sender's encoder delays its output by, dropped on playback
+	InputSampleRate uint32 // informational only, 0 for 48 kHz
+	Tag             *OggTag
+
+	// FlushInterval is the most audio held in memory before a page is
+	// written, so that a file cut short by a crash loses no more. 0 means
+	// one second. It counts audio, not time: call Flush to write out a
+	// stream that has stopped sending packets.
+	FlushInterval time.Duration
+
+	// ReorderWindow is the number of packets held back to put late ones
+	// in order. 0 means 8.
+	ReorderWindow int
+}
+
+// RTPRecorderStats counts what an RTPRecorder did with the packets it was given.
+type RTPRecorderStats struct {
+	Packets    int   // written to the file
+	Late       int   // arrived after a later packet was written, dropped
+	Duplicates int   // dropped
+	GapSamples int64 // of lost audio, filled with packets the decoder conceals
+}
+
+// RTPRecorder writes the Opus payloads of an RTP stream to an Ogg Opus file.
+type RTPRecorder struct {
+	opts  RTPRecorderOptions
+	w     io.Writer
+	enc   *OGGEncoder
+	stats RTPRecorderStats
+
+	window  []recordedPacket // in sequence order
+	ssrc    uint32
+	started bool
+	maxSeq  int64 // highest extended sequence number seen
+	written int64 // extended sequence number of the last packet written, -1 before the first
+	maxTime int64 // highest extended timestamp seen
+	base    int64 // extended timestamp at granule position 0
+
+	position int64 // granule position after the last packet
+	flushed  int64 // granule position of the last page written
+	pending  [][]byte
+	ends     []int64 // granule position after each pending packet
+	segments int
+	closed   bool
+}
+
+type recordedPacket struct {
+	seq     int64
+	time    int64
+	payload []byte
+	samples int64
+}
+
+// NewRTPRecorder writes the OpusHead and OpusTags pages to w and returns a
+// recorder for the audio.
+func NewRTPRecorder(w io.Writer, opts RTPRecorderOptions) (*RTPRecorder, error) {
+	if opts.Channels == 0 {
+		opts.Channels = 2
+	}
+	if opts.InputSampleRate == 0 {
+		opts.InputSampleRate = rtpOpusRate
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.ReorderWindow <= 0 {
+		opts.ReorderWindow = 8
+	}
+	head := &OpusHead{Version: 1, Channels: opts.Channels, PreSkip: opts.PreSkip, InputSampleRate: opts.InputSampleRate}
+	if err := head.validate(); err != nil {
+		return nil, err
+	}
+	tags := createCommentPacket(nil, nil, Opus)
+	if opts.Tag != nil {
+		tag := *opts.Tag
+		tag.Codec = Opus
+		tags = tagCommentPacket(&tag)
+	}
+
+	r := &RTPRecorder{opts: opts, w: w, enc: &OGGEncoder{Writer: w, Serial: opts.Serial}, written: -1}
+	if err := r.enc.EncodeBOS(0, [][]byte{head.toBytesSlice()}); err != nil {
+		return nil, err
+	}
+	if err := r.enc.Encode(0, [][]byte{tags}); err != nil {
+		return nil, err
+	}
+	if err := r.flushWriter(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// WriteRTP parses an RTP packet and records its payload.
+func (r *RTPRecorder) WriteRTP(b []byte) error {
+	p := &RTPPacket{}
+	if err := p.Unmarshal(b); err != nil {
+		return err
+	}
+	return r.WritePacket(p)
+}
+
+// WritePacket records the payload of p.
+func (r *RTPRecorder) WritePacket(p *RTPPacket) error {
+	if r.closed {
+		return io.ErrClosedPipe
+	}
+	if len(p.Payload) == 0 {
+		return nil
+	}
+	samples, err := opusPacketSamples(p.Payload)
+	if err != nil {
+		return err
+	}
+	if r.started && p.SSRC != r.ssrc {
+		if err := r.drain(); err != nil {
+			return err
+		}
+		r.started = false
+	}
+	if !r.started {
+		r.started = true
+		r.ssrc = p.SSRC
+		r.maxSeq, r.maxTime, r.written = int64(p.SequenceNumber), int64(p.Timestamp), -1
+		r.base = int64(p.Timestamp) - r.position
+	}
+
+	seq := r.maxSeq + int64(int16(p.SequenceNumber-uint16(r.maxSeq)))
+	ts := r.maxTime + int64(int32(p.Timestamp-uint32(r.maxTime)))
+	if seq > r.maxSeq {
+		r.maxSeq, r.maxTime = seq, ts
+	}
+	if seq <= r.written {
+		if seq == r.written {
+			r.stats.Duplicates++
+		} else {
+			r.stats.Late++
+		}
+		return nil
+	}
+	i := sort.Search(len(r.window), func(i int) bool { return r.window[i].seq >= seq })
+	if i < len(r.window) && r.window[i].seq == seq {
+		r.stats.Duplicates++
+		return nil
+	}
+	// the payload may be in a buffer the caller reuses
+	payload := append([]byte{}, p.Payload...)
+	r.window = append(r.window, recordedPacket{})
+	copy(r.window[i+1:], r.window[i:])
+	r.window[i] = recordedPacket{seq: seq, time: ts, payload: payload, samples: samples}
+
+	for len(r.window) > r.opts.ReorderWindow {
+		if err := r.emit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats returns the counts so far.
+func (r *RTPRecorder) Stats() RTPRecorderStats {
+	return r.stats
+}
+
+// Flush writes the packets held back for reordering and pages of the audio
+// recorded so far, for instance from a timer while the sender is silent.
+// Packets that arrive afterwards with an earlier sequence number are dropped
+// as late. The last packet stays behind for the page Close writes.
+func (r *RTPRecorder) Flush() error {
+	if r.closed {
+		return io.ErrClosedPipe
+	}
+	if err := r.drain(); err != nil {
+		return err
+	}
+	return r.writePage(len(r.pending) - 1)
+}
+
+// Close writes the packets still held back and the last page, with the EOS
+// flag. It does not close w.
+func (r *RTPRecorder) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if err := r.drain(); err != nil {
+		return err
+	}
+	var err error
+	if len(r.pending) == 0 {
+		// no audio was recorded
+		err = r.enc.encodeEmptyEOS(r.position)
+	} else {
+		err = r.enc.EncodeEOS(r.position, r.pending)
+	}
+	if err != nil {
+		return err
+	}
+	r.pending, r.ends, r.segments = nil, nil, 0
+	return r.flushWriter()
+}
+
+func (r *RTPRecorder) drain() error {
+	for len(r.window) > 0 {
+		if err := r.emit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emit adds the first packet of the window to the file, after filling any
+// gap before its timestamp.
+func (r *RTPRecorder) emit() error {
+	p := r.window[0]
+	r.window = r.window[1:]
+	r.written = p.seq
+	if gap := p.time - r.base - r.position; gap > 0 {
+		for _, fill := range opusGapPackets(gap) {
+			n, err := opusPacketSamples(fill)
+			if err != nil {
+				return err
+			}
+			r.stats.GapSamples += n
+			if err = r.add(fill, n); err != nil {
+				return err
+			}
+		}
+	}
+	r.stats.Packets++
+	return r.add(p.payload, p.samples)
+}
+
+// add queues a packet for the current page, writing the page first if the
+// packet does not fit and afterwards if it holds FlushInterval of audio.
+func (r *RTPRecorder) add(packet []byte, samples int64) error {
+	segments := len(packet)/MaxSegSize + 1
+	if r.segments+segments > MaxSegSize {
+		if err := r.writePage(len(r.pending)); err != nil {
+			return err
+		}
+	}
+	r.position += samples
+	r.pending = append(r.pending, packet)
+	r.ends = append(r.ends, r.position)
+	r.segments += segments
+	if r.position-r.flushed >= int64(r.opts.FlushInterval)*rtpOpusRate/int64(time.Second) {
+		// the last packet stays behind for the EOS page, which needs one
+		return r.writePage(len(r.pending) - 1)
+	}
+	return nil
+}
+
+// writePage writes the first n pending packets as a page.
+func (r *RTPRecorder) writePage(n int) error {
+	if n <= 0 {
+		return nil
+	}
+	if err := r.enc.Encode(r.ends[n-1], r.pending[:n]); err != nil {
+		return err
+	}
+	r.flushed = r.ends[n-1]
+	r.pending = append([][]byte{}, r.pending[n:]...)
+	r.ends = append([]int64{}, r.ends[n:]...)
+	r.segments = 0
+	for _, p := range r.pending {
+		r.segments += len(p)/MaxSegSize + 1
+	}
+	return r.flushWriter()
+}
+
+// flushWriter pushes the pages out of a buffered writer, such as a bufio.Writer.
+func (r *RTPRecorder) flushWriter() error {
+	if f, ok := r.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// opusGapPackets returns packets of empty frames that add up to gap samples,
+// rounded down to 2.5 ms. Decoders conceal an empty frame as a lost one.
+func opusGapPackets(gap int64) [][]byte {
+	var packets [][]byte
+	// CELT fullband frames of 20, 10, 5 and 2.5 ms, code 3 for several frames
+	for _, size := range []struct {
+		toc     byte
+		samples int64
+	}{{0xf8, 960}, {0xf0, 480}, {0xe8, 240}, {0xe0, 120}} {
+		for gap >= size.samples {
+			// at most 120 ms in a packet
+			frames := gap / size.samples
+			if frames*size.samples > 5760 {
+				frames = 5760 / size.samples
+			}
+			packets = append(packets, []byte{size.toc | 3, byte(frames)})
+			gap -= frames * size.samples
+		}
+	}
+	return packets
+}
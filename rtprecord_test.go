@@ -0,0 +1,182 @@
+package oggmeta
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// opusRTPPackets packetizes testdata-opus.ogg.
+func opusRTPPackets(t *testing.T, opts RTPOptions) []*RTPPacket {
+	b, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	p, err := NewRTPPacketizer(bytes.NewReader(b), opts)
+	assert.NoError(t, err)
+	var packets []*RTPPacket
+	for {
+		packet, err := p.Next()
+		if err == io.EOF {
+			return packets
+		}
+		assert.NoError(t, err)
+		packets = append(packets, packet)
+	}
+}
+
+func TestRTPRecorder(t *testing.T) {
+	packets := opusRTPPackets(t, RTPOptions{SSRC: 7, SequenceNumber: 0xfff0, Timestamp: 0xfffff000})
+	var total int64
+	for _, p := range packets {
+		n, err := opusPacketSamples(p.Payload)
+		assert.NoError(t, err)
+		total += n
+	}
+
+	out := new(bytes.Buffer)
+	tag := &OggTag{Title: "Call", Artist: "Support line"}
+	rec, err := NewRTPRecorder(out, RTPRecorderOptions{Serial: 42, PreSkip: 312, Tag: tag})
+	assert.NoError(t, err)
+	// packet 3 comes after 4 and 5, packet 10 twice, packet 20 never and
+	// packet 30 too late for the window
+	var order []int
+	for i := range packets {
+		switch i {
+		case 3, 20, 30:
+		case 5:
+			order = append(order, 5, 3)
+		case 10:
+			order = append(order, 10, 10)
+		case 45:
+			order = append(order, 45, 30)
+		default:
+			order = append(order, i)
+		}
+	}
+	for _, i := range order {
+		raw := packets[i].Marshal()
+		assert.NoError(t, rec.WriteRTP(raw))
+		// the recorder keeps its own copy of the payload
+		for j := range raw {
+			raw[j] = 0
+		}
+	}
+	assert.NoError(t, rec.Close())
+	assert.Equal(t, RTPRecorderStats{Packets: len(packets) - 2, Late: 1, Duplicates: 1, GapSamples: 2 * 960}, rec.Stats())
+
+	b := out.Bytes()
+	verifyPages(t, b)
+	stats, err := AnalyzeOpus(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(312), stats.PreSkip)
+	assert.Equal(t, total, stats.FinalGranule)
+	assert.Empty(t, stats.GranuleMismatches)
+
+	recorded := readAllPackets(t, b)[2:]
+	if assert.Len(t, recorded, len(packets)) {
+		for i, p := range packets {
+			if i == 20 || i == 30 {
+				assert.Equal(t, []byte{0xfb, 1}, recorded[i])
+			} else {
+				assert.Equal(t, p.Payload, recorded[i], "packet %d", i)
+			}
+		}
+	}
+	got, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, "Call", got.Title)
+	assert.Equal(t, uint8(2), got.OpusHead.Channels)
+}
+
+func TestRTPRecorderFlush(t *testing.T) {
+	packets := opusRTPPackets(t, RTPOptions{SSRC: 7})
+	out := new(bytes.Buffer)
+	rec, err := NewRTPRecorder(out, RTPRecorderOptions{FlushInterval: 100 * time.Millisecond, ReorderWindow: 2})
+	assert.NoError(t, err)
+	for _, p := range packets[:50] {
+		assert.NoError(t, rec.WritePacket(p))
+	}
+
+	// without Close, everything but the held back packets is on complete pages
+	b := append([]byte{}, out.Bytes()...)
+	verifyPages(t, b)
+	assert.Greater(t, len(readAllPackets(t, b)), 2+50-2-10)
+	stats, err := AnalyzeOpus(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Empty(t, stats.GranuleMismatches)
+
+	// a new SSRC carries on where the old one stopped
+	restart := opusRTPPackets(t, RTPOptions{SSRC: 8, SequenceNumber: 1000, Timestamp: 123456})
+	for _, p := range restart[:10] {
+		assert.NoError(t, rec.WritePacket(p))
+	}
+	assert.NoError(t, rec.Close())
+	assert.Equal(t, int64(0), rec.Stats().GapSamples)
+	assert.Equal(t, 60, rec.Stats().Packets)
+	assert.Equal(t, io.ErrClosedPipe, rec.WritePacket(packets[0]))
+}
+
+func TestRTPUnmarshal(t *testing.T) {
+	p := &RTPPacket{PayloadType: 111, Marker: true, SequenceNumber: 513, Timestamp: 96000, SSRC: 9, Payload: []byte{0xfc, 1, 2}}
+	got := &RTPPacket{}
+	assert.NoError(t, got.Unmarshal(p.Marshal()))
+	assert.Equal(t, p, got)
+
+	// a CSRC, a one word extension and two bytes of padding
+	raw := p.Marshal()
+	raw[0] |= 0x20 | 0x10 | 1
+	withExtras := append(append([]byte{}, raw[:12]...), 0, 0, 0, 1, 0xbe, 0xde, 0, 1, 1, 2, 3, 4)
+	withExtras = append(append(withExtras, raw[12:]...), 0, 2)
+	assert.NoError(t, got.Unmarshal(withExtras))
+	assert.Equal(t, p.Payload, got.Payload)
+
+	assert.IsType(t, &ErrInvalidRTP{}, got.Unmarshal([]byte{0x80, 0}))
+	assert.IsType(t, &ErrInvalidRTP{}, got.Unmarshal(make([]byte, 12)))
+}
+
+func TestRTPRecorderFlushCall(t *testing.T) {
+	packets := opusRTPPackets(t, RTPOptions{SSRC: 7})
+	out := new(bytes.Buffer)
+	rec, err := NewRTPRecorder(out, RTPRecorderOptions{FlushInterval: time.Minute})
+	assert.NoError(t, err)
+	for _, p := range packets[:20] {
+		assert.NoError(t, rec.WritePacket(p))
+	}
+	assert.Len(t, readAllPackets(t, out.Bytes()), 2)
+
+	// the sender goes quiet: all but the last packet are written
+	assert.NoError(t, rec.Flush())
+	b := append([]byte{}, out.Bytes()...)
+	verifyPages(t, b)
+	assert.Len(t, readAllPackets(t, b), 2+19)
+
+	// a packet from before the flush is late
+	assert.NoError(t, rec.WritePacket(packets[15]))
+	assert.NoError(t, rec.WritePacket(packets[20]))
+	assert.NoError(t, rec.Close())
+	assert.Equal(t, RTPRecorderStats{Packets: 21, Late: 1}, rec.Stats())
+	assert.Len(t, readAllPackets(t, out.Bytes()), 2+21)
+	assert.Equal(t, io.ErrClosedPipe, rec.Flush())
+}
+
+func TestRTPRecorderNoAudio(t *testing.T) {
+	out := new(bytes.Buffer)
+	rec, err := NewRTPRecorder(out, RTPRecorderOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, rec.Flush())
+	assert.NoError(t, rec.Close())
+
+	// the EOS page carries no packet
+	headers := verifyPages(t, out.Bytes())
+	if assert.Len(t, headers, 3) {
+		assert.Equal(t, byte(FlagEOS), headers[2].Flags)
+		assert.Zero(t, headers[2].Segments)
+		assert.Zero(t, headers[2].GranulePosition)
+	}
+	assert.Len(t, readAllPackets(t, out.Bytes()), 2)
+	_, err = ReadOGG(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+}
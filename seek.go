@@ -0,0 +1,274 @@
+package oggmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/gcottom/oggmeta/vorbis"
+)
+
+// SeekResult tells where to resume decoding after a seek. Codec headers are
+// not repeated, so a decoder must have read them from the start of the file.
+type SeekResult struct {
+	// Offset is that of the page holding the target, the first whose
+	// granule position passes it.
+	Offset int64
+
+	// Decoder reads pages from PrerollOffset, where decoding has to start
+	// for the output at the target to be right: the page before the target
+	// page for Vorbis, whose blocks overlap, and a page at least 80 ms
+	// earlier for Opus, as RFC 7845 section 4.6 recommends.
+	Decoder       *OGGDecoder
+	PrerollOffset int64
+
+	// Preroll reports that the packets ending on the page at PrerollOffset
+	// precede PrerollGranule. They should be decoded to prime the codec and
+	// their output dropped up to and including the packet that carries a
+	// granule position.
+	Preroll        bool
+	PrerollGranule int64 // granule position of the preroll page, 0 without one
+
+	// Discard is the number of samples of decoder output to drop after the
+	// preroll to reach the target exactly.
+	Discard int64
+}
+
+// streamInfo is what seeking needs from the headers of the first stream.
+type streamInfo struct {
+	serial    uint32
+	codec     string
+	rate      int
+	preSkip   int64
+	dataStart int64 // offset of the first page after the headers
+}
+
+func readStreamInfo(r io.ReadSeeker) (*streamInfo, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	dec := &OGGDecoder{Reader: r}
+	packet, err := dec.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	info := &streamInfo{serial: packet.Serial}
+	headers := 0
+	switch {
+	case bytes.HasPrefix(packet.Data, VorbisIdentPrefix):
+		ident, err := vorbis.ParseIdent(packet.Data)
+		if err != nil {
+			return nil, err
+		}
+		info.codec = Vorbis
+		info.rate = ident.SampleRate
+		headers = 3
+	case bytes.HasPrefix(packet.Data, OpusHeadPrefix):
+		head, err := parseOpusHead(packet.Data)
+		if err != nil {
+			return nil, err
+		}
+		info.codec = Opus
+		info.rate = 48000
+		info.preSkip = int64(head.PreSkip)
+		headers = 2
+	case bytes.HasPrefix(packet.Data, SpeexPrefix):
+		head, err := parseSpeexHeader(packet.Data)
+		if err != nil {
+			return nil, err
+		}
+		info.codec = Speex
+		info.rate = int(head.SampleRate)
+		headers = 2 + int(head.ExtraHeaders)
+	default:
+		return nil, &ErrUnsupportedCodec{}
+	}
+	for read := 1; read < headers; {
+		if packet, err = dec.ReadPacket(); err != nil {
+			return nil, err
+		}
+		if packet.Serial == info.serial {
+			read++
+		}
+	}
+	info.dataStart = dec.bytesRead
+	return info, nil
+}
+
+// SeekToTime finds the page holding the sample played at t, assuming the
+// stream starts at granule position 0, and positions r where decoding has to
+// start to reach it.
+func SeekToTime(r io.ReadSeeker, t time.Duration) (*SeekResult, error) {
+	info, err := readStreamInfo(r)
+	if err != nil {
+		return nil, err
+	}
+	if t < 0 {
+		t = 0
+	}
+	sample := int64(t / time.Second * time.Duration(info.rate))
+	sample += int64(t%time.Second) * int64(info.rate) / int64(time.Second)
+	return seekToGranule(r, info, sample+info.preSkip)
+}
+
+// SeekToGranule finds the page holding granule by bisection and positions r
+// where decoding has to start to reach it.
+func SeekToGranule(r io.ReadSeeker, granule int64) (*SeekResult, error) {
+	info, err := readStreamInfo(r)
+	if err != nil {
+		return nil, err
+	}
+	return seekToGranule(r, info, granule)
+}
+
+func seekToGranule(r io.ReadSeeker, info *streamInfo, granule int64) (*SeekResult, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	result := &SeekResult{Offset: info.dataStart, PrerollOffset: info.dataStart}
+	off, n, last, err := lastGranulePage(r, info, size, granule)
+	if err != nil {
+		return nil, err
+	}
+	from := info.dataStart
+	if n > 0 {
+		result.Offset, from = off, off+n
+	}
+	// the target page is the next one, unless the target is past the end
+	switch off, _, _, err := granulePage(r, from, info.serial); {
+	case err == nil && off < size:
+		result.Offset = off
+	case err != nil && err != io.EOF:
+		return nil, err
+	}
+
+	if info.codec == Opus {
+		if off, n, last, err = lastGranulePage(r, info, size, granule-opusPreroll); err != nil {
+			return nil, err
+		}
+	}
+	if n > 0 {
+		result.PrerollOffset = off
+		result.Preroll = true
+		result.PrerollGranule = last
+	}
+	result.Discard = granule - result.PrerollGranule
+	if !result.Preroll {
+		// decoders apply pre-skip themselves at the start of the stream
+		result.Discard -= info.preSkip
+	}
+	if result.Discard < 0 {
+		result.Discard = 0
+	}
+	if _, err := r.Seek(result.PrerollOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	result.Decoder = &OGGDecoder{Reader: r}
+	return result, nil
+}
+
+// lastGranulePage finds by bisection the last page that ends at or before
+// granule, returning its offset, length and granule position. The length is
+// 0 if there is none.
+func lastGranulePage(r io.ReadSeeker, info *streamInfo, size, granule int64) (int64, int64, int64, error) {
+	var found, length, last int64
+	lo, hi := info.dataStart, size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		off, header, n, err := granulePage(r, mid, info.serial)
+		if err == io.EOF || err == nil && off >= hi {
+			hi = mid
+			continue
+		}
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if header.GranulePosition <= granule {
+			found, length, last = off, n, header.GranulePosition
+			lo = off + n
+		} else {
+			hi = mid
+		}
+	}
+	return found, length, last, nil
+}
+
+// granulePage returns the first page of serial at or after from that carries
+// a granule position, with its offset and length.
+func granulePage(r io.ReadSeeker, from int64, serial uint32) (int64, OGGPageHeader, int64, error) {
+	for {
+		off, header, n, err := syncPage(r, from)
+		if err != nil {
+			return 0, header, 0, err
+		}
+		if header.SerialNumber == serial && header.GranulePosition != -1 {
+			return off, header, n, nil
+		}
+		from = off + n
+	}
+}
+
+// syncPage returns the first page starting at or after from whose checksum
+// is valid, skipping over anything that merely looks like a capture pattern.
+func syncPage(r io.ReadSeeker, from int64) (int64, OGGPageHeader, int64, error) {
+	buf := make([]byte, 2*MaxPageSize)
+	for {
+		if _, err := r.Seek(from, io.SeekStart); err != nil {
+			return 0, OGGPageHeader{}, 0, err
+		}
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return 0, OGGPageHeader{}, 0, err
+		}
+		end := n < len(buf)
+		// with a full buffer, only look where a whole page still fits
+		limit := n
+		if !end {
+			limit = MaxPageSize
+		}
+		for i := 0; i < limit; i++ {
+			j := bytes.Index(buf[i:n], Oggs[:])
+			if j < 0 || i+j >= limit {
+				break
+			}
+			i += j
+			if header, size, ok := parsePage(buf[i:n]); ok {
+				return from + int64(i), header, int64(size), nil
+			}
+		}
+		if end {
+			return 0, OGGPageHeader{}, 0, io.EOF
+		}
+		from += MaxPageSize
+	}
+}
+
+// parsePage checks that b starts with a complete page with a valid checksum.
+func parsePage(b []byte) (OGGPageHeader, int, bool) {
+	var header OGGPageHeader
+	if len(b) < HeaderSize || b[4] != 0 {
+		return header, 0, false
+	}
+	segs := int(b[26])
+	size := HeaderSize + segs
+	if segs == 0 || len(b) < size {
+		return header, 0, false
+	}
+	for _, s := range b[HeaderSize:size] {
+		size += int(s)
+	}
+	if len(b) < size {
+		return header, 0, false
+	}
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &header); err != nil {
+		return header, 0, false
+	}
+	head := append([]byte{}, b[:HeaderSize]...)
+	binary.LittleEndian.PutUint32(head[22:], 0)
+	if calculateChecksum(head, b[HeaderSize+segs:size], b[HeaderSize:HeaderSize+segs]) != header.CRC {
+		return header, 0, false
+	}
+	return header, size, true
+}
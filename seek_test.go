@@ -0,0 +1,163 @@
+package oggmeta
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gcottom/oggmeta/opus"
+	"github.com/gcottom/oggmeta/vorbis"
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeVorbisFrom reads the headers from the start of b, then decodes the
+// packets of dec and returns the output as left channel samples.
+func decodeVorbisFrom(t *testing.T, b []byte, dec *OGGDecoder, preroll bool) []float32 {
+	headers := &OGGDecoder{Reader: bytes.NewReader(b)}
+	v := vorbis.NewDecoder()
+	for !v.HeadersRead() {
+		packet, err := headers.ReadPacket()
+		assert.NoError(t, err)
+		assert.NoError(t, v.ReadHeader(packet.Data))
+	}
+	var out []float32
+	for {
+		packet, err := dec.ReadPacket()
+		if err == io.EOF {
+			return out
+		}
+		assert.NoError(t, err)
+		pcm, err := v.Decode(packet.Data)
+		assert.NoError(t, err)
+		if preroll {
+			preroll = packet.Granule < 0
+			continue
+		}
+		if len(pcm) > 0 {
+			out = append(out, pcm[0]...)
+		}
+	}
+}
+
+func TestSeekToGranule(t *testing.T) {
+	b, err := os.ReadFile("./testdata/test1.ogg")
+	assert.NoError(t, err)
+	full := decodeVorbisFrom(t, b, &OGGDecoder{Reader: bytes.NewReader(b)}, false)
+
+	for _, tc := range []struct {
+		granule       int64
+		offset        int64
+		prerollOffset int64
+		preroll       bool
+		discard       int64
+	}{
+		{0, 4509, 4509, false, 0},
+		{10, 4509, 4509, false, 10},
+		{44992, 11710, 4509, true, 0},
+		{44993, 11710, 4509, true, 1},
+		{100000, 20053, 11710, true, 10720},
+		{149000, 27823, 20053, true, 14664},
+	} {
+		result, err := SeekToGranule(bytes.NewReader(b), tc.granule)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.offset, result.Offset, "granule %d", tc.granule)
+		assert.Equal(t, tc.prerollOffset, result.PrerollOffset, "granule %d", tc.granule)
+		assert.Equal(t, tc.preroll, result.Preroll, "granule %d", tc.granule)
+		assert.Equal(t, tc.discard, result.Discard, "granule %d", tc.granule)
+
+		// decoding from the seek point lands on the same samples as decoding
+		// from the start
+		out := decodeVorbisFrom(t, b, result.Decoder, result.Preroll)
+		if assert.True(t, int64(len(out)) > result.Discard+100) {
+			out = out[result.Discard:]
+			assert.Equal(t, full[tc.granule:tc.granule+100], out[:100], "granule %d", tc.granule)
+		}
+	}
+}
+
+func TestSeekToTime(t *testing.T) {
+	f, err := os.Open("./testdata/test1.ogg")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	result, err := SeekToTime(f, 2*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, result.Preroll)
+	assert.Equal(t, int64(44992), result.PrerollGranule)
+	assert.Equal(t, int64(88200-44992), result.Discard)
+	page, err := result.Decoder.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(44992), page.Header.GranulePosition)
+
+	o, err := os.Open("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	defer o.Close()
+	stats, err := AnalyzeOpus(o)
+	assert.NoError(t, err)
+
+	// the pages are a second long, so the preroll reaches back a whole page,
+	// or to the start for the first
+	result, err = SeekToTime(o, time.Second)
+	assert.NoError(t, err)
+	assert.False(t, result.Preroll)
+	assert.Equal(t, int64(48000), result.Discard)
+	assert.Equal(t, int64(12760), result.Offset)
+
+	result, err = SeekToTime(o, 2*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, result.Preroll)
+	assert.Equal(t, int64(48000), result.PrerollGranule)
+	assert.Equal(t, 96000+stats.PreSkip, result.PrerollGranule+result.Discard)
+	assert.Equal(t, int64(365), result.PrerollOffset)
+	assert.Equal(t, int64(23732), result.Offset)
+
+	// after the preroll the output matches decoding from the start
+	b, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	packets := readAllPackets(t, b)
+	full, err := opus.NewDecoder(packets[0])
+	assert.NoError(t, err)
+	var want []float32
+	for _, packet := range packets[2:] {
+		pcm, err := full.Decode(packet)
+		assert.NoError(t, err)
+		want = append(want, pcm[0]...)
+	}
+	dec, err := opus.NewDecoder(packets[0])
+	assert.NoError(t, err)
+	// a decoder that seeks has long used up its pre-skip
+	_, err = dec.Decode(packets[2])
+	assert.NoError(t, err)
+	dec.Reset()
+	var got []float32
+	preroll := result.Preroll
+	for {
+		packet, err := result.Decoder.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		pcm, err := dec.Decode(packet.Data)
+		assert.NoError(t, err)
+		if preroll {
+			preroll = packet.Granule < 0
+			continue
+		}
+		got = append(got, pcm[0]...)
+	}
+	if assert.True(t, int64(len(got)) > result.Discard+100) {
+		assert.InDeltaSlice(t, want[96000:96100], got[result.Discard:result.Discard+100], 1e-4)
+	}
+}
+
+func TestSyncPageSkipsFalseCapture(t *testing.T) {
+	b, err := os.ReadFile("./testdata/test1.ogg")
+	assert.NoError(t, err)
+	junk := append([]byte("OggS\x00garbage"), b...)
+	off, header, _, err := syncPage(bytes.NewReader(junk), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), off)
+	assert.Equal(t, byte(FlagBOS), header.Flags)
+}
@@ -0,0 +1,607 @@
+package oggmeta
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"sort"
+	"strings"
+)
+
+// skeletonKeypointInterval is the least time in milliseconds between two
+// keypoints of a generated index.
+const skeletonKeypointInterval = 2000
+
+// Skeleton is an Ogg Skeleton stream, which describes the other logical
+// streams of a file.
+type Skeleton struct {
+	Serial  uint32
+	Head    SkeletonHead
+	Bones   []*SkeletonBone
+	Indexes []*SkeletonIndex // from Skeleton 4
+}
+
+// Bone returns the fisbone describing the stream with serial, or nil.
+func (s *Skeleton) Bone(serial uint32) *SkeletonBone {
+	for _, b := range s.Bones {
+		if b.Serial == serial {
+			return b
+		}
+	}
+	return nil
+}
+
+// Index returns the keyframe index of the stream with serial, or nil.
+func (s *Skeleton) Index(serial uint32) *SkeletonIndex {
+	for _, x := range s.Indexes {
+		if x.Serial == serial {
+			return x
+		}
+	}
+	return nil
+}
+
+// SkeletonHead is the fishead packet that starts a Skeleton stream.
+type SkeletonHead struct {
+	VersionMajor uint16
+	VersionMinor uint16
+
+	// the time the file starts being presented at, and the time granule
+	// position 0 corresponds to
+	PresentationNumerator   int64
+	PresentationDenominator int64
+	BaseNumerator           int64
+	BaseDenominator         int64
+	UTC                     [20]byte
+
+	// from Skeleton 4, the length of the file and the offset of its first
+	// page that is not a header page
+	SegmentLength uint64
+	ContentOffset uint64
+}
+
+// SkeletonBone is a fisbone packet, which describes one other logical stream.
+type SkeletonBone struct {
+	Serial                 uint32
+	HeaderPackets          uint32
+	GranuleRateNumerator   int64 // granule positions per second, as a fraction
+	GranuleRateDenominator int64
+	BaseGranule            int64
+	Preroll                uint32 // packets to decode before a seek target
+	GranuleShift           uint8  // bits of the granule position that count from a keyframe
+
+	// message header fields
+	ContentType string
+	Role        string
+	Name        string
+	Fields      map[string]string // any others
+}
+
+// SkeletonIndex is a Skeleton 4 keyframe index, which lets players seek
+// without bisecting the file.
+type SkeletonIndex struct {
+	Serial          uint32
+	TimeDenominator int64 // units per second of the times below
+	FirstTime       int64 // presentation time of the first sample
+	LastTime        int64 // end time of the last sample
+	Keypoints       []SkeletonKeypoint
+}
+
+// SkeletonKeypoint marks the page a keyframe begins on.
+type SkeletonKeypoint struct {
+	Offset int64 // of the page in the file
+	Time   int64 // presentation time of the keyframe
+}
+
+func parseSkeletonHead(data []byte) (*SkeletonHead, error) {
+	if !bytes.HasPrefix(data, SkeletonPrefix) || len(data) < 64 {
+		return nil, &ErrInvalidSkeleton{Reason: "fishead packet too short"}
+	}
+	h := &SkeletonHead{
+		VersionMajor:            binary.LittleEndian.Uint16(data[8:]),
+		VersionMinor:            binary.LittleEndian.Uint16(data[10:]),
+		PresentationNumerator:   int64(binary.LittleEndian.Uint64(data[12:])),
+		PresentationDenominator: int64(binary.LittleEndian.Uint64(data[20:])),
+		BaseNumerator:           int64(binary.LittleEndian.Uint64(data[28:])),
+		BaseDenominator:         int64(binary.LittleEndian.Uint64(data[36:])),
+	}
+	copy(h.UTC[:], data[44:64])
+	if h.VersionMajor < 3 {
+		return nil, &ErrInvalidSkeleton{Reason: "unsupported version"}
+	}
+	if h.VersionMajor >= 4 {
+		if len(data) < 80 {
+			return nil, &ErrInvalidSkeleton{Reason: "fishead packet too short"}
+		}
+		h.SegmentLength = binary.LittleEndian.Uint64(data[64:])
+		h.ContentOffset = binary.LittleEndian.Uint64(data[72:])
+	}
+	return h, nil
+}
+
+func (h *SkeletonHead) toBytesSlice() []byte {
+	b := make([]byte, 80)
+	copy(b, SkeletonPrefix)
+	binary.LittleEndian.PutUint16(b[8:], h.VersionMajor)
+	binary.LittleEndian.PutUint16(b[10:], h.VersionMinor)
+	binary.LittleEndian.PutUint64(b[12:], uint64(h.PresentationNumerator))
+	binary.LittleEndian.PutUint64(b[20:], uint64(h.PresentationDenominator))
+	binary.LittleEndian.PutUint64(b[28:], uint64(h.BaseNumerator))
+	binary.LittleEndian.PutUint64(b[36:], uint64(h.BaseDenominator))
+	copy(b[44:], h.UTC[:])
+	if h.VersionMajor < 4 {
+		return b[:64]
+	}
+	binary.LittleEndian.PutUint64(b[64:], h.SegmentLength)
+	binary.LittleEndian.PutUint64(b[72:], h.ContentOffset)
+	return b
+}
+
+func parseSkeletonBone(data []byte) (*SkeletonBone, error) {
+	if !bytes.HasPrefix(data, FisbonePrefix) || len(data) < 52 {
+		return nil, &ErrInvalidSkeleton{Reason: "fisbone packet too short"}
+	}
+	b := &SkeletonBone{
+		Serial:                 binary.LittleEndian.Uint32(data[12:]),
+		HeaderPackets:          binary.LittleEndian.Uint32(data[16:]),
+		GranuleRateNumerator:   int64(binary.LittleEndian.Uint64(data[20:])),
+		GranuleRateDenominator: int64(binary.LittleEndian.Uint64(data[28:])),
+		BaseGranule:            int64(binary.LittleEndian.Uint64(data[36:])),
+		Preroll:                binary.LittleEndian.Uint32(data[44:]),
+		GranuleShift:           data[48],
+	}
+	offset := 8 + uint64(binary.LittleEndian.Uint32(data[8:]))
+	if offset < 52 || offset > uint64(len(data)) {
+		return nil, &ErrInvalidSkeleton{Reason: "invalid message header offset"}
+	}
+	for _, line := range strings.Split(string(data[offset:]), "\r\n") {
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		name, value := line[:colon], strings.TrimSpace(line[colon+1:])
+		switch strings.ToLower(name) {
+		case "content-type":
+			b.ContentType = value
+		case "role":
+			b.Role = value
+		case "name":
+			b.Name = value
+		default:
+			if b.Fields == nil {
+				b.Fields = make(map[string]string)
+			}
+			b.Fields[name] = value
+		}
+	}
+	return b, nil
+}
+
+func (b *SkeletonBone) toBytesSlice() []byte {
+	out := make([]byte, 52)
+	copy(out, FisbonePrefix)
+	binary.LittleEndian.PutUint32(out[8:], 52-8)
+	binary.LittleEndian.PutUint32(out[12:], b.Serial)
+	binary.LittleEndian.PutUint32(out[16:], b.HeaderPackets)
+	binary.LittleEndian.PutUint64(out[20:], uint64(b.GranuleRateNumerator))
+	binary.LittleEndian.PutUint64(out[28:], uint64(b.GranuleRateDenominator))
+	binary.LittleEndian.PutUint64(out[36:], uint64(b.BaseGranule))
+	binary.LittleEndian.PutUint32(out[44:], b.Preroll)
+	out[48] = b.GranuleShift
+	field := func(name, value string) {
+		if value != "" {
+			out = append(out, name+": "+value+"\r\n"...)
+		}
+	}
+	field("Content-Type", b.ContentType)
+	field("Role", b.Role)
+	field("Name", b.Name)
+	names := make([]string, 0, len(b.Fields))
+	for name := range b.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field(name, b.Fields[name])
+	}
+	return out
+}
+
+func parseSkeletonIndex(data []byte) (*SkeletonIndex, error) {
+	if !bytes.HasPrefix(data, IndexPrefix) || len(data) < 42 {
+		return nil, &ErrInvalidSkeleton{Reason: "index packet too short"}
+	}
+	x := &SkeletonIndex{
+		Serial:          binary.LittleEndian.Uint32(data[6:]),
+		TimeDenominator: int64(binary.LittleEndian.Uint64(data[18:])),
+		FirstTime:       int64(binary.LittleEndian.Uint64(data[26:])),
+		LastTime:        int64(binary.LittleEndian.Uint64(data[34:])),
+	}
+	count := binary.LittleEndian.Uint64(data[10:])
+	rest := data[42:]
+	// every keypoint takes at least two bytes
+	if count > uint64(len(rest)/2) {
+		return nil, &ErrInvalidSkeleton{Reason: "index keypoints truncated"}
+	}
+	x.Keypoints = make([]SkeletonKeypoint, count)
+	var offset, time int64
+	for i := range x.Keypoints {
+		var delta int64
+		var ok bool
+		if delta, rest, ok = readSkeletonVarint(rest); !ok {
+			return nil, &ErrInvalidSkeleton{Reason: "index keypoints truncated"}
+		}
+		offset += delta
+		if delta, rest, ok = readSkeletonVarint(rest); !ok {
+			return nil, &ErrInvalidSkeleton{Reason: "index keypoints truncated"}
+		}
+		time += delta
+		x.Keypoints[i] = SkeletonKeypoint{Offset: offset, Time: time}
+	}
+	return x, nil
+}
+
+func (x *SkeletonIndex) toBytesSlice() []byte {
+	out := make([]byte, 42)
+	copy(out, IndexPrefix)
+	binary.LittleEndian.PutUint32(out[6:], x.Serial)
+	binary.LittleEndian.PutUint64(out[10:], uint64(len(x.Keypoints)))
+	binary.LittleEndian.PutUint64(out[18:], uint64(x.TimeDenominator))
+	binary.LittleEndian.PutUint64(out[26:], uint64(x.FirstTime))
+	binary.LittleEndian.PutUint64(out[34:], uint64(x.LastTime))
+	var offset, time int64
+	for _, k := range x.Keypoints {
+		out = appendSkeletonVarint(out, k.Offset-offset)
+		out = appendSkeletonVarint(out, k.Time-time)
+		offset, time = k.Offset, k.Time
+	}
+	return out
+}
+
+// readSkeletonVarint reads a keypoint delta: seven bits per byte, least
+// significant first, with the top bit set on the last byte.
+func readSkeletonVarint(b []byte) (int64, []byte, bool) {
+	var n int64
+	for i := 0; i < len(b) && i < 9; i++ {
+		n |= int64(b[i]&0x7f) << (7 * uint(i))
+		if b[i]&0x80 != 0 {
+			return n, b[i+1:], true
+		}
+	}
+	return 0, nil, false
+}
+
+func appendSkeletonVarint(b []byte, n int64) []byte {
+	for {
+		c := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(b, c|0x80)
+		}
+		b = append(b, c)
+	}
+}
+
+// ReadSkeleton reads the Skeleton stream of r. It returns nil without an
+// error if the file has none.
+func ReadSkeleton(r io.ReadSeeker) (*Skeleton, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	packets := newPacketReader(&OGGDecoder{Reader: r}, 0, 0)
+	var skeleton *Skeleton
+	for {
+		packet, err := packets.next()
+		if err == io.EOF {
+			if skeleton != nil && !packets.ended[skeleton.Serial] {
+				return nil, &ErrInvalidSkeleton{Reason: "stream has no EOS page"}
+			}
+			return skeleton, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if skeleton == nil {
+			if !packet.BOS {
+				// the BOS pages of the first link are over
+				return nil, nil
+			}
+			if bytes.HasPrefix(packet.Data, SkeletonPrefix) {
+				head, err := parseSkeletonHead(packet.Data)
+				if err != nil {
+					return nil, err
+				}
+				skeleton = &Skeleton{Serial: packet.Serial, Head: *head}
+			}
+			continue
+		}
+		if packet.Serial != skeleton.Serial {
+			if packets.ended[skeleton.Serial] {
+				// the EOS page of the skeleton held no packet
+				return skeleton, nil
+			}
+			continue
+		}
+		switch {
+		case bytes.HasPrefix(packet.Data, FisbonePrefix):
+			bone, err := parseSkeletonBone(packet.Data)
+			if err != nil {
+				return nil, err
+			}
+			skeleton.Bones = append(skeleton.Bones, bone)
+		case bytes.HasPrefix(packet.Data, IndexPrefix):
+			index, err := parseSkeletonIndex(packet.Data)
+			if err != nil {
+				return nil, err
+			}
+			skeleton.Indexes = append(skeleton.Indexes, index)
+		}
+		if packet.EOS {
+			return skeleton, nil
+		}
+	}
+}
+
+// IndexSkeleton copies r to w with a Skeleton 4 keyframe index.
+func IndexSkeleton(r io.ReadSeeker, w io.Writer) error {
+	return indexSkeleton(context.Background(), r, w)
+}
+
+// indexSkeleton is IndexSkeleton, stopping early when ctx is done. It reads r
+// twice, once to find the keyframes and once to copy it, and keeps only the
+// index in memory.
+func indexSkeleton(ctx context.Context, r io.ReadSeeker, w io.Writer) error {
+	skeleton, err := ReadSkeleton(r)
+	if err != nil {
+		return err
+	}
+	existing := skeleton != nil
+	if !existing {
+		skeleton = &Skeleton{Head: SkeletonHead{PresentationDenominator: 1000, BaseDenominator: 1000}}
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// the pages of the other streams, offsets counting from the first of them
+	var spans []byteRange
+	var length int64
+	insert := -1 // the span the rest of the skeleton goes before
+	contentOffset := int64(-1)
+	streams := make(map[uint32]*indexedStream)
+	var order []uint32
+	dec := &OGGDecoder{Reader: r}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		start := dec.bytesRead
+		page, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		serial := page.Header.SerialNumber
+		if existing && serial == skeleton.Serial {
+			continue
+		}
+		offset := length
+		length += dec.bytesRead - start
+
+		if page.Header.Flags&FlagBOS != 0 {
+			if insert >= 0 {
+				return &ErrInvalidSkeleton{Reason: "a chained file cannot be indexed"}
+			}
+			spans = appendByteRange(spans, start, dec.bytesRead)
+			clock, err := newStreamClock(page.Packets[0])
+			if err != nil {
+				return err
+			}
+			streams[serial] = &indexedStream{
+				clock:   clock,
+				index:   &SkeletonIndex{Serial: serial, TimeDenominator: 1000},
+				headers: clock.headers - 1,
+			}
+			order = append(order, serial)
+			continue
+		}
+		if insert < 0 {
+			insert = len(spans)
+			spans = append(spans, byteRange{start, dec.bytesRead})
+		} else {
+			spans = appendByteRange(spans, start, dec.bytesRead)
+		}
+		s := streams[serial]
+		if s == nil {
+			return &ErrInvalidLink{Index: 0, Reason: "page outside the BOS and EOS of its stream"}
+		}
+		ended := len(page.Packets)
+		if page.continues {
+			ended--
+		}
+		if s.headers > 0 {
+			s.headers -= ended
+		} else {
+			if contentOffset < 0 {
+				contentOffset = offset
+			}
+			s.addKeypoint(page, offset)
+		}
+		if page.Header.GranulePosition != -1 {
+			s.start = s.clock.seconds(page.Header.GranulePosition)
+			s.index.LastTime = milliseconds(s.start)
+		}
+	}
+	if len(order) == 0 {
+		return &ErrUnsupportedCodec{}
+	}
+	if insert < 0 {
+		insert = len(spans)
+	}
+	if contentOffset < 0 {
+		contentOffset = length
+	}
+
+	used := make(map[uint32]bool)
+	var bones []*SkeletonBone
+	var indexes []*SkeletonIndex
+	for _, serial := range order {
+		used[serial] = true
+		bone := skeleton.Bone(serial)
+		if bone == nil {
+			bone = new(SkeletonBone)
+			*bone = streams[serial].clock.bone
+			bone.Serial = serial
+		}
+		bones = append(bones, bone)
+		indexes = append(indexes, streams[serial].index)
+	}
+	if used[skeleton.Serial] {
+		skeleton.Serial = unusedSerial(skeleton.Serial, used)
+	}
+	skeleton.Head.VersionMajor, skeleton.Head.VersionMinor = 4, 0
+	skeleton.Bones = bones
+
+	head, rest, err := skeleton.indexedPages(indexes, length, contentOffset)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	for i := 0; i <= len(spans); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if i == insert {
+			// the bones and indexes follow the BOS pages of the other streams
+			if _, err := w.Write(rest); err != nil {
+				return err
+			}
+		}
+		if i == len(spans) {
+			break
+		}
+		span := spans[i]
+		if _, err := r.Seek(span.start, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, r, span.end-span.start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexedStream is a stream being indexed by indexSkeleton.
+type indexedStream struct {
+	clock   *streamClock
+	index   *SkeletonIndex
+	headers int     // header packets not yet seen
+	start   float64 // time the next packet begins at
+}
+
+// byteRange is the bytes [start, end) of a file.
+type byteRange struct {
+	start, end int64
+}
+
+// appendByteRange adds [start, end) to ranges, joining it to the last range
+// if the two meet.
+func appendByteRange(ranges []byteRange, start, end int64) []byteRange {
+	if n := len(ranges); n > 0 && ranges[n-1].end == start {
+		ranges[n-1].end = end
+		return ranges
+	}
+	return append(ranges, byteRange{start, end})
+}
+
+// indexedPages returns the pages of s, as pages does, with indexes whose
+// offsets count from the first page of the other streams. length is the size
+// of those pages and contentOffset the offset of the first that is not a
+// header page.
+func (s *Skeleton) indexedPages(indexes []*SkeletonIndex, length, contentOffset int64) ([]byte, []byte, error) {
+	// offsets depend on the size of the skeleton pages, which depends on
+	// the offsets, so build them until the size settles
+	size := int64(0)
+	for tries := 0; ; tries++ {
+		s.Head.SegmentLength = uint64(size + length)
+		s.Head.ContentOffset = uint64(size + contentOffset)
+		s.Indexes = nil
+		for _, x := range indexes {
+			shifted := *x
+			shifted.Keypoints = make([]SkeletonKeypoint, len(x.Keypoints))
+			for i, k := range x.Keypoints {
+				shifted.Keypoints[i] = SkeletonKeypoint{Offset: k.Offset + size, Time: k.Time}
+			}
+			s.Indexes = append(s.Indexes, &shifted)
+		}
+		head, rest, err := s.pages()
+		if err != nil {
+			return nil, nil, err
+		}
+		n := int64(len(head) + len(rest))
+		if n == size {
+			return head, rest, nil
+		}
+		if tries == 8 {
+			return nil, nil, &ErrInvalidSkeleton{Reason: "index size does not settle"}
+		}
+		size = n
+	}
+}
+
+// addKeypoint adds the page at offset to the index if a keyframe begins on
+// it and the last keypoint is far enough back.
+func (s *indexedStream) addKeypoint(page *OGGPage, offset int64) {
+	if page.Header.Flags&FlagCOP != 0 || len(page.Packets) == 0 {
+		return
+	}
+	if s.clock.keyframe != nil && !s.clock.keyframe(page.Packets[0]) {
+		return
+	}
+	time := milliseconds(s.start)
+	keypoints := s.index.Keypoints
+	if len(keypoints) == 0 {
+		s.index.FirstTime = time
+	} else if time-keypoints[len(keypoints)-1].Time < skeletonKeypointInterval {
+		return
+	}
+	s.index.Keypoints = append(keypoints, SkeletonKeypoint{Offset: offset, Time: time})
+}
+
+func milliseconds(seconds float64) int64 {
+	if seconds < 0 {
+		return 0
+	}
+	return int64(seconds * 1000)
+}
+
+// pages returns the BOS page of the skeleton and the pages that follow the
+// BOS pages of the other streams.
+func (s *Skeleton) pages() ([]byte, []byte, error) {
+	buf := new(bytes.Buffer)
+	enc := &OGGEncoder{Writer: buf, Serial: s.Serial}
+	if err := enc.EncodeBOS(0, [][]byte{s.Head.toBytesSlice()}); err != nil {
+		return nil, nil, err
+	}
+	head := append([]byte{}, buf.Bytes()...)
+	buf.Reset()
+	for _, b := range s.Bones {
+		if err := enc.Encode(0, [][]byte{b.toBytesSlice()}); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, x := range s.Indexes {
+		if err := enc.Encode(0, [][]byte{x.toBytesSlice()}); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := enc.encodeEmptyEOS(0); err != nil {
+		return nil, nil, err
+	}
+	return head, buf.Bytes(), nil
+}
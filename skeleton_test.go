@@ -0,0 +1,167 @@
+package oggmeta
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// checkKeypoints verifies that every keypoint of the skeleton in b points at
+// a page of its stream on which a packet begins.
+func checkKeypoints(t *testing.T, b []byte, skeleton *Skeleton) {
+	for _, x := range skeleton.Indexes {
+		if !assert.NotEmpty(t, x.Keypoints) {
+			continue
+		}
+		for i, k := range x.Keypoints {
+			header, _, ok := parsePage(b[k.Offset:])
+			if assert.True(t, ok, "keypoint %d of %d", i, x.Serial) {
+				assert.Equal(t, x.Serial, header.SerialNumber)
+				assert.Zero(t, header.Flags&FlagCOP)
+			}
+			if i > 0 {
+				assert.True(t, k.Time-x.Keypoints[i-1].Time >= skeletonKeypointInterval)
+				assert.True(t, k.Offset > x.Keypoints[i-1].Offset)
+			}
+		}
+		assert.True(t, x.LastTime > x.Keypoints[len(x.Keypoints)-1].Time)
+	}
+}
+
+func TestIndexSkeleton(t *testing.T) {
+	audio, err := os.ReadFile("./testdata/testdata-ogg-vorbis-nonEmpty.ogg")
+	assert.NoError(t, err)
+	video := buildTheoraFile(t, []string{"TITLE=Clip"}, 100)
+	grouped := new(bytes.Buffer)
+	assert.NoError(t, Mux(grouped, bytes.NewReader(video), bytes.NewReader(audio)))
+
+	skeleton, err := ReadSkeleton(bytes.NewReader(grouped.Bytes()))
+	assert.NoError(t, err)
+	assert.Nil(t, skeleton)
+
+	out := new(bytes.Buffer)
+	assert.NoError(t, IndexSkeleton(bytes.NewReader(grouped.Bytes()), out))
+	pages := verifyPages(t, out.Bytes())
+
+	skeleton, err = ReadSkeleton(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	if !assert.NotNil(t, skeleton) {
+		return
+	}
+	assert.Equal(t, uint16(4), skeleton.Head.VersionMajor)
+	// the EOS page of the skeleton carries no packet
+	for _, h := range pages {
+		if h.SerialNumber == skeleton.Serial && h.Flags&FlagEOS != 0 {
+			assert.Zero(t, h.Segments)
+		}
+	}
+	assert.Equal(t, uint64(out.Len()), skeleton.Head.SegmentLength)
+	_, _, ok := parsePage(out.Bytes()[skeleton.Head.ContentOffset:])
+	assert.True(t, ok)
+
+	if assert.Len(t, skeleton.Bones, 2) {
+		theora, vorbis := skeleton.Bones[0], skeleton.Bones[1]
+		assert.Equal(t, "video/theora", theora.ContentType)
+		assert.Equal(t, "video/main", theora.Role)
+		assert.Equal(t, int64(30000), theora.GranuleRateNumerator)
+		assert.Equal(t, int64(1001), theora.GranuleRateDenominator)
+		assert.Equal(t, uint8(6), theora.GranuleShift)
+		assert.Equal(t, uint32(3), theora.HeaderPackets)
+		assert.Equal(t, "audio/vorbis", vorbis.ContentType)
+		assert.Equal(t, "audio/main", vorbis.Role)
+		assert.Equal(t, int64(44100), vorbis.GranuleRateNumerator)
+		assert.Equal(t, uint32(2), vorbis.Preroll)
+	}
+	if assert.Len(t, skeleton.Indexes, 2) {
+		checkKeypoints(t, out.Bytes(), skeleton)
+		// with a keyframe every ten frames at 29.97 fps, frames 1 and 61
+		keypoints := skeleton.Index(skeleton.Bones[0].Serial).Keypoints
+		if assert.Len(t, keypoints, 2) {
+			assert.Equal(t, int64(0), keypoints[0].Time)
+			assert.Equal(t, int64(60*1001/30), keypoints[1].Time)
+		}
+	}
+
+	// the other streams are unchanged, and refreshing changes nothing
+	streams, err := Demux(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	if assert.Len(t, streams, 3) {
+		assert.Equal(t, video, streams[1].Data)
+		assert.Equal(t, audio, streams[2].Data)
+	}
+	again := new(bytes.Buffer)
+	assert.NoError(t, IndexSkeleton(bytes.NewReader(out.Bytes()), again))
+	assert.Equal(t, out.Bytes(), again.Bytes())
+
+	tag, err := ReadOGG(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "Clip", tag.TheoraTag.Title)
+}
+
+func TestSaveTagsSkeletonIndex(t *testing.T) {
+	audio, err := os.ReadFile("./testdata/test1.ogg")
+	assert.NoError(t, err)
+	indexed := new(bytes.Buffer)
+	assert.NoError(t, IndexSkeleton(bytes.NewReader(audio), indexed))
+	skeleton, err := ReadSkeleton(bytes.NewReader(indexed.Bytes()))
+	assert.NoError(t, err)
+	serial := skeleton.Serial
+
+	tag, err := ReadOGG(bytes.NewReader(indexed.Bytes()))
+	assert.NoError(t, err)
+	tag.SetTitle("A much longer title that moves every page after the comment header")
+	out := new(bytes.Buffer)
+	assert.NoError(t, SaveTagsContext(context.Background(), tag, out, SaveOptions{SkeletonIndex: true}))
+	verifyPages(t, out.Bytes())
+
+	saved, err := ReadSkeleton(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, serial, saved.Serial)
+	assert.Equal(t, uint64(out.Len()), saved.Head.SegmentLength)
+	checkKeypoints(t, out.Bytes(), saved)
+	assert.NotEqual(t, skeleton.Indexes[0].Keypoints, saved.Indexes[0].Keypoints)
+}
+
+func TestSkeletonPackets(t *testing.T) {
+	bone := &SkeletonBone{
+		Serial:                 7,
+		HeaderPackets:          2,
+		GranuleRateNumerator:   48000,
+		GranuleRateDenominator: 1,
+		Preroll:                4,
+		ContentType:            "audio/opus",
+		Role:                   "audio/alternate",
+		Name:                   "commentary",
+		Fields:                 map[string]string{"Language": "en"},
+	}
+	parsed, err := parseSkeletonBone(bone.toBytesSlice())
+	assert.NoError(t, err)
+	assert.Equal(t, bone, parsed)
+
+	index := &SkeletonIndex{Serial: 7, TimeDenominator: 1000, LastTime: 90000, Keypoints: []SkeletonKeypoint{{Offset: 4000, Time: 0}, {Offset: 1 << 40, Time: 1 << 20}}}
+	parsedIndex, err := parseSkeletonIndex(index.toBytesSlice())
+	assert.NoError(t, err)
+	assert.Equal(t, index, parsedIndex)
+
+	// a count larger than the packet can hold is rejected before allocating
+	b := index.toBytesSlice()
+	binary.LittleEndian.PutUint64(b[10:], 1<<40)
+	_, err = parseSkeletonIndex(b)
+	assert.IsType(t, &ErrInvalidSkeleton{}, err)
+}
+
+func TestIndexSkeletonCancel(t *testing.T) {
+	b, err := os.ReadFile("./testdata/test1.ogg")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	out := new(bytes.Buffer)
+	err = indexSkeleton(ctx, bytes.NewReader(b), out)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Zero(t, out.Len())
+}
@@ -0,0 +1,81 @@
+package oggmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// speexHeaderSize is the length of the Speex header packet.
+const speexHeaderSize = 80
+
+// SpeexHeader is the header packet that starts every Ogg Speex stream.
+type SpeexHeader struct {
+	Version         string // of the encoder that wrote the stream
+	VersionID       int32
+	SampleRate      int32
+	Mode            int32 // 0 narrowband, 1 wideband, 2 ultra-wideband
+	ModeVersion     int32 // bitstream version of the mode
+	Channels        int32
+	Bitrate         int32 // -1 if unknown
+	FrameSize       int32 // samples per frame
+	VBR             bool
+	FramesPerPacket int32
+	ExtraHeaders    int32 // header packets after the comment
+}
+
+func parseSpeexHeader(data []byte) (*SpeexHeader, error) {
+	if !bytes.HasPrefix(data, SpeexPrefix) || len(data) < speexHeaderSize {
+		return nil, &ErrInvalidSpeexHeader{Reason: "packet too short"}
+	}
+	field := func(i int) int32 {
+		return int32(binary.LittleEndian.Uint32(data[28+4*i:]))
+	}
+	if size := field(1); size < speexHeaderSize || int(size) > len(data) {
+		return nil, &ErrInvalidSpeexHeader{Reason: "invalid header size"}
+	}
+	h := &SpeexHeader{
+		Version:         string(bytes.TrimRight(data[8:28], "\x00")),
+		VersionID:       field(0),
+		SampleRate:      field(2),
+		Mode:            field(3),
+		ModeVersion:     field(4),
+		Channels:        field(5),
+		Bitrate:         field(6),
+		FrameSize:       field(7),
+		VBR:             field(8) != 0,
+		FramesPerPacket: field(9),
+		ExtraHeaders:    field(10),
+	}
+	switch {
+	case h.SampleRate <= 0:
+		return nil, &ErrInvalidSpeexHeader{Reason: "invalid sample rate"}
+	case h.Mode < 0 || h.Mode > 2:
+		return nil, &ErrInvalidSpeexHeader{Reason: "unknown mode"}
+	case h.Channels < 1 || h.Channels > 2:
+		return nil, &ErrInvalidSpeexHeader{Reason: "invalid channel count"}
+	case h.ExtraHeaders < 0:
+		return nil, &ErrInvalidSpeexHeader{Reason: "invalid extra header count"}
+	}
+	return h, nil
+}
+
+// readSpeexComment reads the comment packet that follows the Speex header.
+// It is a Vorbis comment without a prefix or framing bit.
+func (dec *OGGDecoder) readSpeexComment(first *Packet, packets *packetReader, side *sideStreams) (*OggTag, error) {
+	header, err := parseSpeexHeader(first.Data)
+	if err != nil {
+		return nil, err
+	}
+	packet, err := side.nextPacketOf(dec, packets, first.Serial)
+	if err != nil {
+		return nil, err
+	}
+	dec.TagReader = bytes.NewReader(packet.Data)
+	tag, err := dec.readComments()
+	if err != nil {
+		return nil, err
+	}
+	tag.Codec = Speex
+	tag.SpeexHeader = header
+	return tag, nil
+}
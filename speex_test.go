@@ -0,0 +1,128 @@
+package oggmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildSpeexFile writes a wideband mono Ogg Speex stream with the given
+// comment fields and stand-in audio packets of one 320-sample frame each.
+func buildSpeexFile(t *testing.T, fields []string, packets int) []byte {
+	head := make([]byte, speexHeaderSize)
+	copy(head, SpeexPrefix)
+	copy(head[8:], "1.2.1")
+	for i, v := range []int32{1, speexHeaderSize, 16000, 1, 4, 1, -1, 320, 0, 1, 0} {
+		binary.LittleEndian.PutUint32(head[28+4*i:], uint32(v))
+	}
+
+	out := new(bytes.Buffer)
+	enc := &OGGEncoder{Writer: out, Serial: 0x5eed}
+	assert.NoError(t, enc.EncodeBOS(0, [][]byte{head}))
+	assert.NoError(t, enc.Encode(0, [][]byte{createCommentPacket(fields, nil, Speex)}))
+	for i := 1; i <= packets; i++ {
+		packet := []byte{byte(i), 0x55, 0xaa}
+		if i == packets {
+			assert.NoError(t, enc.EncodeEOS(int64(i*320), [][]byte{packet}))
+		} else {
+			assert.NoError(t, enc.Encode(int64(i*320), [][]byte{packet}))
+		}
+	}
+	return out.Bytes()
+}
+
+func TestReadSpeex(t *testing.T) {
+	b := buildSpeexFile(t, []string{"TITLE=Memo", "ARTIST=Me", "PLACE=Office"}, 4)
+
+	tag, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, Speex, tag.Codec)
+	assert.Equal(t, "gcottom-oggmeta", tag.Vendor)
+	assert.Equal(t, "Memo", tag.Title)
+	assert.Equal(t, "Me", tag.Artist)
+	assert.Equal(t, "Office", tag.UnmappedFields["PLACE"])
+	if assert.NotNil(t, tag.SpeexHeader) {
+		assert.Equal(t, "1.2.1", tag.SpeexHeader.Version)
+		assert.Equal(t, int32(16000), tag.SpeexHeader.SampleRate)
+		assert.Equal(t, int32(1), tag.SpeexHeader.Mode)
+		assert.Equal(t, int32(1), tag.SpeexHeader.Channels)
+		assert.Equal(t, int32(-1), tag.SpeexHeader.Bitrate)
+		assert.Equal(t, int32(320), tag.SpeexHeader.FrameSize)
+		assert.Equal(t, int32(1), tag.SpeexHeader.FramesPerPacket)
+		assert.False(t, tag.SpeexHeader.VBR)
+	}
+
+	info, err := readStreamInfo(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, Speex, info.codec)
+	assert.Equal(t, 16000, info.rate)
+}
+
+func TestSaveSpeex(t *testing.T) {
+	b := buildSpeexFile(t, []string{"TITLE=Memo"}, 4)
+	tag, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	tag.SetTitle("Renamed")
+	tag.SetAlbum("Voice Memos")
+
+	out := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, out))
+	verifyPages(t, out.Bytes())
+
+	saved, err := ReadOGG(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, Speex, saved.Codec)
+	assert.Equal(t, "Renamed", saved.Title)
+	assert.Equal(t, "Voice Memos", saved.Album)
+	assert.Equal(t, tag.SpeexHeader, saved.SpeexHeader)
+
+	// the comment has no prefix and no framing bit, and the audio is untouched
+	dec := &OGGDecoder{Reader: bytes.NewReader(out.Bytes())}
+	_, err = dec.ReadPacket()
+	assert.NoError(t, err)
+	comment, err := dec.ReadPacket()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(len("gcottom-oggmeta")), binary.LittleEndian.Uint32(comment.Data))
+	vorbisComment := createCommentPacket(tagCommentFields(saved), nil, Vorbis)
+	assert.Equal(t, len(vorbisComment)-len(VorbisPrefix)-1, len(comment.Data))
+	for i := 1; i <= 4; i++ {
+		packet, err := dec.ReadPacket()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{byte(i), 0x55, 0xaa}, packet.Data)
+	}
+}
+
+func TestParseSpeexHeaderInvalid(t *testing.T) {
+	_, err := parseSpeexHeader(SpeexPrefix)
+	assert.IsType(t, &ErrInvalidSpeexHeader{}, err)
+
+	head := make([]byte, speexHeaderSize)
+	copy(head, SpeexPrefix)
+	binary.LittleEndian.PutUint32(head[32:], speexHeaderSize)
+	_, err = parseSpeexHeader(head)
+	assert.IsType(t, &ErrInvalidSpeexHeader{}, err)
+}
+
+func TestSaveSpeexGrouped(t *testing.T) {
+	audio := buildSpeexFile(t, []string{"TITLE=Memo"}, 4)
+	subtitles := buildKateFile(t, []string{"TITLE=Transcript"}, kateEvents)
+	grouped := new(bytes.Buffer)
+	assert.NoError(t, Mux(grouped, bytes.NewReader(subtitles), bytes.NewReader(audio)))
+
+	tag, err := ReadOGG(bytes.NewReader(grouped.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, Speex, tag.Codec)
+	tag.SetTitle("Renamed")
+	out := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, out))
+	verifyPages(t, out.Bytes())
+
+	saved, err := ReadOGG(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "Renamed", saved.Title)
+	if assert.Len(t, saved.KateTags, 1) {
+		assert.Equal(t, "Transcript", saved.KateTags[0].Title)
+	}
+}
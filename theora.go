@@ -0,0 +1,140 @@
+package oggmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// theoraIdentSize is the length of the Theora identification header.
+const theoraIdentSize = 42
+
+// Theora color spaces.
+const (
+	TheoraColorSpaceUnspecified = 0
+	TheoraColorSpaceRec470M     = 1
+	TheoraColorSpaceRec470BG    = 2
+)
+
+// TheoraHeader is the identification header that starts every Ogg Theora stream.
+type TheoraHeader struct {
+	VersionMajor    uint8
+	VersionMinor    uint8
+	VersionRevision uint8
+
+	// the coded frame, a whole number of 16 pixel macroblocks
+	FrameWidth  uint32
+	FrameHeight uint32
+
+	// the visible region of the frame, PictureY counting from the bottom
+	PictureWidth  uint32
+	PictureHeight uint32
+	PictureX      uint32
+	PictureY      uint32
+
+	FrameRateNumerator   uint32
+	FrameRateDenominator uint32
+	AspectNumerator      uint32 // 0 if the pixel aspect ratio is unknown
+	AspectDenominator    uint32
+
+	ColorSpace     uint8
+	PixelFormat    uint8 // 0 4:2:0, 2 4:2:2, 3 4:4:4
+	NominalBitrate uint32
+	Quality        uint8
+	KeyframeShift  uint8 // bits of the granule position that count frames since a keyframe
+}
+
+// FrameRate returns the frames per second.
+func (h *TheoraHeader) FrameRate() float64 {
+	return float64(h.FrameRateNumerator) / float64(h.FrameRateDenominator)
+}
+
+// PixelAspect returns the width of a pixel over its height, 1 if unknown.
+func (h *TheoraHeader) PixelAspect() float64 {
+	if h.AspectNumerator == 0 || h.AspectDenominator == 0 {
+		return 1
+	}
+	return float64(h.AspectNumerator) / float64(h.AspectDenominator)
+}
+
+// frames returns the number of frames up to and including the one a granule position refers to.
+func (h *TheoraHeader) frames(granule int64) int64 {
+	keyframe := granule >> h.KeyframeShift
+	frames := keyframe + granule - keyframe<<h.KeyframeShift
+	if h.VersionMajor == 3 && h.VersionMinor == 2 && h.VersionRevision == 0 {
+		// before 3.2.1 granule positions counted from the first frame as 0
+		frames++
+	}
+	return frames
+}
+
+func parseTheoraHeader(data []byte) (*TheoraHeader, error) {
+	if !bytes.HasPrefix(data, TheoraIdentPrefix) || len(data) < theoraIdentSize {
+		return nil, &ErrInvalidTheoraHeader{Reason: "packet too short"}
+	}
+	uint24 := func(i int) uint32 {
+		return uint32(data[i])<<16 | uint32(data[i+1])<<8 | uint32(data[i+2])
+	}
+	h := &TheoraHeader{
+		VersionMajor:         data[7],
+		VersionMinor:         data[8],
+		VersionRevision:      data[9],
+		FrameWidth:           uint32(binary.BigEndian.Uint16(data[10:])) * 16,
+		FrameHeight:          uint32(binary.BigEndian.Uint16(data[12:])) * 16,
+		PictureWidth:         uint24(14),
+		PictureHeight:        uint24(17),
+		PictureX:             uint32(data[20]),
+		PictureY:             uint32(data[21]),
+		FrameRateNumerator:   binary.BigEndian.Uint32(data[22:]),
+		FrameRateDenominator: binary.BigEndian.Uint32(data[26:]),
+		AspectNumerator:      uint24(30),
+		AspectDenominator:    uint24(33),
+		ColorSpace:           data[36],
+		NominalBitrate:       uint24(37),
+		Quality:              data[40] >> 2,
+		KeyframeShift:        (data[40]&0x3)<<3 | data[41]>>5,
+		PixelFormat:          data[41] >> 3 & 0x3,
+	}
+	switch {
+	case h.VersionMajor != 3:
+		return nil, &ErrInvalidTheoraHeader{Reason: "unsupported version"}
+	case h.FrameWidth == 0 || h.FrameHeight == 0:
+		return nil, &ErrInvalidTheoraHeader{Reason: "frame size is zero"}
+	case h.PictureWidth+h.PictureX > h.FrameWidth || h.PictureHeight+h.PictureY > h.FrameHeight:
+		return nil, &ErrInvalidTheoraHeader{Reason: "picture region outside the frame"}
+	case h.FrameRateNumerator == 0 || h.FrameRateDenominator == 0:
+		return nil, &ErrInvalidTheoraHeader{Reason: "frame rate is zero"}
+	case h.PixelFormat == 1:
+		return nil, &ErrInvalidTheoraHeader{Reason: "reserved pixel format"}
+	}
+	return h, nil
+}
+
+// readTheoraComment reads a Theora comment header, which is a Vorbis comment
+// with its own prefix and no framing bit.
+func (dec *OGGDecoder) readTheoraComment(data []byte, head *TheoraHeader) (*OggTag, error) {
+	if head == nil {
+		return nil, &ErrInvalidTheoraHeader{Reason: "comment header before the identification header"}
+	}
+	dec.TagReader = bytes.NewReader(data[len(TheoraPrefix):])
+	tag, err := dec.readComments()
+	if err != nil {
+		return nil, err
+	}
+	tag.Codec = Theora
+	tag.TheoraHeader = head
+	return tag, nil
+}
+
+// theoraCommentPacket returns the Theora comment header SaveTags writes for
+// tag, or nothing to keep the one in the file.
+func theoraCommentPacket(tag *OggTag) []byte {
+	if tag.Codec == Theora {
+		return tagCommentPacket(tag)
+	}
+	if tag.TheoraTag == nil {
+		return nil
+	}
+	video := *tag.TheoraTag
+	video.Codec = Theora
+	return tagCommentPacket(&video)
+}
@@ -0,0 +1,136 @@
+package oggmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildTheoraFile writes a 320x240 Theora stream at 29.97 frames per second
+// with the given comment fields and stand-in frames, a keyframe every 10.
+func buildTheoraFile(t *testing.T, fields []string, frames int) []byte {
+	ident := make([]byte, theoraIdentSize)
+	copy(ident, TheoraIdentPrefix)
+	copy(ident[7:], []byte{3, 2, 1, 0, 20, 0, 15, 0x00, 0x01, 0x40, 0x00, 0x00, 0xf0, 0, 0})
+	binary.BigEndian.PutUint32(ident[22:], 30000)
+	binary.BigEndian.PutUint32(ident[26:], 1001)
+	copy(ident[30:], []byte{0, 0, 1, 0, 0, 1, TheoraColorSpaceRec470BG})
+	// quality 48, keyframe shift 6, 4:2:0
+	ident[40] = 48<<2 | 6>>3
+	ident[41] = 6 << 5
+
+	out := new(bytes.Buffer)
+	enc := &OGGEncoder{Writer: out, Serial: 0x7e0}
+	assert.NoError(t, enc.EncodeBOS(0, [][]byte{ident}))
+	comment := createCommentPacket(fields, nil, Theora)
+	assert.NoError(t, enc.Encode(0, [][]byte{comment, append([]byte("\x82theora"), 1, 2, 3)}))
+	for n := 1; n <= frames; n++ {
+		key := (n-1)/10*10 + 1
+		granule := int64(key)<<6 | int64(n-key)
+		frame := [][]byte{{0x40, byte(n)}}
+		if n == key {
+			frame[0][0] = 0
+		}
+		if n == frames {
+			assert.NoError(t, enc.EncodeEOS(granule, frame))
+		} else {
+			assert.NoError(t, enc.Encode(granule, frame))
+		}
+	}
+	return out.Bytes()
+}
+
+func TestReadTheora(t *testing.T) {
+	b := buildTheoraFile(t, []string{"TITLE=Clip", "DIRECTOR=Someone"}, 30)
+
+	tag, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, Theora, tag.Codec)
+	assert.Equal(t, "Clip", tag.Title)
+	assert.Equal(t, "Someone", tag.UnmappedFields["DIRECTOR"])
+	assert.Nil(t, tag.TheoraTag)
+	h := tag.TheoraHeader
+	if assert.NotNil(t, h) {
+		assert.Equal(t, uint32(320), h.FrameWidth)
+		assert.Equal(t, uint32(240), h.FrameHeight)
+		assert.Equal(t, uint32(320), h.PictureWidth)
+		assert.Equal(t, uint32(240), h.PictureHeight)
+		assert.Equal(t, uint32(0), h.PictureX)
+		assert.InDelta(t, 29.97, h.FrameRate(), 0.001)
+		assert.Equal(t, 1.0, h.PixelAspect())
+		assert.Equal(t, uint8(TheoraColorSpaceRec470BG), h.ColorSpace)
+		assert.Equal(t, uint8(48), h.Quality)
+		assert.Equal(t, uint8(6), h.KeyframeShift)
+		assert.Equal(t, uint8(0), h.PixelFormat)
+		assert.Equal(t, int64(12), h.frames(11<<6|1))
+	}
+
+	tag.SetTitle("Renamed Clip")
+	out := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, out))
+	verifyPages(t, out.Bytes())
+	saved, err := ReadOGG(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, Theora, saved.Codec)
+	assert.Equal(t, "Renamed Clip", saved.Title)
+	assert.Equal(t, "Someone", saved.UnmappedFields["DIRECTOR"])
+}
+
+func TestTheoraVorbisGrouped(t *testing.T) {
+	audio, err := os.ReadFile("./testdata/testdata-ogg-vorbis-nonEmpty.ogg")
+	assert.NoError(t, err)
+	video := buildTheoraFile(t, []string{"TITLE=Clip"}, 100)
+	grouped := new(bytes.Buffer)
+	assert.NoError(t, Mux(grouped, bytes.NewReader(video), bytes.NewReader(audio)))
+
+	tag, err := ReadOGG(bytes.NewReader(grouped.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, Vorbis, tag.Codec)
+	assert.NotEqual(t, "", tag.Title)
+	assert.NotNil(t, tag.TheoraHeader)
+	if !assert.NotNil(t, tag.TheoraTag) {
+		return
+	}
+	assert.Equal(t, "Clip", tag.TheoraTag.Title)
+
+	tag.SetTitle("Audio Title")
+	tag.TheoraTag.SetTitle("Video Title")
+	out := new(bytes.Buffer)
+	assert.NoError(t, SaveTags(tag, out))
+	verifyPages(t, out.Bytes())
+
+	saved, err := ReadOGG(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "Audio Title", saved.Title)
+	assert.Equal(t, "Video Title", saved.TheoraTag.Title)
+
+	// both streams survive with their own serials and their data intact
+	streams, err := Demux(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	if !assert.Len(t, streams, 2) {
+		return
+	}
+	for i, original := range [][]byte{video, audio} {
+		want := readAllPackets(t, original)
+		got := readAllPackets(t, streams[i].Data)
+		if assert.Equal(t, len(want), len(got)) {
+			// skip the headers, the comment being the second
+			assert.Equal(t, want[2:], got[2:])
+		}
+	}
+}
+
+func readAllPackets(t *testing.T, b []byte) [][]byte {
+	dec := &OGGDecoder{Reader: bytes.NewReader(b)}
+	var packets [][]byte
+	for {
+		packet, err := dec.ReadPacket()
+		if err != nil {
+			return packets
+		}
+		packets = append(packets, packet.Data)
+	}
+}
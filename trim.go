@@ -0,0 +1,432 @@
+package oggmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/gcottom/oggmeta/opus"
+	"github.com/gcottom/oggmeta/vorbis"
+)
+
+// opusPreroll is how much audio before a cut is kept for the decoder to
+// converge, as RFC 7845 section 4.5 recommends. It is hidden by pre-skip.
+const opusPreroll = 3840
+
+// untilEnd is the end of a range that keeps everything after its start.
+const untilEnd = time.Duration(math.MaxInt64)
+
+// Trim copies the Opus or Vorbis audio of r between start and end to w
+// without re-encoding. An end of 0 keeps everything after start. A Vorbis
+// range that ends in the packet it starts in begins at the start of that
+// packet, since a lone page can only be trimmed at one end.
+func Trim(r io.ReadSeeker, w io.Writer, start, end time.Duration) error {
+	src, err := analyzeTrim(r)
+	if err != nil {
+		return err
+	}
+	if end == 0 {
+		end = untilEnd
+	}
+	return src.trim(r, w, start, end)
+}
+
+// Split cuts r at the given points, which must be positive and strictly
+// increasing, and returns one stand-alone file per part, as Trim would write
+// them.
+func Split(r io.ReadSeeker, points ...time.Duration) ([][]byte, error) {
+	for i, point := range points {
+		if i == 0 && point <= 0 {
+			return nil, &ErrInvalidRange{Start: 0, End: point}
+		}
+		if i > 0 && point <= points[i-1] {
+			return nil, &ErrInvalidRange{Start: points[i-1], End: point}
+		}
+	}
+	src, err := analyzeTrim(r)
+	if err != nil {
+		return nil, err
+	}
+	var parts [][]byte
+	start := time.Duration(0)
+	for i := 0; i <= len(points); i++ {
+		end := untilEnd
+		if i < len(points) {
+			end = points[i]
+		}
+		out := new(bytes.Buffer)
+		if err := src.trim(r, out, start, end); err != nil {
+			return nil, err
+		}
+		parts = append(parts, out.Bytes())
+		start = end
+	}
+	return parts, nil
+}
+
+// trimSource is what trimming needs to know about a whole file, found once
+// for all the parts Split and SplitCue cut from it.
+type trimSource struct {
+	info    *streamInfo
+	opus    *OpusStats
+	vorbis  *VorbisStats
+	comment []byte // Vorbis comment header without track gain fields
+}
+
+func analyzeTrim(r io.ReadSeeker) (*trimSource, error) {
+	info, err := readStreamInfo(r)
+	if err != nil {
+		return nil, err
+	}
+	src := &trimSource{info: info}
+	switch info.codec {
+	case Opus:
+		src.opus, err = AnalyzeOpus(r)
+		return src, err
+	case Vorbis:
+		if src.vorbis, err = AnalyzeVorbis(r); err != nil {
+			return nil, err
+		}
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		tag, err := ReadOGG(r)
+		if err != nil {
+			return nil, err
+		}
+		src.comment, err = filterCommentPacket(tagCommentPacket(tag), VorbisPrefix, trackGainField)
+		return src, err
+	}
+	return nil, &ErrUnsupportedCodec{}
+}
+
+// trim copies the audio of r between start and end to w, as Trim does, but
+// with untilEnd rather than 0 for an end that keeps the rest of the stream.
+func (src *trimSource) trim(r io.ReadSeeker, w io.Writer, start, end time.Duration) error {
+	if start < 0 || end <= start {
+		return &ErrInvalidRange{Start: start, End: end}
+	}
+	from := durationSamples(start, src.info.rate)
+	to := int64(-1)
+	if end != untilEnd {
+		to = durationSamples(end, src.info.rate)
+	}
+	if src.opus != nil {
+		return trimOpus(r, w, src.opus, from, to)
+	}
+	return trimVorbis(r, w, src.vorbis, src.comment, from, to)
+}
+
+func durationSamples(d time.Duration, rate int) int64 {
+	return int64(d/time.Second)*int64(rate) + int64(d%time.Second)*int64(rate)/int64(time.Second)
+}
+
+// trimOpus keeps output samples [from, to) of an Opus stream, to < 0 meaning
+// the end of the stream.
+func trimOpus(r io.ReadSeeker, w io.Writer, stats *OpusStats, from, to int64) error {
+	playable := stats.PlayableSamples()
+	if to < 0 || to > playable {
+		to = playable
+	}
+	if from >= to {
+		return &ErrInvalidRange{Start: samplesDuration(from, 48000), End: samplesDuration(to, 48000)}
+	}
+	// positions below count coded samples from the first audio packet
+	targetStart := from + stats.PreSkip
+	targetEnd := to + stats.PreSkip
+	keepFrom := targetStart - opusPreroll
+	if keepFrom < 0 {
+		keepFrom = 0
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	packets := newPacketReader(&OGGDecoder{Reader: r}, 0, 0)
+	head, err := packets.next()
+	if err != nil {
+		return err
+	}
+	tags, err := nextPacketOf(packets, head.Serial)
+	if err != nil {
+		return err
+	}
+	opusHead, err := parseOpusHead(head.Data)
+	if err != nil {
+		return err
+	}
+	tagsData, err := filterCommentPacket(tags.Data, OpusPrefix, trackGainField)
+	if err != nil {
+		return err
+	}
+
+	pages := &packetPager{enc: &OGGEncoder{Writer: w, Serial: head.Serial}}
+	var position, first int64 = 0, -1
+	for position < targetEnd {
+		packet, err := nextPacketOf(packets, head.Serial)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		samples, err := opusPacketSamples(packet.Data)
+		if err != nil {
+			return err
+		}
+		if position+samples > keepFrom {
+			if first < 0 {
+				first = position
+				// the header goes out once pre-skip is known
+				opusHead.PreSkip = uint16(targetStart - first)
+				if err := pages.enc.EncodeBOS(0, [][]byte{opusHead.toBytesSlice()}); err != nil {
+					return err
+				}
+				if err := pages.enc.Encode(0, [][]byte{tagsData}); err != nil {
+					return err
+				}
+			}
+			if err := pages.add(packet.Data, position+samples-first); err != nil {
+				return err
+			}
+		}
+		position += samples
+	}
+	if first < 0 {
+		return &ErrInvalidRange{Start: samplesDuration(from, 48000), End: samplesDuration(to, 48000)}
+	}
+	return pages.finish(targetEnd - first)
+}
+
+// trimVorbis keeps output samples [from, to) of a Vorbis stream, writing
+// comment as its comment header.
+func trimVorbis(r io.ReadSeeker, w io.Writer, stats *VorbisStats, comment []byte, from, to int64) error {
+	playable := stats.PlayableSamples()
+	if to < 0 || to > playable {
+		to = playable
+	}
+	if from >= to {
+		return &ErrInvalidRange{Start: samplesDuration(from, stats.SampleRate), End: samplesDuration(to, stats.SampleRate)}
+	}
+	// positions below count decoded samples, which include any lead-in the
+	// source itself trims
+	var lead int64
+	if stats.StartGranule < 0 {
+		lead = -stats.StartGranule
+	}
+	targetStart := from + lead
+	targetEnd := to + lead
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	packets := newPacketReader(&OGGDecoder{Reader: r}, 0, 0)
+	ident, err := packets.next()
+	if err != nil {
+		return err
+	}
+	headers := [][]byte{ident.Data}
+	dec := vorbis.NewDecoder()
+	if err := dec.ReadHeader(ident.Data); err != nil {
+		return err
+	}
+	for !dec.HeadersRead() {
+		packet, err := nextPacketOf(packets, ident.Serial)
+		if err != nil {
+			return err
+		}
+		if err := dec.ReadHeader(packet.Data); err != nil {
+			return err
+		}
+		headers = append(headers, packet.Data)
+	}
+
+	pages := &packetPager{enc: &OGGEncoder{Writer: w, Serial: ident.Serial}}
+	var position int64
+	var previous []byte
+	previousSize := 0
+	started, split := false, false
+	for position < targetEnd {
+		packet, err := nextPacketOf(packets, ident.Serial)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		size := dec.BlockSize(packet.Data)
+		if size == 0 {
+			continue
+		}
+		var samples int64
+		if previousSize > 0 {
+			samples = int64(previousSize/4 + size/4)
+		}
+		if !started && samples > 0 && position+samples > targetStart {
+			started = true
+			if err := pages.enc.EncodeBOS(0, headers[:1]); err != nil {
+				return err
+			}
+			if err := pages.enc.Encode(0, [][]byte{comment, headers[2]}); err != nil {
+				return err
+			}
+			if err := pages.add(previous, position-targetStart); err != nil {
+				return err
+			}
+		}
+		if started {
+			if split {
+				// a lone first page would read as end trimming
+				if err := pages.flush(); err != nil {
+					return err
+				}
+			}
+			if err := pages.add(packet.Data, position+samples-targetStart); err != nil {
+				return err
+			}
+			// the first page is only closed once another packet follows it,
+			// so the EOS page always carries audio
+			split = position <= targetStart
+		}
+		position += samples
+		previous, previousSize = packet.Data, size
+	}
+	if !started {
+		return &ErrInvalidRange{Start: samplesDuration(from, stats.SampleRate), End: samplesDuration(to, stats.SampleRate)}
+	}
+	return pages.finish(targetEnd - targetStart)
+}
+
+func samplesDuration(samples int64, rate int) time.Duration {
+	return time.Duration(samples) * time.Second / time.Duration(rate)
+}
+
+func opusPacketSamples(data []byte) (int64, error) {
+	p, err := opus.ParsePacket(data)
+	if err != nil {
+		return 0, err
+	}
+	return int64(p.Samples()), nil
+}
+
+// nextPacketOf returns the next packet of serial, skipping other streams.
+func nextPacketOf(packets *packetReader, serial uint32) (*Packet, error) {
+	for {
+		packet, err := packets.next()
+		if err != nil || packet.Serial == serial {
+			return packet, err
+		}
+	}
+}
+
+// packetPager collects packets into pages of at most 255 segments, each page
+// carrying the granule position of its last packet.
+type packetPager struct {
+	enc      *OGGEncoder
+	packets  [][]byte
+	segments int
+	granule  int64
+}
+
+func (p *packetPager) add(packet []byte, granule int64) error {
+	segments := len(packet)/MaxSegSize + 1
+	if p.segments+segments > MaxSegSize {
+		if err := p.flush(); err != nil {
+			return err
+		}
+	}
+	p.packets = append(p.packets, packet)
+	p.segments += segments
+	p.granule = granule
+	return nil
+}
+
+// flush writes the packets collected so far as a page.
+func (p *packetPager) flush() error {
+	if len(p.packets) == 0 {
+		return nil
+	}
+	if err := p.enc.Encode(p.granule, p.packets); err != nil {
+		return err
+	}
+	p.packets, p.segments = nil, 0
+	return nil
+}
+
+// finish writes the last page with the EOS flag and the given granule position.
+func (p *packetPager) finish(granule int64) error {
+	return p.enc.EncodeEOS(granule, p.packets)
+}
+
+// trackGainField reports the fields that describe the loudness of a whole track.
+func trackGainField(name string) bool {
+	switch strings.ToUpper(name) {
+	case ReplayGainTrackGain, ReplayGainTrackPeak, R128TrackGain:
+		return true
+	}
+	return false
+}
+
+// filterCommentPacket returns a copy of a comment packet without the fields
+// drop returns true for.
+func filterCommentPacket(data, prefix []byte, drop func(name string) bool) ([]byte, error) {
+	if !bytes.HasPrefix(data, prefix) {
+		return nil, &ErrInvalidOggs{}
+	}
+	rest := data[len(prefix):]
+	field := func() ([]byte, error) {
+		if len(rest) < 4 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		n := binary.LittleEndian.Uint32(rest)
+		if uint64(n) > uint64(len(rest)-4) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		f := rest[4 : 4+n]
+		rest = rest[4+n:]
+		return f, nil
+	}
+	vendor, err := field()
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < 4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	count := binary.LittleEndian.Uint32(rest)
+	rest = rest[4:]
+	var kept [][]byte
+	for i := uint32(0); i < count; i++ {
+		f, err := field()
+		if err != nil {
+			return nil, err
+		}
+		name := f
+		if eq := bytes.IndexByte(f, '='); eq >= 0 {
+			name = f[:eq]
+		}
+		if !drop(string(name)) {
+			kept = append(kept, f)
+		}
+	}
+
+	out := append([]byte{}, prefix...)
+	out = appendUint32(out, uint32(len(vendor)))
+	out = append(out, vendor...)
+	out = appendUint32(out, uint32(len(kept)))
+	for _, f := range kept {
+		out = appendUint32(out, uint32(len(f)))
+		out = append(out, f...)
+	}
+	// anything after the fields, such as the Vorbis framing bit, is kept
+	return append(out, rest...), nil
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
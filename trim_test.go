@@ -0,0 +1,227 @@
+package oggmeta
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrimOpus(t *testing.T) {
+	src, err := os.ReadFile("./testdata/testdata-opus-nonEmpty.ogg")
+	assert.NoError(t, err)
+	orig, err := AnalyzeOpus(bytes.NewReader(src))
+	assert.NoError(t, err)
+	tag, err := ReadOGG(bytes.NewReader(src))
+	assert.NoError(t, err)
+
+	for _, tc := range []struct {
+		start, end time.Duration
+		samples    int64
+	}{
+		{0, time.Second, 48000},
+		{time.Second, 2 * time.Second, 48000},
+		{1234 * time.Millisecond, 0, orig.PlayableSamples() - 59232},
+		{0, 0, orig.PlayableSamples()},
+	} {
+		out := new(bytes.Buffer)
+		assert.NoError(t, Trim(bytes.NewReader(src), out, tc.start, tc.end))
+		verifyPages(t, out.Bytes())
+
+		stats, err := AnalyzeOpus(bytes.NewReader(out.Bytes()))
+		assert.NoError(t, err)
+		assert.Empty(t, stats.GranuleMismatches)
+		assert.Equal(t, tc.samples, stats.PlayableSamples(), "%v to %v", tc.start, tc.end)
+		if tc.start == 0 {
+			assert.Equal(t, orig.PreSkip, stats.PreSkip)
+		} else {
+			assert.True(t, stats.PreSkip >= opusPreroll)
+		}
+
+		trimmed, err := ReadOGG(bytes.NewReader(out.Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, tag.Title, trimmed.Title)
+		assert.Equal(t, tag.Artist, trimmed.Artist)
+	}
+}
+
+func TestTrimInvalidRange(t *testing.T) {
+	src, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	out := new(bytes.Buffer)
+	assert.IsType(t, &ErrInvalidRange{}, Trim(bytes.NewReader(src), out, 2*time.Second, time.Second))
+	assert.IsType(t, &ErrInvalidRange{}, Trim(bytes.NewReader(src), out, time.Hour, 0))
+}
+
+func TestSplitOpus(t *testing.T) {
+	src, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	orig, err := AnalyzeOpus(bytes.NewReader(src))
+	assert.NoError(t, err)
+
+	parts, err := Split(bytes.NewReader(src), time.Second, 2500*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Len(t, parts, 3)
+	var total int64
+	for _, part := range parts {
+		stats, err := AnalyzeOpus(bytes.NewReader(part))
+		assert.NoError(t, err)
+		total += stats.PlayableSamples()
+	}
+	assert.Equal(t, orig.PlayableSamples(), total)
+
+	// points must be positive and strictly increasing
+	for _, points := range [][]time.Duration{{0}, {-time.Second}, {2 * time.Second, time.Second}, {time.Second, time.Second}} {
+		_, err := Split(bytes.NewReader(src), points...)
+		assert.IsType(t, &ErrInvalidRange{}, err, "%v", points)
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.ReadSeeker
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadSeeker.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestSplitAnalyzesOnce(t *testing.T) {
+	src, err := os.ReadFile("./testdata/testdata-opus.ogg")
+	assert.NoError(t, err)
+	points := []time.Duration{time.Second, 2500 * time.Millisecond}
+
+	split := &countingReader{ReadSeeker: bytes.NewReader(src)}
+	_, err = Split(split, points...)
+	assert.NoError(t, err)
+	trims := &countingReader{ReadSeeker: bytes.NewReader(src)}
+	start := time.Duration(0)
+	for _, end := range append(points, 0) {
+		assert.NoError(t, Trim(trims, new(bytes.Buffer), start, end))
+		start = end
+	}
+	// each Trim reads the whole file once to analyse it
+	assert.LessOrEqual(t, split.n, trims.n-2*len(src))
+}
+
+func TestFilterCommentPacket(t *testing.T) {
+	packet := createCommentPacket([]string{"TITLE=a", "R128_TRACK_GAIN=-256", "replaygain_track_peak=1.0", "R128_ALBUM_GAIN=12"}, nil, Vorbis)
+	filtered, err := filterCommentPacket(packet, VorbisPrefix, trackGainField)
+	assert.NoError(t, err)
+	assert.Equal(t, createCommentPacket([]string{"TITLE=a", "R128_ALBUM_GAIN=12"}, nil, Vorbis), filtered)
+}
+
+// decodeVorbisFile decodes the left channel of b the way a player would,
+// honouring start and end trimming.
+func decodeVorbisFile(t *testing.T, b []byte) []float32 {
+	packets := newPacketReader(&OGGDecoder{Reader: bytes.NewReader(b)}, 0, 0)
+	head, err := packets.next()
+	assert.NoError(t, err)
+	dec, err := newVorbisPCM(head.Data)
+	assert.NoError(t, err)
+	var out []float32
+	for {
+		packet, err := packets.next()
+		if err == io.EOF {
+			return out
+		}
+		assert.NoError(t, err)
+		pcm, err := dec.decode(packet)
+		assert.NoError(t, err)
+		if len(pcm) > 0 {
+			out = append(out, pcm[0]...)
+		}
+	}
+}
+
+func TestTrimVorbis(t *testing.T) {
+	src, err := os.ReadFile("./testdata/testdata-ogg-vorbis-nonEmpty.ogg")
+	assert.NoError(t, err)
+	full := decodeVorbisFile(t, src)
+	assert.Len(t, full, 149880)
+	tag, err := ReadOGG(bytes.NewReader(src))
+	assert.NoError(t, err)
+
+	for _, tc := range []struct {
+		start, end time.Duration
+		from, to   int
+	}{
+		{0, time.Second, 0, 44100},
+		{time.Second, 2 * time.Second, 44100, 88200},
+		{1234 * time.Millisecond, 0, 54419, 149880},
+		{0, 0, 0, 149880},
+	} {
+		out := new(bytes.Buffer)
+		assert.NoError(t, Trim(bytes.NewReader(src), out, tc.start, tc.end))
+		verifyPages(t, out.Bytes())
+
+		stats, err := AnalyzeVorbis(bytes.NewReader(out.Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(tc.to-tc.from), stats.PlayableSamples(), "%v to %v", tc.start, tc.end)
+
+		pcm := decodeVorbisFile(t, out.Bytes())
+		if assert.Len(t, pcm, tc.to-tc.from, "%v to %v", tc.start, tc.end) {
+			assert.InDeltaSlice(t, full[tc.from:tc.to], pcm, 1e-6)
+		}
+
+		trimmed, err := ReadOGG(bytes.NewReader(out.Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, tag.Title, trimmed.Title)
+		assert.Equal(t, tag.Artist, trimmed.Artist)
+	}
+}
+
+func TestTrimVorbisWithinPacket(t *testing.T) {
+	src, err := os.ReadFile("./testdata/testdata-ogg-vorbis-nonEmpty.ogg")
+	assert.NoError(t, err)
+	full := decodeVorbisFile(t, src)
+
+	// 88 samples from the middle of a block
+	out := new(bytes.Buffer)
+	assert.NoError(t, Trim(bytes.NewReader(src), out, time.Second, time.Second+2*time.Millisecond))
+	pages := verifyPages(t, out.Bytes())
+	assert.Len(t, pages, 3)
+	last := pages[len(pages)-1]
+	assert.Equal(t, byte(FlagEOS), last.Flags&FlagEOS)
+	assert.Equal(t, int64(88), last.GranulePosition)
+	var granule int64
+	for _, page := range pages {
+		if page.GranulePosition >= 0 {
+			assert.GreaterOrEqual(t, page.GranulePosition, granule)
+			granule = page.GranulePosition
+		}
+	}
+
+	// the range starts at the start of the packet holding it
+	pcm := decodeVorbisFile(t, out.Bytes())
+	if assert.Len(t, pcm, 88) {
+		from := -1
+		for i := 44100; i > 44100-2048 && from < 0; i-- {
+			if assert.ObjectsAreEqualValues(full[i:i+88], pcm) {
+				from = i
+			}
+		}
+		assert.GreaterOrEqual(t, from, 0)
+	}
+}
+
+func TestSplitVorbis(t *testing.T) {
+	src, err := os.ReadFile("./testdata/test1.ogg")
+	assert.NoError(t, err)
+	full := decodeVorbisFile(t, src)
+
+	parts, err := Split(bytes.NewReader(src), time.Second, 2500*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Len(t, parts, 3)
+	var joined []float32
+	for _, part := range parts {
+		joined = append(joined, decodeVorbisFile(t, part)...)
+	}
+	assert.InDeltaSlice(t, full, joined, 1e-6)
+}
@@ -5,6 +5,10 @@ import "io"
 const (
 	Vorbis = "vorbis"
 	Opus   = "opus"
+	FLAC   = "flac"
+	Speex  = "speex"
+	Theora = "theora"
+	Kate   = "kate"
 )
 
 const (
@@ -21,11 +25,24 @@ const (
 )
 
 var (
-	Oggs         = [4]byte{'O', 'g', 'g', 'S'}
-	VorbisPrefix = []byte("\x03vorbis")
-	OpusPrefix   = []byte("OpusTags")
+	Oggs              = [4]byte{'O', 'g', 'g', 'S'}
+	VorbisPrefix      = []byte("\x03vorbis")
+	VorbisIdentPrefix = []byte("\x01vorbis")
+	OpusPrefix        = []byte("OpusTags")
+	OpusHeadPrefix    = []byte("OpusHead")
+	FLACPrefix        = []byte("\x7fFLAC")
+	SpeexPrefix       = []byte("Speex   ")
+	TheoraPrefix      = []byte("\x81theora")
+	TheoraIdentPrefix = []byte("\x80theora")
+	KatePrefix        = []byte("\x81kate\x00\x00\x00")
+	KateIdentPrefix   = []byte("\x80kate\x00\x00\x00")
+	SkeletonPrefix    = []byte("fishead\x00")
+	FisbonePrefix     = []byte("fisbone\x00")
+	IndexPrefix       = []byte("index\x00")
 )
 
+const pictureFieldPrefix = "METADATA_BLOCK_PICTURE="
+
 var dummyPacket = [1][]byte{{}}
 
 var tagFieldMapping = map[string]string{"ALBUM": "Album", "ALBUMARTIST": "AlbumArtist", "ARTIST": "Artist", "BPM": "BPM", "COMPOSER": "Composer", "COPYRIGHT": "Copyright", "DISCNUMBER": "DiscNumber", "DISCTOTAL": "DiscTotal", "ENCODER": "Encoder", "GENRE": "Genre", "TITLE": "Title", "TRACKNUMBER": "TrackNumber", "TRACKTOTAL": "TrackTotal"}
@@ -46,11 +63,37 @@ type OGGPageHeader struct {
 type OGGPage struct {
 	Header  OGGPageHeader
 	Packets [][]byte
+
+	continues bool // last packet is completed on a following page
 }
 
 type OGGDecoder struct {
 	Reader    io.ReadSeeker
 	TagReader io.ReadSeeker
+	Limits    Limits
+	Progress  ProgressFunc
+
+	packets   *packetReader
+	bytesRead int64
+	pagesRead int64
+}
+
+// ProgressFunc is called after every page with the running totals of bytes
+// and pages read from the source.
+type ProgressFunc func(bytes, pages int64)
+
+// SaveOptions tunes SaveTagsContext. The zero value behaves like SaveTags.
+type SaveOptions struct {
+	Progress ProgressFunc
+
+	// FixGranules replaces the granule position of every Vorbis page with
+	// the one its packets' block sizes add up to, see AnalyzeVorbis.
+	FixGranules bool
+
+	// SkeletonIndex adds a Skeleton 4 stream with a keyframe index to the
+	// saved file, or refreshes the one it has, see IndexSkeleton. Matroska
+	// files are saved without one.
+	SkeletonIndex bool
 }
 
 type OGGEncoder struct {
@@ -31,6 +31,21 @@ func readString(reader io.Reader, n uint) (string, error) {
 	return string(data), nil
 }
 
+func bytesRemaining(s io.Seeker) (int64, error) {
+	cur, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.Seek(cur, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return end - cur, nil
+}
+
 func getInt(b []byte) int {
 	var n int
 	for _, x := range b {
@@ -0,0 +1,52 @@
+package vorbis
+
+// bitReader reads Vorbis packets, which pack fields LSb first.
+type bitReader struct {
+	data []byte
+	pos  int  // next byte
+	bit  uint // next bit within data[pos]
+	eop  bool // a read ran past the end of the packet
+}
+
+func newBitReader(data []byte) *bitReader {
+	return &bitReader{data: data}
+}
+
+// read returns the next n bits, n <= 32. Bits past the end of the packet read
+// as zero and set eop.
+func (r *bitReader) read(n uint) uint32 {
+	var v uint32
+	for i := uint(0); i < n; {
+		if r.pos >= len(r.data) {
+			r.eop = true
+			return 0
+		}
+		take := 8 - r.bit
+		if take > n-i {
+			take = n - i
+		}
+		bits := uint32(r.data[r.pos]>>r.bit) & (1<<take - 1)
+		v |= bits << i
+		i += take
+		r.bit += take
+		if r.bit == 8 {
+			r.bit = 0
+			r.pos++
+		}
+	}
+	return v
+}
+
+func (r *bitReader) readBool() bool {
+	return r.read(1) == 1
+}
+
+// ilog returns the position of the highest set bit, counting from 1.
+func ilog(x int) uint {
+	var n uint
+	for x > 0 {
+		n++
+		x >>= 1
+	}
+	return n
+}
@@ -0,0 +1,260 @@
+package vorbis
+
+import "math"
+
+const (
+	codebookSync = 0x564342
+	maxVQValues  = 1 << 24
+)
+
+type codebook struct {
+	dimensions int
+	entries    int
+	lengths    []uint8 // 0 marks an unused entry
+
+	// tree holds the Huffman tree as pairs of children. A child >= 0 is the
+	// index of another pair, a child < 0 is the leaf ^entry.
+	tree []int32
+
+	lookupType int
+	vq         []float32 // entries * dimensions values, when lookupType != 0
+}
+
+func readCodebook(r *bitReader) (*codebook, error) {
+	if r.read(24) != codebookSync {
+		return nil, &ErrInvalidHeader{Reason: "missing codebook sync pattern"}
+	}
+	cb := &codebook{
+		dimensions: int(r.read(16)),
+		entries:    int(r.read(24)),
+	}
+	if cb.entries == 0 {
+		return nil, &ErrInvalidHeader{Reason: "codebook has no entries"}
+	}
+	if r.eop {
+		return nil, &ErrInvalidHeader{Reason: "setup header truncated"}
+	}
+	cb.lengths = make([]uint8, cb.entries)
+
+	if !r.readBool() {
+		sparse := r.readBool()
+		for i := range cb.lengths {
+			if !sparse || r.readBool() {
+				cb.lengths[i] = uint8(r.read(5) + 1)
+			}
+			if r.eop {
+				return nil, &ErrInvalidHeader{Reason: "setup header truncated"}
+			}
+		}
+	} else {
+		entry := 0
+		length := uint8(r.read(5) + 1)
+		for entry < cb.entries {
+			n := int(r.read(ilog(cb.entries - entry)))
+			if entry+n > cb.entries || length > 32 {
+				return nil, &ErrInvalidHeader{Reason: "invalid ordered codebook lengths"}
+			}
+			for i := entry; i < entry+n; i++ {
+				cb.lengths[i] = length
+			}
+			entry += n
+			length++
+			if r.eop {
+				return nil, &ErrInvalidHeader{Reason: "setup header truncated"}
+			}
+		}
+	}
+
+	cb.lookupType = int(r.read(4))
+	switch cb.lookupType {
+	case 0:
+	case 1, 2:
+		if cb.dimensions == 0 || cb.entries*cb.dimensions > maxVQValues {
+			return nil, &ErrInvalidHeader{Reason: "invalid codebook dimensions"}
+		}
+		minimum := float32Unpack(r.read(32))
+		delta := float32Unpack(r.read(32))
+		valueBits := uint(r.read(4) + 1)
+		sequenceP := r.readBool()
+		var lookupValues int
+		if cb.lookupType == 1 {
+			lookupValues = lookup1Values(cb.entries, cb.dimensions)
+		} else {
+			lookupValues = cb.entries * cb.dimensions
+		}
+		multiplicands := make([]uint32, lookupValues)
+		for i := range multiplicands {
+			multiplicands[i] = r.read(valueBits)
+		}
+		if r.eop {
+			return nil, &ErrInvalidHeader{Reason: "setup header truncated"}
+		}
+		cb.unpackVQ(multiplicands, minimum, delta, sequenceP)
+	default:
+		return nil, &ErrInvalidHeader{Reason: "invalid codebook lookup type"}
+	}
+
+	if err := cb.buildTree(); err != nil {
+		return nil, err
+	}
+	return cb, nil
+}
+
+func (cb *codebook) unpackVQ(multiplicands []uint32, minimum, delta float32, sequenceP bool) {
+	cb.vq = make([]float32, cb.entries*cb.dimensions)
+	for entry := 0; entry < cb.entries; entry++ {
+		var last float32
+		out := cb.vq[entry*cb.dimensions : (entry+1)*cb.dimensions]
+		if cb.lookupType == 1 {
+			divisor := 1
+			for i := range out {
+				offset := (entry / divisor) % len(multiplicands)
+				out[i] = float32(multiplicands[offset])*delta + minimum + last
+				if sequenceP {
+					last = out[i]
+				}
+				divisor *= len(multiplicands)
+			}
+		} else {
+			offset := entry * cb.dimensions
+			for i := range out {
+				out[i] = float32(multiplicands[offset])*delta + minimum + last
+				if sequenceP {
+					last = out[i]
+				}
+				offset++
+			}
+		}
+	}
+}
+
+// buildTree assigns codewords the way the specification does: each used
+// entry in order takes the lowest free codeword of its length.
+func (cb *codebook) buildTree() error {
+	used := 0
+	single := -1
+	for i, l := range cb.lengths {
+		if l > 0 {
+			used++
+			single = i
+		}
+	}
+	cb.tree = []int32{0, 0}
+	if used == 0 {
+		return nil
+	}
+	if used == 1 {
+		// a lone entry decodes from either bit value
+		cb.tree[0], cb.tree[1] = ^int32(single), ^int32(single)
+		return nil
+	}
+
+	var available [33]uint32
+	first := true
+	for entry, l := range cb.lengths {
+		if l == 0 {
+			continue
+		}
+		length := int(l)
+		var code uint32
+		if first {
+			first = false
+			for i := 1; i <= length; i++ {
+				available[i] = 1 << (32 - i)
+			}
+		} else {
+			z := length
+			for z > 0 && available[z] == 0 {
+				z--
+			}
+			if z == 0 {
+				return &ErrInvalidHeader{Reason: "overspecified codebook"}
+			}
+			code = available[z]
+			available[z] = 0
+			for y := length; y > z; y-- {
+				available[y] = code + 1<<(32-y)
+			}
+		}
+		cb.insert(code, length, entry)
+	}
+	return nil
+}
+
+// insert adds the codeword held in the top length bits of code.
+func (cb *codebook) insert(code uint32, length, entry int) {
+	node := 0
+	for i := 0; i < length; i++ {
+		bit := int(code>>(31-i)) & 1
+		slot := node*2 + bit
+		if i == length-1 {
+			cb.tree[slot] = ^int32(entry)
+			return
+		}
+		if cb.tree[slot] <= 0 {
+			cb.tree = append(cb.tree, 0, 0)
+			cb.tree[slot] = int32(len(cb.tree)/2 - 1)
+		}
+		node = int(cb.tree[slot])
+	}
+}
+
+// decodeScalar returns the next entry number, or -1 at the end of the packet
+// or on a codeword the codebook does not define.
+func (cb *codebook) decodeScalar(r *bitReader) int {
+	node := 0
+	for {
+		child := cb.tree[node*2+int(r.read(1))]
+		if r.eop {
+			return -1
+		}
+		if child < 0 {
+			return int(^child)
+		}
+		if child == 0 {
+			return -1
+		}
+		node = int(child)
+	}
+}
+
+// decodeVector returns the VQ values of the next entry, or nil.
+func (cb *codebook) decodeVector(r *bitReader) []float32 {
+	entry := cb.decodeScalar(r)
+	if entry < 0 || cb.vq == nil {
+		return nil
+	}
+	return cb.vq[entry*cb.dimensions : (entry+1)*cb.dimensions]
+}
+
+func float32Unpack(x uint32) float32 {
+	mantissa := float64(x & 0x1fffff)
+	exponent := int((x & 0x7fe00000) >> 21)
+	if x&0x80000000 != 0 {
+		mantissa = -mantissa
+	}
+	return float32(math.Ldexp(mantissa, exponent-788))
+}
+
+// lookup1Values returns the greatest r for which r^dimensions <= entries.
+func lookup1Values(entries, dimensions int) int {
+	r := int(math.Floor(math.Pow(float64(entries), 1/float64(dimensions))))
+	for pow(r+1, dimensions) <= entries {
+		r++
+	}
+	for r > 0 && pow(r, dimensions) > entries {
+		r--
+	}
+	return r
+}
+
+func pow(b, e int) int {
+	n := 1
+	for i := 0; i < e; i++ {
+		n *= b
+		if n > 1<<30 {
+			return n
+		}
+	}
+	return n
+}
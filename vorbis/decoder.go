@@ -0,0 +1,436 @@
+// Package vorbis decodes Vorbis I audio packets to float32 PCM.
+package vorbis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+const (
+	packetIdent   = 1
+	packetComment = 3
+	packetSetup   = 5
+)
+
+var vorbisMagic = []byte("vorbis")
+
+// Ident is the identification header.
+type Ident struct {
+	Version        uint32
+	Channels       int
+	SampleRate     int
+	BitrateMaximum int32
+	BitrateNominal int32
+	BitrateMinimum int32
+	Blocksize0     int
+	Blocksize1     int
+}
+
+type mapping struct {
+	magnitudes []int
+	angles     []int
+	mux        []int
+	floors     []int // per submap
+	residues   []int // per submap
+}
+
+type mode struct {
+	blockFlag bool
+	mapping   int
+}
+
+type setup struct {
+	books    []*codebook
+	floors   []floor
+	residues []*residue
+	mappings []mapping
+	modes    []mode
+}
+
+// Decoder decodes the packets of a single Vorbis stream.
+type Decoder struct {
+	Ident Ident
+
+	setup    *setup
+	headers  int
+	imdct    [2]*imdct
+	windows  map[[3]int][]float32
+	previous [][]float32 // windowed right half of the last block per channel
+	prevN    int
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{windows: make(map[[3]int][]float32)}
+}
+
+// HeadersRead reports whether all three header packets have been read.
+func (d *Decoder) HeadersRead() bool {
+	return d.headers == 3
+}
+
+// ReadHeader reads the identification, comment and setup headers, which must
+// be passed in that order.
+func (d *Decoder) ReadHeader(packet []byte) error {
+	want := [3]byte{packetIdent, packetComment, packetSetup}
+	if d.headers >= 3 {
+		return &ErrInvalidHeader{Reason: "all headers already read"}
+	}
+	if len(packet) < 7 || packet[0] != want[d.headers] || !bytes.Equal(packet[1:7], vorbisMagic) {
+		return &ErrInvalidHeader{Reason: "unexpected header packet"}
+	}
+	var err error
+	switch packet[0] {
+	case packetIdent:
+		d.Ident, err = ParseIdent(packet)
+	case packetSetup:
+		d.setup, err = parseSetup(packet, d.Ident.Channels)
+	}
+	if err != nil {
+		return err
+	}
+	d.headers++
+	if d.headers == 3 {
+		d.imdct[0] = newIMDCT(d.Ident.Blocksize0)
+		d.imdct[1] = newIMDCT(d.Ident.Blocksize1)
+	}
+	return nil
+}
+
+// ParseIdent parses an identification header packet.
+func ParseIdent(packet []byte) (Ident, error) {
+	if len(packet) < 30 || packet[0] != packetIdent || !bytes.Equal(packet[1:7], vorbisMagic) {
+		return Ident{}, &ErrInvalidHeader{Reason: "not an identification header"}
+	}
+	id := Ident{
+		Version:        binary.LittleEndian.Uint32(packet[7:]),
+		Channels:       int(packet[11]),
+		SampleRate:     int(binary.LittleEndian.Uint32(packet[12:])),
+		BitrateMaximum: int32(binary.LittleEndian.Uint32(packet[16:])),
+		BitrateNominal: int32(binary.LittleEndian.Uint32(packet[20:])),
+		BitrateMinimum: int32(binary.LittleEndian.Uint32(packet[24:])),
+		Blocksize0:     1 << (packet[28] & 0x0f),
+		Blocksize1:     1 << (packet[28] >> 4),
+	}
+	switch {
+	case id.Version != 0:
+		return Ident{}, &ErrInvalidHeader{Reason: "unsupported version"}
+	case id.Channels == 0 || id.SampleRate == 0:
+		return Ident{}, &ErrInvalidHeader{Reason: "no channels or sample rate"}
+	case id.Blocksize0 < 64 || id.Blocksize1 > 8192 || id.Blocksize0 > id.Blocksize1:
+		return Ident{}, &ErrInvalidHeader{Reason: "invalid block sizes"}
+	case packet[29]&1 == 0:
+		return Ident{}, &ErrInvalidHeader{Reason: "missing framing bit"}
+	}
+	return id, nil
+}
+
+func parseSetup(packet []byte, channels int) (*setup, error) {
+	r := newBitReader(packet[7:])
+	s := &setup{}
+
+	count := int(r.read(8)) + 1
+	for i := 0; i < count; i++ {
+		cb, err := readCodebook(r)
+		if err != nil {
+			return nil, err
+		}
+		s.books = append(s.books, cb)
+	}
+
+	count = int(r.read(6)) + 1
+	for i := 0; i < count; i++ {
+		if r.read(16) != 0 {
+			return nil, &ErrInvalidHeader{Reason: "invalid time domain transform"}
+		}
+	}
+
+	count = int(r.read(6)) + 1
+	for i := 0; i < count; i++ {
+		f, err := readFloor(r, len(s.books))
+		if err != nil {
+			return nil, err
+		}
+		s.floors = append(s.floors, f)
+	}
+
+	count = int(r.read(6)) + 1
+	for i := 0; i < count; i++ {
+		res, err := readResidue(r, s.books)
+		if err != nil {
+			return nil, err
+		}
+		s.residues = append(s.residues, res)
+	}
+
+	count = int(r.read(6)) + 1
+	for i := 0; i < count; i++ {
+		m, err := readMapping(r, channels, len(s.floors), len(s.residues))
+		if err != nil {
+			return nil, err
+		}
+		s.mappings = append(s.mappings, m)
+	}
+
+	count = int(r.read(6)) + 1
+	for i := 0; i < count; i++ {
+		m := mode{blockFlag: r.readBool()}
+		if r.read(16) != 0 || r.read(16) != 0 {
+			return nil, &ErrInvalidHeader{Reason: "invalid mode window or transform type"}
+		}
+		m.mapping = int(r.read(8))
+		if m.mapping >= len(s.mappings) {
+			return nil, &ErrInvalidHeader{Reason: "mode refers to a missing mapping"}
+		}
+		s.modes = append(s.modes, m)
+	}
+
+	if !r.readBool() || r.eop {
+		return nil, &ErrInvalidHeader{Reason: "missing framing bit"}
+	}
+	return s, nil
+}
+
+func readMapping(r *bitReader, channels, floors, residues int) (mapping, error) {
+	var m mapping
+	if r.read(16) != 0 {
+		return m, &ErrInvalidHeader{Reason: "invalid mapping type"}
+	}
+	submaps := 1
+	if r.readBool() {
+		submaps = int(r.read(4)) + 1
+	}
+	if r.readBool() {
+		steps := int(r.read(8)) + 1
+		bits := ilog(channels - 1)
+		for i := 0; i < steps; i++ {
+			magnitude, angle := int(r.read(bits)), int(r.read(bits))
+			if magnitude == angle || magnitude >= channels || angle >= channels {
+				return m, &ErrInvalidHeader{Reason: "invalid channel coupling"}
+			}
+			m.magnitudes = append(m.magnitudes, magnitude)
+			m.angles = append(m.angles, angle)
+		}
+	}
+	if r.read(2) != 0 {
+		return m, &ErrInvalidHeader{Reason: "reserved mapping bits set"}
+	}
+	m.mux = make([]int, channels)
+	if submaps > 1 {
+		for i := range m.mux {
+			m.mux[i] = int(r.read(4))
+			if m.mux[i] >= submaps {
+				return m, &ErrInvalidHeader{Reason: "invalid mapping mux"}
+			}
+		}
+	}
+	for i := 0; i < submaps; i++ {
+		r.read(8)
+		floor, residue := int(r.read(8)), int(r.read(8))
+		if floor >= floors || residue >= residues {
+			return m, &ErrInvalidHeader{Reason: "mapping refers to a missing floor or residue"}
+		}
+		m.floors = append(m.floors, floor)
+		m.residues = append(m.residues, residue)
+	}
+	return m, nil
+}
+
+// BlockSize returns the block size an audio packet was coded with, without
+// decoding it. It is 0 for packets that are not audio packets.
+func (d *Decoder) BlockSize(packet []byte) int {
+	if !d.HeadersRead() {
+		return 0
+	}
+	r := newBitReader(packet)
+	if r.readBool() || r.eop {
+		return 0
+	}
+	m := int(r.read(ilog(len(d.setup.modes) - 1)))
+	if m >= len(d.setup.modes) || r.eop {
+		return 0
+	}
+	if d.setup.modes[m].blockFlag {
+		return d.Ident.Blocksize1
+	}
+	return d.Ident.Blocksize0
+}
+
+// Reset forgets the overlap from the previous packet, as is needed after a seek.
+// The next packet decoded returns no samples.
+func (d *Decoder) Reset() {
+	d.previous = nil
+	d.prevN = 0
+}
+
+// Decode decodes an audio packet into one slice of samples per channel.
+func (d *Decoder) Decode(packet []byte) ([][]float32, error) {
+	if !d.HeadersRead() {
+		return nil, &ErrInvalidPacket{Reason: "headers not read"}
+	}
+	if len(packet) == 0 {
+		return nil, nil
+	}
+	r := newBitReader(packet)
+	if r.readBool() {
+		return nil, nil
+	}
+	s := d.setup
+	modeNumber := int(r.read(ilog(len(s.modes) - 1)))
+	if modeNumber >= len(s.modes) {
+		return nil, &ErrInvalidPacket{Reason: "invalid mode"}
+	}
+	mode := s.modes[modeNumber]
+	mp := &s.mappings[mode.mapping]
+	n := d.Ident.Blocksize0
+	prevLong, nextLong := false, false
+	if mode.blockFlag {
+		n = d.Ident.Blocksize1
+		prevLong = r.readBool()
+		nextLong = r.readBool()
+	}
+	if r.eop {
+		return nil, &ErrInvalidPacket{Reason: "truncated packet"}
+	}
+	half := n / 2
+	channels := d.Ident.Channels
+
+	floors := make([]floorData, channels)
+	nonzero := make([]bool, channels)
+	for ch := 0; ch < channels; ch++ {
+		floors[ch] = s.floors[mp.floors[mp.mux[ch]]].decode(r, s.books, half)
+		nonzero[ch] = floors[ch] != nil
+	}
+	for i := range mp.magnitudes {
+		if nonzero[mp.magnitudes[i]] || nonzero[mp.angles[i]] {
+			nonzero[mp.magnitudes[i]] = true
+			nonzero[mp.angles[i]] = true
+		}
+	}
+
+	residues := make([][]float32, channels)
+	for ch := range residues {
+		residues[ch] = make([]float32, half)
+	}
+	for submap := range mp.residues {
+		var vectors [][]float32
+		var doNotDecode []bool
+		for ch := 0; ch < channels; ch++ {
+			if mp.mux[ch] == submap {
+				vectors = append(vectors, residues[ch])
+				doNotDecode = append(doNotDecode, !nonzero[ch])
+			}
+		}
+		s.residues[mp.residues[submap]].decode(r, s.books, vectors, doNotDecode, half)
+	}
+
+	for i := len(mp.magnitudes) - 1; i >= 0; i-- {
+		magnitude, angle := residues[mp.magnitudes[i]], residues[mp.angles[i]]
+		for j := range magnitude {
+			m, a := magnitude[j], angle[j]
+			if m > 0 {
+				if a > 0 {
+					magnitude[j], angle[j] = m, m-a
+				} else {
+					magnitude[j], angle[j] = m+a, m
+				}
+			} else {
+				if a > 0 {
+					magnitude[j], angle[j] = m, m+a
+				} else {
+					magnitude[j], angle[j] = m-a, m
+				}
+			}
+		}
+	}
+
+	window := d.window(n, prevLong, nextLong)
+	block := make([][]float32, channels)
+	for ch := 0; ch < channels; ch++ {
+		block[ch] = make([]float32, n)
+		if floors[ch] == nil {
+			continue
+		}
+		floors[ch].apply(residues[ch])
+		d.imdct[boolIndex(mode.blockFlag)].transform(residues[ch], block[ch])
+		for i, w := range window {
+			block[ch][i] *= w
+		}
+	}
+
+	return d.overlap(block, n), nil
+}
+
+// overlap adds the left half of block to the saved right half of the previous
+// block and returns the samples between the centres of the two blocks.
+func (d *Decoder) overlap(block [][]float32, n int) [][]float32 {
+	if d.previous == nil {
+		d.previous = make([][]float32, len(block))
+		for ch := range block {
+			d.previous[ch] = append([]float32{}, block[ch][n/2:]...)
+		}
+		d.prevN = n
+		return nil
+	}
+
+	pn := d.prevN
+	count := pn/4 + n/4
+	out := make([][]float32, len(block))
+	for ch := range block {
+		out[ch] = make([]float32, count)
+		for t := 0; t < count; t++ {
+			var v float32
+			if t < pn/2 {
+				v = d.previous[ch][t]
+			}
+			if i := t - pn/4 + n/4; i >= 0 && i < n {
+				v += block[ch][i]
+			}
+			out[ch][t] = v
+		}
+		d.previous[ch] = append(d.previous[ch][:0], block[ch][n/2:]...)
+	}
+	d.prevN = n
+	return out
+}
+
+// window returns the window for a block of size n given the sizes of its
+// neighbours. Only long blocks can have a short neighbour.
+func (d *Decoder) window(n int, prevLong, nextLong bool) []float32 {
+	long := n == d.Ident.Blocksize1 && n != d.Ident.Blocksize0
+	key := [3]int{n, boolIndex(prevLong || !long), boolIndex(nextLong || !long)}
+	if w, ok := d.windows[key]; ok {
+		return w
+	}
+	short := d.Ident.Blocksize0
+	leftStart, leftEnd, leftN := 0, n/2, n/2
+	if long && !prevLong {
+		leftStart, leftEnd, leftN = n/4-short/4, n/4+short/4, short/2
+	}
+	rightStart, rightEnd, rightN := n/2, n, n/2
+	if long && !nextLong {
+		rightStart, rightEnd, rightN = n*3/4-short/4, n*3/4+short/4, short/2
+	}
+
+	w := make([]float32, n)
+	for i := leftStart; i < leftEnd; i++ {
+		x := math.Sin((float64(i-leftStart) + 0.5) / float64(leftN) * math.Pi / 2)
+		w[i] = float32(math.Sin(math.Pi / 2 * x * x))
+	}
+	for i := leftEnd; i < rightStart; i++ {
+		w[i] = 1
+	}
+	for i := rightStart; i < rightEnd; i++ {
+		x := math.Sin((float64(i-rightStart)+0.5)/float64(rightN)*math.Pi/2 + math.Pi/2)
+		w[i] = float32(math.Sin(math.Pi / 2 * x * x))
+	}
+	d.windows[key] = w
+	return w
+}
+
+func boolIndex(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
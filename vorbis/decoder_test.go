@@ -0,0 +1,113 @@
+package vorbis_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/gcottom/oggmeta"
+	"github.com/gcottom/oggmeta/vorbis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	b, err := os.ReadFile("../testdata/test1.ogg")
+	assert.NoError(t, err)
+	ogg := &oggmeta.OGGDecoder{Reader: bytes.NewReader(b)}
+	dec := vorbis.NewDecoder()
+
+	var samples, lastGranule int64
+	var energy float64
+	for {
+		packet, err := ogg.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		if !dec.HeadersRead() {
+			assert.NoError(t, dec.ReadHeader(packet.Data))
+			continue
+		}
+		assert.NotZero(t, dec.BlockSize(packet.Data))
+		pcm, err := dec.Decode(packet.Data)
+		assert.NoError(t, err)
+		if len(pcm) == 0 {
+			continue
+		}
+		assert.Len(t, pcm, 2)
+		for _, x := range pcm[0] {
+			assert.False(t, math.IsNaN(float64(x)))
+			assert.Less(t, math.Abs(float64(x)), 2.0)
+			energy += float64(x) * float64(x)
+		}
+		samples += int64(len(pcm[0]))
+		if packet.Granule >= 0 {
+			lastGranule = packet.Granule
+		}
+	}
+	assert.Equal(t, 44100, dec.Ident.SampleRate)
+	assert.Equal(t, 2, dec.Ident.Channels)
+	// the last page's granule cuts the padding of the final block
+	assert.GreaterOrEqual(t, samples, lastGranule)
+	assert.Less(t, samples-lastGranule, int64(dec.Ident.Blocksize1))
+	assert.Greater(t, energy/float64(samples), 0.01)
+}
+
+func TestReadHeaderOrder(t *testing.T) {
+	dec := vorbis.NewDecoder()
+	assert.Error(t, dec.ReadHeader([]byte("\x03vorbis")))
+	_, err := dec.Decode([]byte{0})
+	assert.Error(t, err)
+}
+
+// test1-reference.f32 holds every 64th stereo frame of test1.ogg as decoded
+// by an independent decoder, as little-endian float32 clipped to [-1, 1].
+func TestDecodeReference(t *testing.T) {
+	b, err := os.ReadFile("../testdata/test1.ogg")
+	assert.NoError(t, err)
+	ref, err := os.ReadFile("../testdata/test1-reference.f32")
+	assert.NoError(t, err)
+	ogg := &oggmeta.OGGDecoder{Reader: bytes.NewReader(b)}
+	dec := vorbis.NewDecoder()
+
+	var pcm [2][]float32
+	var granule int64
+	for {
+		packet, err := ogg.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		if !dec.HeadersRead() {
+			assert.NoError(t, dec.ReadHeader(packet.Data))
+			continue
+		}
+		out, err := dec.Decode(packet.Data)
+		assert.NoError(t, err)
+		for ch := range out {
+			pcm[ch] = append(pcm[ch], out[ch]...)
+		}
+		if packet.Granule >= 0 {
+			granule = packet.Granule
+		}
+	}
+	assert.Equal(t, int64(149440), granule)
+	frames := (int(granule) + 63) / 64
+	if !assert.Equal(t, frames*8, len(ref)) {
+		return
+	}
+	clip := func(x float32) float64 {
+		return math.Max(-1, math.Min(1, float64(x)))
+	}
+	var worst float64
+	for i := 0; i < frames; i++ {
+		for ch := 0; ch < 2; ch++ {
+			want := math.Float32frombits(binary.LittleEndian.Uint32(ref[8*i+4*ch:]))
+			worst = math.Max(worst, math.Abs(clip(pcm[ch][64*i])-float64(want)))
+		}
+	}
+	assert.Less(t, worst, 1e-4)
+}
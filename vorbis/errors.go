@@ -0,0 +1,17 @@
+package vorbis
+
+type ErrInvalidHeader struct {
+	Reason string
+}
+
+func (e *ErrInvalidHeader) Error() string {
+	return "invalid vorbis header: " + e.Reason
+}
+
+type ErrInvalidPacket struct {
+	Reason string
+}
+
+func (e *ErrInvalidPacket) Error() string {
+	return "invalid vorbis audio packet: " + e.Reason
+}
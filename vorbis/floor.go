@@ -0,0 +1,413 @@
+package vorbis
+
+import (
+	"math"
+	"sort"
+)
+
+type floor interface {
+	// decode reads the floor of one channel. It returns nil when the channel is
+	// unused in this packet.
+	decode(r *bitReader, books []*codebook, n int) floorData
+}
+
+// floorData is the decoded floor of one channel, ready to be rendered into
+// a curve of n values.
+type floorData interface {
+	apply(out []float32)
+}
+
+type floor0 struct {
+	order          int
+	rate           int
+	barkMapSize    int
+	amplitudeBits  uint
+	amplitudeOff   int
+	books          []int
+	maps           map[int][]int // bark map per half block size
+	maxBookEntries int
+}
+
+type floor1 struct {
+	partitionClasses []int
+	classDimensions  []int
+	classSubclasses  []uint
+	classMasterbooks []int
+	subclassBooks    [][]int
+	multiplier       int
+	xList            []int
+	sorted           []int // indexes into xList in increasing x order
+	low, high        []int // neighbours of every point after the first two
+}
+
+func readFloor(r *bitReader, bookCount int) (floor, error) {
+	switch r.read(16) {
+	case 0:
+		return readFloor0(r, bookCount)
+	case 1:
+		return readFloor1(r, bookCount)
+	}
+	return nil, &ErrInvalidHeader{Reason: "invalid floor type"}
+}
+
+func readFloor0(r *bitReader, bookCount int) (floor, error) {
+	f := &floor0{
+		order:         int(r.read(8)),
+		rate:          int(r.read(16)),
+		barkMapSize:   int(r.read(16)),
+		amplitudeBits: uint(r.read(6)),
+		amplitudeOff:  int(r.read(8)),
+		maps:          make(map[int][]int),
+	}
+	books := int(r.read(4)) + 1
+	for i := 0; i < books; i++ {
+		book := int(r.read(8))
+		if book >= bookCount {
+			return nil, &ErrInvalidHeader{Reason: "floor refers to a missing codebook"}
+		}
+		f.books = append(f.books, book)
+	}
+	if f.order == 0 || f.barkMapSize == 0 || f.rate == 0 || r.eop {
+		return nil, &ErrInvalidHeader{Reason: "invalid floor 0"}
+	}
+	return f, nil
+}
+
+type floor0Data struct {
+	f           *floor0
+	amplitude   uint32
+	coefficient []float64
+	n           int
+}
+
+func (f *floor0) decode(r *bitReader, books []*codebook, n int) floorData {
+	amplitude := r.read(f.amplitudeBits)
+	if amplitude == 0 || r.eop {
+		return nil
+	}
+	book := int(r.read(ilog(len(f.books))))
+	if book >= len(f.books) || r.eop {
+		return nil
+	}
+	cb := books[f.books[book]]
+	var coefficients []float64
+	var last float64
+	for len(coefficients) < f.order {
+		v := cb.decodeVector(r)
+		if v == nil {
+			return nil
+		}
+		for _, x := range v {
+			coefficients = append(coefficients, float64(x)+last)
+		}
+		last = coefficients[len(coefficients)-1]
+	}
+	return &floor0Data{f: f, amplitude: amplitude, coefficient: coefficients[:f.order], n: n}
+}
+
+func bark(x float64) float64 {
+	return 13.1*math.Atan(.00074*x) + 2.24*math.Atan(.0000000185*x*x) + .0001*x
+}
+
+func (f *floor0) barkMap(n int) []int {
+	if m, ok := f.maps[n]; ok {
+		return m
+	}
+	m := make([]int, n+1)
+	scale := float64(f.barkMapSize) / bark(.5*float64(f.rate))
+	for i := 0; i < n; i++ {
+		v := int(math.Floor(bark(float64(f.rate*i)/float64(2*n)) * scale))
+		if v > f.barkMapSize-1 {
+			v = f.barkMapSize - 1
+		}
+		m[i] = v
+	}
+	m[n] = -1
+	f.maps[n] = m
+	return m
+}
+
+func (d *floor0Data) apply(out []float32) {
+	f := d.f
+	m := f.barkMap(d.n)
+	cosCoefficients := make([]float64, len(d.coefficient))
+	for i, c := range d.coefficient {
+		cosCoefficients[i] = math.Cos(c)
+	}
+	maxAmplitude := float64(uint64(1)<<f.amplitudeBits - 1)
+	for i := 0; i < d.n; {
+		w := math.Cos(math.Pi * float64(m[i]) / float64(f.barkMapSize))
+		p, q := 1.0, 1.0
+		if f.order%2 == 1 {
+			p = 1 - w*w
+			for j := 0; j <= (f.order-3)/2; j++ {
+				t := cosCoefficients[2*j+1] - w
+				p *= 4 * t * t
+			}
+			for j := 0; j <= (f.order-1)/2; j++ {
+				t := cosCoefficients[2*j] - w
+				q *= 4 * t * t
+			}
+		} else {
+			p = 1 - w
+			q = 1 + w
+			for j := 0; j <= (f.order-2)/2; j++ {
+				t := cosCoefficients[2*j+1] - w
+				p *= 4 * t * t
+				t = cosCoefficients[2*j] - w
+				q *= 4 * t * t
+			}
+		}
+		value := float32(math.Exp(.11512925 * (float64(d.amplitude)*float64(f.amplitudeOff)/(maxAmplitude*math.Sqrt(p+q)) - float64(f.amplitudeOff))))
+		condition := m[i]
+		for i < d.n && m[i] == condition {
+			out[i] *= value
+			i++
+		}
+	}
+}
+
+func readFloor1(r *bitReader, bookCount int) (floor, error) {
+	f := &floor1{}
+	partitions := int(r.read(5))
+	maxClass := -1
+	for i := 0; i < partitions; i++ {
+		class := int(r.read(4))
+		f.partitionClasses = append(f.partitionClasses, class)
+		if class > maxClass {
+			maxClass = class
+		}
+	}
+	for i := 0; i <= maxClass; i++ {
+		f.classDimensions = append(f.classDimensions, int(r.read(3))+1)
+		subclasses := uint(r.read(2))
+		f.classSubclasses = append(f.classSubclasses, subclasses)
+		master := -1
+		if subclasses > 0 {
+			master = int(r.read(8))
+			if master >= bookCount {
+				return nil, &ErrInvalidHeader{Reason: "floor refers to a missing codebook"}
+			}
+		}
+		f.classMasterbooks = append(f.classMasterbooks, master)
+		books := make([]int, 1<<subclasses)
+		for j := range books {
+			books[j] = int(r.read(8)) - 1
+			if books[j] >= bookCount {
+				return nil, &ErrInvalidHeader{Reason: "floor refers to a missing codebook"}
+			}
+		}
+		f.subclassBooks = append(f.subclassBooks, books)
+	}
+	f.multiplier = int(r.read(2)) + 1
+	rangeBits := uint(r.read(4))
+	f.xList = []int{0, 1 << rangeBits}
+	for _, class := range f.partitionClasses {
+		for j := 0; j < f.classDimensions[class]; j++ {
+			f.xList = append(f.xList, int(r.read(rangeBits)))
+		}
+	}
+	if r.eop || len(f.xList) > 65 {
+		return nil, &ErrInvalidHeader{Reason: "invalid floor 1"}
+	}
+
+	f.sorted = make([]int, len(f.xList))
+	for i := range f.sorted {
+		f.sorted[i] = i
+	}
+	sort.SliceStable(f.sorted, func(a, b int) bool { return f.xList[f.sorted[a]] < f.xList[f.sorted[b]] })
+	for i := 1; i < len(f.sorted); i++ {
+		if f.xList[f.sorted[i]] == f.xList[f.sorted[i-1]] {
+			return nil, &ErrInvalidHeader{Reason: "floor 1 repeats an X value"}
+		}
+	}
+
+	f.low = make([]int, len(f.xList))
+	f.high = make([]int, len(f.xList))
+	for i := 2; i < len(f.xList); i++ {
+		f.low[i], f.high[i] = neighbors(f.xList, i)
+	}
+	return f, nil
+}
+
+// neighbors returns the positions before i holding the closest X values
+// below and above xList[i].
+func neighbors(xList []int, i int) (int, int) {
+	low, high := -1, -1
+	for j := 0; j < i; j++ {
+		if xList[j] < xList[i] && (low < 0 || xList[j] > xList[low]) {
+			low = j
+		}
+		if xList[j] > xList[i] && (high < 0 || xList[j] < xList[high]) {
+			high = j
+		}
+	}
+	return low, high
+}
+
+var floor1Ranges = [4]int{256, 128, 86, 64}
+
+type floor1Data struct {
+	f *floor1
+	y []int
+	n int
+}
+
+func (f *floor1) decode(r *bitReader, books []*codebook, n int) floorData {
+	if !r.readBool() {
+		return nil
+	}
+	rangeBits := ilog(floor1Ranges[f.multiplier-1] - 1)
+	y := make([]int, 2, len(f.xList))
+	y[0] = int(r.read(rangeBits))
+	y[1] = int(r.read(rangeBits))
+	for _, class := range f.partitionClasses {
+		dimensions := f.classDimensions[class]
+		bits := f.classSubclasses[class]
+		mask := 1<<bits - 1
+		cval := 0
+		if bits > 0 {
+			cval = books[f.classMasterbooks[class]].decodeScalar(r)
+			if cval < 0 {
+				return nil
+			}
+		}
+		for j := 0; j < dimensions; j++ {
+			book := f.subclassBooks[class][cval&mask]
+			cval >>= bits
+			if book < 0 {
+				y = append(y, 0)
+				continue
+			}
+			v := books[book].decodeScalar(r)
+			if v < 0 {
+				return nil
+			}
+			y = append(y, v)
+		}
+	}
+	if r.eop {
+		return nil
+	}
+	return &floor1Data{f: f, y: y, n: n}
+}
+
+func renderPoint(x0, y0, x1, y1, x int) int {
+	dy := y1 - y0
+	adx := x1 - x0
+	ady := dy
+	if ady < 0 {
+		ady = -ady
+	}
+	off := ady * (x - x0) / adx
+	if dy < 0 {
+		return y0 - off
+	}
+	return y0 + off
+}
+
+func renderLine(x0, y0, x1, y1 int, out []float32) {
+	dy := y1 - y0
+	adx := x1 - x0
+	ady := dy
+	if ady < 0 {
+		ady = -ady
+	}
+	base := dy / adx
+	sy := base + 1
+	if dy < 0 {
+		sy = base - 1
+	}
+	absBase := base
+	if absBase < 0 {
+		absBase = -absBase
+	}
+	ady -= absBase * adx
+	y := y0
+	err := 0
+	if x0 < len(out) {
+		out[x0] *= floor1InverseDB[clampY(y)]
+	}
+	for x := x0 + 1; x < x1 && x < len(out); x++ {
+		err += ady
+		if err >= adx {
+			err -= adx
+			y += sy
+		} else {
+			y += base
+		}
+		out[x] *= floor1InverseDB[clampY(y)]
+	}
+}
+
+func clampY(y int) int {
+	if y < 0 {
+		return 0
+	}
+	if y > 255 {
+		return 255
+	}
+	return y
+}
+
+func (d *floor1Data) apply(out []float32) {
+	f := d.f
+	rng := floor1Ranges[f.multiplier-1]
+	finalY := make([]int, len(d.y))
+	step2 := make([]bool, len(d.y))
+	finalY[0], finalY[1] = d.y[0], d.y[1]
+	step2[0], step2[1] = true, true
+	for i := 2; i < len(d.y); i++ {
+		low, high := f.low[i], f.high[i]
+		predicted := renderPoint(f.xList[low], finalY[low], f.xList[high], finalY[high], f.xList[i])
+		val := d.y[i]
+		highRoom := rng - predicted
+		lowRoom := predicted
+		room := lowRoom * 2
+		if highRoom < lowRoom {
+			room = highRoom * 2
+		}
+		if val == 0 {
+			finalY[i] = predicted
+			continue
+		}
+		step2[low], step2[high], step2[i] = true, true, true
+		switch {
+		case val >= room && highRoom > lowRoom:
+			finalY[i] = val - lowRoom + predicted
+		case val >= room:
+			finalY[i] = predicted - val + highRoom - 1
+		case val%2 == 1:
+			finalY[i] = predicted - (val+1)/2
+		default:
+			finalY[i] = predicted + val/2
+		}
+	}
+
+	lx, ly := 0, finalY[f.sorted[0]]*f.multiplier
+	hx, hy := 0, 0
+	for _, i := range f.sorted[1:] {
+		if !step2[i] {
+			continue
+		}
+		hy = finalY[i] * f.multiplier
+		hx = f.xList[i]
+		renderLine(lx, ly, hx, hy, out)
+		lx, ly = hx, hy
+	}
+	if hx < d.n {
+		renderLine(hx, hy, d.n, hy, out)
+	}
+}
+
+// floor1InverseDB maps floor 1 amplitudes to linear scale. The specification
+// lists it as a table whose entries step evenly in dB from 1.0649863e-07 to 1.
+var floor1InverseDB [256]float32
+
+func init() {
+	const first = 1.0649863e-07
+	step := math.Log(first) / 255
+	for i := range floor1InverseDB {
+		floor1InverseDB[i] = float32(math.Exp(step * float64(255-i)))
+	}
+}
@@ -0,0 +1,101 @@
+package vorbis
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// imdct computes the inverse MDCT of n/2 coefficients into n samples.
+type imdct struct {
+	n       int
+	pre     []complex128 // exp(-i*pi*(k+1/8)/m), m = n/2
+	fft     *fft
+	scratch []complex128
+	dct     []float64
+}
+
+func newIMDCT(n int) *imdct {
+	m := n / 2
+	t := &imdct{
+		n:       n,
+		pre:     make([]complex128, m/2),
+		fft:     newFFT(m / 2),
+		scratch: make([]complex128, m/2),
+		dct:     make([]float64, m),
+	}
+	for k := range t.pre {
+		t.pre[k] = cmplx.Exp(complex(0, -math.Pi*(float64(k)+0.125)/float64(m)))
+	}
+	return t
+}
+
+func (t *imdct) transform(in []float32, out []float32) {
+	m := t.n / 2
+	for k := range t.scratch {
+		t.scratch[k] = complex(float64(in[2*k]), float64(in[m-1-2*k])) * t.pre[k]
+	}
+	t.fft.transform(t.scratch)
+	for k, z := range t.scratch {
+		z *= t.pre[k]
+		t.dct[2*k] = real(z)
+		t.dct[m-1-2*k] = -imag(z)
+	}
+
+	// unfold the DCT-IV output u into the n samples: y[i] = u[i+m/2], with
+	// u[2m-1-j] = -u[j] and u[j+2m] = -u[j]
+	for i := 0; i < t.n; i++ {
+		j := i + m/2
+		switch {
+		case j < m:
+			out[i] = float32(t.dct[j])
+		case j < 2*m:
+			out[i] = float32(-t.dct[2*m-1-j])
+		default:
+			out[i] = float32(-t.dct[j-2*m])
+		}
+	}
+}
+
+// fft is an in-place radix-2 forward FFT.
+type fft struct {
+	n       int
+	twiddle []complex128
+	rev     []int
+}
+
+func newFFT(n int) *fft {
+	f := &fft{n: n, twiddle: make([]complex128, n/2), rev: make([]int, n)}
+	for i := range f.twiddle {
+		f.twiddle[i] = cmplx.Exp(complex(0, -2*math.Pi*float64(i)/float64(n)))
+	}
+	bits := ilog(n) - 1
+	for i := range f.rev {
+		r := 0
+		for b := uint(0); b < bits; b++ {
+			if i&(1<<b) != 0 {
+				r |= 1 << (bits - 1 - b)
+			}
+		}
+		f.rev[i] = r
+	}
+	return f
+}
+
+func (f *fft) transform(x []complex128) {
+	for i, r := range f.rev {
+		if i < r {
+			x[i], x[r] = x[r], x[i]
+		}
+	}
+	for size := 2; size <= f.n; size <<= 1 {
+		half := size / 2
+		step := f.n / size
+		for start := 0; start < f.n; start += size {
+			for k := 0; k < half; k++ {
+				w := f.twiddle[k*step] * x[start+k+half]
+				x[start+k+half] = x[start+k] - w
+				x[start+k] += w
+			}
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package vorbis
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIMDCT(t *testing.T) {
+	for _, n := range []int{64, 256, 2048} {
+		in := make([]float32, n/2)
+		for i := range in {
+			in[i] = rand.Float32()*2 - 1
+		}
+		out := make([]float32, n)
+		newIMDCT(n).transform(in, out)
+		for i := 0; i < n; i++ {
+			var want float64
+			for k, x := range in {
+				want += float64(x) * math.Cos(2*math.Pi/float64(n)*(float64(i)+0.5+float64(n)/4)*(float64(k)+0.5))
+			}
+			assert.InDelta(t, want, out[i], 1e-3)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package vorbis
+
+type residue struct {
+	kind            int
+	begin, end      int
+	partitionSize   int
+	classifications int
+	classbook       int
+	books           [][8]int // per classification and pass, -1 when unused
+}
+
+func readResidue(r *bitReader, books []*codebook) (*residue, error) {
+	res := &residue{kind: int(r.read(16))}
+	if res.kind > 2 {
+		return nil, &ErrInvalidHeader{Reason: "invalid residue type"}
+	}
+	res.begin = int(r.read(24))
+	res.end = int(r.read(24))
+	res.partitionSize = int(r.read(24)) + 1
+	res.classifications = int(r.read(6)) + 1
+	res.classbook = int(r.read(8))
+	if res.classbook >= len(books) || books[res.classbook].dimensions == 0 {
+		return nil, &ErrInvalidHeader{Reason: "residue refers to a missing codebook"}
+	}
+	cascade := make([]uint32, res.classifications)
+	for i := range cascade {
+		low := r.read(3)
+		var high uint32
+		if r.readBool() {
+			high = r.read(5)
+		}
+		cascade[i] = high<<3 | low
+	}
+	res.books = make([][8]int, res.classifications)
+	for i := range res.books {
+		for j := 0; j < 8; j++ {
+			res.books[i][j] = -1
+			if cascade[i]&(1<<j) != 0 {
+				book := int(r.read(8))
+				if book >= len(books) || books[book].vq == nil {
+					return nil, &ErrInvalidHeader{Reason: "residue refers to a missing codebook"}
+				}
+				res.books[i][j] = book
+			}
+		}
+	}
+	if r.eop {
+		return nil, &ErrInvalidHeader{Reason: "setup header truncated"}
+	}
+	return res, nil
+}
+
+// decode adds the residue of every vector whose doNotDecode flag is false.
+// Each vector has n values.
+func (res *residue) decode(r *bitReader, books []*codebook, vectors [][]float32, doNotDecode []bool, n int) {
+	if res.kind == 2 {
+		decode := false
+		for _, skip := range doNotDecode {
+			decode = decode || !skip
+		}
+		if !decode {
+			return
+		}
+		interleaved := make([]float32, n*len(vectors))
+		res.decodeVectors(r, books, [][]float32{interleaved}, []bool{false}, len(interleaved))
+		for i, v := range interleaved {
+			vectors[i%len(vectors)][i/len(vectors)] += v
+		}
+		return
+	}
+	res.decodeVectors(r, books, vectors, doNotDecode, n)
+}
+
+func (res *residue) decodeVectors(r *bitReader, books []*codebook, vectors [][]float32, doNotDecode []bool, n int) {
+	classbook := books[res.classbook]
+	perCodeword := classbook.dimensions
+	begin, end := res.begin, res.end
+	if begin > n {
+		begin = n
+	}
+	if end > n {
+		end = n
+	}
+	if end <= begin {
+		return
+	}
+	partitions := (end - begin) / res.partitionSize
+	if partitions == 0 {
+		return
+	}
+	classes := make([][]int, len(vectors))
+	for j := range classes {
+		classes[j] = make([]int, partitions+perCodeword)
+	}
+
+	for pass := 0; pass < 8; pass++ {
+		for partition := 0; partition < partitions; {
+			if pass == 0 {
+				for j := range vectors {
+					if doNotDecode[j] {
+						continue
+					}
+					temp := classbook.decodeScalar(r)
+					if temp < 0 {
+						return
+					}
+					for i := perCodeword - 1; i >= 0; i-- {
+						classes[j][i+partition] = temp % res.classifications
+						temp /= res.classifications
+					}
+				}
+			}
+			for i := 0; i < perCodeword && partition < partitions; i++ {
+				for j, v := range vectors {
+					if doNotDecode[j] {
+						continue
+					}
+					book := res.books[classes[j][partition]][pass]
+					if book < 0 {
+						continue
+					}
+					offset := begin + partition*res.partitionSize
+					if !res.decodePartition(r, books[book], v[offset:offset+res.partitionSize]) {
+						return
+					}
+				}
+				partition++
+			}
+		}
+	}
+}
+
+// decodePartition reports false once the packet runs out.
+func (res *residue) decodePartition(r *bitReader, cb *codebook, out []float32) bool {
+	if res.kind == 0 {
+		step := len(out) / cb.dimensions
+		for i := 0; i < step; i++ {
+			v := cb.decodeVector(r)
+			if v == nil {
+				return false
+			}
+			for j, x := range v {
+				out[i+j*step] += x
+			}
+		}
+		return true
+	}
+	for i := 0; i < len(out); {
+		v := cb.decodeVector(r)
+		if v == nil {
+			return false
+		}
+		for _, x := range v {
+			if i >= len(out) {
+				break
+			}
+			out[i] += x
+			i++
+		}
+	}
+	return true
+}
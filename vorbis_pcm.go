@@ -0,0 +1,88 @@
+package oggmeta
+
+import "github.com/gcottom/oggmeta/vorbis"
+
+// vorbisPCM adapts vorbis.Decoder to pcmDecoder and uses the granule positions
+// to cut the samples the encoder asked decoders to drop at either end.
+type vorbisPCM struct {
+	dec      *vorbis.Decoder
+	position int64 // granule of the last sample returned
+	started  bool  // a granule position has been seen
+	pending  [][]float32
+}
+
+func newVorbisPCM(head []byte) (pcmDecoder, error) {
+	dec := vorbis.NewDecoder()
+	if err := dec.ReadHeader(head); err != nil {
+		return nil, err
+	}
+	return &vorbisPCM{dec: dec}, nil
+}
+
+func (v *vorbisPCM) sampleRate() int {
+	return v.dec.Ident.SampleRate
+}
+
+func (v *vorbisPCM) channels() int {
+	return v.dec.Ident.Channels
+}
+
+func (v *vorbisPCM) decode(packet *Packet) ([][]float32, error) {
+	if !v.dec.HeadersRead() {
+		return nil, v.dec.ReadHeader(packet.Data)
+	}
+	pcm, err := v.dec.Decode(packet.Data)
+	if err != nil {
+		return nil, err
+	}
+	if !v.started {
+		v.pending = appendPCM(v.pending, pcm)
+		if packet.Granule < 0 {
+			return nil, nil
+		}
+		v.started = true
+		pcm, v.pending = v.pending, nil
+		if !packet.EOS {
+			if excess := int64(pcmLen(pcm)) - packet.Granule; excess > 0 {
+				// the first page starts part way into the decoded audio
+				pcm = trimPCM(pcm, int(excess), pcmLen(pcm))
+			}
+			v.position = packet.Granule - int64(pcmLen(pcm))
+		}
+	}
+	if packet.EOS && packet.Granule >= 0 {
+		if keep := packet.Granule - v.position; keep < int64(pcmLen(pcm)) && keep >= 0 {
+			pcm = trimPCM(pcm, 0, int(keep))
+		}
+	}
+	v.position += int64(pcmLen(pcm))
+	return pcm, nil
+}
+
+func pcmLen(pcm [][]float32) int {
+	if len(pcm) == 0 {
+		return 0
+	}
+	return len(pcm[0])
+}
+
+func appendPCM(dst, src [][]float32) [][]float32 {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make([][]float32, len(src))
+	}
+	for ch := range src {
+		dst[ch] = append(dst[ch], src[ch]...)
+	}
+	return dst
+}
+
+// trimPCM keeps samples [from, to) of every channel.
+func trimPCM(pcm [][]float32, from, to int) [][]float32 {
+	for ch := range pcm {
+		pcm[ch] = pcm[ch][from:to]
+	}
+	return pcm
+}
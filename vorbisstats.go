@@ -0,0 +1,228 @@
+package oggmeta
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"github.com/gcottom/oggmeta/vorbis"
+)
+
+// VorbisStats describes the audio packets of an Ogg Vorbis stream, sized from
+// the block sizes of the identification header and the modes of the setup header.
+type VorbisStats struct {
+	Packets      int
+	Samples      int64 // samples the audio packets decode to
+	SampleRate   int
+	FinalGranule int64
+	StartGranule int64 // granule the stream would have before its first packet, 0 for a file that starts cleanly and negative for one that trims its start
+
+	BlockSizes map[int]int // number of audio packets of each block size
+
+	// GranuleMismatches lists pages whose granule position disagrees with the
+	// block sizes of the packets before them. A final granule that trims
+	// less than the last packet is end trimming and is not listed.
+	GranuleMismatches []GranuleMismatch
+
+	finalMismatch bool // the final granule is among the mismatches
+}
+
+// PlayableSamples returns the number of samples a decoder outputs. It does
+// not trust a final granule that disagrees with the packets.
+func (s *VorbisStats) PlayableSamples() int64 {
+	start := s.StartGranule
+	if start < 0 {
+		// decoders drop the trimmed lead-in, so granules count output samples
+		start = 0
+	}
+	if !s.finalMismatch && s.FinalGranule >= start {
+		return s.FinalGranule - start
+	}
+	return s.Samples + s.StartGranule - start
+}
+
+// Duration returns PlayableSamples as a time.
+func (s *VorbisStats) Duration() time.Duration {
+	if s.SampleRate == 0 {
+		return 0
+	}
+	return time.Duration(s.PlayableSamples()) * time.Second / time.Duration(s.SampleRate)
+}
+
+// AnalyzeVorbis walks the pages of the first Vorbis stream in r.
+func AnalyzeVorbis(r io.ReadSeeker) (*VorbisStats, error) {
+	return analyzeVorbis(context.Background(), r)
+}
+
+func analyzeVorbis(ctx context.Context, r io.ReadSeeker) (*VorbisStats, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	decoder := &OGGDecoder{Reader: r}
+	var granules *vorbisGranules
+	var serial uint32
+	var pages []vorbisPage
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		page, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if granules == nil {
+			if page.Header.Flags&FlagBOS == 0 || len(page.Packets) == 0 || !bytes.HasPrefix(page.Packets[0], VorbisIdentPrefix) {
+				continue
+			}
+			granules = newVorbisGranules()
+			serial = page.Header.SerialNumber
+		}
+		if page.Header.SerialNumber != serial {
+			continue
+		}
+		samples, err := granules.page(page)
+		if err != nil {
+			return nil, err
+		}
+		if granules.audio {
+			pages = append(pages, vorbisPage{
+				granule: page.Header.GranulePosition,
+				samples: samples,
+				last:    granules.last,
+				eos:     page.Header.Flags&FlagEOS != 0,
+			})
+		}
+		if page.Header.Flags&FlagEOS != 0 {
+			break
+		}
+	}
+	if granules == nil {
+		return nil, &ErrUnsupportedCodec{}
+	}
+
+	stats := &VorbisStats{
+		Packets:      granules.packets,
+		Samples:      granules.samples,
+		SampleRate:   granules.dec.Ident.SampleRate,
+		FinalGranule: -1,
+		StartGranule: vorbisStart(pages),
+		BlockSizes:   granules.blockSizes,
+	}
+	for i, p := range pages {
+		if expected := p.expected(stats.StartGranule); p.granule != expected {
+			stats.GranuleMismatches = append(stats.GranuleMismatches, GranuleMismatch{Page: int64(i), Granule: p.granule, Expected: expected})
+			stats.finalMismatch = i == len(pages)-1
+		}
+		stats.FinalGranule = p.granule
+	}
+	return stats, nil
+}
+
+// vorbisPage is a page that ends an audio packet.
+type vorbisPage struct {
+	granule int64 // as written
+	samples int64 // decoded up to the last packet ending on the page
+	last    int   // samples of that packet
+	eos     bool
+}
+
+// expected returns the granule the page should carry in a stream offset by
+// start. A final page that trims the end of its last packet is kept.
+func (p vorbisPage) expected(start int64) int64 {
+	expected := start + p.samples
+	if p.eos && p.granule < expected && expected-p.granule < int64(p.last) {
+		return p.granule
+	}
+	return expected
+}
+
+// vorbisStart returns the offset most pages agree on.
+func vorbisStart(pages []vorbisPage) int64 {
+	counts := make(map[int64]int)
+	var start int64
+	best := 0
+	for _, p := range pages {
+		if p.eos {
+			continue
+		}
+		offset := p.granule - p.samples
+		counts[offset]++
+		if counts[offset] > best || counts[offset] == best && offset == 0 {
+			start, best = offset, counts[offset]
+		}
+	}
+	return start
+}
+
+// vorbisGranules follows the packets of a Vorbis stream page by page,
+// starting with the BOS page, and sizes the audio packets.
+type vorbisGranules struct {
+	dec     *vorbis.Decoder
+	partial []byte // start of a packet completed on a following page
+	open    bool
+
+	previous   int // block size of the previous audio packet
+	last       int // samples of the last audio packet
+	samples    int64
+	packets    int
+	blockSizes map[int]int
+
+	audio bool // the current page ends an audio packet
+}
+
+func newVorbisGranules() *vorbisGranules {
+	return &vorbisGranules{dec: vorbis.NewDecoder(), blockSizes: make(map[int]int)}
+}
+
+// page returns the number of samples decoded up to the last packet ending on
+// page, or -1 when no packet ends on it.
+func (g *vorbisGranules) page(page *OGGPage) (int64, error) {
+	ended := false
+	g.audio = false
+	for i, data := range page.Packets {
+		if i == 0 && page.Header.Flags&FlagCOP != 0 {
+			if !g.open {
+				continue
+			}
+			data = append(g.partial, data...)
+		}
+		g.open = false
+		if i == len(page.Packets)-1 && page.continues {
+			g.partial = append(g.partial[:0], data...)
+			g.open = true
+			break
+		}
+		if err := g.packet(data); err != nil {
+			return 0, err
+		}
+		ended = true
+	}
+	if !ended {
+		return -1, nil
+	}
+	return g.samples, nil
+}
+func (g *vorbisGranules) packet(data []byte) error {
+	if !g.dec.HeadersRead() {
+		return g.dec.ReadHeader(data)
+	}
+	n := g.dec.BlockSize(data)
+	if n == 0 {
+		// decoders skip packets that are not audio
+		return nil
+	}
+	g.last = 0
+	if g.previous > 0 {
+		g.last = g.previous/4 + n/4
+	}
+	g.samples += int64(g.last)
+	g.previous = n
+	g.packets++
+	g.blockSizes[n]++
+	g.audio = true
+	return nil
+}
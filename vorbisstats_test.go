@@ -0,0 +1,98 @@
+package oggmeta
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setGranule overwrites the granule position of page n and updates its CRC.
+func setGranule(t *testing.T, b []byte, n int, granule int64) {
+	for off, i := 0, 0; off < len(b); i++ {
+		segs := int(b[off+26])
+		size := HeaderSize + segs
+		for _, s := range b[off+HeaderSize : off+HeaderSize+segs] {
+			size += int(s)
+		}
+		if i == n {
+			page := b[off : off+size]
+			binary.LittleEndian.PutUint64(page[6:], uint64(granule))
+			binary.LittleEndian.PutUint32(page[22:], 0)
+			header := append([]byte{}, page[:HeaderSize]...)
+			binary.LittleEndian.PutUint32(page[22:], calculateChecksum(header, page[HeaderSize+segs:], page[HeaderSize:HeaderSize+segs]))
+			return
+		}
+		off += size
+	}
+	t.Fatalf("no page %d", n)
+}
+
+func TestAnalyzeVorbis(t *testing.T) {
+	for _, tc := range []struct {
+		file  string
+		final int64
+	}{
+		{"test1.ogg", 149440},
+		{"testdata-ogg.ogg", 149880},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			f, err := os.Open("./testdata/" + tc.file)
+			assert.NoError(t, err)
+			defer f.Close()
+
+			stats, err := AnalyzeVorbis(f)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.final, stats.FinalGranule)
+			assert.Equal(t, int64(0), stats.StartGranule)
+			assert.Empty(t, stats.GranuleMismatches)
+			assert.Equal(t, 44100, stats.SampleRate)
+			assert.Equal(t, tc.final, stats.PlayableSamples())
+			assert.True(t, stats.Samples >= tc.final)
+
+			var packets int
+			for size, n := range stats.BlockSizes {
+				assert.Contains(t, []int{256, 2048}, size)
+				packets += n
+			}
+			assert.Equal(t, stats.Packets, packets)
+		})
+	}
+}
+
+func TestFixGranules(t *testing.T) {
+	b, err := os.ReadFile("./testdata/test1.ogg")
+	assert.NoError(t, err)
+	setGranule(t, b, 2, 12345)
+	setGranule(t, b, 4, 0)
+
+	stats, err := AnalyzeVorbis(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Len(t, stats.GranuleMismatches, 2)
+	assert.Equal(t, int64(12345), stats.GranuleMismatches[0].Granule)
+	assert.Equal(t, int64(149440), stats.FinalGranule)
+	// the mismatches are mid-stream, so the final granule still trims the end
+	assert.Equal(t, int64(149440), stats.PlayableSamples())
+	assert.Less(t, stats.PlayableSamples(), stats.Samples)
+
+	// a final granule that disagrees with the packets is not trusted
+	broken := append([]byte{}, b...)
+	setGranule(t, broken, len(verifyPages(t, broken))-1, 1)
+	brokenStats, err := AnalyzeVorbis(bytes.NewReader(broken))
+	assert.NoError(t, err)
+	assert.Equal(t, brokenStats.Samples, brokenStats.PlayableSamples())
+
+	tag, err := ReadOGG(bytes.NewReader(b))
+	assert.NoError(t, err)
+	out := new(bytes.Buffer)
+	assert.NoError(t, SaveTagsContext(context.Background(), tag, out, SaveOptions{FixGranules: true}))
+	verifyPages(t, out.Bytes())
+
+	stats, err = AnalyzeVorbis(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Empty(t, stats.GranuleMismatches)
+	assert.Equal(t, int64(149440), stats.FinalGranule)
+}